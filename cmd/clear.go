@@ -1,15 +1,39 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/whisker"
 	"github.com/spf13/cobra"
 )
 
+var clearCurrent bool
+
 var clearCmd = &cobra.Command{
 	Use:   "clear",
 	Short: "Clear all local data",
-	Long:  `This will delete all the data that has been captured and cached locally from the cluster`,
+	Long: `This will delete all the data that has been captured and cached locally from the cluster.
+
+With --session, or --current for the current session of the kube context, only
+the flows captured into that session are deleted, and the session is kept.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return flowdata.Clear()
+		if sessionName == "" && !clearCurrent {
+			return flowdata.Clear()
+		}
+		kubeContext := whisker.ClusterName(cmd.Context())
+		if err := flowdata.ClearSession(kubeContext, sessionName); err != nil {
+			return err
+		}
+		name := sessionName
+		if name == "" {
+			name, _ = flowdata.CurrentSession(kubeContext)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Cleared session %q\n", name)
+		return nil
 	},
 }
+
+func init() {
+	clearCmd.Flags().BoolVar(&clearCurrent, "current", false, "Only clear the current session of the kube context")
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/printer"
+	"github.com/doucol/clyde/internal/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	clusterInfoOutput   string
+	clusterInfoContexts []string
+	clusterInfoTimeout  time.Duration
+)
+
+type contextClusterInfo struct {
+	Context string `json:"context"`
+	util.ClusterNetworkingInfo
+}
+
+var clusterInfoCmd = &cobra.Command{
+	Use:   "cluster-info",
+	Short: "Print the networking and Calico details of one or more clusters",
+	Long: `Print the CNI, pod/service CIDRs, overlay, Calico and operator versions and
+pods, and Whisker availability of each given kubeconfig context.
+
+Repeat --context to check several clusters in one go. The command exits non-zero
+when Calico or Whisker is missing on any of them, so it can be used as a
+preflight check.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _, err := printer.ParseFormat(clusterInfoOutput, "",
+			printer.FormatTable, printer.FormatWide, printer.FormatJSON, printer.FormatYAML)
+		if err != nil {
+			return err
+		}
+		p, err := printer.New(cmd.OutOrStdout(), format, "", clusterInfoColumns)
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		cc := cmdctx.CmdCtxFromContext(ctx)
+		contexts := clusterInfoContexts
+		if len(contexts) == 0 {
+			contexts = []string{cc.KubeContext()}
+		}
+
+		var failed []string
+		for _, name := range contexts {
+			ci := getClusterInfo(ctx, cc, name)
+			if err := p.Print(ci); err != nil {
+				return err
+			}
+			if missing := ci.Missing(); len(missing) > 0 {
+				failed = append(failed, fmt.Sprintf("%s (%s)", ci.Context, strings.Join(missing, ", ")))
+			}
+		}
+		if err := p.Flush(); err != nil {
+			return err
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("missing on %s", strings.Join(failed, "; "))
+		}
+		return nil
+	},
+}
+
+// getClusterInfo gathers the networking info of the named context, or of the
+// current context when name is empty. Problems reaching the cluster are
+// reported in the Errors of the result.
+func getClusterInfo(ctx context.Context, cc *cmdctx.CmdCtx, name string) *contextClusterInfo {
+	cc.SetContext(name)
+	ci := &contextClusterInfo{Context: name}
+	if name == "" {
+		ci.Context = "(current)"
+		if kc, err := util.LoadKubeconfigInfo(cc.KubeconfigPath(), cc.KubeconfigSource()); err == nil && kc.CurrentContext != "" {
+			ci.Context = kc.CurrentContext
+		}
+	}
+	config, err := cc.K8sConfig()
+	if err != nil {
+		ci.Errors = append(ci.Errors, "kubeconfig: "+err.Error())
+		return ci
+	}
+	config.Timeout = clusterInfoTimeout
+	ctx, cancel := context.WithTimeout(ctx, clusterInfoTimeout)
+	defer cancel()
+	if _, err := cc.Clientset().Discovery().ServerVersion(); err != nil {
+		ci.Errors = append(ci.Errors, "api server: "+err.Error())
+		return ci
+	}
+	ci.ClusterNetworkingInfo = util.GetClusterNetworkingInfo(ctx, cc.Clientset(), cc.ClientDyn(), config)
+	return ci
+}
+
+var clusterInfoColumns = []printer.Column[*contextClusterInfo]{
+	{Header: "CONTEXT", Width: 24, Value: func(ci *contextClusterInfo) string { return ci.Context }},
+	{Header: "CNI", Width: 10, Value: func(ci *contextClusterInfo) string { return ci.CNIType }},
+	{Header: "CALICO", Width: 10, Value: func(ci *contextClusterInfo) string { return installed(ci.CalicoInstalled, ci.CalicoVersion) }},
+	{Header: "OPERATOR", Width: 10, Value: func(ci *contextClusterInfo) string {
+		return installed(ci.OperatorInstalled, ci.OperatorVersion)
+	}},
+	{Header: "WHISKER", Width: 7, Value: func(ci *contextClusterInfo) string { return yesNo(ci.WhiskerAvailable) }},
+	{Header: "OVERLAY", Width: 8, Value: func(ci *contextClusterInfo) string { return ci.Overlay }},
+	{Header: "POD CIDRS", Width: 20, Value: func(ci *contextClusterInfo) string { return strings.Join(ci.PodCIDRs, ",") }},
+	{Header: "SERVICE CIDRS", Width: 20, Wide: true, Value: func(ci *contextClusterInfo) string { return strings.Join(ci.ServiceCIDRs, ",") }},
+	{Header: "ENCAPSULATION", Width: 13, Wide: true, Value: func(ci *contextClusterInfo) string { return ci.Encapsulation }},
+	{Header: "CALICO PODS", Width: 11, Wide: true, Value: func(ci *contextClusterInfo) string { return fmt.Sprint(len(ci.CalicoPods)) }},
+	{Header: "OPERATOR PODS", Width: 13, Wide: true, Value: func(ci *contextClusterInfo) string { return fmt.Sprint(len(ci.OperatorPods)) }},
+	{Header: "ERRORS", Width: 40, Value: func(ci *contextClusterInfo) string { return strings.Join(ci.Errors, "; ") }},
+}
+
+func installed(ok bool, version string) string {
+	switch {
+	case !ok:
+		return "no"
+	case version == "":
+		return "yes"
+	}
+	return version
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func init() {
+	clusterInfoCmd.Flags().StringVarP(&clusterInfoOutput, "output", "o", "table", "Output format: table, wide, json or yaml")
+	clusterInfoCmd.Flags().StringArrayVar(&clusterInfoContexts, "context", nil, "The kubeconfig context to check, repeat for several (defaults to the current context)")
+	clusterInfoCmd.Flags().DurationVar(&clusterInfoTimeout, "timeout", 15*time.Second, "How long to wait on each cluster")
+}
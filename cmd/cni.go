@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/cnitype"
+	"github.com/doucol/clyde/internal/printer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cniOutput  string
+	cniTimeout time.Duration
+)
+
+var cniCmd = &cobra.Command{
+	Use:   "cni",
+	Short: "Detect the CNI plugin of the cluster",
+	Long: `Detect the CNI plugin of the cluster and print its name, version and the
+evidence it was detected from (a daemonset, deployment, pod image, configmap,
+node annotation or cloud provider).`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _, err := printer.ParseFormat(cniOutput, "",
+			printer.FormatTable, printer.FormatJSON, printer.FormatYAML)
+		if err != nil {
+			return err
+		}
+		p, err := printer.New(cmd.OutOrStdout(), format, "", cniColumns)
+		if err != nil {
+			return err
+		}
+
+		cc := cmdctx.CmdCtxFromContext(cmd.Context())
+		config, err := cc.K8sConfig()
+		if err != nil {
+			return err
+		}
+		config.Timeout = cniTimeout
+		ctx, cancel := context.WithTimeout(cmd.Context(), cniTimeout)
+		defer cancel()
+		info, err := cnitype.DetectCNI(ctx, cc.Clientset())
+		if err != nil {
+			return err
+		}
+		if err := p.Print(info); err != nil {
+			return err
+		}
+		return p.Flush()
+	},
+}
+
+var cniColumns = []printer.Column[*cnitype.CNIInfo]{
+	{Header: "NAME", Width: 20, Value: func(c *cnitype.CNIInfo) string { return c.DisplayName() }},
+	{Header: "VERSION", Width: 12, Value: func(c *cnitype.CNIInfo) string { return c.Version }},
+	{Header: "SOURCE", Width: 12, Value: func(c *cnitype.CNIInfo) string { return c.Details["source"] }},
+	{Header: "EVIDENCE", Width: 60, Value: func(c *cnitype.CNIInfo) string {
+		var evidence []string
+		for k, v := range c.Details {
+			if k != "source" {
+				evidence = append(evidence, fmt.Sprintf("%s=%s", k, v))
+			}
+		}
+		sort.Strings(evidence)
+		return strings.Join(evidence, ",")
+	}},
+}
+
+func init() {
+	cniCmd.Flags().StringVarP(&cniOutput, "output", "o", "table", "Output format: table, json or yaml")
+	cniCmd.Flags().DurationVar(&cniTimeout, "timeout", 15*time.Second, "How long to wait on the cluster")
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/doucol/clyde/internal/catcher"
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/config"
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/printer"
+	"github.com/doucol/clyde/internal/util"
+	"github.com/doucol/clyde/internal/whisker"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	configFile   string
+	flagSettings config.Settings
+	configOutput string
+)
+
+// addConfigFlags adds the flags that override the config file and env vars.
+func addConfigFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&configFile, "config", "", "Path to the config file (defaults to $CLYDE_CONFIG or $XDG_CONFIG_HOME/clyde/config.yaml)")
+	flags.StringVar(&flagSettings.CalicoNamespace, "calico-namespace", "", "The namespace Whisker runs in (default calico-system)")
+	flags.StringVar(&flagSettings.WhiskerContainer, "whisker-container", "", "The Whisker container serving flows (default whisker-backend)")
+	flags.StringVar(&flagSettings.URL, "whisker-url", "", "Connect to this Whisker backend URL instead of port-forwarding")
+	flags.StringVar(&flagSettings.URLPath, "whisker-url-path", "", "The path of the flow stream (default /flows?watch=true)")
+	flags.IntVar(&flagSettings.RateCalcWindow, "rate-window", 0, "The window, in seconds, flow rates are calculated over (default 60)")
+	flags.IntVar(&flagSettings.RateCalcInterval, "rate-interval", 0, "How often, in seconds, flow rates are recalculated (default 5)")
+	flags.StringVar(&flagSettings.Backend, "backend", "", "Where to read flows from: whisker or goldmane (default whisker)")
+	flags.StringVar(&flagSettings.GoldmaneContainer, "goldmane-container", "", "The Goldmane container serving flows (default goldmane)")
+	flags.StringVar(&flagSettings.GoldmaneAddr, "goldmane-addr", "", "Connect to this Goldmane host:port, in plaintext, instead of port-forwarding")
+	flags.StringVar(&flagSettings.GoldmaneTLSSecret, "goldmane-tls-secret", "", "The secret with the client certificate for Goldmane (default whisker-backend-key-pair)")
+	flags.StringVar(&flagSettings.ConnectMode, "connect-mode", "", "How to reach Whisker: auto, port-forward or service-proxy (default auto)")
+	flags.StringVar(&flagSettings.WhiskerService, "whisker-service", "", "The Whisker service, as name or name:port, to stream through the API server proxy (default whisker)")
+	urlOption := func(set func(o *catcher.HTTPOptions, v string)) func(string) error {
+		return func(v string) error {
+			set(flagSettings.EnsureURLOptions(), v)
+			return nil
+		}
+	}
+	flags.Func("whisker-ca-file", "The CA bundle to verify --whisker-url with", urlOption(func(o *catcher.HTTPOptions, v string) { o.CAFile = v }))
+	flags.Func("whisker-cert-file", "The client certificate to connect to --whisker-url with", urlOption(func(o *catcher.HTTPOptions, v string) { o.CertFile = v }))
+	flags.Func("whisker-key-file", "The key of --whisker-cert-file", urlOption(func(o *catcher.HTTPOptions, v string) { o.KeyFile = v }))
+	flags.Func("whisker-token-file", "The file with the bearer token to connect to --whisker-url with", urlOption(func(o *catcher.HTTPOptions, v string) { o.BearerTokenFile = v }))
+	flags.Func("whisker-proxy", "The HTTP proxy to connect to --whisker-url through", urlOption(func(o *catcher.HTTPOptions, v string) { o.ProxyURL = v }))
+	flags.IntVar(&flagSettings.ReconnectMaxAttempts, "reconnect-max-attempts", 0, "Give up after this many failed attempts in a row to reach the flow stream (default 0, never)")
+	flags.Func("ingest-batch-size", "The most flows written to the local store in one transaction (default 500)", func(v string) (err error) {
+		flagSettings.EnsureIngest().BatchSize, err = strconv.Atoi(v)
+		return
+	})
+	flags.Func("ingest-batch-latency", "The longest a flow waits for its batch to fill up (default 100ms)", func(v string) (err error) {
+		flagSettings.EnsureIngest().BatchLatency.Duration, err = time.ParseDuration(v)
+		return
+	})
+	flags.Func("ingest-queue-size", "The most flows waiting to be written to the local store (default 10000)", func(v string) (err error) {
+		flagSettings.EnsureIngest().QueueSize, err = strconv.Atoi(v)
+		return
+	})
+	flags.Func("ingest-backpressure", "What to do with flows while the queue is full: block, drop-oldest or sample (default block)", func(v string) error {
+		flagSettings.EnsureIngest().Backpressure = flowdata.Backpressure(v)
+		return nil
+	})
+	flags.Func("retention-max-age", "How long flows are kept in the local store after they ended (default no limit)", func(v string) (err error) {
+		flagSettings.EnsureRetention().MaxAge.Duration, err = time.ParseDuration(v)
+		return
+	})
+	flags.Func("retention-max-flows", "The most flows kept in the local store, the oldest are deleted first (default 0, no limit)", func(v string) (err error) {
+		flagSettings.EnsureRetention().MaxFlows, err = strconv.Atoi(v)
+		return
+	})
+	flags.Func("retention-max-size", "The largest the local store may grow to, e.g. 512Mi (default 1Gi)", func(v string) (err error) {
+		flagSettings.EnsureRetention().MaxSize, err = resource.ParseQuantity(v)
+		return
+	})
+	flags.StringVar(&flagSettings.Storage, "storage", "", "Where to keep flows: bolt (the session file) or memory, up to --retention-max-flows of them or 100000 (default bolt)")
+}
+
+// loadConfigFile loads the --config file, or the default one.
+func loadConfigFile() (*config.File, error) {
+	path := configFile
+	if path == "" {
+		path = config.DefaultPath()
+	}
+	return config.Load(path)
+}
+
+// settingsResolver loads the config file and env vars and returns a func
+// that layers them, with the flags, for a kube context. An empty context
+// name resolves to the current context of the kubeconfig.
+func settingsResolver(ctx context.Context) (func(kubeContext string) config.Settings, error) {
+	file, err := loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	env, err := config.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err := flagSettings.Validate(); err != nil {
+		return nil, err
+	}
+	cc := cmdctx.CmdCtxFromContext(ctx)
+	current := ""
+	if kc, err := util.LoadKubeconfigInfo(cc.KubeconfigPath(), cc.KubeconfigSource()); err == nil {
+		current = kc.CurrentContext
+	}
+	return func(kubeContext string) config.Settings {
+		if kubeContext == "" {
+			kubeContext = current
+		}
+		return file.Resolve(kubeContext, env, flagSettings)
+	}, nil
+}
+
+// applySettings overrides the whisker config with the settings that are set.
+func applySettings(cfg *whisker.WhiskerConfig, s config.Settings) {
+	if s.CalicoNamespace != "" {
+		cfg.CalicoNamespace = s.CalicoNamespace
+	}
+	if s.WhiskerContainer != "" {
+		cfg.WhiskerContainer = s.WhiskerContainer
+	}
+	if s.URL != "" {
+		cfg.URL = s.URL
+	}
+	if s.URLPath != "" {
+		cfg.URLPath = s.URLPath
+	}
+	if s.RateCalcWindow != 0 {
+		cfg.RateCalcWindow = s.RateCalcWindow
+	}
+	if s.RateCalcInterval != 0 {
+		cfg.RateCalcInterval = s.RateCalcInterval
+	}
+	if s.Backend != "" {
+		cfg.Backend = s.Backend
+	}
+	if s.GoldmaneContainer != "" {
+		cfg.GoldmaneContainer = s.GoldmaneContainer
+	}
+	if s.GoldmaneAddr != "" {
+		cfg.GoldmaneAddr = s.GoldmaneAddr
+	}
+	if s.GoldmaneTLSSecret != "" {
+		cfg.GoldmaneTLSSecret = s.GoldmaneTLSSecret
+	}
+	if s.ReconnectMaxAttempts != 0 {
+		cfg.Backoff.MaxAttempts = s.ReconnectMaxAttempts
+	}
+	if s.ConnectMode != "" {
+		cfg.ConnectMode = s.ConnectMode
+	}
+	if s.WhiskerService != "" {
+		cfg.WhiskerService = s.WhiskerService
+	}
+	if s.URLOptions != nil {
+		cfg.URLOptions = s.URLOptions
+	}
+	if s.Ingest != nil {
+		cfg.Ingest = cfg.Ingest.Merge(*s.Ingest)
+	}
+	if s.Retention != nil {
+		cfg.Retention = cfg.Retention.Merge(*s.Retention)
+	}
+	if s.Storage != "" {
+		cfg.Storage = s.Storage
+	}
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the clyde configuration",
+	Long: `Settings are layered, each layer overriding the one before it:
+
+  1. the built in defaults
+  2. the config file ($CLYDE_CONFIG or $XDG_CONFIG_HOME/clyde/config.yaml)
+  3. the overrides for the kube context under "contexts" in the config file
+  4. CLYDE_* environment variables
+  5. command line flags
+
+An example config file:
+
+  calicoNamespace: calico-system
+  rateCalcWindow: 120
+  contexts:
+    enterprise:
+      calicoNamespace: tigera-system`,
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of the config file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path := configFile
+		if path == "" {
+			path = config.DefaultPath()
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), path)
+	},
+}
+
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Print the effective settings for the kube context",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _, err := printer.ParseFormat(configOutput, "", printer.FormatYAML, printer.FormatJSON)
+		if err != nil {
+			return err
+		}
+		resolve, err := settingsResolver(cmd.Context())
+		if err != nil {
+			return err
+		}
+		cfg := whisker.DefaultConfig()
+		applySettings(cfg, resolve(cmdctx.CmdCtxFromContext(cmd.Context()).KubeContext()))
+		effective := config.Settings{
+			CalicoNamespace:  cfg.CalicoNamespace,
+			WhiskerContainer: cfg.WhiskerContainer,
+			URL:              cfg.URL,
+			URLPath:          cfg.URLPath,
+			RateCalcWindow:   cfg.RateCalcWindow,
+			RateCalcInterval: cfg.RateCalcInterval,
+
+			Backend:           cfg.Backend,
+			GoldmaneContainer: cfg.GoldmaneContainer,
+			GoldmaneAddr:      cfg.GoldmaneAddr,
+			GoldmaneTLSSecret: cfg.GoldmaneTLSSecret,
+
+			ReconnectMaxAttempts: cfg.Backoff.MaxAttempts,
+			ConnectMode:          cfg.ConnectMode,
+			WhiskerService:       cfg.WhiskerService,
+			URLOptions:           cfg.URLOptions,
+			Ingest:               &cfg.Ingest,
+			Retention:            &cfg.Retention,
+			Storage:              cfg.Storage,
+		}
+		var out []byte
+		if format == printer.FormatJSON {
+			out, err = json.MarshalIndent(effective, "", "  ")
+			out = append(out, '\n')
+		} else {
+			out, err = yaml.Marshal(effective)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(out)
+		return err
+	},
+}
+
+func init() {
+	configViewCmd.Flags().StringVarP(&configOutput, "output", "o", "yaml", "Output format: yaml or json")
+	configCmd.AddCommand(configPathCmd, configViewCmd)
+}
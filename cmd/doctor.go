@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/doctor"
+	"github.com/doucol/clyde/internal/printer"
+	"github.com/doucol/clyde/internal/whisker"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	doctorOutput  string
+	doctorTimeout time.Duration
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check every step needed to watch flows and suggest fixes",
+	Long: `Check, in order, everything clyde needs to get flows out of a cluster: the
+kubeconfig, API server, RBAC for listing and port-forwarding to pods, the Whisker
+pod and its PORT env var, the port-forward, the SSE handshake and the time to
+the first flow. Each step passes or fails with a suggested fix, and the steps
+after a failure are skipped.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _, err := printer.ParseFormat(doctorOutput, "",
+			printer.FormatTable, printer.FormatJSON, printer.FormatYAML)
+		if err != nil {
+			return err
+		}
+		p, err := printer.New(cmd.OutOrStdout(), format, "", doctorColumns)
+		if err != nil {
+			return err
+		}
+		p.Stream = format == printer.FormatTable
+
+		ctx := cmd.Context()
+		resolve, err := settingsResolver(ctx)
+		if err != nil {
+			return err
+		}
+		cc := cmdctx.CmdCtxFromContext(ctx)
+		cfg := whisker.DefaultConfig()
+		applySettings(cfg, resolve(cc.KubeContext()))
+
+		var printErr error
+		d := &doctor.Doctor{
+			KubeconfigPath:   cc.KubeconfigPath(),
+			KubeconfigSource: cc.KubeconfigSource(),
+			KubeContext:      cc.KubeContext(),
+			Namespace:        cfg.CalicoNamespace,
+			Container:        cfg.WhiskerContainer,
+			PortEnvVar:       "PORT",
+			URLPath:          cfg.URLPath,
+			URL:              cfg.URL,
+			Timeout:          doctorTimeout,
+			Connect: func() (*rest.Config, kubernetes.Interface, error) {
+				config, err := cc.K8sConfig()
+				if err != nil {
+					return nil, nil, err
+				}
+				config.Timeout = doctorTimeout
+				return config, cc.Clientset(), nil
+			},
+			OnResult: func(r doctor.Result) {
+				if printErr == nil {
+					printErr = p.Print(r)
+				}
+			},
+		}
+		results := d.Run(ctx)
+		if printErr != nil {
+			return printErr
+		}
+		if err := p.Flush(); err != nil {
+			return err
+		}
+		if doctor.Failed(results) {
+			return fmt.Errorf("doctor found a problem")
+		}
+		return nil
+	},
+}
+
+var doctorColumns = []printer.Column[doctor.Result]{
+	{Header: "STEP", Width: 18, Value: func(r doctor.Result) string { return r.Step }},
+	{Header: "STATUS", Width: 6, Value: func(r doctor.Result) string { return string(r.Status) }},
+	{Header: "TOOK", Width: 8, Value: func(r doctor.Result) string {
+		if r.Status == doctor.StatusSkip {
+			return ""
+		}
+		return r.Took.String()
+	}},
+	{Header: "DETAIL", Width: 60, Value: func(r doctor.Result) string { return r.Detail }},
+	{Header: "FIX", Width: 0, Value: func(r doctor.Result) string { return r.Fix }},
+}
+
+func init() {
+	doctorCmd.Flags().StringVarP(&doctorOutput, "output", "o", "table", "Output format: table, json or yaml")
+	doctorCmd.Flags().DurationVar(&doctorTimeout, "timeout", 15*time.Second, "How long to wait on each step, including the first flow")
+}
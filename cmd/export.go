@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/doucol/clyde/internal/export"
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/printer"
+	"github.com/doucol/clyde/internal/whisker"
+	"github.com/spf13/cobra"
+)
+
+var exportOutput, exportKind, exportFilter, exportFile string
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export locally captured flow data",
+	Long: `Write the flow summaries and/or flows captured in the local data store as
+JSON, NDJSON or CSV.
+
+Kinds:
+  sums    flow summaries (the rows of the summary pages)
+  flows   individual flows, including their policy trace
+  all     flow summaries with their flows nested under them (json/ndjson only)
+
+The --filter flag takes the same fields as the TUI filter overlay, as a comma
+separated list of key=value pairs, e.g. --filter action=Deny,namespace=prod`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _, err := printer.ParseFormat(exportOutput, "", export.Formats...)
+		if err != nil {
+			return err
+		}
+		filter, err := flowdata.ParseFilter(exportFilter)
+		if err != nil {
+			return err
+		}
+		fds, err := flowdata.OpenReadOnly(whisker.ClusterName(cmd.Context()), sessionName)
+		if err != nil {
+			return err
+		}
+		defer fds.Close()
+
+		var out io.Writer = cmd.OutOrStdout()
+		var f *os.File
+		if exportFile != "" && exportFile != "-" {
+			if f, err = os.Create(exportFile); err != nil {
+				return err
+			}
+			out = f
+		}
+		opts := export.Options{Kind: export.Kind(exportKind), Format: format, Filter: filter}
+		if err := export.Write(out, fds, opts); err != nil {
+			if f != nil {
+				f.Close()
+			}
+			return fmt.Errorf("export %s: %w", exportKind, err)
+		}
+		// The export isn't written until the file is closed without an error
+		if f != nil {
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("export %s: %w", exportKind, err)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	formats := make([]string, len(export.Formats))
+	for i, f := range export.Formats {
+		formats[i] = string(f)
+	}
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", string(printer.FormatJSON), "Output format: "+strings.Join(formats, ", "))
+	exportCmd.Flags().StringVarP(&exportKind, "kind", "k", string(export.KindFlows), "What to export: sums, flows or all")
+	exportCmd.Flags().StringVar(&exportFilter, "filter", "", "Filter as key=value pairs: "+strings.Join(flowdata.FilterKeys, ", "))
+	exportCmd.Flags().StringVarP(&exportFile, "file", "f", "", "Write to this file instead of stdout")
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/printer"
+	"github.com/doucol/clyde/internal/whisker"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flowsOutput, flowsTemplate string
+	flowsLimit                 int
+)
+
+var flowsCmd = &cobra.Command{
+	Use:   "flows",
+	Short: "Stream Calico flows to stdout",
+	Long: `Watch Calico flows without the terminal UI and print each flow as it arrives.
+
+Output formats:
+  table, wide    aligned columns, one flow per line
+  json, ndjson   one JSON object per line
+  go-template    a Go template applied to each flow, e.g. -o go-template='{{.SourceName}} {{.Action}}'`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, tmpl, err := printer.ParseFormat(flowsOutput, flowsTemplate,
+			printer.FormatTable, printer.FormatWide, printer.FormatJSON, printer.FormatNDJSON, printer.FormatGoTemplate)
+		if err != nil {
+			return err
+		}
+		p, err := printer.New(cmd.OutOrStdout(), format, tmpl, flowColumns)
+		if err != nil {
+			return err
+		}
+		p.Stream = true
+
+		ctx := cmd.Context()
+		cfg, err := watchConfig(ctx)
+		if err != nil {
+			return err
+		}
+		cfg.TerminalUI = false
+		// Stdout carries the flows, alerts go to stderr
+		cfg.AlertOut = cmd.ErrOrStderr()
+		w := whisker.New(cfg)
+		// Every flow is printed, a printer that falls behind holds up the
+		// stream rather than losing flows
+		added := w.Subscribe(flowdata.DefaultIngestOptions.BatchSize)
+
+		ready := make(chan bool)
+		done := make(chan error, 1)
+		go func() {
+			done <- w.WatchFlows(ctx, ready)
+		}()
+
+		select {
+		case <-ready:
+		case err := <-done:
+			return err
+		}
+
+		// The subscription is closed once the watch is over, after the flows
+		// caught before it
+		count := 0
+		stop := func() error {
+			cmdctx.CmdCtxFromContext(ctx).Cancel()
+			// Nothing may wait on the flows left while the watch stops
+			go func() {
+				for range added {
+				}
+			}()
+			return <-done
+		}
+		for in := range added {
+			if err := p.Print(in.Flow); err != nil {
+				stop()
+				return err
+			}
+			count++
+			if flowsLimit > 0 && count >= flowsLimit {
+				return stop()
+			}
+		}
+		return <-done
+	},
+}
+
+var flowColumns = []printer.Column[*flowdata.FlowData]{
+	{Header: "START TIME", Width: 20, Wide: true, Value: func(fd *flowdata.FlowData) string { return timeString(fd.StartTime) }},
+	{Header: "END TIME", Width: 20, Value: func(fd *flowdata.FlowData) string { return timeString(fd.EndTime) }},
+	{Header: "CLUSTER", Width: 16, Value: func(fd *flowdata.FlowData) string { return fd.Cluster }},
+	{Header: "SRC NAMESPACE / NAME", Width: 40, Value: func(fd *flowdata.FlowData) string {
+		return fmt.Sprintf("%s / %s", fd.SourceNamespace, fd.SourceName)
+	}},
+	{Header: "DST NAMESPACE / NAME", Width: 40, Value: func(fd *flowdata.FlowData) string {
+		return fmt.Sprintf("%s / %s", fd.DestNamespace, fd.DestName)
+	}},
+	{Header: "PROTO:PORT", Width: 10, Value: func(fd *flowdata.FlowData) string { return fmt.Sprintf("%s:%d", fd.Protocol, fd.DestPort) }},
+	{Header: "REPORTER", Width: 8, Value: func(fd *flowdata.FlowData) string { return fd.Reporter }},
+	{Header: "PACK I/O", Width: 12, Value: func(fd *flowdata.FlowData) string { return fmt.Sprintf("%d / %d", fd.PacketsIn, fd.PacketsOut) }},
+	{Header: "BYTE I/O", Width: 16, Value: func(fd *flowdata.FlowData) string { return fmt.Sprintf("%d / %d", fd.BytesIn, fd.BytesOut) }},
+	{Header: "ACTION", Width: 6, Value: func(fd *flowdata.FlowData) string { return fd.Action }},
+	{Header: "SRC LABELS", Width: 40, Wide: true, Value: func(fd *flowdata.FlowData) string { return fd.SourceLabels }},
+	{Header: "DST LABELS", Width: 40, Wide: true, Value: func(fd *flowdata.FlowData) string { return fd.DestLabels }},
+	{Header: "ENFORCED POLICIES", Width: 40, Wide: true, Value: func(fd *flowdata.FlowData) string {
+		return policyNames(fd.Policies.Enforced)
+	}},
+}
+
+func policyNames(hits []*flowdata.PolicyHit) string {
+	names := make([]string, 0, len(hits))
+	for _, ph := range hits {
+		name := ph.Name
+		if ph.Namespace != "" {
+			name = ph.Namespace + "/" + name
+		}
+		if ph.Tier != "" {
+			name = ph.Tier + "|" + name
+		}
+		names = append(names, name+":"+ph.Action)
+	}
+	return strings.Join(names, ",")
+}
+
+func timeString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func init() {
+	flowsCmd.Flags().StringVarP(&flowsOutput, "output", "o", "table", "Output format: table, wide, json, ndjson or go-template")
+	flowsCmd.Flags().StringVar(&flowsTemplate, "template", "", "Go template to use with -o go-template")
+	flowsCmd.Flags().IntVar(&flowsLimit, "limit", 0, "Exit after printing this many flows (0 means no limit)")
+	addWatchFlags(flowsCmd)
+}
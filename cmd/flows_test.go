@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/util"
+)
+
+// syncBuffer is a bytes.Buffer written to and read from concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFlows_AlertsStayOutOfTheOutput(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	alerts := "alerts:\n  rules:\n  - name: denied\n    type: deny\n  notifiers:\n  - type: stdout\n"
+	if err := os.WriteFile(configFile, []byte(alerts), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLYDE_CONFIG", configFile)
+	capture := filepath.Join(dir, "capture.ndjson")
+	var lines string
+	for i := range 3 {
+		data := fmt.Sprintf(`{\"source_namespace\":\"ns\",\"source_name\":\"src%d\",\"dest_name\":\"dst\",\"reporter\":\"Src\",\"action\":\"Deny\"}`, i)
+		lines += fmt.Sprintf("{\"time\":\"2025-06-01T12:00:0%dZ\",\"data\":\"%s\"}\n", i, data)
+	}
+	if err := os.WriteFile(capture, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	rootCmd.SetOut(stdout)
+	rootCmd.SetErr(stderr)
+	rootCmd.SetArgs([]string{"flows", "-o", "ndjson", "--replay", capture, "--replay-speed", "0"})
+	t.Cleanup(func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+		rootCmd.SetArgs(nil)
+		replayFile, replaySpeed, flowsOutput = "", 1, "table"
+	})
+	cc := cmdctx.NewCmdCtx(filepath.Join(dir, "kubeconfig"), util.KubeconfigSourceDefault, "")
+	ctx := cc.ToContext(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- rootCmd.ExecuteContext(ctx)
+	}()
+
+	// The replay is kept around until the command is stopped
+	deadline := time.Now().Add(5 * time.Second)
+	for (strings.Count(stdout.String(), "\n") < 3 || strings.Count(stderr.String(), "ALERT") < 3) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cc.Cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	out := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(out) != 3 {
+		t.Fatalf("expected a line per flow, got %q", stdout.String())
+	}
+	for _, line := range out {
+		var flow map[string]any
+		if err := json.Unmarshal([]byte(line), &flow); err != nil {
+			t.Errorf("expected the output to be NDJSON, got %q: %v", line, err)
+		}
+	}
+	if got := strings.Count(stderr.String(), "ALERT [denied]"); got != 3 {
+		t.Errorf("expected an alert per denied flow on stderr, got %q", stderr.String())
+	}
+}
@@ -32,7 +32,10 @@ var rootCmd = &cobra.Command{
 	SilenceUsage:  true,
 	SilenceErrors: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg := whisker.DefaultConfig()
+		cfg, err := watchConfig(cmd.Context())
+		if err != nil {
+			return err
+		}
 		w := whisker.New(cfg)
 		return w.WatchFlows(cmd.Context(), nil)
 	},
@@ -52,9 +55,13 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&kubeContext, "context", "", "The name of the kubeconfig context to use")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "loglevel", "warn", "The log level to use (trace, debug, info, warn, error)")
 	rootCmd.PersistentFlags().StringVar(&logFile, "logfile", logger.GetDefaultLogFile(), "The log file to use")
+	rootCmd.PersistentFlags().StringVar(&sessionName, "session", "", "The capture session to use instead of the current one of the kube context")
+
+	addConfigFlags(rootCmd.PersistentFlags())
+	addWatchFlags(rootCmd)
 
 	// Add all root commands
-	rootCmd.AddCommand(aboutCmd, versionCmd, clearCmd)
+	rootCmd.AddCommand(aboutCmd, versionCmd, clearCmd, flowsCmd, exportCmd, sumsCmd, clusterInfoCmd, cniCmd, configCmd, doctorCmd, serveCmd, sessionCmd)
 }
 
 func Execute() int {
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/doucol/clyde/internal/api"
+	"github.com/doucol/clyde/internal/flowcache"
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/metrics"
+	"github.com/doucol/clyde/internal/whisker"
+	"github.com/spf13/cobra"
+)
+
+var (
+	metricsAddr      string
+	metricsMaxSeries int
+	apiAddr          string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Watch flows headless and serve them over HTTP",
+	Long: `Watch flows without the terminal UI and serve what is captured over HTTP.
+
+--metrics-addr exposes the flow summaries at /metrics in the Prometheus text
+format: packet, byte and report counters and the packet and byte rates, per
+reporter (src or dst) and direction, labelled by cluster, source and
+destination namespace and name, protocol, port and action. Past
+--metrics-max-series label sets the remaining summaries are folded into a
+single "__other__" series.
+
+--api-addr serves a read-only JSON API under /api/v1/:
+
+  GET /api/v1/sums/totals      flow summaries
+  GET /api/v1/sums/rates       flow summaries, by source packet rate by default
+  GET /api/v1/sums/{id}        one flow summary
+  GET /api/v1/sums/{id}/flows  the flows of a summary
+  GET /api/v1/flows/{id}       one flow with its policy trace
+  GET /api/v1/events           SSE stream of "flow" and "flowsum" events
+
+The list endpoints filter on the cluster, action, port, namespace, name, label,
+from and to query parameters and sort with sort=<field>&asc=true.
+
+Both can share one address.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if metricsAddr == "" && apiAddr == "" {
+			return fmt.Errorf("nothing to serve: set --metrics-addr and/or --api-addr")
+		}
+		ctx := cmd.Context()
+		cfg, err := watchConfig(ctx)
+		if err != nil {
+			return err
+		}
+		cfg.TerminalUI = false
+		w := whisker.New(cfg)
+
+		// The store must outlive the HTTP servers, so the watch only stops once
+		// they have shut down
+		watchCtx, stopWatch := context.WithCancel(context.WithoutCancel(ctx))
+		defer stopWatch()
+		// The event stream gets every flow, it is up to each of its
+		// subscribers to keep up
+		var ingested <-chan flowdata.Ingested
+		if apiAddr != "" {
+			ingested = w.Subscribe(flowdata.DefaultIngestOptions.BatchSize)
+		}
+		// Served from before the flow stream connects, with what the store
+		// holds, so scrapes and health checks work while Whisker is down
+		fds, err := w.OpenStore(watchCtx)
+		if err != nil {
+			return err
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- w.WatchFlows(watchCtx, nil)
+		}()
+
+		// The cache reads the store, so it has to stop before the watch does
+		cacheCtx, stopCache := context.WithCancel(ctx)
+		defer stopCache()
+		fc := flowcache.NewFlowCache(cacheCtx, fds)
+
+		muxes := map[string]*http.ServeMux{}
+		mux := func(addr string) *http.ServeMux {
+			if muxes[addr] == nil {
+				muxes[addr] = http.NewServeMux()
+			}
+			return muxes[addr]
+		}
+		if metricsAddr != "" {
+			exporter := metrics.NewExporter(fds)
+			exporter.MaxSeries = metricsMaxSeries
+			mux(metricsAddr).Handle("GET /metrics", exporter)
+		}
+		if apiAddr != "" {
+			apiServer := api.New(fc, fds)
+			go apiServer.Broadcast(ingested)
+			mux(apiAddr).Handle("/api/", apiServer.Handler())
+		}
+
+		var servers []*http.Server
+		served := make(chan error, len(muxes))
+		for addr, m := range muxes {
+			var ln net.Listener
+			if ln, err = net.Listen("tcp", addr); err != nil {
+				break
+			}
+			srv := &http.Server{
+				Handler:           m,
+				ReadHeaderTimeout: 10 * time.Second,
+				// Requests, the event streams in particular, end with the cache
+				BaseContext: func(net.Listener) context.Context { return cacheCtx },
+			}
+			servers = append(servers, srv)
+			go func() {
+				served <- srv.Serve(ln)
+			}()
+			if addr == metricsAddr {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Serving metrics on http://%s/metrics\n", ln.Addr())
+			}
+			if addr == apiAddr {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Serving the API on http://%s/api/v1/\n", ln.Addr())
+			}
+		}
+
+		watched := false
+		if err == nil {
+			select {
+			case <-ctx.Done():
+			case err = <-served:
+			case err = <-done:
+				// The listeners are up by now, they still need shutting down
+				watched = true
+			}
+		}
+		stopCache()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		for _, srv := range servers {
+			if serr := srv.Shutdown(shutdownCtx); serr != nil && err == nil {
+				err = serr
+			}
+		}
+		<-fc.Done()
+		stopWatch()
+		if !watched {
+			if werr := <-done; err == nil {
+				err = werr
+			}
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		return err
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. :9090")
+	serveCmd.Flags().IntVar(&metricsMaxSeries, "metrics-max-series", metrics.DefaultMaxSeries, "The most label sets to export, the rest are folded into one series (0 for no limit)")
+	serveCmd.Flags().StringVar(&apiAddr, "api-addr", "", "Serve the JSON API at /api/v1/ on this address, e.g. localhost:8080")
+	addWatchFlags(serveCmd)
+}
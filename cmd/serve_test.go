@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/util"
+)
+
+func TestServe_ListensBeforeTheStreamConnects(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dir)
+	t.Setenv("CLYDE_CONFIG", filepath.Join(dir, "config.yaml"))
+	// Nothing listens on the cluster Whisker is port-forwarded from
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := ln.Addr().String()
+	ln.Close()
+	t.Setenv("CLYDE_CONNECT_MODE", "port-forward")
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	cluster := "apiVersion: v1\nkind: Config\nclusters:\n- name: down\n  cluster:\n    server: https://" + server +
+		"\ncontexts:\n- name: down\n  context:\n    cluster: down\n    user: down\ncurrent-context: down\nusers:\n- name: down\n  user: {}\n"
+	if err := os.WriteFile(kubeconfig, []byte(cluster), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := &syncBuffer{}
+	rootCmd.SetErr(stderr)
+	rootCmd.SetArgs([]string{"serve", "--metrics-addr", "127.0.0.1:0"})
+	t.Cleanup(func() {
+		rootCmd.SetErr(nil)
+		rootCmd.SetArgs(nil)
+		metricsAddr = ""
+	})
+	cc := cmdctx.NewCmdCtx(kubeconfig, util.KubeconfigSourceDefault, "")
+	ctx := cc.ToContext(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- rootCmd.ExecuteContext(ctx)
+	}()
+	defer func() {
+		cc.Cancel()
+		<-done
+	}()
+
+	serving := regexp.MustCompile(`Serving metrics on (http://\S+/metrics)`)
+	var url string
+	deadline := time.Now().Add(5 * time.Second)
+	for url == "" && time.Now().Before(deadline) {
+		if m := serving.FindStringSubmatch(stderr.String()); m != nil {
+			url = m[1]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if url == "" {
+		t.Fatalf("expected the metrics to be served while Whisker is unreachable, got %q", stderr.String())
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the metrics to be scraped, got %s", resp.Status)
+	}
+}
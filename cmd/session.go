@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/printer"
+	"github.com/doucol/clyde/internal/whisker"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sessionName        string
+	sessionOutput      string
+	sessionAllContexts bool
+)
+
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Manage the capture sessions of the kube context",
+	Long: `Flows are captured into a session of the kube context they come from, so
+the flows of different clusters never end up in the same sums. Each context
+starts out with the "default" session, and another one can be created to
+capture into, e.g. one per investigation, and switched back and forth.
+
+The --session flag captures into, or reads from, another session than the
+current one for a single command.`,
+}
+
+var sessionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the sessions of the kube context",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _, err := printer.ParseFormat(sessionOutput, "",
+			printer.FormatTable, printer.FormatJSON, printer.FormatYAML)
+		if err != nil {
+			return err
+		}
+		columns := sessionColumns
+		var sessions []flowdata.Session
+		if sessionAllContexts {
+			columns = append([]printer.Column[flowdata.Session]{sessionContextColumn}, columns...)
+			sessions, err = flowdata.AllSessions()
+		} else {
+			sessions, err = flowdata.Sessions(whisker.ClusterName(cmd.Context()))
+		}
+		if err != nil {
+			return err
+		}
+		p, err := printer.New(cmd.OutOrStdout(), format, "", columns)
+		if err != nil {
+			return err
+		}
+		for _, s := range sessions {
+			if err := p.Print(s); err != nil {
+				return err
+			}
+		}
+		return p.Flush()
+	},
+}
+
+var sessionNewCmd = &cobra.Command{
+	Use:   "new NAME",
+	Short: "Create a session and capture into it from now on",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kubeContext := whisker.ClusterName(cmd.Context())
+		if err := flowdata.NewSession(kubeContext, args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Created session %q, now capturing into it\n", args[0])
+		return nil
+	},
+}
+
+var sessionUseCmd = &cobra.Command{
+	Use:   "use NAME",
+	Short: "Capture into and read from the session from now on",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := flowdata.UseSession(whisker.ClusterName(cmd.Context()), args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Switched to session %q\n", args[0])
+		return nil
+	},
+}
+
+var sessionDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete the session and the flows captured into it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := flowdata.DeleteSession(whisker.ClusterName(cmd.Context()), args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Deleted session %q\n", args[0])
+		return nil
+	},
+}
+
+var sessionRenameCmd = &cobra.Command{
+	Use:   "rename OLD NEW",
+	Short: "Rename the session",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := flowdata.RenameSession(whisker.ClusterName(cmd.Context()), args[0], args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Renamed session %q to %q\n", args[0], args[1])
+		return nil
+	},
+}
+
+var sessionContextColumn = printer.Column[flowdata.Session]{Header: "CONTEXT", Width: 24, Value: func(s flowdata.Session) string {
+	if s.Context == "" {
+		return "(none)"
+	}
+	return s.Context
+}}
+
+var sessionColumns = []printer.Column[flowdata.Session]{
+	{Header: "CURRENT", Width: 7, Value: func(s flowdata.Session) string {
+		if s.Current {
+			return "*"
+		}
+		return ""
+	}},
+	{Header: "NAME", Width: 20, Value: func(s flowdata.Session) string { return s.Name }},
+	{Header: "SIZE", Width: 8, Value: func(s flowdata.Session) string { return formatSize(s.Size) }},
+	{Header: "MODIFIED", Width: 20, Value: func(s flowdata.Session) string {
+		if s.Modified.IsZero() {
+			return ""
+		}
+		return s.Modified.Local().Format(time.DateTime)
+	}},
+}
+
+// formatSize renders a file size in binary units.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	sessionListCmd.Flags().StringVarP(&sessionOutput, "output", "o", "table", "Output format: table, json or yaml")
+	sessionListCmd.Flags().BoolVarP(&sessionAllContexts, "all-contexts", "A", false, "List the sessions of every kube context flows were captured from")
+	sessionCmd.AddCommand(sessionListCmd, sessionNewCmd, sessionUseCmd, sessionDeleteCmd, sessionRenameCmd)
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/flowcache"
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/global"
+	"github.com/doucol/clyde/internal/printer"
+	"github.com/doucol/clyde/internal/whisker"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sumsOutput, sumsSort, sumsFilter string
+	sumsAsc                          bool
+	sumsDuration, sumsSince          time.Duration
+)
+
+var sumsCmd = &cobra.Command{
+	Use:   "sums [totals|rates]",
+	Short: "Print the flow summary totals or rates",
+	Long: `Print the same flow summaries as the Summary Totals / Summary Rates pages of
+the TUI, once, and exit.
+
+With --duration, clyde connects to the cluster and collects flows for that long
+before printing. Without it the flows already captured locally are printed.
+
+Sort by any flow summary field, by its name or json name, e.g.
+  clyde sums rates --sort source_total_packet_rate
+  clyde sums --duration 2m --since 2m --filter action=Deny --sort DestReports`,
+	Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"totals", "rates"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rates := len(args) == 1 && args[0] == "rates"
+		format, _, err := printer.ParseFormat(sumsOutput, "",
+			printer.FormatTable, printer.FormatWide, printer.FormatJSON, printer.FormatYAML)
+		if err != nil {
+			return err
+		}
+		filter, err := flowdata.ParseFilter(sumsFilter)
+		if err != nil {
+			return err
+		}
+		if sumsSince > 0 {
+			filter.DateFrom = time.Now().Add(-sumsSince)
+		}
+		sort := flowdata.SortAttributes{}
+		if sumsSort != "" {
+			field, err := flowdata.SumSortField(sumsSort)
+			if err != nil {
+				return err
+			}
+			sort = flowdata.SortAttributes{
+				SumTotalsFieldName: field, SumTotalsAscending: sumsAsc,
+				SumRatesFieldName: field, SumRatesAscending: sumsAsc,
+			}
+		}
+		columns := sumTotalsColumns
+		if rates {
+			columns = sumRatesColumns
+		}
+		p, err := printer.New(cmd.OutOrStdout(), format, "", columns)
+		if err != nil {
+			return err
+		}
+
+		printSums := func(fds flowcache.FlowDataStore) error {
+			global.SetFilter(filter)
+			global.SetSort(sort)
+			ctx, cancel := context.WithCancel(cmd.Context())
+			fc := flowcache.NewFlowCache(ctx, fds)
+			defer func() {
+				cancel()
+				<-fc.Done()
+			}()
+			fss := fc.GetFlowSumTotals()
+			if rates {
+				fss = fc.GetFlowSumRates()
+			}
+			for _, fs := range fss {
+				if err := p.Print(fs); err != nil {
+					return err
+				}
+			}
+			return p.Flush()
+		}
+
+		if sumsDuration <= 0 {
+			fds, err := flowdata.OpenReadOnly(whisker.ClusterName(cmd.Context()), sessionName)
+			if err != nil {
+				return err
+			}
+			defer fds.Close()
+			return printSums(fds)
+		}
+		// Set before collecting, so the filter is pushed to the server
+		global.SetFilter(filter)
+		return collectSums(cmd.Context(), sumsDuration, printSums)
+	},
+}
+
+// collectSums watches flows for the given duration and then hands the store
+// to printSums while it is still open.
+func collectSums(ctx context.Context, d time.Duration, printSums func(flowcache.FlowDataStore) error) error {
+	cfg, err := watchConfig(ctx)
+	if err != nil {
+		return err
+	}
+	cfg.TerminalUI = false
+	w := whisker.New(cfg)
+
+	ready := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.WatchFlows(ctx, ready)
+	}()
+
+	select {
+	case <-ready:
+	case err := <-done:
+		return err
+	}
+	select {
+	case <-time.After(d):
+	case err := <-done:
+		return err
+	}
+	err = printSums(w.FlowDataStore())
+	cmdctx.CmdCtxFromContext(ctx).Cancel()
+	if werr := <-done; err == nil {
+		err = werr
+	}
+	return err
+}
+
+var sumTotalsColumns = append([]printer.Column[*flowdata.FlowSum]{
+	{Header: "CLUSTER", Width: 16, Value: func(fs *flowdata.FlowSum) string { return fs.Cluster }},
+	{Header: "SRC NAMESPACE / NAME", Width: 40, Value: func(fs *flowdata.FlowSum) string {
+		return fmt.Sprintf("%s / %s", fs.SourceNamespace, fs.SourceName)
+	}},
+	{Header: "DST NAMESPACE / NAME", Width: 40, Value: func(fs *flowdata.FlowSum) string {
+		return fmt.Sprintf("%s / %s", fs.DestNamespace, fs.DestName)
+	}},
+	{Header: "PROTO:PORT", Width: 10, Value: func(fs *flowdata.FlowSum) string { return fmt.Sprintf("%s:%d", fs.Protocol, fs.DestPort) }},
+	{Header: "SRC / DST", Width: 10, Value: func(fs *flowdata.FlowSum) string {
+		return fmt.Sprintf("%d / %d", fs.SourceReports, fs.DestReports)
+	}},
+	{Header: "SRC PACK I/O", Width: 16, Value: func(fs *flowdata.FlowSum) string {
+		return fmt.Sprintf("%d / %d", fs.SourcePacketsIn, fs.SourcePacketsOut)
+	}},
+	{Header: "SRC BYTE I/O", Width: 18, Value: func(fs *flowdata.FlowSum) string {
+		return fmt.Sprintf("%d / %d", fs.SourceBytesIn, fs.SourceBytesOut)
+	}},
+	{Header: "DST PACK I/O", Width: 16, Value: func(fs *flowdata.FlowSum) string {
+		return fmt.Sprintf("%d / %d", fs.DestPacketsIn, fs.DestPacketsOut)
+	}},
+	{Header: "DST BYTE I/O", Width: 18, Value: func(fs *flowdata.FlowSum) string {
+		return fmt.Sprintf("%d / %d", fs.DestBytesIn, fs.DestBytesOut)
+	}},
+	{Header: "ACTION", Width: 6, Value: func(fs *flowdata.FlowSum) string { return fs.Action }},
+}, sumWideColumns...)
+
+var sumRatesColumns = append([]printer.Column[*flowdata.FlowSum]{
+	sumTotalsColumns[0], sumTotalsColumns[1], sumTotalsColumns[2], sumTotalsColumns[3],
+	{Header: "SRC PACK/SEC", Width: 14, Value: func(fs *flowdata.FlowSum) string { return fmt.Sprintf("%.2f", fs.SourceTotalPacketRate) }},
+	{Header: "SRC BYTE/SEC", Width: 14, Value: func(fs *flowdata.FlowSum) string { return fmt.Sprintf("%.2f", fs.SourceTotalByteRate) }},
+	{Header: "DST PACK/SEC", Width: 14, Value: func(fs *flowdata.FlowSum) string { return fmt.Sprintf("%.2f", fs.DestTotalPacketRate) }},
+	{Header: "DST BYTE/SEC", Width: 14, Value: func(fs *flowdata.FlowSum) string { return fmt.Sprintf("%.2f", fs.DestTotalByteRate) }},
+	sumTotalsColumns[9],
+}, sumWideColumns...)
+
+var sumWideColumns = []printer.Column[*flowdata.FlowSum]{
+	{Header: "START TIME", Width: 20, Wide: true, Value: func(fs *flowdata.FlowSum) string { return timeString(fs.StartTime) }},
+	{Header: "END TIME", Width: 20, Wide: true, Value: func(fs *flowdata.FlowSum) string { return timeString(fs.EndTime) }},
+	{Header: "SRC LABELS", Width: 40, Wide: true, Value: func(fs *flowdata.FlowSum) string { return fs.SourceLabels }},
+	{Header: "DST LABELS", Width: 40, Wide: true, Value: func(fs *flowdata.FlowSum) string { return fs.DestLabels }},
+}
+
+func init() {
+	sumsCmd.Flags().StringVarP(&sumsOutput, "output", "o", "table", "Output format: table, wide, json or yaml")
+	sumsCmd.Flags().StringVar(&sumsSort, "sort", "", "Flow summary field to sort by, e.g. SourceNamespace or source_total_packet_rate")
+	sumsCmd.Flags().BoolVar(&sumsAsc, "asc", false, "Sort in ascending order")
+	sumsCmd.Flags().StringVar(&sumsFilter, "filter", "", "Filter as key=value pairs, e.g. action=Deny,namespace=prod")
+	sumsCmd.Flags().DurationVar(&sumsDuration, "duration", 0, "Collect flows from the cluster for this long before printing (0 prints what has been captured locally)")
+	sumsCmd.Flags().DurationVar(&sumsSince, "since", 0, "Only include flow summaries seen within this long, e.g. 2m")
+	addWatchFlags(sumsCmd)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/whisker"
+	"github.com/spf13/cobra"
+)
+
+var (
+	recordFile, replayFile string
+	replaySpeed            float64
+	watchContexts          []string
+)
+
+// addWatchFlags adds the flags shared by the commands that watch flows.
+func addWatchFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&recordFile, "record", "", "Record the raw flow stream to this file so it can be replayed later")
+	cmd.Flags().StringVar(&replayFile, "replay", "", "Replay a file written by --record instead of watching a cluster")
+	cmd.Flags().Float64Var(&replaySpeed, "replay-speed", 1, "Replay speed: 1 is the original speed, 10 is ten times faster, 0 is as fast as possible")
+	cmd.Flags().StringSliceVar(&watchContexts, "contexts", nil, "Watch these kubeconfig contexts at once, e.g. --contexts prod-eu,prod-us")
+	cmd.MarkFlagsMutuallyExclusive("record", "replay")
+	cmd.MarkFlagsMutuallyExclusive("contexts", "replay")
+}
+
+// watchConfig returns the whisker config for the watch flags, layered with
+// the config file, env vars and config flags for the kube context, along
+// with the alerts of the config file.
+func watchConfig(ctx context.Context) (*whisker.WhiskerConfig, error) {
+	resolve, err := settingsResolver(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file, err := loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg := whisker.DefaultConfig()
+	cfg.RecordFile = recordFile
+	cfg.ReplayFile = replayFile
+	cfg.ReplaySpeed = replaySpeed
+	cfg.Alerts = file.Alerts
+	cfg.Contexts = watchContexts
+	cfg.Session = sessionName
+	applySettings(cfg, resolve(cmdctx.CmdCtxFromContext(ctx).KubeContext()))
+	cfg.ForContext = func(kubeContext string) *whisker.WhiskerConfig {
+		c := *cfg
+		applySettings(&c, resolve(kubeContext))
+		return &c
+	}
+	return cfg, nil
+}
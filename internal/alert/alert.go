@@ -0,0 +1,159 @@
+// Package alert evaluates alert rules against live flows and flow summary
+// rates and sends the alerts that fire to notifiers.
+package alert
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type RuleType string
+
+const (
+	// RuleDeny fires on every denied flow
+	RuleDeny RuleType = "deny"
+	// RuleRate fires when a rate of a flow summary stays above a threshold
+	RuleRate RuleType = "rate"
+	// RuleNewEdge fires the first time traffic is seen between two endpoints
+	RuleNewEdge RuleType = "new-edge"
+)
+
+// DefaultCooldown is how long an alert is held back after it fired for the
+// same rule and edge.
+const DefaultCooldown = 5 * time.Minute
+
+// DefaultRateField is the flow summary rate a rate rule watches by default.
+const DefaultRateField = "SourceTotalByteRate"
+
+// Config is the alerts section of the config file.
+type Config struct {
+	// Cooldown applies to the rules that don't set their own
+	Cooldown  metav1.Duration  `json:"cooldown,omitempty"`
+	Rules     []Rule           `json:"rules,omitempty"`
+	Notifiers []NotifierConfig `json:"notifiers,omitempty"`
+}
+
+// Rule matches flows or flow summaries. Source and Dest narrow any rule down
+// to an endpoint, either "namespace" or "namespace/name".
+type Rule struct {
+	Name   string   `json:"name"`
+	Type   RuleType `json:"type"`
+	Source string   `json:"source,omitempty"`
+	Dest   string   `json:"dest,omitempty"`
+	// Field is the rate a rate rule compares, by its Go or json name
+	Field     string  `json:"field,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+	// For is how long a rate has to stay above the threshold to fire
+	For      metav1.Duration `json:"for,omitempty"`
+	Cooldown metav1.Duration `json:"cooldown,omitempty"`
+}
+
+// Alert is a rule that fired for an edge.
+type Alert struct {
+	Rule            string    `json:"rule"`
+	Type            RuleType  `json:"type"`
+	Message         string    `json:"message"`
+	Key             string    `json:"key"`
+	Time            time.Time `json:"time"`
+	SourceNamespace string    `json:"source_namespace"`
+	SourceName      string    `json:"source_name"`
+	DestNamespace   string    `json:"dest_namespace"`
+	DestName        string    `json:"dest_name"`
+	Value           float64   `json:"value,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Cooldown.Duration < 0 {
+		return fmt.Errorf("alerts: cooldown must be positive, got %s", c.Cooldown.Duration)
+	}
+	names := map[string]bool{}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.Name == "" {
+			return fmt.Errorf("alerts: rule %d has no name", i+1)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("alerts: rule %s is defined twice", r.Name)
+		}
+		names[r.Name] = true
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("alerts: rule %s: %w", r.Name, err)
+		}
+	}
+	for i, n := range c.Notifiers {
+		if err := n.validate(); err != nil {
+			return fmt.Errorf("alerts: notifier %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) validate() error {
+	switch r.Type {
+	case RuleDeny, RuleNewEdge:
+	case RuleRate:
+		if r.Field == "" {
+			r.Field = DefaultRateField
+		}
+		field, err := flowdata.SumSortField(r.Field)
+		if err != nil {
+			return err
+		}
+		if f, _ := reflect.TypeFor[flowdata.FlowSum]().FieldByName(field); f.Type.Kind() != reflect.Float64 {
+			return fmt.Errorf("%s is not a rate", r.Field)
+		}
+		r.Field = field
+		if r.Threshold <= 0 {
+			return fmt.Errorf("threshold must be positive, got %g", r.Threshold)
+		}
+	default:
+		return fmt.Errorf("unknown type %q, expected %s, %s or %s", r.Type, RuleDeny, RuleRate, RuleNewEdge)
+	}
+	if r.For.Duration < 0 || r.Cooldown.Duration < 0 {
+		return fmt.Errorf("for and cooldown must be positive")
+	}
+	return nil
+}
+
+// matches reports whether the endpoints of the flow match the rule.
+func (r *Rule) matches(f flowdata.Flower) bool {
+	return matchEndpoint(r.Source, f.GetSourceNamespace(), f.GetSourceName()) &&
+		matchEndpoint(r.Dest, f.GetDestNamespace(), f.GetDestName())
+}
+
+func matchEndpoint(pattern, namespace, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	ns, n, hasName := strings.Cut(pattern, "/")
+	return ns == namespace && (!hasName || n == name)
+}
+
+// rate returns the value of the rule's rate field of the flow summary.
+func (r *Rule) rate(fs *flowdata.FlowSum) float64 {
+	return reflect.ValueOf(fs).Elem().FieldByName(r.Field).Float()
+}
+
+func newAlert(r *Rule, f flowdata.Flower, at time.Time) Alert {
+	return Alert{
+		Rule:            r.Name,
+		Type:            r.Type,
+		Key:             f.GetSumKey(),
+		Time:            at,
+		SourceNamespace: f.GetSourceNamespace(),
+		SourceName:      f.GetSourceName(),
+		DestNamespace:   f.GetDestNamespace(),
+		DestName:        f.GetDestName(),
+	}
+}
+
+// edge describes the endpoints and port of a flow for alert messages.
+func edge(f flowdata.Flower) string {
+	return fmt.Sprintf("%s/%s -> %s/%s:%d", f.GetSourceNamespace(), f.GetSourceName(),
+		f.GetDestNamespace(), f.GetDestName(), f.GetPort())
+}
@@ -0,0 +1,141 @@
+package alert
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func flow(srcNs, src, dstNs, dst, action string) *flowdata.FlowData {
+	return &flowdata.FlowData{FlowResponse: flowdata.FlowResponse{
+		SourceNamespace: srcNs, SourceName: src, DestNamespace: dstNs, DestName: dst,
+		Protocol: "tcp", DestPort: 443, Action: action, Reporter: "Src",
+	}}
+}
+
+// clock is a fake clock tests move forward by hand.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newTestEngine(t *testing.T, cfg *Config) (*Engine, *clock) {
+	t.Helper()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+	c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e := NewEngine(cfg, nil)
+	e.Now = c.Now
+	return e, c
+}
+
+func TestEngine_Deny(t *testing.T) {
+	e, c := newTestEngine(t, &Config{
+		Cooldown: metav1.Duration{Duration: time.Minute},
+		Rules:    []Rule{{Name: "deny-prod", Type: RuleDeny, Dest: "prod"}},
+	})
+
+	e.OnFlow(flow("dev", "web", "prod", "db", "Deny"))
+	e.OnFlow(flow("dev", "web", "prod", "db", "Allow"))
+	e.OnFlow(flow("dev", "web", "dev", "db", "Deny"))
+	// Held back by the cooldown
+	e.OnFlow(flow("dev", "web", "prod", "db", "Deny"))
+	// Another edge isn't
+	e.OnFlow(flow("dev", "api", "prod", "db", "Deny"))
+	c.now = c.now.Add(time.Minute)
+	e.OnFlow(flow("dev", "web", "prod", "db", "Deny"))
+
+	got := e.Recent()
+	if len(got) != 3 || e.Total() != 3 {
+		t.Fatalf("expected 3 alerts, got %d: %+v", len(got), got)
+	}
+	if got[0].Rule != "deny-prod" || got[0].Message != "denied flow dev/web -> prod/db:443" {
+		t.Errorf("unexpected alert %+v", got[0])
+	}
+	if got[1].SourceName != "api" || !got[2].Time.Equal(c.now) {
+		t.Errorf("unexpected alerts %+v", got[1:])
+	}
+}
+
+func TestEngine_NewEdge(t *testing.T) {
+	e, _ := newTestEngine(t, &Config{Rules: []Rule{{Name: "new", Type: RuleNewEdge}}})
+	known := flow("dev", "web", "prod", "db", "Allow")
+	e.Seed([]*flowdata.FlowSum{{Key: known.GetSumKey()}})
+
+	e.OnFlow(known)
+	e.OnFlow(flow("dev", "web", "prod", "cache", "Allow"))
+	e.OnFlow(flow("dev", "web", "prod", "cache", "Allow"))
+
+	got := e.Recent()
+	if len(got) != 1 || got[0].Message != "new edge dev/web -> prod/cache:443 (Allow)" {
+		t.Errorf("expected one new edge alert, got %+v", got)
+	}
+}
+
+func TestEngine_Rate(t *testing.T) {
+	e, c := newTestEngine(t, &Config{Rules: []Rule{{
+		Name: "hot", Type: RuleRate, Source: "dev/web", Dest: "prod",
+		Field: "source_total_byte_rate", Threshold: 1000,
+		For: metav1.Duration{Duration: 30 * time.Second},
+	}}})
+	fs := &flowdata.FlowSum{Key: "k", SourceNamespace: "dev", SourceName: "web", DestNamespace: "prod", DestName: "db"}
+	other := &flowdata.FlowSum{Key: "o", SourceNamespace: "dev", SourceName: "api", DestNamespace: "prod", SourceTotalByteRate: 5000}
+
+	steps := []struct {
+		after time.Duration
+		rate  float64
+		fired int
+	}{
+		{0, 2000, 0},
+		{20 * time.Second, 2000, 0},
+		// Dipping below the threshold restarts the window
+		{5 * time.Second, 500, 0},
+		{5 * time.Second, 2000, 0},
+		{30 * time.Second, 2000, 1},
+		// Still above, but within the cooldown
+		{10 * time.Second, 3000, 1},
+	}
+	for i, s := range steps {
+		c.now = c.now.Add(s.after)
+		fs.SourceTotalByteRate = s.rate
+		e.OnRates([]*flowdata.FlowSum{fs, other})
+		if got := e.Total(); got != s.fired {
+			t.Fatalf("step %d: expected %d alerts, got %d", i, s.fired, got)
+		}
+	}
+	a := e.Recent()[0]
+	if a.Value != 2000 || !strings.HasPrefix(a.Message, "SourceTotalByteRate 2000.00 > 1000 on dev/web -> prod/db") {
+		t.Errorf("unexpected alert %+v", a)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{"valid", Config{Rules: []Rule{{Name: "a", Type: RuleDeny}, {Name: "b", Type: RuleRate, Threshold: 1}}}, ""},
+		{"no name", Config{Rules: []Rule{{Type: RuleDeny}}}, "rule 1 has no name"},
+		{"duplicate", Config{Rules: []Rule{{Name: "a", Type: RuleDeny}, {Name: "a", Type: RuleNewEdge}}}, "defined twice"},
+		{"bad type", Config{Rules: []Rule{{Name: "a", Type: "loud"}}}, `unknown type "loud"`},
+		{"not a rate", Config{Rules: []Rule{{Name: "a", Type: RuleRate, Field: "dest_port", Threshold: 1}}}, "dest_port is not a rate"},
+		{"no threshold", Config{Rules: []Rule{{Name: "a", Type: RuleRate}}}, "threshold must be positive"},
+		{"webhook", Config{Notifiers: []NotifierConfig{{Type: NotifierWebhook}}}, "webhook needs a url"},
+		{"command", Config{Notifiers: []NotifierConfig{{Type: NotifierCommand}}}, "command needs a command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.err == "" && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRecent is how many of the latest alerts are kept for the TUI.
+const maxRecent = 100
+
+// queueSize is how many alerts can wait for the notifiers before new ones
+// are dropped.
+const queueSize = 256
+
+type FlowSumSource interface {
+	GetFlowSums(filter flowdata.FilterAttributes) []*flowdata.FlowSum
+}
+
+// Engine evaluates the rules. Deny and new edge rules are evaluated as flows
+// arrive through OnFlow, rate rules each time Run re-reads the flow summary
+// rates.
+type Engine struct {
+	rules     []Rule
+	notifiers []Notifier
+	cooldown  time.Duration
+	// Now is the clock alerts are stamped and windows measured with
+	Now func() time.Time
+
+	mu     sync.Mutex
+	seen   map[string]bool
+	fired  map[string]time.Time
+	breach map[string]time.Time
+	recent []Alert
+	total  int
+	queue  chan Alert
+}
+
+// NewEngine returns an engine for the validated config that sends its alerts
+// to the given notifiers.
+func NewEngine(cfg *Config, notifiers []Notifier) *Engine {
+	cooldown := cfg.Cooldown.Duration
+	if cooldown == 0 {
+		cooldown = DefaultCooldown
+	}
+	return &Engine{
+		rules:     cfg.Rules,
+		notifiers: notifiers,
+		cooldown:  cooldown,
+		Now:       time.Now,
+		seen:      map[string]bool{},
+		fired:     map[string]time.Time{},
+		breach:    map[string]time.Time{},
+		queue:     make(chan Alert, queueSize),
+	}
+}
+
+// Seed marks the edges of the flow summaries as already seen, so restarting
+// clyde over an existing store doesn't report them as new.
+func (e *Engine) Seed(sums []*flowdata.FlowSum) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, fs := range sums {
+		e.seen[fs.GetSumKey()] = true
+	}
+}
+
+// OnFlow evaluates the deny and new edge rules against a flow as it arrives.
+func (e *Engine) OnFlow(fd *flowdata.FlowData) {
+	now := e.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := fd.GetSumKey()
+	isNew := !e.seen[key]
+	e.seen[key] = true
+	for i := range e.rules {
+		r := &e.rules[i]
+		if !r.matches(fd) {
+			continue
+		}
+		switch {
+		case r.Type == RuleDeny && fd.Action == flowdata.Action_name[int32(flowdata.Action_Deny)]:
+			a := newAlert(r, fd, now)
+			a.Message = fmt.Sprintf("denied flow %s", edge(fd))
+			e.fire(r, a)
+		case r.Type == RuleNewEdge && isNew:
+			a := newAlert(r, fd, now)
+			a.Message = fmt.Sprintf("new edge %s (%s)", edge(fd), fd.Action)
+			e.fire(r, a)
+		}
+	}
+}
+
+// OnRates evaluates the rate rules against the flow summaries.
+func (e *Engine) OnRates(sums []*flowdata.FlowSum) {
+	now := e.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.rules {
+		r := &e.rules[i]
+		if r.Type != RuleRate {
+			continue
+		}
+		for _, fs := range sums {
+			if !r.matches(fs) {
+				continue
+			}
+			id := r.Name + "|" + fs.Key
+			v := r.rate(fs)
+			if v <= r.Threshold {
+				delete(e.breach, id)
+				continue
+			}
+			since, ok := e.breach[id]
+			if !ok {
+				since = now
+				e.breach[id] = now
+			}
+			if now.Sub(since) < r.For.Duration {
+				continue
+			}
+			a := newAlert(r, fs, now)
+			a.Value = v
+			a.Message = fmt.Sprintf("%s %.2f > %g on %s", r.Field, v, r.Threshold, edge(fs))
+			if r.For.Duration > 0 {
+				a.Message += fmt.Sprintf(" for %s", r.For.Duration)
+			}
+			e.fire(r, a)
+		}
+	}
+}
+
+// fire records and queues the alert unless the same rule fired for the same
+// edge within the cooldown. The caller holds the lock.
+func (e *Engine) fire(r *Rule, a Alert) {
+	cooldown := r.Cooldown.Duration
+	if cooldown == 0 {
+		cooldown = e.cooldown
+	}
+	id := r.Name + "|" + a.Key
+	if last, ok := e.fired[id]; ok && a.Time.Sub(last) < cooldown {
+		return
+	}
+	e.fired[id] = a.Time
+	e.total++
+	e.recent = append(e.recent, a)
+	if len(e.recent) > maxRecent {
+		e.recent = e.recent[len(e.recent)-maxRecent:]
+	}
+	select {
+	case e.queue <- a:
+	default:
+		logrus.Warnf("alert queue is full, not notifying %s: %s", a.Rule, a.Message)
+	}
+}
+
+// Recent returns the latest alerts, newest last.
+func (e *Engine) Recent() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Alert(nil), e.recent...)
+}
+
+// Total returns how many alerts have fired.
+func (e *Engine) Total() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.total
+}
+
+// Run sends the alerts to the notifiers and evaluates the rate rules against
+// the flow summaries of fss every interval, until ctx is done.
+func (e *Engine) Run(ctx context.Context, fss FlowSumSource, interval time.Duration) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case a := <-e.queue:
+				e.notify(ctx, a)
+			}
+		}
+	}()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-tick.C:
+			e.OnRates(fss.GetFlowSums(flowdata.FilterAttributes{}))
+		}
+	}
+}
+
+func (e *Engine) notify(ctx context.Context, a Alert) {
+	for _, n := range e.notifiers {
+		if err := n.Notify(ctx, a); err != nil {
+			logrus.WithError(err).Errorf("error sending alert %s", a.Rule)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	NotifierStdout  = "stdout"
+	NotifierWebhook = "webhook"
+	NotifierCommand = "command"
+)
+
+// DefaultNotifyTimeout bounds a webhook request or a command run.
+const DefaultNotifyTimeout = 10 * time.Second
+
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// NotifierConfig configures one notifier. URL and Headers apply to webhooks,
+// Command to commands.
+type NotifierConfig struct {
+	Type    string            `json:"type"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Command []string          `json:"command,omitempty"`
+	Timeout metav1.Duration   `json:"timeout,omitempty"`
+}
+
+func (n NotifierConfig) validate() error {
+	switch n.Type {
+	case NotifierStdout:
+	case NotifierWebhook:
+		if n.URL == "" {
+			return fmt.Errorf("webhook needs a url")
+		}
+	case NotifierCommand:
+		if len(n.Command) == 0 {
+			return fmt.Errorf("command needs a command to run")
+		}
+	default:
+		return fmt.Errorf("unknown type %q, expected %s, %s or %s", n.Type, NotifierStdout, NotifierWebhook, NotifierCommand)
+	}
+	if n.Timeout.Duration < 0 {
+		return fmt.Errorf("timeout must be positive, got %s", n.Timeout.Duration)
+	}
+	return nil
+}
+
+// NewNotifiers builds the configured notifiers. Stdout notifiers write to
+// stdout, and are left out when it is nil (e.g. while the TUI owns the
+// terminal).
+func NewNotifiers(cfgs []NotifierConfig, stdout io.Writer) []Notifier {
+	var notifiers []Notifier
+	for _, c := range cfgs {
+		timeout := c.Timeout.Duration
+		if timeout == 0 {
+			timeout = DefaultNotifyTimeout
+		}
+		switch c.Type {
+		case NotifierStdout:
+			if stdout != nil {
+				notifiers = append(notifiers, &WriterNotifier{W: stdout})
+			}
+		case NotifierWebhook:
+			notifiers = append(notifiers, &WebhookNotifier{
+				URL:     c.URL,
+				Headers: c.Headers,
+				Client:  &http.Client{Timeout: timeout},
+			})
+		case NotifierCommand:
+			notifiers = append(notifiers, &CommandNotifier{Command: c.Command, Timeout: timeout})
+		}
+	}
+	return notifiers
+}
+
+// WriterNotifier prints one line per alert.
+type WriterNotifier struct {
+	W io.Writer
+}
+
+func (n *WriterNotifier) Notify(ctx context.Context, a Alert) error {
+	_, err := fmt.Fprintf(n.W, "%s ALERT [%s] %s\n", a.Time.Format(time.RFC3339), a.Rule, a.Message)
+	return err
+}
+
+// WebhookNotifier POSTs each alert as JSON.
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %w", n.URL, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %s", n.URL, resp.Status)
+	}
+	return nil
+}
+
+// CommandNotifier runs a local command for each alert, with the alert as
+// JSON on its stdin and its fields in CLYDE_ALERT_* env vars.
+type CommandNotifier struct {
+	Command []string
+	Timeout time.Duration
+}
+
+func (n *CommandNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, n.Command[0], n.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(append(body, '\n'))
+	cmd.Env = append(os.Environ(),
+		"CLYDE_ALERT_RULE="+a.Rule,
+		"CLYDE_ALERT_TYPE="+string(a.Type),
+		"CLYDE_ALERT_MESSAGE="+a.Message,
+		"CLYDE_ALERT_KEY="+a.Key,
+		"CLYDE_ALERT_TIME="+a.Time.Format(time.RFC3339),
+		"CLYDE_ALERT_VALUE="+strconv.FormatFloat(a.Value, 'g', -1, 64),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("command %s: %w: %s", n.Command[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
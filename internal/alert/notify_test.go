@@ -0,0 +1,95 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAlert = Alert{
+	Rule:    "deny-prod",
+	Type:    RuleDeny,
+	Message: "denied flow dev/web -> prod/db:443",
+	Key:     "dev|web|prod|db|tcp|443",
+	Time:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+func TestWriterNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	n := NewNotifiers([]NotifierConfig{{Type: NotifierStdout}}, &buf)
+	if len(n) != 1 {
+		t.Fatalf("expected 1 notifier, got %d", len(n))
+	}
+	if err := n[0].Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+	want := "2026-01-01T00:00:00Z ALERT [deny-prod] denied flow dev/web -> prod/db:443\n"
+	if buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+	if n := NewNotifiers([]NotifierConfig{{Type: NotifierStdout}}, nil); len(n) != 0 {
+		t.Errorf("expected no stdout notifier without stdout, got %d", len(n))
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got Alert
+	var auth string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n := NewNotifiers([]NotifierConfig{{
+		Type: NotifierWebhook, URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer x"},
+	}}, nil)[0]
+	if err := n.Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+	if got != testAlert || auth != "Bearer x" {
+		t.Errorf("expected %+v with the auth header, got %+v and %q", testAlert, got, auth)
+	}
+
+	status = http.StatusInternalServerError
+	if err := n.Notify(context.Background(), testAlert); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected a 500 error, got %v", err)
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "alert")
+	n := NewNotifiers([]NotifierConfig{{
+		Type:    NotifierCommand,
+		Command: []string{"sh", "-c", `{ echo "$CLYDE_ALERT_RULE $CLYDE_ALERT_TYPE"; cat; } > "$0"`, out},
+	}}, nil)[0]
+	if err := n.Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, body, _ := strings.Cut(string(data), "\n")
+	var got Alert
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if env != "deny-prod deny" || got != testAlert {
+		t.Errorf("expected the alert in env and on stdin, got %q and %+v", env, got)
+	}
+
+	fail := &CommandNotifier{Command: []string{"sh", "-c", "echo nope >&2; exit 3"}, Timeout: time.Second}
+	if err := fail.Notify(context.Background(), testAlert); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("expected the command output in the error, got %v", err)
+	}
+}
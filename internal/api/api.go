@@ -0,0 +1,256 @@
+// Package api serves the captured flows as a read-only HTTP/JSON API.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/sirupsen/logrus"
+)
+
+// Querier is what the API reads flows through, usually a FlowCache.
+type Querier interface {
+	QueryFlowSums(filter flowdata.FilterAttributes, sortBy string, asc bool) []*flowdata.FlowSum
+	QueryFlowsBySumID(sumID int, filter flowdata.FilterAttributes) []*flowdata.FlowData
+}
+
+// Store looks up single records by ID.
+type Store interface {
+	GetFlowSum(id int) *flowdata.FlowSum
+	GetFlowDetail(id int) *flowdata.FlowData
+}
+
+// Event is a flow or flow summary re-broadcast on the events endpoint.
+type Event struct {
+	Name string
+	Data any
+}
+
+const (
+	EventFlow    = "flow"
+	EventFlowSum = "flowsum"
+)
+
+// subscriberBuffer is how many events a slow client can fall behind before
+// events are dropped for it.
+const subscriberBuffer = 64
+
+type Server struct {
+	q     Querier
+	store Store
+
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func New(q Querier, store Store) *Server {
+	return &Server{q: q, store: store, subscribers: map[chan Event]struct{}{}}
+}
+
+// Handler returns the routes of the API:
+//
+//	GET /api/v1/sums/totals     flow summaries, sorted by ?sort= (default none)
+//	GET /api/v1/sums/rates      flow summaries, sorted by ?sort= (default source_total_packet_rate)
+//	GET /api/v1/sums/{id}       one flow summary
+//	GET /api/v1/sums/{id}/flows the flows of a summary
+//	GET /api/v1/flows/{id}      one flow with its policy trace
+//	GET /api/v1/events          SSE stream of flow and flowsum events
+//
+// The list endpoints take the filter keys (cluster, action, port, namespace,
+// name, label, from and to) as query parameters, and ?asc=true to sort ascending.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/sums/totals", s.sums(""))
+	mux.HandleFunc("GET /api/v1/sums/rates", s.sums("SourceTotalPacketRate"))
+	mux.HandleFunc("GET /api/v1/sums/{id}", s.sum)
+	mux.HandleFunc("GET /api/v1/sums/{id}/flows", s.flowsBySum)
+	mux.HandleFunc("GET /api/v1/flows/{id}", s.flow)
+	mux.HandleFunc("GET /api/v1/events", s.events)
+	return mux
+}
+
+// Broadcast re-broadcasts the flows, and the flow summaries they add, from
+// the store subscription to the events subscribers until it is closed.
+func (s *Server) Broadcast(ingested <-chan flowdata.Ingested) {
+	for in := range ingested {
+		s.publish(Event{Name: EventFlow, Data: in.Flow})
+		if in.NewSum {
+			s.publish(Event{Name: EventFlowSum, Data: in.Sum})
+		}
+	}
+}
+
+func (s *Server) publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			logrus.Debugf("api: dropping %s event for a slow subscriber", e.Name)
+		}
+	}
+}
+
+func (s *Server) subscribe() chan Event {
+	ch := make(chan Event, subscriberBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (s *Server) unsubscribe(ch chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, ch)
+}
+
+func (s *Server) sums(defaultSort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter, err := parseFilter(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		sortBy := defaultSort
+		if v := query.Get("sort"); v != "" {
+			if sortBy, err = flowdata.SumSortField(v); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		asc, err := parseBool(query, "asc")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, s.q.QueryFlowSums(filter, sortBy, asc))
+	}
+}
+
+func (s *Server) sum(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	fs := s.store.GetFlowSum(id)
+	if fs == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("flow summary %d not found", id))
+		return
+	}
+	writeJSON(w, fs)
+}
+
+func (s *Server) flowsBySum(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, s.q.QueryFlowsBySumID(id, filter))
+}
+
+func (s *Server) flow(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	fd := s.store.GetFlowDetail(id)
+	if fd == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("flow %d not found", id))
+		return
+	}
+	writeJSON(w, fd)
+}
+
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				logrus.WithError(err).Errorf("api: error encoding %s event", e.Name)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// parseFilter maps the filter query parameters onto FilterAttributes, using
+// the same keys as flowdata.ParseFilter.
+func parseFilter(query url.Values) (flowdata.FilterAttributes, error) {
+	var pairs []string
+	for _, k := range flowdata.FilterKeys {
+		if v := query.Get(k); v != "" {
+			if strings.Contains(v, ",") {
+				return flowdata.FilterAttributes{}, fmt.Errorf("invalid %s %q: commas are not allowed", k, v)
+			}
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	return flowdata.ParseFilter(strings.Join(pairs, ","))
+}
+
+func parseBool(query url.Values, key string) (bool, error) {
+	v := query.Get(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return b, nil
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid id %q", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithError(err).Error("api: error writing response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+)
+
+type fakeStore struct {
+	sums  []*flowdata.FlowSum
+	flows []*flowdata.FlowData
+
+	filter flowdata.FilterAttributes
+	sortBy string
+	asc    bool
+}
+
+func (f *fakeStore) QueryFlowSums(filter flowdata.FilterAttributes, sortBy string, asc bool) []*flowdata.FlowSum {
+	f.filter, f.sortBy, f.asc = filter, sortBy, asc
+	return f.sums
+}
+
+func (f *fakeStore) QueryFlowsBySumID(sumID int, filter flowdata.FilterAttributes) []*flowdata.FlowData {
+	f.filter = filter
+	var flows []*flowdata.FlowData
+	for _, fd := range f.flows {
+		if fd.SumID == sumID {
+			flows = append(flows, fd)
+		}
+	}
+	return flows
+}
+
+func (f *fakeStore) GetFlowSum(id int) *flowdata.FlowSum {
+	for _, fs := range f.sums {
+		if fs.ID == id {
+			return fs
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) GetFlowDetail(id int) *flowdata.FlowData {
+	for _, fd := range f.flows {
+		if fd.ID == id {
+			return fd
+		}
+	}
+	return nil
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		sums:  []*flowdata.FlowSum{{ID: 1, SourceName: "cart"}, {ID: 2, SourceName: "web"}},
+		flows: []*flowdata.FlowData{{ID: 10, SumID: 1}, {ID: 11, SumID: 1}, {ID: 12, SumID: 2}},
+	}
+}
+
+func TestServer_Endpoints(t *testing.T) {
+	store := newFakeStore()
+	srv := httptest.NewServer(New(store, store).Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+		check      func(t *testing.T)
+	}{
+		{
+			name:       "totals with filter and sort",
+			path:       "/api/v1/sums/totals?action=deny&namespace=prod&port=443&sort=source_name&asc=true",
+			wantStatus: http.StatusOK,
+			wantBody:   `"source_name":"cart"`,
+			check: func(t *testing.T) {
+				want := flowdata.FilterAttributes{Action: "Deny", Namespace: "prod", Port: 443}
+				if store.filter != want || store.sortBy != "SourceName" || !store.asc {
+					t.Errorf("expected %+v sorted by SourceName ascending, got %+v by %s asc=%v", want, store.filter, store.sortBy, store.asc)
+				}
+			},
+		},
+		{
+			name:       "rates sort by rate by default",
+			path:       "/api/v1/sums/rates",
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T) {
+				if store.sortBy != "SourceTotalPacketRate" || store.asc {
+					t.Errorf("expected a descending SourceTotalPacketRate sort, got %s asc=%v", store.sortBy, store.asc)
+				}
+			},
+		},
+		{name: "bad sort", path: "/api/v1/sums/totals?sort=bogus", wantStatus: http.StatusBadRequest, wantBody: `invalid sort field`},
+		{name: "bad filter", path: "/api/v1/sums/totals?action=maybe", wantStatus: http.StatusBadRequest, wantBody: `invalid action`},
+		{name: "bad asc", path: "/api/v1/sums/rates?asc=sure", wantStatus: http.StatusBadRequest, wantBody: `invalid asc`},
+		{name: "one sum", path: "/api/v1/sums/2", wantStatus: http.StatusOK, wantBody: `"source_name":"web"`},
+		{name: "missing sum", path: "/api/v1/sums/9", wantStatus: http.StatusNotFound, wantBody: `flow summary 9 not found`},
+		{name: "flows by sum", path: "/api/v1/sums/1/flows", wantStatus: http.StatusOK, wantBody: `"sum_id":1`},
+		{name: "bad id", path: "/api/v1/sums/one/flows", wantStatus: http.StatusBadRequest, wantBody: `invalid id`},
+		{name: "flow detail", path: "/api/v1/flows/12", wantStatus: http.StatusOK, wantBody: `"sum_id":2`},
+		{name: "missing flow", path: "/api/v1/flows/99", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var body json.RawMessage
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("expected a JSON body, got %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, resp.StatusCode, body)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("expected body to contain %s, got %s", tt.wantBody, body)
+			}
+			if tt.check != nil {
+				tt.check(t)
+			}
+		})
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	store := newFakeStore()
+	s := New(store, store)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ingested := make(chan flowdata.Ingested)
+	defer close(ingested)
+	go s.Broadcast(ingested)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected an event stream, got %q", ct)
+	}
+
+	// The subscription is registered before the headers are sent
+	fd := store.flows[2]
+	ingested <- flowdata.Ingested{Flow: fd, Sum: store.GetFlowSum(fd.SumID), NewSum: true}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				lines <- line
+			}
+		}
+	}()
+	var got []string
+	for len(got) < 4 {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for events, got %v", got)
+		}
+	}
+	if got[0] != "event: flow" || !strings.Contains(got[1], `"id":12`) {
+		t.Errorf("expected the flow event first, got %v", got[:2])
+	}
+	if got[2] != "event: flowsum" || !strings.Contains(got[3], `"source_name":"web"`) {
+		t.Errorf("expected the flow summary of the flow next, got %v", got[2:])
+	}
+}
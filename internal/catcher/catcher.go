@@ -2,8 +2,8 @@
 package catcher
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,6 +15,7 @@ import (
 	"github.com/doucol/clyde/internal/cmdctx"
 	"github.com/doucol/clyde/internal/util"
 	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/transport/spdy"
 )
@@ -43,6 +44,59 @@ type DataCatcher struct {
 	PortEnvVarName string
 	recoverFunc    func()
 	URLFull        string
+	// Recorder, when set, receives every data payload before the catcher does
+	Recorder *Recorder
+	// Cluster names the kube context the payloads are recorded from
+	Cluster string
+	// Skip, when set, drops the payloads it returns true for before they are
+	// recorded or caught
+	Skip func(data string) bool
+	// MaxEventSize bounds the size of an event, DefaultMaxEventSize when 0
+	MaxEventSize int
+	// Resume, when set, carries the last event ID and the retry time the
+	// server asked for from one connection to the next
+	Resume *Resume
+	// OnState, when set, is told each state the connection goes through
+	OnState func(State)
+	// Mode is how the stream is reached when URLFull isn't set, ConnectAuto
+	// when empty
+	Mode string
+	// Service is the service, as name or name:port, streamed through in the
+	// ConnectServiceProxy mode
+	Service string
+	// HTTP, when set, configures the TLS, credentials, proxy and timeouts
+	// URLFull is connected with
+	HTTP *HTTPOptions
+	// Forwarder, when set, keeps the port-forward to a Ready pod up across
+	// connections, instead of each connection port-forwarding to the first
+	// pod listed
+	Forwarder *Forwarder
+
+	client   *http.Client
+	streamed bool
+}
+
+const (
+	// ConnectAuto streams through the service proxy, falling back to
+	// port-forwarding when the service proxy can't be reached
+	ConnectAuto = "auto"
+	// ConnectPortForward port-forwards to a pod running the container,
+	// which needs the pods/portforward permission
+	ConnectPortForward = "port-forward"
+	// ConnectServiceProxy streams through the API server's proxy to the
+	// service, which only needs the services/proxy permission and follows
+	// the pods behind the service as they are rescheduled
+	ConnectServiceProxy = "service-proxy"
+)
+
+// Resume is the state a stream is resumed from after a reconnect.
+type Resume struct {
+	// LastEventID is sent as the Last-Event-ID header, so the server can
+	// pick up after the last event we got
+	LastEventID string
+	// Retry is how long the server asked clients to wait before
+	// reconnecting, 0 when it didn't
+	Retry time.Duration
 }
 
 func NewDataCatcher(namespace, containerName, urlPath string, catcher CatcherFunc, recover func()) *DataCatcher {
@@ -56,6 +110,18 @@ func NewDataCatcher(namespace, containerName, urlPath string, catcher CatcherFun
 	}
 }
 
+// state reports the state the connection is in.
+func (dc *DataCatcher) state(s State) {
+	if s == StateStreaming {
+		dc.streamed = true
+	}
+	if dc.OnState != nil {
+		dc.OnState(s)
+	}
+}
+
+// CatchServerSentEvents catches the events until ctx is done or the stream
+// ends, and returns why it ended.
 func (dc *DataCatcher) CatchServerSentEvents(ctx context.Context, sseReady chan bool) error {
 	select {
 	case <-ctx.Done():
@@ -65,6 +131,18 @@ func (dc *DataCatcher) CatchServerSentEvents(ctx context.Context, sseReady chan
 		logrus.Debug("entering data catcher")
 	}
 
+	// Without a service to go through, auto port-forwards
+	if dc.URLFull == "" && (dc.Mode == ConnectServiceProxy || dc.Mode != ConnectPortForward && dc.Service != "") {
+		err := dc.catchThroughServiceProxy(ctx, sseReady)
+		if dc.Mode == ConnectServiceProxy || err == nil || dc.streamed || ctx.Err() != nil {
+			return err
+		}
+		logrus.Debugf("service proxy unavailable, falling back to port-forwarding: %s", err.Error())
+	}
+	if dc.URLFull == "" && dc.Forwarder != nil {
+		return dc.catchThroughForwarder(ctx, sseReady)
+	}
+
 	wg := &sync.WaitGroup{}
 
 	// Channels for port forward signaling
@@ -76,7 +154,7 @@ func (dc *DataCatcher) CatchServerSentEvents(ctx context.Context, sseReady chan
 		logrus.Debug("exited data catcher")
 	}()
 
-	var err error
+	var err, consumeErr error
 	sseURL := dc.URLFull
 	if sseURL == "" {
 		sseURL, err = dc.portFoward(ctx, stopChan, readyChan, wg)
@@ -84,6 +162,12 @@ func (dc *DataCatcher) CatchServerSentEvents(ctx context.Context, sseReady chan
 			return err
 		}
 	} else {
+		if dc.HTTP != nil {
+			if dc.client, err = dc.HTTP.Client(sseURL); err != nil {
+				return err
+			}
+			defer func() { dc.client = nil }()
+		}
 		readyChan <- struct{}{}
 	}
 
@@ -96,11 +180,12 @@ func (dc *DataCatcher) CatchServerSentEvents(ctx context.Context, sseReady chan
 		case <-readyChan:
 			// Wait for the port forwarding to be ready
 			logrus.Debug("SSE server is ready, now starting SSE consumer")
-			if err := dc.consumeSSEStream(ctx, sseURL, stopChan, sseReady); err != nil {
-				logrus.Debugf("error: ConsumeSSEStream return error: %s", err.Error())
+			if consumeErr = dc.consumeSSEStream(ctx, sseURL, stopChan, sseReady); consumeErr != nil {
+				logrus.Debugf("error: ConsumeSSEStream return error: %s", consumeErr.Error())
 			}
 		case <-time.After(5 * time.Second):
-			logrus.Debug("timeout waiting for port forward to be ready")
+			consumeErr = errors.New("timeout waiting for port forward to be ready")
+			logrus.Debug(consumeErr.Error())
 		}
 		logrus.Debug("sse consumer has stopped")
 	}()
@@ -116,7 +201,7 @@ func (dc *DataCatcher) CatchServerSentEvents(ctx context.Context, sseReady chan
 
 	wg.Wait()
 	logrus.Debug("all goroutines have exited, now exiting data catcher")
-	return nil
+	return consumeErr
 }
 
 func (dc *DataCatcher) portFoward(ctx context.Context, stopChan, readyChan chan struct{}, wg *sync.WaitGroup) (string, error) {
@@ -124,30 +209,14 @@ func (dc *DataCatcher) portFoward(ctx context.Context, stopChan, readyChan chan
 	clientset := cmdctx.K8sClientsetFromContext(ctx)
 
 	// URL for the portforward endpoint on the pod
+	dc.state(StateResolvingPod)
 	podName, port, err := util.GetPodAndEnvVarByContainerName(ctx, clientset, dc.namespace, dc.containerName, dc.PortEnvVarName)
 	if err != nil {
 		return "", err
 	}
 
-	apiURL, _ := url.Parse(fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/portforward", config.Host, dc.namespace, podName))
-
-	// Dialer for establishing the connection
-	logrus.Debugf("apiURL: %s", apiURL)
-	transport, upgrader, err := spdy.RoundTripperFor(config)
-	if err != nil {
-		return "", err
-	}
-
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, apiURL)
-
-	// Port mappings (local port: pod port)
-	freePort, err := util.GetFreePort()
-	if err != nil {
-		return "", err
-	}
-	ports := []string{fmt.Sprintf("%d:%s", freePort, port)}
-
-	pf, err := portforward.New(dialer, ports, stopChan, readyChan, pfOut{}, pfErr{})
+	dc.state(StatePortForwarding)
+	pf, freePort, err := PortForward(config, dc.namespace, podName, port, stopChan, readyChan)
 	if err != nil {
 		return "", err
 	}
@@ -169,12 +238,126 @@ func (dc *DataCatcher) portFoward(ctx context.Context, stopChan, readyChan chan
 	return sseURL, nil
 }
 
+// catchThroughServiceProxy streams the events through the API server's proxy
+// to the service.
+func (dc *DataCatcher) catchThroughServiceProxy(ctx context.Context, sseReady chan bool) error {
+	config, err := cmdctx.CmdCtxFromContext(ctx).K8sConfig()
+	if err != nil {
+		return err
+	}
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return err
+	}
+	dc.client = client
+	defer func() { dc.client = nil }()
+	sseURL := ServiceProxyURL(config, dc.namespace, dc.Service, dc.urlPath)
+	return dc.consumeSSEStream(ctx, sseURL, make(chan struct{}), sseReady)
+}
+
+// catchThroughForwarder streams the events through the port-forward the
+// Forwarder keeps up.
+func (dc *DataCatcher) catchThroughForwarder(ctx context.Context, sseReady chan bool) error {
+	addr, done, err := dc.Forwarder.Addr(ctx, dc.state)
+	if err != nil {
+		return err
+	}
+	err = dc.consumeSSEStream(ctx, "http://"+addr+dc.urlPath, done, sseReady)
+	if err != nil && !dc.streamed {
+		// The forward itself may be broken, so the next connection starts
+		// another one
+		dc.Forwarder.Drop(done)
+	}
+	return err
+}
+
+// ServiceProxyURL is the URL of the path on the service (name or name:port)
+// through the API server's service proxy.
+func ServiceProxyURL(config *rest.Config, namespace, service, path string) string {
+	return fmt.Sprintf("%s/api/v1/namespaces/%s/services/%s/proxy%s", strings.TrimSuffix(config.Host, "/"), namespace, service, path)
+}
+
+// PortForward prepares a port forward from a free local port to the given
+// port of the pod. Call ForwardPorts on the result to start it.
+func PortForward(config *rest.Config, namespace, podName, port string, stopChan, readyChan chan struct{}) (*portforward.PortForwarder, int, error) {
+	apiURL, _ := url.Parse(fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/portforward", config.Host, namespace, podName))
+
+	// Dialer for establishing the connection
+	logrus.Debugf("apiURL: %s", apiURL)
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, apiURL)
+
+	// Port mappings (local port: pod port)
+	freePort, err := util.GetFreePort()
+	if err != nil {
+		return nil, 0, err
+	}
+	ports := []string{fmt.Sprintf("%d:%s", freePort, port)}
+
+	pf, err := portforward.New(dialer, ports, stopChan, readyChan, pfOut{}, pfErr{})
+	if err != nil {
+		return nil, 0, err
+	}
+	return pf, freePort, nil
+}
+
 // consumeSSEStream connects to an SSE endpoint and processes events.
-func (dc *DataCatcher) consumeSSEStream(ctx context.Context, url string, stopChan chan struct{}, sseReady chan bool) error {
+func (dc *DataCatcher) consumeSSEStream(ctx context.Context, url string, stopChan <-chan struct{}, sseReady chan bool) error {
 	logrus.Debugf("Connecting to SSE stream at %s", url)
 	util.ChanClose(sseReady)
-	resp, err := http.Get(url)
+
+	// Closing the connection is what unblocks the reader, so the request is
+	// cancelled when we are told to stop
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	dc.state(StateConnecting)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if dc.Resume != nil && dc.Resume.LastEventID != "" {
+		req.Header.Set("Last-Event-ID", dc.Resume.LastEventID)
+	}
+	var connectTimeout, idleTimeout time.Duration
+	if dc.HTTP != nil {
+		for name, value := range dc.HTTP.Headers {
+			req.Header.Set(name, value)
+		}
+		connectTimeout, idleTimeout = dc.HTTP.ConnectTimeout.Duration, dc.HTTP.IdleTimeout.Duration
+	}
+	client := dc.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var connectTimer *time.Timer
+	if connectTimeout > 0 {
+		connectTimer = time.AfterFunc(connectTimeout, cancel)
+	}
+	resp, err := client.Do(req)
+	if connectTimer != nil && !connectTimer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return fmt.Errorf("failed to connect to SSE stream: no response within %s", connectTimeout)
+	}
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
 		return fmt.Errorf("failed to connect to SSE stream: %w", err)
 	}
 	logrus.Debug("SSE stream responded")
@@ -187,65 +370,54 @@ func (dc *DataCatcher) consumeSSEStream(ctx context.Context, url string, stopCha
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
+	// A service that only serves the UI answers with a page, not a stream
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		return fmt.Errorf("unexpected content type %q, not text/event-stream", ct)
+	}
+	dc.state(StateStreaming)
+
+	var body io.Reader = resp.Body
+	var idle *idleReader
+	if idleTimeout > 0 {
+		idle = newIdleReader(resp.Body, idleTimeout, cancel)
+		defer idle.stop()
+		body = idle
+	}
+	er := NewEventReader(body, dc.MaxEventSize)
+	if dc.Resume != nil {
+		er.lastID = dc.Resume.LastEventID
+		defer func() {
+			dc.Resume.LastEventID = er.LastEventID()
+			if retry := er.Retry(); retry > 0 {
+				dc.Resume.Retry = retry
+			}
+		}()
+	}
 
-	var scanDone chan bool
-	defer util.ChanClose(scanDone)
-	scanner := bufio.NewScanner(resp.Body)
-	ssePrefixes := []string{"id:", "event:", "retry:", "message:", ":"}
-
-	// Read the SSE stream line by line
 	logrus.Debug("Entering SSE stream consumer loop")
 	for {
-		util.ChanClose(scanDone)
-		scanDone = make(chan bool, 1)
-		go func() {
-			defer dc.recoverFunc()
-			scanDone <- scanner.Scan()
-		}()
-
-		select {
-		case <-stopChan:
-			return nil
-		case <-ctx.Done():
-			return nil
-		case hasMore := <-scanDone:
-			if !hasMore {
-				return nil
-			}
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
-				continue
+		ev, err := er.Next()
+		if err != nil {
+			if idle != nil && idle.stop() {
+				return fmt.Errorf("%w: nothing read for %s", ErrIdleTimeout, idleTimeout)
 			}
-
-			// Parse the event data
-			// We don't handle multi-line events yet i.e. 'data: foo'\n'data: continuation of foo`
-			if strings.HasPrefix(line, "data:") {
-				logrus.Tracef("Stream data received: %s", line)
-				data := strings.TrimPrefix(line, "data:")
-				data = strings.TrimSpace(data)
-				if err := dc.catcher(data); err != nil {
-					return err
-				}
-			} else {
-				prefix := "unknown:"
-				for _, p := range ssePrefixes {
-					if strings.HasPrefix(line, p) {
-						prefix = p
-						break
-					}
-				}
-				logrus.Debugf("SSE %s %s", prefix, line)
+			if ctx.Err() != nil || util.IsErr(err, io.EOF, io.ErrUnexpectedEOF) {
+				logrus.Debug("SSE stream ended, exiting now")
+				return nil
 			}
-			// Handle any errors during scanning
-			err = scanner.Err()
-			if err != nil {
-				if util.IsErr(err, io.EOF, bufio.ErrFinalToken, io.ErrUnexpectedEOF) {
-					logrus.Debug("EOF or final token received from scanner in ConsumeSSEStream, exiting now")
-					return nil
-				} else {
-					return err
-				}
+			return err
+		}
+		logrus.Tracef("Stream %s event %s received: %s", ev.Type, ev.ID, ev.Data)
+		if dc.Skip != nil && dc.Skip(ev.Data) {
+			continue
+		}
+		if dc.Recorder != nil {
+			if err := dc.Recorder.Record(dc.Cluster, ev.Data); err != nil {
+				logrus.WithError(err).Error("error recording SSE data")
 			}
 		}
+		if err := dc.catcher(ev.Data); err != nil {
+			return err
+		}
 	}
 }
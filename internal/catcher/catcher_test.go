@@ -3,11 +3,18 @@ package catcher
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
 )
 
 // mockCatcher is a simple CatcherFunc for testing
@@ -63,7 +70,7 @@ func TestDataCatcher_consumeSSEStream_HandlesNon200(t *testing.T) {
 
 func TestDataCatcher_consumeSSEStream_HandlesDataAndOtherLines(t *testing.T) {
 	var received []string
-	body := "id: 1\nevent: test\ndata: foo\nmessage: bar\ndata: bar\n\n"
+	body := "id: 1\nevent: test\ndata: foo\nmessage: bar\ndata: bar\n\ndata: baz\n\n"
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.WriteHeader(http.StatusOK)
@@ -78,8 +85,9 @@ func TestDataCatcher_consumeSSEStream_HandlesDataAndOtherLines(t *testing.T) {
 	if err != nil {
 		t.Fatalf("consumeSSEStream returned error: %v", err)
 	}
-	if len(received) != 2 || received[0] != "foo" || received[1] != "bar" {
-		t.Errorf("Expected to receive ['foo', 'bar'], got: %v", received)
+	// The data lines of an event are joined, unknown fields ignored
+	if len(received) != 2 || received[0] != "foo\nbar" || received[1] != "baz" {
+		t.Errorf("Expected to receive ['foo\\nbar', 'baz'], got: %q", received)
 	}
 }
 
@@ -136,3 +144,94 @@ func TestDataCatcher_consumeSSEStream_StopChan(t *testing.T) {
 		t.Errorf("Expected nil error when stopChan is closed, got: %v", err)
 	}
 }
+
+// apiServerContext returns a context whose kubeconfig points at server.
+func apiServerContext(t *testing.T, server *httptest.Server) context.Context {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+- name: test
+  context: {cluster: test, user: test}
+clusters:
+- name: test
+  cluster: {server: %q, insecure-skip-tls-verify: true}
+users:
+- name: test
+  user: {token: abc}
+`, server.URL)
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cmdctx.NewCmdCtx(path, "flag", "").ToContext(context.Background())
+}
+
+func TestDataCatcher_ServiceProxy(t *testing.T) {
+	proxyStatus := http.StatusOK
+	proxyHTML := false
+	var paths []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/calico-system/services/whisker:8081/proxy/flows":
+			if r.Header.Get("Authorization") != "Bearer abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if proxyHTML {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(proxyStatus)
+				w.Write([]byte("<html><body>Whisker</body></html>"))
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(proxyStatus)
+			w.Write([]byte("data: proxied\n\n"))
+		case strings.HasSuffix(r.URL.Path, "/pods"):
+			// No whisker pod to port-forward to
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","items":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		mode        string
+		proxyStatus int
+		proxyHTML   bool
+		want        []string
+		wantErr     bool
+		wantPods    bool
+	}{
+		{name: "auto streams through the proxy", mode: ConnectAuto, proxyStatus: http.StatusOK, want: []string{"proxied"}},
+		{name: "auto falls back to port-forwarding", mode: ConnectAuto, proxyStatus: http.StatusForbidden, wantErr: true, wantPods: true},
+		// The service only serves the UI
+		{name: "auto falls back from a page", mode: ConnectAuto, proxyStatus: http.StatusOK, proxyHTML: true, wantErr: true, wantPods: true},
+		{name: "service proxy only", mode: ConnectServiceProxy, proxyStatus: http.StatusForbidden, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyStatus, proxyHTML, paths = tt.proxyStatus, tt.proxyHTML, nil
+			var received []string
+			dc := NewDataCatcher("calico-system", "whisker-backend", "/flows", mockCatcher(&received), func() {})
+			dc.Mode = tt.mode
+			dc.Service = "whisker:8081"
+			err := dc.CatchServerSentEvents(apiServerContext(t, server), make(chan bool))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected an error %v, got %v", tt.wantErr, err)
+			}
+			if fmt.Sprint(received) != fmt.Sprint(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, received)
+			}
+			pods := slices.ContainsFunc(paths, func(p string) bool { return strings.HasSuffix(p, "/pods") })
+			if pods != tt.wantPods {
+				t.Errorf("expected looking up the pod to port-forward to be %v, got requests %v", tt.wantPods, paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package catcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+// HTTPOptions configure how a direct URL is connected to, e.g. a Whisker
+// backend exposed through an authenticated ingress. They are the TLS and
+// credential settings of a kubeconfig user and cluster, so tokens and
+// certificates can come from files, which are re-read as they rotate, or
+// from the same exec credential plugins kubectl runs.
+type HTTPOptions struct {
+	// CAFile is a PEM bundle the server certificate is verified against,
+	// instead of the system roots
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are the client certificate, for mutual TLS
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// ServerName is the name the server certificate is verified for, the
+	// host of the URL when empty
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	// BearerToken is sent in the Authorization header, read from
+	// BearerTokenFile when that is set instead
+	BearerToken     string `json:"bearerToken,omitempty"`
+	BearerTokenFile string `json:"bearerTokenFile,omitempty"`
+	// Exec runs a client.authentication.k8s.io credential plugin for a token
+	// or client certificate
+	Exec *clientcmdv1.ExecConfig `json:"exec,omitempty"`
+	// Headers are added to the request, for ingresses with their own auth
+	Headers map[string]string `json:"headers,omitempty"`
+	// ProxyURL sends the request through an HTTP proxy, instead of the one
+	// from the environment
+	ProxyURL string `json:"proxyURL,omitempty"`
+	// ConnectTimeout bounds connecting, up to the response headers
+	ConnectTimeout metav1.Duration `json:"connectTimeout,omitempty"`
+	// IdleTimeout drops a stream that nothing, not even a comment, was read
+	// from for that long
+	IdleTimeout metav1.Duration `json:"idleTimeout,omitempty"`
+}
+
+// ErrIdleTimeout is returned when a stream was idle past its IdleTimeout.
+var ErrIdleTimeout = errors.New("stream idle timeout")
+
+// Validate reports settings that can't work together.
+func (o *HTTPOptions) Validate() error {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return errors.New("certFile and keyFile must be set together")
+	}
+	if o.BearerToken != "" && o.BearerTokenFile != "" {
+		return errors.New("only one of bearerToken and bearerTokenFile can be set")
+	}
+	if o.Exec != nil && o.Exec.Command == "" {
+		return errors.New("exec needs a command")
+	}
+	if o.ProxyURL != "" {
+		if _, err := url.Parse(o.ProxyURL); err != nil {
+			return fmt.Errorf("invalid proxyURL: %w", err)
+		}
+	}
+	if o.ConnectTimeout.Duration < 0 || o.IdleTimeout.Duration < 0 {
+		return errors.New("timeouts must be positive")
+	}
+	return nil
+}
+
+// Client returns the HTTP client to connect to host (a URL) with.
+func (o *HTTPOptions) Client(host string) (*http.Client, error) {
+	config := &rest.Config{
+		Host:            host,
+		BearerToken:     o.BearerToken,
+		BearerTokenFile: o.BearerTokenFile,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile:     o.CAFile,
+			CertFile:   o.CertFile,
+			KeyFile:    o.KeyFile,
+			ServerName: o.ServerName,
+			Insecure:   o.InsecureSkipVerify,
+		},
+	}
+	if o.Exec != nil {
+		config.ExecProvider = &clientcmdapi.ExecConfig{}
+		if err := clientcmdv1.Convert_v1_ExecConfig_To_api_ExecConfig(o.Exec, config.ExecProvider, nil); err != nil {
+			return nil, err
+		}
+		if config.ExecProvider.InteractiveMode == "" {
+			config.ExecProvider.InteractiveMode = clientcmdapi.NeverExecInteractiveMode
+		}
+	}
+	if o.ProxyURL != "" {
+		proxy, err := url.Parse(o.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		config.Proxy = http.ProxyURL(proxy)
+	}
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP options for %s: %w", host, err)
+	}
+	return client, nil
+}
+
+// idleReader cancels the stream when nothing was read from it for a while.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	idle    atomic.Bool
+}
+
+// newIdleReader calls cancel once r is idle for timeout.
+func newIdleReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, func() {
+		ir.idle.Store(true)
+		cancel()
+	})
+	return ir
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+// stop stops the timer, and reports whether it went off.
+func (ir *idleReader) stop() bool {
+	ir.timer.Stop()
+	return ir.idle.Load()
+}
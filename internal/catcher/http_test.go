@@ -0,0 +1,235 @@
+package catcher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+// writeFile writes content to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string, perm os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clientCert returns a self-signed client certificate, and its PEM cert and
+// key.
+func clientCert(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "clyde"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestHTTPOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    HTTPOptions
+		wantErr string
+	}{
+		{name: "empty", opts: HTTPOptions{}},
+		{name: "cert without key", opts: HTTPOptions{CertFile: "tls.crt"}, wantErr: "certFile and keyFile"},
+		{name: "token twice", opts: HTTPOptions{BearerToken: "abc", BearerTokenFile: "token"}, wantErr: "only one of"},
+		{name: "exec without command", opts: HTTPOptions{Exec: &clientcmdv1.ExecConfig{}}, wantErr: "command"},
+		{name: "bad proxy", opts: HTTPOptions{ProxyURL: "http://[::1"}, wantErr: "proxyURL"},
+		{name: "negative timeout", opts: HTTPOptions{IdleTimeout: metav1.Duration{Duration: -time.Second}}, wantErr: "timeouts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDataCatcher_HTTPOptions(t *testing.T) {
+	cert, certPEM, keyPEM := clientCert(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization") + " " + r.Header.Get("X-Tenant"); got != "Bearer s3cret blue" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: authenticated\n\n"))
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	// The handshakes meant to fail would be logged otherwise
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := writeFile(t, dir, "ca.crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})), 0o600)
+	certFile := writeFile(t, dir, "tls.crt", certPEM, 0o600)
+	keyFile := writeFile(t, dir, "tls.key", keyPEM, 0o600)
+	tokenFile := writeFile(t, dir, "token", "s3cret\n", 0o600)
+	// The plugin hands out the client certificate as well as the token
+	credential, _ := json.Marshal(map[string]any{
+		"apiVersion": "client.authentication.k8s.io/v1",
+		"kind":       "ExecCredential",
+		"status":     map[string]string{"token": "s3cret", "clientCertificateData": certPEM, "clientKeyData": keyPEM},
+	})
+	credFile := writeFile(t, dir, "credential.json", string(credential), 0o600)
+	plugin := writeFile(t, dir, "plugin.sh", "#!/bin/sh\ncat "+credFile+"\n", 0o700)
+
+	tests := []struct {
+		name    string
+		opts    HTTPOptions
+		wantErr string
+	}{
+		{
+			name: "mTLS with a token file",
+			opts: HTTPOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, BearerTokenFile: tokenFile, Headers: map[string]string{"X-Tenant": "blue"}},
+		},
+		{
+			name: "credentials from an exec plugin",
+			opts: HTTPOptions{
+				CAFile: caFile, Headers: map[string]string{"X-Tenant": "blue"},
+				Exec: &clientcmdv1.ExecConfig{Command: plugin, APIVersion: "client.authentication.k8s.io/v1"},
+			},
+		},
+		{
+			name:    "unknown CA",
+			opts:    HTTPOptions{CertFile: certFile, KeyFile: keyFile, BearerToken: "s3cret"},
+			wantErr: "certificate",
+		},
+		{
+			name:    "no client certificate",
+			opts:    HTTPOptions{CAFile: caFile, BearerToken: "s3cret"},
+			wantErr: "failed to connect",
+		},
+		{
+			name:    "wrong token",
+			opts:    HTTPOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, BearerToken: "guess"},
+			wantErr: "unexpected status code: 401",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []string
+			dc := &DataCatcher{catcher: mockCatcher(&received), recoverFunc: func() {}, URLFull: server.URL + "/flows", HTTP: &tt.opts}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := dc.CatchServerSentEvents(ctx, make(chan bool))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || len(received) != 1 || received[0] != "authenticated" {
+				t.Errorf("expected the authenticated event, got %v and %v", received, err)
+			}
+		})
+	}
+}
+
+func TestDataCatcher_HTTPProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: via proxy\n\n"))
+	}))
+	defer proxy.Close()
+
+	var received []string
+	dc := &DataCatcher{
+		catcher: mockCatcher(&received), recoverFunc: func() {},
+		URLFull: "http://whisker.example/flows?watch=true",
+		HTTP:    &HTTPOptions{ProxyURL: proxy.URL},
+	}
+	if err := dc.CatchServerSentEvents(context.Background(), make(chan bool)); err != nil {
+		t.Fatal(err)
+	}
+	if proxied != "http://whisker.example/flows?watch=true" || fmt.Sprint(received) != "[via proxy]" {
+		t.Errorf("expected the request to go through the proxy, got %q and %v", proxied, received)
+	}
+}
+
+func TestDataCatcher_HTTPTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	timeout := metav1.Duration{Duration: 50 * time.Millisecond}
+	var received []string
+	dc := &DataCatcher{catcher: mockCatcher(&received), recoverFunc: func() {}, HTTP: &HTTPOptions{ConnectTimeout: timeout}}
+	err := dc.consumeSSEStream(context.Background(), server.URL+"/slow", make(chan struct{}), make(chan bool))
+	if err == nil || !strings.Contains(err.Error(), "no response within 50ms") {
+		t.Errorf("expected a connect timeout, got %v", err)
+	}
+
+	dc.HTTP = &HTTPOptions{IdleTimeout: timeout}
+	err = dc.consumeSSEStream(context.Background(), server.URL+"/idle", make(chan struct{}), make(chan bool))
+	if !errors.Is(err, ErrIdleTimeout) || fmt.Sprint(received) != "[first]" {
+		t.Errorf("expected an idle timeout after the first event, got %v and %v", err, received)
+	}
+}
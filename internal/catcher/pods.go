@@ -0,0 +1,362 @@
+package catcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/util"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Pod is a pod running the container streamed from, and the port it serves
+// the stream on.
+type Pod struct {
+	Name string
+	Port string
+}
+
+// PodWatcher follows the pods of a namespace with an informer, and selects
+// the one running the container to connect to. A Ready pod is preferred, and
+// the selected one is kept for as long as it stays Ready, so a rollout only
+// moves the stream once the old replica goes away.
+type PodWatcher struct {
+	clientset      kubernetes.Interface
+	namespace      string
+	containerName  string
+	portEnvVarName string
+
+	synced chan struct{}
+
+	mu       sync.Mutex
+	current  Pod
+	selected bool
+	changed  chan struct{}
+	// listErr is the last error listing or watching the pods failed with
+	listErr error
+}
+
+// podSyncTimeout bounds the wait for the pods to be listed first.
+const podSyncTimeout = 10 * time.Second
+
+func NewPodWatcher(clientset kubernetes.Interface, namespace, containerName, portEnvVarName string) *PodWatcher {
+	return &PodWatcher{
+		clientset:      clientset,
+		namespace:      namespace,
+		containerName:  containerName,
+		portEnvVarName: portEnvVarName,
+		synced:         make(chan struct{}),
+		changed:        make(chan struct{}),
+	}
+}
+
+// Run watches the pods until ctx is done.
+func (w *PodWatcher) Run(ctx context.Context) {
+	factory := informers.NewSharedInformerFactoryWithOptions(w.clientset, 0, informers.WithNamespace(w.namespace))
+	defer factory.Shutdown()
+	pods := factory.Core().V1().Pods()
+	lister := pods.Lister()
+	err := pods.Informer().SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.listErr = err
+	})
+	if err != nil {
+		logrus.WithError(err).Error("error watching pods")
+		return
+	}
+	_, err = pods.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { w.sync(lister) },
+		UpdateFunc: func(any, any) { w.sync(lister) },
+		DeleteFunc: func(any) { w.sync(lister) },
+	})
+	if err != nil {
+		logrus.WithError(err).Error("error watching pods")
+		return
+	}
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), pods.Informer().HasSynced) {
+		return
+	}
+	w.sync(lister)
+	close(w.synced)
+	<-ctx.Done()
+}
+
+// sync selects the pod to connect to out of the pods listed.
+func (w *PodWatcher) sync(lister corelisters.PodLister) {
+	list, err := lister.Pods(w.namespace).List(labels.Everything())
+	if err != nil {
+		logrus.WithError(err).Error("error listing pods")
+		return
+	}
+	pods := make([]corev1.Pod, len(list))
+	for i, pod := range list {
+		pods[i] = *pod
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var next Pod
+	var found, ready bool
+	for _, pod := range util.PodsByReadiness(pods) {
+		env, ok := util.ContainerEnvVars(pod, w.containerName, w.portEnvVarName)
+		if !ok || env[w.portEnvVarName] == "" {
+			continue
+		}
+		candidate := Pod{Name: pod.Name, Port: env[w.portEnvVarName]}
+		if !found {
+			next, found, ready = candidate, true, util.PodReady(pod)
+		}
+		// Stay on the selected pod while it is Ready, or while none is
+		if w.selected && candidate == w.current && (util.PodReady(pod) || !ready) {
+			next = candidate
+			break
+		}
+	}
+	if found == w.selected && next == w.current {
+		return
+	}
+	if found {
+		logrus.Debugf("selected pod %s/%s (ready: %t)", w.namespace, next.Name, ready)
+	}
+	w.current, w.selected = next, found
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// Current waits for the pods to be listed, and returns the selected one.
+func (w *PodWatcher) Current(ctx context.Context) (Pod, error) {
+	timer := time.NewTimer(podSyncTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.synced:
+	case <-ctx.Done():
+		return Pod{}, ctx.Err()
+	case <-timer.C:
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.listErr != nil {
+			return Pod{}, fmt.Errorf("failed to list the pods in namespace %s: %w", w.namespace, w.listErr)
+		}
+		return Pod{}, fmt.Errorf("timeout listing the pods in namespace %s", w.namespace)
+	}
+	pod, ok := w.Selected()
+	if !ok {
+		return Pod{}, fmt.Errorf("no pod running container %s found in namespace %s", w.containerName, w.namespace)
+	}
+	return pod, nil
+}
+
+// Selected returns the selected pod, if there is one.
+func (w *PodWatcher) Selected() (Pod, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current, w.selected
+}
+
+// Changed returns a channel that is closed once another pod is selected.
+func (w *PodWatcher) Changed() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.changed
+}
+
+// PortForwardFunc starts a port-forward to the pod, and returns the local
+// address it listens on, a channel that is closed once it stopped and a
+// func that stops it.
+type PortForwardFunc func(ctx context.Context, namespace string, pod Pod) (addr string, done <-chan struct{}, stop func(), err error)
+
+// forward is a running port-forward.
+type forward struct {
+	pod  Pod
+	addr string
+	done <-chan struct{}
+	stop func()
+}
+
+// Forwarder keeps a single port-forward up to the pod a PodWatcher selected,
+// which every stream of a cluster and every reconnect goes through. The
+// forward is stopped as soon as another pod is selected, which ends the
+// streams through it, so they reconnect to the new pod.
+type Forwarder struct {
+	namespace      string
+	containerName  string
+	portEnvVarName string
+	// Forward starts the port-forwards, PortForwardPod when nil
+	Forward PortForwardFunc
+
+	clientset kubernetes.Interface
+
+	mu      sync.Mutex
+	watcher *PodWatcher
+	cancel  context.CancelFunc
+	fw      *forward
+	// live is fw, for Pod to read while mu is held, e.g. while the states
+	// are reported
+	live atomic.Pointer[forward]
+}
+
+func NewForwarder(namespace, containerName, portEnvVarName string) *Forwarder {
+	return &Forwarder{namespace: namespace, containerName: containerName, portEnvVarName: portEnvVarName}
+}
+
+// Addr returns the local address of the port-forward to the selected pod,
+// and a channel that is closed once the forward stopped. The forward is
+// started when there isn't one up to that pod, and the pods are watched from
+// the first call on, with the kube context of ctx, until Close.
+func (f *Forwarder) Addr(ctx context.Context, report func(State)) (string, <-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	report(StateResolvingPod)
+	if f.watcher == nil {
+		clientset := f.clientset
+		if clientset == nil {
+			clientset = cmdctx.K8sClientsetFromContext(ctx)
+		}
+		f.watch(context.WithoutCancel(ctx), NewPodWatcher(clientset, f.namespace, f.containerName, f.portEnvVarName))
+	}
+	pod, err := f.watcher.Current(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if f.fw != nil {
+		select {
+		case <-f.fw.done:
+		default:
+			if f.fw.pod == pod {
+				return f.fw.addr, f.fw.done, nil
+			}
+		}
+		f.setForward(nil)
+	}
+
+	report(StatePortForwarding)
+	start := f.Forward
+	if start == nil {
+		start = PortForwardPod
+	}
+	addr, done, stop, err := start(ctx, f.namespace, pod)
+	if err != nil {
+		return "", nil, err
+	}
+	f.setForward(&forward{pod: pod, addr: addr, done: done, stop: stop})
+	return addr, done, nil
+}
+
+// watch starts the watcher, and switches pods whenever it selects another.
+func (f *Forwarder) watch(ctx context.Context, watcher *PodWatcher) {
+	ctx, f.cancel = context.WithCancel(ctx)
+	f.watcher = watcher
+	go watcher.Run(ctx)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-watcher.Changed():
+			}
+			f.mu.Lock()
+			if f.fw != nil {
+				if pod, ok := watcher.Selected(); !ok || pod != f.fw.pod {
+					logrus.Debugf("pod %s/%s is no longer selected, stopping its port-forward", f.namespace, f.fw.pod.Name)
+					f.setForward(nil)
+				}
+			}
+			f.mu.Unlock()
+		}
+	}()
+}
+
+// Drop stops the port-forward done belongs to, e.g. when a stream couldn't
+// be connected through it, so the next call to Addr starts another.
+func (f *Forwarder) Drop(done <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fw != nil && f.fw.done == done {
+		f.setForward(nil)
+	}
+}
+
+// Pod returns the name of the pod forwarded to, empty when no forward is up.
+func (f *Forwarder) Pod() string {
+	fw := f.live.Load()
+	if fw == nil {
+		return ""
+	}
+	select {
+	case <-fw.done:
+		return ""
+	default:
+		return fw.pod.Name
+	}
+}
+
+// setForward replaces the forward, which is stopped first.
+func (f *Forwarder) setForward(fw *forward) {
+	if f.fw != nil {
+		f.fw.stop()
+	}
+	f.fw = fw
+	f.live.Store(fw)
+}
+
+// Close stops the port-forward and watching the pods.
+func (f *Forwarder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.setForward(nil)
+}
+
+// PortForwardPod is the PortForwardFunc that port-forwards through the API
+// server of the kube context of ctx.
+func PortForwardPod(ctx context.Context, namespace string, pod Pod) (string, <-chan struct{}, func(), error) {
+	config, err := cmdctx.CmdCtxFromContext(ctx).K8sConfig()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	stopChan := make(chan struct{})
+	readyChan := make(chan struct{})
+	pf, freePort, err := PortForward(config, namespace, pod.Name, pod.Port, stopChan, readyChan)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	done := make(chan struct{})
+	var forwardErr error
+	go func() {
+		defer close(done)
+		logrus.Debugf("Starting port forward from localhost:%d to %s/%s:%s", freePort, namespace, pod.Name, pod.Port)
+		if forwardErr = pf.ForwardPorts(); forwardErr != nil {
+			logrus.Debugf("error: ForwardPorts return error: %s", forwardErr.Error())
+		}
+		logrus.Debug("port forward has stopped")
+	}()
+	stop := sync.OnceFunc(func() { close(stopChan) })
+	select {
+	case <-readyChan:
+		return fmt.Sprintf("localhost:%d", freePort), done, stop, nil
+	case <-done:
+		if forwardErr == nil {
+			forwardErr = fmt.Errorf("port forward to %s stopped", pod.Name)
+		}
+		return "", nil, nil, forwardErr
+	case <-time.After(5 * time.Second):
+		stop()
+		return "", nil, nil, fmt.Errorf("timeout waiting for port forward to %s to be ready", pod.Name)
+	case <-ctx.Done():
+		stop()
+		return "", nil, nil, ctx.Err()
+	}
+}
@@ -0,0 +1,186 @@
+package catcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// whiskerPod returns a Whisker pod created at the given time, Ready or not.
+func whiskerPod(name string, created time.Time, ready bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "calico-system", CreationTimestamp: metav1.NewTime(created)},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "whisker-backend",
+			Env:  []corev1.EnvVar{{Name: "PORT", Value: "3002"}},
+		}}},
+	}
+	if ready {
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	return pod
+}
+
+// watchedPods returns a clientset listing the pods, and the watch the
+// changes to them are sent on.
+func watchedPods(pods ...runtime.Object) (*fake.Clientset, *watch.FakeWatcher) {
+	clientset := fake.NewSimpleClientset(pods...)
+	watcher := watch.NewFake()
+	clientset.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watcher, nil))
+	return clientset, watcher
+}
+
+// waitChanged waits for another pod to be selected.
+func waitChanged(t *testing.T, changed <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected another pod to be selected")
+	}
+}
+
+func TestPodWatcher(t *testing.T) {
+	now := time.Now()
+	clientset, changes := watchedPods(
+		whiskerPod("pending", now, false),
+		whiskerPod("old", now.Add(-time.Hour), true),
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "calico-system"}},
+	)
+	w := NewPodWatcher(clientset, "calico-system", "whisker-backend", "PORT")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	pod, err := w.Current(ctx)
+	if err != nil || pod != (Pod{Name: "old", Port: "3002"}) {
+		t.Fatalf("expected the Ready pod, got %+v and %v", pod, err)
+	}
+
+	// A rollout brings up a newer replica, which is only switched to once
+	// the old one goes away
+	changed := w.Changed()
+	changes.Add(whiskerPod("new", now, true))
+	terminating := whiskerPod("old", now.Add(-time.Hour), true)
+	terminating.DeletionTimestamp = &metav1.Time{Time: now}
+	changes.Modify(terminating)
+	waitChanged(t, changed)
+	if pod, _ := w.Current(ctx); pod.Name != "new" {
+		t.Errorf("expected the new replica once the old one terminates, got %+v", pod)
+	}
+
+	changed = w.Changed()
+	changes.Delete(whiskerPod("new", now, true))
+	waitChanged(t, changed)
+	if pod, _ := w.Current(ctx); pod.Name != "pending" {
+		t.Errorf("expected a pod that isn't Ready yet when no other is left, got %+v", pod)
+	}
+
+	changed = w.Changed()
+	changes.Delete(whiskerPod("pending", now, false))
+	changes.Delete(terminating)
+	waitChanged(t, changed)
+	if _, err := w.Current(ctx); err == nil || !strings.Contains(err.Error(), "no pod running container whisker-backend") {
+		t.Errorf("expected no pod to connect to, got %v", err)
+	}
+}
+
+// forwards counts the port-forwards started, to the address of server.
+type forwards struct {
+	mu      sync.Mutex
+	addr    string
+	started []string
+	stopped []string
+}
+
+func (f *forwards) forward(_ context.Context, _ string, pod Pod) (string, <-chan struct{}, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, pod.Name)
+	done := make(chan struct{})
+	return f.addr, done, sync.OnceFunc(func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.stopped = append(f.stopped, pod.Name)
+		close(done)
+	}), nil
+}
+
+func (f *forwards) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fmt.Sprintf("started %v, stopped %v", f.started, f.stopped)
+}
+
+func TestForwarder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: " + r.URL.Path + "\n\n"))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	clientset, changes := watchedPods(whiskerPod("old", now.Add(-time.Hour), true))
+	fws := &forwards{addr: strings.TrimPrefix(server.URL, "http://")}
+	fwd := NewForwarder("calico-system", "whisker-backend", "PORT")
+	fwd.clientset = clientset
+	fwd.Forward = fws.forward
+	defer fwd.Close()
+
+	// Every reconnect goes through the same forward
+	var received []string
+	var states []State
+	for range 3 {
+		dc := NewDataCatcher("calico-system", "whisker-backend", "/flows", mockCatcher(&received), func() {})
+		dc.Forwarder = fwd
+		dc.OnState = func(s State) { states = append(states, s) }
+		if err := dc.CatchServerSentEvents(context.Background(), make(chan bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fws.String(); got != "started [old], stopped []" || len(received) != 3 || fwd.Pod() != "old" {
+		t.Errorf("expected a single forward to the old pod for every connection, got %s, %v and %q", got, received, fwd.Pod())
+	}
+	if fmt.Sprint(states[:4]) != "[resolving pod port-forwarding connecting streaming]" {
+		t.Errorf("expected the first connection to port-forward, got %v", states)
+	}
+
+	// The forward is stopped as soon as the old pod is going away
+	changes.Add(whiskerPod("new", now, true))
+	terminating := whiskerPod("old", now.Add(-time.Hour), true)
+	terminating.DeletionTimestamp = &metav1.Time{Time: now}
+	changes.Modify(terminating)
+	deadline := time.Now().Add(5 * time.Second)
+	for fwd.Pod() != "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := fws.String(); got != "started [old], stopped [old]" {
+		t.Fatalf("expected the forward to the old pod to be stopped, got %s", got)
+	}
+	addr, done, err := fwd.Addr(context.Background(), func(State) {})
+	if err != nil || addr != fws.addr || fwd.Pod() != "new" {
+		t.Fatalf("expected a forward to the new pod, got %q, %q and %v", addr, fwd.Pod(), err)
+	}
+
+	fwd.Drop(done)
+	if fwd.Pod() != "" {
+		t.Errorf("expected no forward once dropped, got %q", fwd.Pod())
+	}
+	fwd.Addr(context.Background(), func(State) {})
+	if got := fws.String(); got != "started [old new new], stopped [old new]" {
+		t.Errorf("expected another forward after dropping one, got %s", got)
+	}
+}
@@ -0,0 +1,153 @@
+package catcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Record is a single SSE data payload as it arrived from the server.
+type Record struct {
+	Time time.Time `json:"time"`
+	Data string    `json:"data"`
+	// Cluster is the kube context of the stream, when several are recorded
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// Recorder tees the raw data payloads of an SSE stream into a capture file,
+// one JSON encoded Record per line.
+type Recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	return &Recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (r *Recorder) Record(cluster, data string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(Record{Time: time.Now().UTC(), Data: data, Cluster: cluster})
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// Replayer feeds a capture file written by a Recorder back through a
+// CatcherFunc, keeping the recorded gaps between payloads.
+type Replayer struct {
+	path    string
+	catcher CatcherFunc
+	// Speed scales the recorded gaps: 1 replays at the original speed, 10 at
+	// ten times the speed and 0 (or less) as fast as possible.
+	Speed float64
+	// ForCluster, when set, returns the catcher for the cluster a payload
+	// was recorded from instead of using the one given to NewReplayer
+	ForCluster func(cluster string) CatcherFunc
+
+	mu    sync.Mutex
+	clock time.Time
+}
+
+func NewReplayer(path string, speed float64, catcher CatcherFunc) *Replayer {
+	return &Replayer{path: path, catcher: catcher, Speed: speed}
+}
+
+// Replay reads the capture file and hands each payload to the catcher. It
+// returns once the file is exhausted or the context is done.
+func (rp *Replayer) Replay(ctx context.Context) error {
+	f, err := os.Open(rp.path)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var first time.Time
+	start := time.Now()
+	count := 0
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var rec Record
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return fmt.Errorf("invalid record on line %d of %s: %w", n, rp.path, err)
+			}
+			if first.IsZero() {
+				first = rec.Time
+			}
+			if rp.Speed > 0 {
+				due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / rp.Speed))
+				if !sleepUntil(ctx, due) {
+					return nil
+				}
+			} else if ctx.Err() != nil {
+				return nil
+			}
+			rp.mu.Lock()
+			rp.clock = rec.Time
+			rp.mu.Unlock()
+			catcher := rp.catcher
+			if rp.ForCluster != nil {
+				catcher = rp.ForCluster(rec.Cluster)
+			}
+			if err := catcher(rec.Data); err != nil {
+				return err
+			}
+			count++
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				logrus.Debugf("replay of %s complete: %d records", rp.path, count)
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Now returns the recorded arrival time of the payload replayed last, so
+// anything windowed on the current time (e.g. rates) lines up with the
+// recording instead of the wall clock. Before the first payload it returns
+// the wall clock time.
+func (rp *Replayer) Now() time.Time {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.clock.IsZero() {
+		return time.Now()
+	}
+	return rp.clock
+}
+
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
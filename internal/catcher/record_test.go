@@ -0,0 +1,163 @@
+package catcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeCapture(t *testing.T, recs ...Record) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	var b strings.Builder
+	for _, r := range recs {
+		fmt.Fprintf(&b, "{\"time\":%q,\"data\":%q}\n", r.Time.Format(time.RFC3339Nano), r.Data)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDataCatcher_RecordsPayloads(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("data: {\"a\":1}\n\nid: 2\ndata: {\"a\":2}\n\n"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var received []string
+	dc := &DataCatcher{
+		catcher:     mockCatcher(&received),
+		URLFull:     server.URL,
+		recoverFunc: func() {},
+		Recorder:    rec,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := dc.CatchServerSentEvents(ctx, make(chan bool, 1)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	var replayed []string
+	rp := NewReplayer(path, 0, mockCatcher(&replayed))
+	if err := rp.Replay(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Join(replayed, "|") != strings.Join(received, "|") || len(replayed) != 2 {
+		t.Errorf("expected replay %v to match the recorded payloads %v", replayed, received)
+	}
+}
+
+func TestReplayer_Speed(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	path := writeCapture(t,
+		Record{Time: start, Data: "one"},
+		Record{Time: start.Add(200 * time.Millisecond), Data: "two"},
+		Record{Time: start.Add(400 * time.Millisecond), Data: "three"},
+	)
+
+	tests := []struct {
+		name     string
+		speed    float64
+		min, max time.Duration
+	}{
+		{name: "original speed", speed: 1, min: 400 * time.Millisecond, max: 2 * time.Second},
+		{name: "four times faster", speed: 4, min: 100 * time.Millisecond, max: 350 * time.Millisecond},
+		{name: "as fast as possible", speed: 0, min: 0, max: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			rp := NewReplayer(path, tt.speed, mockCatcher(&got))
+			began := time.Now()
+			if err := rp.Replay(context.Background()); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			took := time.Since(began)
+			if took < tt.min || took > tt.max {
+				t.Errorf("expected replay to take between %s and %s, took %s", tt.min, tt.max, took)
+			}
+			if strings.Join(got, ",") != "one,two,three" {
+				t.Errorf("expected payloads in order, got %v", got)
+			}
+			if !rp.Now().Equal(start.Add(400 * time.Millisecond)) {
+				t.Errorf("expected replay clock at the last record, got %s", rp.Now())
+			}
+		})
+	}
+}
+
+func TestReplayer_Cancel(t *testing.T) {
+	start := time.Now()
+	path := writeCapture(t, Record{Time: start, Data: "one"}, Record{Time: start.Add(time.Hour), Data: "two"})
+	var got []string
+	rp := NewReplayer(path, 1, mockCatcher(&got))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := rp.Replay(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("expected only the first payload before cancel, got %v", got)
+	}
+}
+
+func TestReplayer_ForCluster(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Record{{Cluster: "one", Data: "a"}, {Cluster: "two", Data: "b"}, {Data: "c"}} {
+		if err := rec.Record(r.Cluster, r.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec.Close()
+
+	var got []string
+	rp := NewReplayer(path, 0, nil)
+	rp.ForCluster = func(cluster string) CatcherFunc {
+		return func(data string) error {
+			got = append(got, cluster+"="+data)
+			return nil
+		}
+	}
+	if err := rp.Replay(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Join(got, ",") != "one=a,two=b,=c" {
+		t.Errorf("expected each payload with its cluster, got %v", got)
+	}
+}
+
+func TestReplayer_Errors(t *testing.T) {
+	if err := NewReplayer(filepath.Join(t.TempDir(), "missing"), 0, func(string) error { return nil }).Replay(context.Background()); err == nil {
+		t.Error("expected an error for a missing capture file")
+	}
+
+	path := filepath.Join(t.TempDir(), "bad.ndjson")
+	if err := os.WriteFile(path, []byte("{\"time\":\"2025-06-01T12:00:00Z\",\"data\":\"x\"}\n\nnot json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := NewReplayer(path, 0, func(string) error { return nil }).Replay(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected an error naming line 3, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package catcher
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxEventSize bounds the data of a single event, well past the 64KB
+// a bufio.Scanner allows by default, so large flows still get through.
+const DefaultMaxEventSize = 16 << 20
+
+// ErrEventTooLarge is returned when an event, or a single line, is larger
+// than the reader's limit.
+var ErrEventTooLarge = errors.New("server-sent event too large")
+
+// Event is a server-sent event.
+type Event struct {
+	// ID is the last event ID as of this event, which may have been set by
+	// an earlier one
+	ID string
+	// Type is the event type, "message" unless the server named it
+	Type string
+	Data string
+}
+
+// EventReader parses a server-sent event stream as specified in
+// https://html.spec.whatwg.org/multipage/server-sent-events.html: multi-line
+// data, ids, event types, retry and comments, with any of the CRLF, LF or CR
+// line endings.
+type EventReader struct {
+	r      *bufio.Reader
+	max    int
+	lastID string
+	retry  time.Duration
+	first  bool
+	// afterCR is set when the last line ended in a CR, whose LF may follow
+	afterCR bool
+}
+
+// NewEventReader reads events from r, none larger than max bytes (0 is
+// DefaultMaxEventSize).
+func NewEventReader(r io.Reader, max int) *EventReader {
+	if max <= 0 {
+		max = DefaultMaxEventSize
+	}
+	return &EventReader{r: bufio.NewReaderSize(r, 64*1024), max: max, first: true}
+}
+
+// LastEventID returns the ID to resume the stream from.
+func (er *EventReader) LastEventID() string {
+	return er.lastID
+}
+
+// Retry returns the reconnection time the server asked for, or 0.
+func (er *EventReader) Retry() time.Duration {
+	return er.retry
+}
+
+// Next returns the next event. An event the stream ends in the middle of is
+// discarded, as the spec requires, and io.EOF returned.
+func (er *EventReader) Next() (*Event, error) {
+	var data strings.Builder
+	hasData := false
+	eventType := ""
+	idBuf := er.lastID
+	for {
+		line, err := er.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			// Dispatch
+			er.lastID = idBuf
+			if !hasData {
+				eventType = ""
+				continue
+			}
+			if eventType == "" {
+				eventType = "message"
+			}
+			return &Event{ID: er.lastID, Type: eventType, Data: strings.TrimSuffix(data.String(), "\n")}, nil
+		}
+		if line[0] == ':' {
+			// Comment
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			if data.Len()+len(value)+1 > er.max {
+				return nil, fmt.Errorf("%w: more than %d bytes of data", ErrEventTooLarge, er.max)
+			}
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				idBuf = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				er.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine returns the next line without its line ending. A line ends at
+// the first CR or LF, so one ended by a lone CR is returned as soon as the CR
+// arrives; the LF of a CRLF split across reads is skipped on the next call.
+func (er *EventReader) readLine() (string, error) {
+	var buf []byte
+	for {
+		if _, err := er.r.Peek(1); err != nil {
+			// A line the stream ends in the middle of is never dispatched
+			if err == io.EOF && len(buf) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		chunk, _ := er.r.Peek(er.r.Buffered())
+		if er.afterCR {
+			er.afterCR = false
+			if chunk[0] == '\n' {
+				_, _ = er.r.Discard(1)
+				continue
+			}
+		}
+		end := bytes.IndexAny(chunk, "\r\n")
+		if end < 0 {
+			end = len(chunk)
+		}
+		if len(buf)+end > er.max {
+			return "", fmt.Errorf("%w: line longer than %d bytes", ErrEventTooLarge, er.max)
+		}
+		buf = append(buf, chunk[:end]...)
+		if end == len(chunk) {
+			_, _ = er.r.Discard(end)
+			continue
+		}
+		er.afterCR = chunk[end] == '\r'
+		_, _ = er.r.Discard(end + 1)
+		break
+	}
+	if er.first {
+		er.first = false
+		buf = bytes.TrimPrefix(buf, []byte("\uFEFF"))
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,194 @@
+package catcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, stream string, max int) ([]Event, *EventReader, error) {
+	t.Helper()
+	er := NewEventReader(strings.NewReader(stream), max)
+	var events []Event
+	for {
+		ev, err := er.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return events, er, err
+		}
+		events = append(events, *ev)
+	}
+}
+
+func TestEventReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []Event
+	}{
+		{
+			name:   "single data line",
+			stream: "data: foo\n\n",
+			want:   []Event{{Type: "message", Data: "foo"}},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: {\"a\":\ndata:  1}\n\n",
+			want:   []Event{{Type: "message", Data: "{\"a\":\n 1}"}},
+		},
+		{
+			name:   "id, event type and comments",
+			stream: ": keep-alive\nid: 7\nevent: flow\ndata: x\n\ndata: y\n\n",
+			want:   []Event{{ID: "7", Type: "flow", Data: "x"}, {ID: "7", Type: "message", Data: "y"}},
+		},
+		{
+			name:   "id without data still counts",
+			stream: "id: 3\n\ndata: z\n\nid\ndata: w\n\n",
+			want:   []Event{{ID: "3", Type: "message", Data: "z"}, {ID: "", Type: "message", Data: "w"}},
+		},
+		{
+			name:   "id with a null is ignored",
+			stream: "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+			want:   []Event{{ID: "1", Type: "message", Data: "a"}, {ID: "1", Type: "message", Data: "b"}},
+		},
+		{
+			name:   "CRLF and CR line endings",
+			stream: "data: a\r\n\r\ndata: b\r\rdata: c\n\n",
+			want:   []Event{{Type: "message", Data: "a"}, {Type: "message", Data: "b"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:   "byte order mark and field without a colon",
+			stream: "\uFEFFdata\n\ndata:no space\n\n",
+			want:   []Event{{Type: "message", Data: ""}, {Type: "message", Data: "no space"}},
+		},
+		{
+			name:   "unterminated event is dropped",
+			stream: "data: done\n\ndata: partial\n",
+			want:   []Event{{Type: "message", Data: "done"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := readEvents(t, tt.stream, 0)
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEventReader_Streaming(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	er := NewEventReader(pr, 0)
+	events := make(chan Event, 2)
+	go func() {
+		defer close(events)
+		for {
+			ev, err := er.Next()
+			if err != nil {
+				return
+			}
+			events <- *ev
+		}
+	}()
+	next := func(want string) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Data != want {
+				t.Errorf("expected %q, got %q", want, ev.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	// Each write is a read of its own, and nothing ever sends a LF
+	for _, chunk := range []string{"data: a\r", "\r"} {
+		if _, err := pw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next("a")
+	// A CRLF split across reads ends a single line
+	for _, chunk := range []string{"data: b\r", "\n", "\r", "\n"} {
+		if _, err := pw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next("b")
+	pw.Close()
+}
+
+func TestEventReader_Retry(t *testing.T) {
+	_, er, err := readEvents(t, "retry: 1500\n\nretry: soon\ndata: x\n\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if er.Retry() != 1500*time.Millisecond {
+		t.Errorf("expected a 1.5s retry, got %s", er.Retry())
+	}
+}
+
+func TestEventReader_LargeEvents(t *testing.T) {
+	big := strings.Repeat("x", 200*1024)
+	got, _, err := readEvents(t, "data: "+big+"\n\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Data != big {
+		t.Errorf("expected one %d byte event, got %d events", len(big), len(got))
+	}
+
+	_, _, err = readEvents(t, "data: "+big+"\n\n", 100*1024)
+	if !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("expected %v, got %v", ErrEventTooLarge, err)
+	}
+	_, _, err = readEvents(t, strings.Repeat("data: 0123456789\n", 100)+"\n", 1000)
+	if !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("expected %v for too many lines, got %v", ErrEventTooLarge, err)
+	}
+}
+
+func TestDataCatcher_Resume(t *testing.T) {
+	var lastIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastIDs = append(lastIDs, r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(lastIDs) == 1 {
+			w.Write([]byte("retry: 250\nid: 1\ndata: a\n\nid: 2\ndata: b\n\n"))
+		} else {
+			w.Write([]byte("id: 3\ndata: c\n\n"))
+		}
+	}))
+	defer ts.Close()
+
+	var received []string
+	dc := &DataCatcher{
+		catcher:     mockCatcher(&received),
+		recoverFunc: func() {},
+		Resume:      &Resume{},
+	}
+	for range 2 {
+		if err := dc.consumeSSEStream(context.Background(), ts.URL, make(chan struct{}), make(chan bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(lastIDs) != 2 || lastIDs[0] != "" || lastIDs[1] != "2" {
+		t.Errorf("expected to resume from event 2, got %q", lastIDs)
+	}
+	if strings.Join(received, ",") != "a,b,c" || dc.Resume.LastEventID != "3" || dc.Resume.Retry != 250*time.Millisecond {
+		t.Errorf("unexpected events %v or resume state %+v", received, dc.Resume)
+	}
+}
@@ -0,0 +1,230 @@
+package catcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// State is a step of getting a flow stream up.
+type State string
+
+const (
+	StateResolvingPod   State = "resolving pod"
+	StatePortForwarding State = "port-forwarding"
+	StateConnecting     State = "connecting"
+	StateStreaming      State = "streaming"
+	StateBackingOff     State = "backing off"
+	StateFailed         State = "failed"
+)
+
+// Status is the state a connection is in, since when, and why.
+type Status struct {
+	// Cluster names the kube context of the connection
+	Cluster string
+	State   State
+	// Pod names the pod the stream is port-forwarded to, when it is
+	Pod string
+	// Since is when the connection entered the state
+	Since time.Time
+	// Attempt counts the attempts since the stream was last up, from 1. It
+	// is 0 while backing off from a stream that was up
+	Attempt int
+	// Err is the error that ended the last attempt, while backing off or
+	// failed
+	Err error
+	// RetryAt is when the next attempt starts, while backing off
+	RetryAt time.Time
+	// LastStreaming is when the stream last came up, zero if it never did
+	LastStreaming time.Time
+}
+
+// String describes the status in a line, e.g. "backing off, attempt 3,
+// retrying in 4s: connection refused" or "streaming via whisker-7d9f-x2k".
+func (s Status) String() string {
+	text := string(s.State)
+	if s.Pod != "" {
+		text += " via " + s.Pod
+	}
+	switch s.State {
+	case StateBackingOff:
+		if s.Attempt > 0 {
+			text += fmt.Sprintf(", attempt %d", s.Attempt)
+		}
+		text += fmt.Sprintf(", retrying in %s", time.Until(s.RetryAt).Round(time.Second))
+	case StateFailed:
+		text += fmt.Sprintf(" after %d attempts", s.Attempt)
+	}
+	if s.Err != nil {
+		text += ": " + s.Err.Error()
+	}
+	return text
+}
+
+// Backoff spaces out the attempts to connect: Initial after the first
+// failure, multiplied by Factor after each one that follows, up to Max, and
+// randomly moved by up to Jitter (a fraction) either way so clients don't
+// reconnect in lockstep.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+	// MaxAttempts gives up after that many attempts in a row fail, 0 never
+	// gives up
+	MaxAttempts int
+}
+
+// DefaultBackoff starts at a second and backs off to a minute.
+var DefaultBackoff = Backoff{Initial: time.Second, Max: time.Minute, Factor: 2, Jitter: 0.2}
+
+// Delay returns how long to wait after the given failed attempt (from 1).
+// rnd returns a number in [0, 1).
+func (b Backoff) Delay(attempt int, rnd func() float64) time.Duration {
+	d := float64(b.Initial)
+	for i := 1; i < attempt && d < float64(b.Max); i++ {
+		d *= b.Factor
+	}
+	d = min(d, float64(b.Max))
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rnd() - 1)
+	}
+	return time.Duration(d)
+}
+
+// ErrGaveUp is returned by Supervisor.Run once MaxAttempts is reached.
+var ErrGaveUp = errors.New("gave up reconnecting")
+
+// Supervisor keeps a flow stream up: it reconnects whenever the stream ends,
+// backing off while the attempts keep failing, and reports each state the
+// connection goes through.
+type Supervisor struct {
+	Backoff Backoff
+	// Report, when set, is called with each status
+	Report func(Status)
+	// RetryHint, when set, returns the reconnection time the server asked
+	// for, which is waited at least
+	RetryHint func() time.Duration
+	Now       func() time.Time
+
+	rnd func() float64
+
+	// mu guards the state, reported from the goroutines of connect
+	mu       sync.Mutex
+	attempt  int
+	streamed bool
+	last     Status
+}
+
+func NewSupervisor(backoff Backoff) *Supervisor {
+	return &Supervisor{Backoff: backoff, Now: time.Now, rnd: rand.Float64}
+}
+
+// Run calls connect until ctx is done, or MaxAttempts attempts in a row
+// failed. connect reports the states it goes through, and returns when the
+// stream ends. An attempt that got to streaming starts the attempts over.
+func (s *Supervisor) Run(ctx context.Context, connect func(ctx context.Context, report func(State)) error) error {
+	for {
+		s.mu.Lock()
+		s.attempt++
+		s.streamed = false
+		s.mu.Unlock()
+		err := connect(ctx, s.report)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			err = errors.New("stream ended")
+		}
+		s.mu.Lock()
+		if s.streamed {
+			// The stream was up, so the attempts start over
+			s.attempt = 0
+		}
+		attempt := s.attempt
+		s.mu.Unlock()
+		if s.Backoff.MaxAttempts > 0 && attempt >= s.Backoff.MaxAttempts {
+			s.set(Status{State: StateFailed, Attempt: attempt, Err: err})
+			return fmt.Errorf("%w after %d attempts: %w", ErrGaveUp, attempt, err)
+		}
+		delay := s.Backoff.Delay(max(attempt, 1), s.rnd)
+		if s.RetryHint != nil {
+			delay = max(delay, s.RetryHint())
+		}
+		s.set(Status{State: StateBackingOff, Attempt: attempt, Err: err, RetryAt: s.Now().Add(delay)})
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// report is the report func connect is called with.
+func (s *Supervisor) report(state State) {
+	s.mu.Lock()
+	if state == StateStreaming {
+		s.streamed = true
+	}
+	attempt := s.attempt
+	s.mu.Unlock()
+	s.set(Status{State: state, Attempt: attempt})
+}
+
+// set stamps the status and reports it, unless nothing changed.
+func (s *Supervisor) set(st Status) {
+	s.mu.Lock()
+	st.LastStreaming = s.last.LastStreaming
+	if st.State == s.last.State && st.Attempt == s.last.Attempt && st.Err == nil && s.last.Err == nil {
+		s.mu.Unlock()
+		return
+	}
+	st.Since = s.Now()
+	if st.State == StateStreaming {
+		st.LastStreaming = st.Since
+	}
+	s.last = st
+	s.mu.Unlock()
+	if s.Report != nil {
+		s.Report(st)
+	}
+}
+
+// Statuses keeps the latest status of each of a fixed number of
+// connections.
+type Statuses struct {
+	mu     sync.Mutex
+	latest []Status
+	set    []bool
+}
+
+func NewStatuses(n int) *Statuses {
+	return &Statuses{latest: make([]Status, n), set: make([]bool, n)}
+}
+
+// Set records the status of the i-th connection.
+func (s *Statuses) Set(i int, st Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest[i] = st
+	s.set[i] = true
+}
+
+// Connections returns the latest status of each connection that reported
+// one.
+func (s *Statuses) Connections() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Status, 0, len(s.latest))
+	for i, st := range s.latest {
+		if s.set[i] {
+			out = append(out, st)
+		}
+	}
+	return out
+}
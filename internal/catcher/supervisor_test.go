@@ -0,0 +1,167 @@
+package catcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Factor: 2}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := b.Delay(tt.attempt, nil); got != tt.want {
+			t.Errorf("attempt %d: expected %s, got %s", tt.attempt, tt.want, got)
+		}
+	}
+
+	b.Jitter = 0.5
+	if lo, hi := b.Delay(2, func() float64 { return 0 }), b.Delay(2, func() float64 { return 0.999 }); lo != time.Second || hi <= 2*time.Second || hi > 3*time.Second {
+		t.Errorf("expected a 2s delay jittered within [1s, 3s), got %s and %s", lo, hi)
+	}
+}
+
+// statusLog collects the statuses a supervisor reports.
+type statusLog struct {
+	mu       sync.Mutex
+	statuses []Status
+}
+
+func (l *statusLog) report(st Status) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.statuses = append(l.statuses, st)
+}
+
+func (l *statusLog) states() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var states []string
+	for _, st := range l.statuses {
+		states = append(states, string(st.State))
+	}
+	return strings.Join(states, ",")
+}
+
+func testSupervisor(maxAttempts int) (*Supervisor, *statusLog) {
+	sup := NewSupervisor(Backoff{Initial: time.Millisecond, Max: 4 * time.Millisecond, Factor: 2, MaxAttempts: maxAttempts})
+	log := &statusLog{}
+	sup.Report = log.report
+	return sup, log
+}
+
+func TestSupervisor_MaxAttempts(t *testing.T) {
+	sup, log := testSupervisor(3)
+	refused := errors.New("connection refused")
+	calls := 0
+	err := sup.Run(context.Background(), func(ctx context.Context, report func(State)) error {
+		calls++
+		report(StateConnecting)
+		return refused
+	})
+	if !errors.Is(err, ErrGaveUp) || !errors.Is(err, refused) || calls != 3 {
+		t.Fatalf("expected to give up after 3 attempts with %v, got %d attempts and %v", refused, calls, err)
+	}
+	want := "connecting,backing off,connecting,backing off,connecting,failed"
+	if got := log.states(); got != want {
+		t.Errorf("expected states %s, got %s", want, got)
+	}
+	last := log.statuses[len(log.statuses)-1]
+	if last.Attempt != 3 || !errors.Is(last.Err, refused) || last.Since.IsZero() {
+		t.Errorf("expected the failed status to carry the attempt, error and time, got %+v", last)
+	}
+	if backingOff := log.statuses[1]; !backingOff.RetryAt.After(backingOff.Since) || backingOff.Err == nil {
+		t.Errorf("expected backing off to carry the error and when it retries, got %+v", backingOff)
+	}
+}
+
+func TestSupervisor_StreamingResetsAttempts(t *testing.T) {
+	sup, log := testSupervisor(2)
+	calls := 0
+	err := sup.Run(context.Background(), func(ctx context.Context, report func(State)) error {
+		calls++
+		report(StateConnecting)
+		if calls <= 3 {
+			// Each of these got the stream up before it broke
+			report(StateStreaming)
+			return nil
+		}
+		return errors.New("connection refused")
+	})
+	if !errors.Is(err, ErrGaveUp) || calls != 5 {
+		t.Fatalf("expected to give up 2 attempts after the stream was last up, got %d attempts and %v", calls, err)
+	}
+	for _, st := range log.statuses {
+		if st.State == StateStreaming && (st.Attempt != 1 || st.LastStreaming.IsZero()) {
+			t.Errorf("expected streaming to start the attempts over, got %+v", st)
+		}
+		if st.State == StateBackingOff && st.Attempt == 0 && !strings.HasPrefix(st.String(), "backing off, retrying in") {
+			t.Errorf("expected no attempt count after the stream was up, got %q", st.String())
+		}
+	}
+	if last := log.statuses[len(log.statuses)-1]; last.LastStreaming.IsZero() || !strings.Contains(last.String(), "failed after 2 attempts: connection refused") {
+		t.Errorf("expected the failure to remember the stream was up, got %+v", last)
+	}
+}
+
+func TestSupervisor_Cancel(t *testing.T) {
+	sup, _ := testSupervisor(0)
+	sup.Backoff.Initial = time.Hour
+	sup.Backoff.Max = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- sup.Run(ctx, func(ctx context.Context, report func(State)) error {
+			return errors.New("connection refused")
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error when cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected cancelling to stop the backoff")
+	}
+}
+
+func TestSupervisor_RetryHint(t *testing.T) {
+	sup, log := testSupervisor(2)
+	sup.RetryHint = func() time.Duration { return time.Hour }
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sup.Run(ctx, func(ctx context.Context, report func(State)) error {
+		return errors.New("unavailable")
+	})
+	if st := log.statuses[0]; st.State != StateBackingOff || st.RetryAt.Sub(st.Since) < 59*time.Minute {
+		t.Errorf("expected to back off as long as the server asked, got %+v", st)
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	s := NewStatuses(2)
+	if got := s.Connections(); len(got) != 0 {
+		t.Errorf("expected no connections before any reported, got %v", got)
+	}
+	s.Set(1, Status{Cluster: "two", State: StateConnecting})
+	s.Set(0, Status{Cluster: "one", State: StateBackingOff})
+	s.Set(1, Status{Cluster: "two", State: StateStreaming})
+	got := s.Connections()
+	if len(got) != 2 || got[0].Cluster != "one" || got[1].State != StateStreaming {
+		t.Errorf("expected the latest status of each connection in order, got %+v", got)
+	}
+}
@@ -53,8 +53,18 @@ func (c *CmdCtx) SetContext(name string) {
 }
 
 func (c *CmdCtx) GetK8sConfig() *rest.Config {
+	config, err := c.K8sConfig()
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// K8sConfig is GetK8sConfig for callers that report a broken context instead
+// of panicking. Once it succeeds the other client getters will not panic.
+func (c *CmdCtx) K8sConfig() (*rest.Config, error) {
 	if c.k8scfg != nil {
-		return c.k8scfg
+		return c.k8scfg, nil
 	}
 	var configOverrides *clientcmd.ConfigOverrides
 	configLoadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: c.kubeConfig}
@@ -63,10 +73,10 @@ func (c *CmdCtx) GetK8sConfig() *rest.Config {
 	}
 	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(configLoadingRules, configOverrides).ClientConfig()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	c.k8scfg = config
-	return c.k8scfg
+	return c.k8scfg, nil
 }
 
 func (c *CmdCtx) ClientDyn() *dynamic.DynamicClient {
@@ -106,6 +116,12 @@ func CmdCtxFromContext(ctx context.Context) *CmdCtx {
 	return ctx.Value(cmdCtxKey).(*CmdCtx)
 }
 
+// LookupCmdCtx is CmdCtxFromContext for callers that can do without one.
+func LookupCmdCtx(ctx context.Context) (*CmdCtx, bool) {
+	cc, ok := ctx.Value(cmdCtxKey).(*CmdCtx)
+	return cc, ok
+}
+
 func K8sClientDynFromContext(ctx context.Context) *dynamic.DynamicClient {
 	return CmdCtxFromContext(ctx).ClientDyn()
 }
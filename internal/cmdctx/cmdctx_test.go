@@ -70,6 +70,12 @@ func TestToContext(t *testing.T) {
 	if retrievedCtx != ctx {
 		t.Error("CmdCtxFromContext() returned different context")
 	}
+	if got, ok := LookupCmdCtx(newCtx); !ok || got != ctx {
+		t.Error("LookupCmdCtx() returned different context")
+	}
+	if _, ok := LookupCmdCtx(parentCtx); ok {
+		t.Error("LookupCmdCtx() found a context that was never set")
+	}
 
 	// Test cancellation
 	ctx.Cancel()
@@ -150,6 +156,35 @@ func TestGetK8sConfig(t *testing.T) {
 	}
 }
 
+func TestK8sConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	kubeconfigPath := filepath.Join(tmpDir, "kubeconfig")
+	config := api.Config{
+		Clusters: map[string]*api.Cluster{"test-cluster": {Server: "https://test-server:6443"}},
+		Contexts: map[string]*api.Context{"test-context": {Cluster: "test-cluster"}},
+	}
+	if err := clientcmd.WriteToFile(config, kubeconfigPath); err != nil {
+		t.Fatalf("Failed to write kubeconfig: %v", err)
+	}
+
+	cc := NewCmdCtx(kubeconfigPath, "", "missing-context")
+	if _, err := cc.K8sConfig(); err == nil {
+		t.Error("expected an error for a missing context")
+	}
+
+	cc.SetContext("test-context")
+	cfg, err := cc.K8sConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.Host != "https://test-server:6443" {
+		t.Errorf("expected host https://test-server:6443, got %s", cfg.Host)
+	}
+	if cc.GetK8sConfig() != cfg {
+		t.Error("expected GetK8sConfig to return the cached config")
+	}
+}
+
 func TestClientDyn(t *testing.T) {
 	ctx := &CmdCtx{
 		k8scfg: &rest.Config{},
@@ -9,180 +9,192 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 type CNIInfo struct {
-	Name    string
-	Version string
-	Details map[string]string
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Details map[string]string `json:"details"`
 }
 
-// DetectCNI detects the CNI plugin being used in the Kubernetes cluster
-func DetectCNI(kubeconfig string) (*CNIInfo, error) {
-	// Create Kubernetes client
-	client, err := createKubernetesClient(kubeconfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
-	}
+// namespaces are searched, in order, for the workloads of a CNI plugin.
+var namespaces = []string{"kube-system", "calico-system", "tigera-operator"}
 
-	ctx := context.Background()
-
-	// Method 1: Check DaemonSets in kube-system namespace
-	cniInfo, err := detectFromDaemonSets(ctx, client)
-	if err == nil && cniInfo != nil {
-		return cniInfo, nil
-	}
+type cniPattern struct {
+	match string
+	name  string
+}
 
-	// Method 2: Check Deployments in kube-system namespace
-	cniInfo, err = detectFromDeployments(ctx, client)
-	if err == nil && cniInfo != nil {
-		return cniInfo, nil
-	}
+// cniPatterns map workload names to CNI names. They are ordered so that the
+// more specific names win, e.g. canal before calico and flannel.
+var cniPatterns = []cniPattern{
+	{"canal", "canal"},
+	{"calico", "calico"},
+	{"cilium", "cilium"},
+	{"flannel", "flannel"},
+	{"weave", "weave"},
+	{"antrea", "antrea"},
+	{"kube-router", "kube-router"},
+	{"ovn", "ovn-kubernetes"},
+	// AWS EKS CNI
+	{"aws-node", "aws-vpc-cni"},
+	// Azure AKS CNI
+	{"azure-cni", "azure-cni"},
+	{"azure-npm", "azure-npm"}, // Azure Network Policy Manager
+	// GKE CNI
+	{"gke-node", "gke-native"}, // GKE native networking
+}
 
-	// Method 3: Check ConfigMaps for CNI configuration
-	cniInfo, err = detectFromConfigMaps(ctx, client)
-	if err == nil && cniInfo != nil {
-		return cniInfo, nil
-	}
+// imagePatterns map container images to CNI names, for installs whose
+// workloads have unremarkable names.
+var imagePatterns = []cniPattern{
+	{"calico/node", "calico"},
+	{"calico/cni", "calico"},
+	{"cilium", "cilium"},
+	{"weave", "weave"},
+	{"flannel", "flannel"},
+}
 
-	// Method 4: Check node annotations
-	cniInfo, err = detectFromNodeAnnotations(ctx, client)
-	if err == nil && cniInfo != nil {
-		return cniInfo, nil
-	}
+var displayNames = map[string]string{
+	"calico":         "Calico",
+	"canal":          "Canal",
+	"cilium":         "Cilium",
+	"flannel":        "Flannel",
+	"weave":          "Weave",
+	"weave-net":      "Weave",
+	"antrea":         "Antrea",
+	"kube-router":    "Kube-Router",
+	"ovn-kubernetes": "OVN-Kubernetes",
+	"aws-vpc-cni":    "AWS VPC CNI",
+	"azure-cni":      "Azure CNI",
+}
 
-	// Method 5: Detect cloud provider specific CNI
-	cniInfo, err = detectCloudProviderCNI(ctx, client)
-	if err == nil && cniInfo != nil {
-		return cniInfo, nil
+// DisplayName is the CNI name as people usually write it, e.g. "Calico".
+func (c *CNIInfo) DisplayName() string {
+	if name, ok := displayNames[c.Name]; ok {
+		return name
 	}
-
-	return nil, fmt.Errorf("unable to detect CNI plugin")
+	return c.Name
 }
 
-func createKubernetesClient(kubeconfig string) (*kubernetes.Clientset, error) {
-	var config *rest.Config
-	var err error
+// IsCalico reports whether the detected CNI is Calico, including when it
+// provides network policy on top of another CNI.
+func (c *CNIInfo) IsCalico() bool {
+	return c.Name == "calico" || strings.Contains(c.Name, "with-calico")
+}
 
-	if kubeconfig != "" {
-		// Use provided kubeconfig file
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	} else {
-		// Use in-cluster config (when running inside a pod)
-		config, err = rest.InClusterConfig()
+// DetectCNI detects the CNI plugin being used in the Kubernetes cluster. The
+// Details of the result record the evidence it was detected from.
+func DetectCNI(ctx context.Context, client kubernetes.Interface) (*CNIInfo, error) {
+	detectors := []func(context.Context, kubernetes.Interface) (*CNIInfo, error){
+		// Method 1: Check DaemonSets
+		detectFromDaemonSets,
+		// Method 2: Check Deployments
+		detectFromDeployments,
+		// Method 3: Check pod images (e.g. operator managed installs)
+		detectFromPodImages,
+		// Method 4: Check ConfigMaps for CNI configuration
+		detectFromConfigMaps,
+		// Method 5: Check node annotations
+		detectFromNodeAnnotations,
+		// Method 6: Detect cloud provider specific CNI
+		detectCloudProviderCNI,
+	}
+	var lastErr error
+	for _, detect := range detectors {
+		cniInfo, err := detect(ctx, client)
+		if err == nil && cniInfo != nil {
+			return cniInfo, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
 	}
-
-	if err != nil {
-		return nil, err
+	if lastErr != nil {
+		return nil, fmt.Errorf("unable to detect CNI plugin: %w", lastErr)
 	}
-
-	return kubernetes.NewForConfig(config)
+	return nil, fmt.Errorf("unable to detect CNI plugin")
 }
 
-func detectFromDaemonSets(ctx context.Context, client *kubernetes.Clientset) (*CNIInfo, error) {
-	daemonSets, err := client.AppsV1().DaemonSets("kube-system").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+func matchPattern(patterns []cniPattern, s string) string {
+	s = strings.ToLower(s)
+	for _, p := range patterns {
+		if strings.Contains(s, p.match) {
+			return p.name
+		}
 	}
+	return ""
+}
 
-	cniPatterns := map[string]string{
-		"calico":  "calico",
-		"flannel": "flannel",
-		"weave":   "weave",
-		"cilium":  "cilium",
-		"antrea":  "antrea",
-		"canal":   "canal",
-		// AWS EKS CNI
-		"aws-node":   "aws-vpc-cni",
-		"kube-proxy": "aws-kube-proxy", // Often indicates EKS
-		// Azure AKS CNI
-		"azure-cni": "azure-cni",
-		"azure-npm": "azure-npm", // Azure Network Policy Manager
-		// GKE CNI
-		"gke-node": "gke-native", // GKE native networking
-		"ip-masq":  "gke-ip-masq-agent",
-	}
-
-	for _, ds := range daemonSets.Items {
-		dsName := strings.ToLower(ds.Name)
-		for pattern, cniName := range cniPatterns {
-			if strings.Contains(dsName, pattern) {
-				details := make(map[string]string)
-				details["daemonset"] = ds.Name
-				details["namespace"] = ds.Namespace
-
-				// Try to get version from image tag
-				version := "unknown"
-				if len(ds.Spec.Template.Spec.Containers) > 0 {
-					image := ds.Spec.Template.Spec.Containers[0].Image
-					if parts := strings.Split(image, ":"); len(parts) > 1 {
-						version = parts[1]
-					}
-				}
+func imageVersion(containers []corev1.Container) string {
+	if len(containers) > 0 {
+		if parts := strings.Split(containers[0].Image, ":"); len(parts) > 1 {
+			return parts[1]
+		}
+	}
+	return "unknown"
+}
 
+func detectFromDaemonSets(ctx context.Context, client kubernetes.Interface) (*CNIInfo, error) {
+	for _, ns := range namespaces {
+		daemonSets, err := client.AppsV1().DaemonSets(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			continue
+		}
+		for _, ds := range daemonSets.Items {
+			if cniName := matchPattern(cniPatterns, ds.Name); cniName != "" {
 				return &CNIInfo{
 					Name:    cniName,
-					Version: version,
-					Details: details,
+					Version: imageVersion(ds.Spec.Template.Spec.Containers),
+					Details: map[string]string{"source": "daemonset", "daemonset": ds.Name, "namespace": ds.Namespace},
 				}, nil
 			}
 		}
 	}
-
 	return nil, fmt.Errorf("no CNI found in daemonsets")
 }
 
-func detectFromDeployments(ctx context.Context, client *kubernetes.Clientset) (*CNIInfo, error) {
-	deployments, err := client.AppsV1().Deployments("kube-system").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
-	}
-
-	cniPatterns := map[string]string{
-		"calico":  "calico",
-		"flannel": "flannel",
-		"weave":   "weave",
-		"cilium":  "cilium",
-		"antrea":  "antrea",
-		// Azure AKS specific
-		"azure-cni": "azure-cni",
-		"coredns":   "coredns", // Often customized in managed clusters
-		// GKE specific
-		"gke":        "gke-native",
-		"l7-default": "gke-ingress",
-	}
-
-	for _, deploy := range deployments.Items {
-		deployName := strings.ToLower(deploy.Name)
-		for pattern, cniName := range cniPatterns {
-			if strings.Contains(deployName, pattern) {
-				details := make(map[string]string)
-				details["deployment"] = deploy.Name
-				details["namespace"] = deploy.Namespace
-
-				version := "unknown"
-				if len(deploy.Spec.Template.Spec.Containers) > 0 {
-					image := deploy.Spec.Template.Spec.Containers[0].Image
-					if parts := strings.Split(image, ":"); len(parts) > 1 {
-						version = parts[1]
-					}
-				}
-
+func detectFromDeployments(ctx context.Context, client kubernetes.Interface) (*CNIInfo, error) {
+	for _, ns := range namespaces {
+		deployments, err := client.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			continue
+		}
+		for _, deploy := range deployments.Items {
+			if cniName := matchPattern(cniPatterns, deploy.Name); cniName != "" {
 				return &CNIInfo{
 					Name:    cniName,
-					Version: version,
-					Details: details,
+					Version: imageVersion(deploy.Spec.Template.Spec.Containers),
+					Details: map[string]string{"source": "deployment", "deployment": deploy.Name, "namespace": deploy.Namespace},
 				}, nil
 			}
 		}
 	}
-
 	return nil, fmt.Errorf("no CNI found in deployments")
 }
 
-func detectFromConfigMaps(ctx context.Context, client *kubernetes.Clientset) (*CNIInfo, error) {
+func detectFromPodImages(ctx context.Context, client kubernetes.Interface) (*CNIInfo, error) {
+	for _, ns := range namespaces {
+		pods, err := client.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			continue
+		}
+		for _, pod := range pods.Items {
+			for _, c := range pod.Spec.Containers {
+				if cniName := matchPattern(imagePatterns, c.Image); cniName != "" {
+					return &CNIInfo{
+						Name:    cniName,
+						Version: imageVersion([]corev1.Container{c}),
+						Details: map[string]string{"source": "pod", "pod": pod.Name, "namespace": pod.Namespace, "image": c.Image},
+					}, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no CNI found in pod images")
+}
+
+func detectFromConfigMaps(ctx context.Context, client kubernetes.Interface) (*CNIInfo, error) {
 	configMaps, err := client.CoreV1().ConfigMaps("kube-system").List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -207,6 +219,7 @@ func detectFromConfigMaps(ctx context.Context, client *kubernetes.Clientset) (*C
 				for cniType, cniName := range cniTypes {
 					if strings.Contains(dataLower, cniType) {
 						details := make(map[string]string)
+						details["source"] = "configmap"
 						details["configmap"] = cm.Name
 						details["key"] = key
 						details["namespace"] = cm.Namespace
@@ -225,7 +238,7 @@ func detectFromConfigMaps(ctx context.Context, client *kubernetes.Clientset) (*C
 	return nil, fmt.Errorf("no CNI found in configmaps")
 }
 
-func detectFromNodeAnnotations(ctx context.Context, client *kubernetes.Clientset) (*CNIInfo, error) {
+func detectFromNodeAnnotations(ctx context.Context, client kubernetes.Interface) (*CNIInfo, error) {
 	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -249,6 +262,7 @@ func detectFromNodeAnnotations(ctx context.Context, client *kubernetes.Clientset
 				for cniType, cniName := range cniTypes {
 					if strings.Contains(valueLower, cniType) {
 						details := make(map[string]string)
+						details["source"] = "node-annotation"
 						details["node"] = node.Name
 						details["annotation"] = key
 						details["value"] = value
@@ -267,7 +281,7 @@ func detectFromNodeAnnotations(ctx context.Context, client *kubernetes.Clientset
 	return nil, fmt.Errorf("no CNI found in node annotations")
 }
 
-func detectCloudProviderCNI(ctx context.Context, client *kubernetes.Clientset) (*CNIInfo, error) {
+func detectCloudProviderCNI(ctx context.Context, client kubernetes.Interface) (*CNIInfo, error) {
 	// Method 1: Check nodes for cloud provider labels and annotations
 	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
@@ -392,13 +406,13 @@ func isGKE(node corev1.Node) bool {
 	return false
 }
 
-func hasAWSVPCCNI(ctx context.Context, client *kubernetes.Clientset) bool {
+func hasAWSVPCCNI(ctx context.Context, client kubernetes.Interface) bool {
 	// Check for AWS VPC CNI DaemonSet
 	_, err := client.AppsV1().DaemonSets("kube-system").Get(ctx, "aws-node", metav1.GetOptions{})
 	return err == nil
 }
 
-func getAWSVPCCNIVersion(ctx context.Context, client *kubernetes.Clientset) string {
+func getAWSVPCCNIVersion(ctx context.Context, client kubernetes.Interface) string {
 	ds, err := client.AppsV1().DaemonSets("kube-system").Get(ctx, "aws-node", metav1.GetOptions{})
 	if err != nil {
 		return "unknown"
@@ -414,7 +428,7 @@ func getAWSVPCCNIVersion(ctx context.Context, client *kubernetes.Clientset) stri
 	return "unknown"
 }
 
-func getAKSCNIType(ctx context.Context, client *kubernetes.Clientset, node corev1.Node) string {
+func getAKSCNIType(ctx context.Context, client kubernetes.Interface, node corev1.Node) string {
 	// Check for Azure CNI vs Kubenet
 	if networkPlugin, exists := node.Labels["kubernetes.azure.com/network-plugin"]; exists {
 		if networkPlugin == "azure" {
@@ -433,7 +447,7 @@ func getAKSCNIType(ctx context.Context, client *kubernetes.Clientset, node corev
 	return "azure-cni"
 }
 
-func getAKSCNIVersion(ctx context.Context, client *kubernetes.Clientset, cniType string) string {
+func getAKSCNIVersion(ctx context.Context, client kubernetes.Interface, cniType string) string {
 	// Try to get version from azure-cni-networkmonitor pod
 	pods, err := client.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{
 		LabelSelector: "component=azure-cni-networkmonitor",
@@ -450,7 +464,7 @@ func getAKSCNIVersion(ctx context.Context, client *kubernetes.Clientset, cniType
 	return "unknown"
 }
 
-func getGKECNIType(ctx context.Context, client *kubernetes.Clientset, node corev1.Node) string {
+func getGKECNIType(ctx context.Context, client kubernetes.Interface, node corev1.Node) string {
 	// Check for Dataplane V2 (Cilium)
 	if _, err := client.AppsV1().DaemonSets("kube-system").Get(ctx, "cilium", metav1.GetOptions{}); err == nil {
 		return "gke-dataplane-v2-cilium"
@@ -471,7 +485,7 @@ func getGKECNIType(ctx context.Context, client *kubernetes.Clientset, node corev
 	return "gke-native"
 }
 
-func getGKECNIVersion(ctx context.Context, client *kubernetes.Clientset) string {
+func getGKECNIVersion(ctx context.Context, client kubernetes.Interface) string {
 	// Try to get GKE version from node
 	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 1})
 	if err != nil || len(nodes.Items) == 0 {
@@ -1,11 +1,15 @@
 package cnitype
 
 import (
+	"context"
 	"strings"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestIsEKS(t *testing.T) {
@@ -526,3 +530,98 @@ func TestImageVersionParsing(t *testing.T) {
 		})
 	}
 }
+
+func daemonSet(ns, name, image string) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: name, Image: image}},
+		}}},
+	}
+}
+
+func TestDetectCNI(t *testing.T) {
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		wantName    string
+		wantVersion string
+		wantDetails map[string]string
+		wantErr     bool
+	}{
+		{
+			name: "operator install next to kube-proxy",
+			objects: []runtime.Object{
+				daemonSet("kube-system", "kube-proxy", "registry.k8s.io/kube-proxy:v1.33.0"),
+				daemonSet("calico-system", "calico-node", "docker.io/calico/node:v3.30.2"),
+			},
+			wantName:    "calico",
+			wantVersion: "v3.30.2",
+			wantDetails: map[string]string{"source": "daemonset", "daemonset": "calico-node", "namespace": "calico-system"},
+		},
+		{
+			name:        "canal wins over calico and flannel",
+			objects:     []runtime.Object{daemonSet("kube-system", "canal", "calico/node:v3.29.0")},
+			wantName:    "canal",
+			wantVersion: "v3.29.0",
+		},
+		{
+			name: "pod image",
+			objects: []runtime.Object{&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-abc", Namespace: "kube-system"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "agent", Image: "quay.io/cilium/cilium:v1.16.1"}}},
+			}},
+			wantName:    "cilium",
+			wantVersion: "v1.16.1",
+			wantDetails: map[string]string{"source": "pod", "pod": "node-abc", "namespace": "kube-system", "image": "quay.io/cilium/cilium:v1.16.1"},
+		},
+		{
+			name:    "nothing to go on",
+			objects: []runtime.Object{daemonSet("kube-system", "kube-proxy", "registry.k8s.io/kube-proxy:v1.33.0")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := DetectCNI(context.Background(), fake.NewSimpleClientset(tt.objects...))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if info.Name != tt.wantName || info.Version != tt.wantVersion {
+				t.Errorf("expected %s %s, got %s %s", tt.wantName, tt.wantVersion, info.Name, info.Version)
+			}
+			for k, v := range tt.wantDetails {
+				if info.Details[k] != v {
+					t.Errorf("expected detail %s = %q, got %q", k, v, info.Details[k])
+				}
+			}
+		})
+	}
+}
+
+func TestCNIInfo_DisplayName(t *testing.T) {
+	tests := []struct {
+		info       CNIInfo
+		want       string
+		wantCalico bool
+	}{
+		{info: CNIInfo{Name: "calico"}, want: "Calico", wantCalico: true},
+		{info: CNIInfo{Name: "gke-native-with-calico"}, want: "gke-native-with-calico", wantCalico: true},
+		{info: CNIInfo{Name: "aws-vpc-cni"}, want: "AWS VPC CNI"},
+	}
+	for _, tt := range tests {
+		if got := tt.info.DisplayName(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+		if got := tt.info.IsCalico(); got != tt.wantCalico {
+			t.Errorf("expected IsCalico() = %v for %s, got %v", tt.wantCalico, tt.info.Name, got)
+		}
+	}
+}
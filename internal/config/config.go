@@ -0,0 +1,363 @@
+// Package config loads the clyde configuration file and layers it with
+// per-context overrides, environment variables and command line flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/doucol/clyde/internal/alert"
+	"github.com/doucol/clyde/internal/catcher"
+	"github.com/doucol/clyde/internal/flowdata"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/yaml"
+)
+
+// EnvConfigFile names an alternative config file.
+const EnvConfigFile = "CLYDE_CONFIG"
+
+// Settings are the values that can be configured. The zero value of a field
+// means it is not set at that layer, so a lower layer (or the default) wins.
+type Settings struct {
+	// CalicoNamespace is where the Whisker pod runs
+	CalicoNamespace string `json:"calicoNamespace,omitempty"`
+	// WhiskerContainer is the container serving the flow stream
+	WhiskerContainer string `json:"whiskerContainer,omitempty"`
+	// URL connects straight to a flow stream instead of port-forwarding
+	URL string `json:"url,omitempty"`
+	// URLPath is the path of the flow stream on the Whisker backend
+	URLPath string `json:"urlPath,omitempty"`
+	// RateCalcWindow is the window, in seconds, rates are calculated over
+	RateCalcWindow int `json:"rateCalcWindow,omitempty"`
+	// RateCalcInterval is how often, in seconds, rates are recalculated
+	RateCalcInterval int `json:"rateCalcInterval,omitempty"`
+	// Backend is where flows are read from: whisker (the Whisker backend's
+	// SSE stream) or goldmane (Goldmane's gRPC API)
+	Backend string `json:"backend,omitempty"`
+	// GoldmaneContainer is the container serving the Goldmane API
+	GoldmaneContainer string `json:"goldmaneContainer,omitempty"`
+	// GoldmaneAddr connects straight to a Goldmane server instead of
+	// port-forwarding
+	GoldmaneAddr string `json:"goldmaneAddr,omitempty"`
+	// GoldmaneTLSSecret holds the client certificate Goldmane requires
+	GoldmaneTLSSecret string `json:"goldmaneTLSSecret,omitempty"`
+	// ReconnectMaxAttempts gives up on a cluster after that many failed
+	// attempts in a row to reach its flow stream, 0 never gives up
+	ReconnectMaxAttempts int `json:"reconnectMaxAttempts,omitempty"`
+	// ConnectMode is how the Whisker backend is reached: auto (the service
+	// proxy, falling back to port-forwarding), port-forward or service-proxy
+	ConnectMode string `json:"connectMode,omitempty"`
+	// WhiskerService is the service, as name or name:port, the service proxy
+	// streams through
+	WhiskerService string `json:"whiskerService,omitempty"`
+	// URLOptions configure the TLS, credentials, proxy and timeouts URL is
+	// connected with
+	URLOptions *catcher.HTTPOptions `json:"urlOptions,omitempty"`
+	// Ingest configures how flows are batched into the local store, and
+	// what happens to them when the store can't keep up
+	Ingest *flowdata.IngestOptions `json:"ingest,omitempty"`
+	// Retention bounds the flow data kept in the local store by age, count
+	// and file size
+	Retention *flowdata.RetentionOptions `json:"retention,omitempty"`
+	// Storage is where the local store keeps flows: bolt (the file of the
+	// session) or memory (nothing on disk, up to retention.maxFlows flows)
+	Storage string `json:"storage,omitempty"`
+}
+
+// File is the layout of the config file. Contexts holds overrides keyed by
+// kubeconfig context name. Alerts apply to every context.
+type File struct {
+	Settings
+	Contexts map[string]Settings `json:"contexts,omitempty"`
+	Alerts   *alert.Config       `json:"alerts,omitempty"`
+}
+
+// env maps each environment variable to the setting it overrides.
+var env = []struct {
+	name string
+	set  func(s *Settings, v string) error
+}{
+	{"CLYDE_CALICO_NAMESPACE", func(s *Settings, v string) error { s.CalicoNamespace = v; return nil }},
+	{"CLYDE_WHISKER_CONTAINER", func(s *Settings, v string) error { s.WhiskerContainer = v; return nil }},
+	{"CLYDE_WHISKER_URL", func(s *Settings, v string) error { s.URL = v; return nil }},
+	{"CLYDE_WHISKER_URL_PATH", func(s *Settings, v string) error { s.URLPath = v; return nil }},
+	{"CLYDE_RATE_WINDOW", func(s *Settings, v string) (err error) { s.RateCalcWindow, err = strconv.Atoi(v); return }},
+	{"CLYDE_RATE_INTERVAL", func(s *Settings, v string) (err error) { s.RateCalcInterval, err = strconv.Atoi(v); return }},
+	{"CLYDE_BACKEND", func(s *Settings, v string) error { s.Backend = v; return nil }},
+	{"CLYDE_GOLDMANE_CONTAINER", func(s *Settings, v string) error { s.GoldmaneContainer = v; return nil }},
+	{"CLYDE_GOLDMANE_ADDR", func(s *Settings, v string) error { s.GoldmaneAddr = v; return nil }},
+	{"CLYDE_GOLDMANE_TLS_SECRET", func(s *Settings, v string) error { s.GoldmaneTLSSecret = v; return nil }},
+	{"CLYDE_RECONNECT_MAX_ATTEMPTS", func(s *Settings, v string) (err error) { s.ReconnectMaxAttempts, err = strconv.Atoi(v); return }},
+	{"CLYDE_CONNECT_MODE", func(s *Settings, v string) error { s.ConnectMode = v; return nil }},
+	{"CLYDE_WHISKER_SERVICE", func(s *Settings, v string) error { s.WhiskerService = v; return nil }},
+	{"CLYDE_WHISKER_CA_FILE", func(s *Settings, v string) error { s.EnsureURLOptions().CAFile = v; return nil }},
+	{"CLYDE_WHISKER_CERT_FILE", func(s *Settings, v string) error { s.EnsureURLOptions().CertFile = v; return nil }},
+	{"CLYDE_WHISKER_KEY_FILE", func(s *Settings, v string) error { s.EnsureURLOptions().KeyFile = v; return nil }},
+	{"CLYDE_WHISKER_TOKEN_FILE", func(s *Settings, v string) error { s.EnsureURLOptions().BearerTokenFile = v; return nil }},
+	{"CLYDE_WHISKER_PROXY", func(s *Settings, v string) error { s.EnsureURLOptions().ProxyURL = v; return nil }},
+	{"CLYDE_INGEST_BATCH_SIZE", func(s *Settings, v string) (err error) { s.EnsureIngest().BatchSize, err = strconv.Atoi(v); return }},
+	{"CLYDE_INGEST_BATCH_LATENCY", func(s *Settings, v string) (err error) {
+		s.EnsureIngest().BatchLatency.Duration, err = time.ParseDuration(v)
+		return
+	}},
+	{"CLYDE_INGEST_QUEUE_SIZE", func(s *Settings, v string) (err error) { s.EnsureIngest().QueueSize, err = strconv.Atoi(v); return }},
+	{"CLYDE_INGEST_BACKPRESSURE", func(s *Settings, v string) error {
+		s.EnsureIngest().Backpressure = flowdata.Backpressure(v)
+		return nil
+	}},
+	{"CLYDE_RETENTION_MAX_AGE", func(s *Settings, v string) (err error) {
+		s.EnsureRetention().MaxAge.Duration, err = time.ParseDuration(v)
+		return
+	}},
+	{"CLYDE_RETENTION_MAX_FLOWS", func(s *Settings, v string) (err error) { s.EnsureRetention().MaxFlows, err = strconv.Atoi(v); return }},
+	{"CLYDE_RETENTION_MAX_SIZE", func(s *Settings, v string) (err error) {
+		s.EnsureRetention().MaxSize, err = resource.ParseQuantity(v)
+		return
+	}},
+	{"CLYDE_STORAGE", func(s *Settings, v string) error { s.Storage = v; return nil }},
+}
+
+// DefaultPath returns the config file location: $CLYDE_CONFIG when set,
+// otherwise clyde/config.yaml under $XDG_CONFIG_HOME (~/.config by default).
+func DefaultPath() string {
+	if p := os.Getenv(EnvConfigFile); p != "" {
+		return p
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(homedir.HomeDir(), ".config")
+	}
+	return filepath.Join(dir, "clyde", "config.yaml")
+}
+
+// Load reads the config file at path. A missing file is not an error, it
+// just leaves everything to the other layers.
+func Load(path string) (*File, error) {
+	f := &File{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	for name, s := range f.Contexts {
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: context %s: %w", path, name, err)
+		}
+	}
+	if f.Alerts != nil {
+		if err := f.Alerts.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+	return f, nil
+}
+
+// FromEnv returns the settings given by CLYDE_* environment variables.
+func FromEnv() (Settings, error) {
+	var s Settings
+	for _, e := range env {
+		if v, ok := os.LookupEnv(e.name); ok && v != "" {
+			if err := e.set(&s, v); err != nil {
+				return s, fmt.Errorf("invalid value for %s: %q", e.name, v)
+			}
+		}
+	}
+	return s, s.Validate()
+}
+
+// Resolve layers the settings for the named kube context: the top level of
+// the file, then the context's overrides, then each of the given layers in
+// order (e.g. env vars followed by flags).
+func (f *File) Resolve(kubeContext string, layers ...Settings) Settings {
+	s := f.Settings.Merge(f.Contexts[kubeContext])
+	for _, l := range layers {
+		s = s.Merge(l)
+	}
+	return s
+}
+
+// Merge returns s with every field that is set in over replaced.
+func (s Settings) Merge(over Settings) Settings {
+	if over.CalicoNamespace != "" {
+		s.CalicoNamespace = over.CalicoNamespace
+	}
+	if over.WhiskerContainer != "" {
+		s.WhiskerContainer = over.WhiskerContainer
+	}
+	if over.URL != "" {
+		s.URL = over.URL
+	}
+	if over.URLPath != "" {
+		s.URLPath = over.URLPath
+	}
+	if over.RateCalcWindow != 0 {
+		s.RateCalcWindow = over.RateCalcWindow
+	}
+	if over.RateCalcInterval != 0 {
+		s.RateCalcInterval = over.RateCalcInterval
+	}
+	if over.Backend != "" {
+		s.Backend = over.Backend
+	}
+	if over.GoldmaneContainer != "" {
+		s.GoldmaneContainer = over.GoldmaneContainer
+	}
+	if over.GoldmaneAddr != "" {
+		s.GoldmaneAddr = over.GoldmaneAddr
+	}
+	if over.GoldmaneTLSSecret != "" {
+		s.GoldmaneTLSSecret = over.GoldmaneTLSSecret
+	}
+	if over.ReconnectMaxAttempts != 0 {
+		s.ReconnectMaxAttempts = over.ReconnectMaxAttempts
+	}
+	if over.ConnectMode != "" {
+		s.ConnectMode = over.ConnectMode
+	}
+	if over.WhiskerService != "" {
+		s.WhiskerService = over.WhiskerService
+	}
+	s.URLOptions = mergeURLOptions(s.URLOptions, over.URLOptions)
+	if over.Ingest != nil {
+		ingest := *over.Ingest
+		if s.Ingest != nil {
+			ingest = s.Ingest.Merge(ingest)
+		}
+		s.Ingest = &ingest
+	}
+	if over.Retention != nil {
+		retention := *over.Retention
+		if s.Retention != nil {
+			retention = s.Retention.Merge(retention)
+		}
+		s.Retention = &retention
+	}
+	if over.Storage != "" {
+		s.Storage = over.Storage
+	}
+	return s
+}
+
+// mergeURLOptions returns o with every option that is set in over replaced.
+func mergeURLOptions(o, over *catcher.HTTPOptions) *catcher.HTTPOptions {
+	if over == nil {
+		return o
+	}
+	if o == nil {
+		return over
+	}
+	m := *o
+	if over.CAFile != "" {
+		m.CAFile = over.CAFile
+	}
+	if over.CertFile != "" {
+		m.CertFile, m.KeyFile = over.CertFile, over.KeyFile
+	}
+	if over.ServerName != "" {
+		m.ServerName = over.ServerName
+	}
+	if over.InsecureSkipVerify {
+		m.InsecureSkipVerify = true
+	}
+	if over.BearerToken != "" || over.BearerTokenFile != "" {
+		m.BearerToken, m.BearerTokenFile = over.BearerToken, over.BearerTokenFile
+	}
+	if over.Exec != nil {
+		m.Exec = over.Exec
+	}
+	if over.Headers != nil {
+		m.Headers = over.Headers
+	}
+	if over.ProxyURL != "" {
+		m.ProxyURL = over.ProxyURL
+	}
+	if over.ConnectTimeout.Duration != 0 {
+		m.ConnectTimeout = over.ConnectTimeout
+	}
+	if over.IdleTimeout.Duration != 0 {
+		m.IdleTimeout = over.IdleTimeout
+	}
+	return &m
+}
+
+// EnsureURLOptions returns the URL options, adding them when there are
+// none, so they can be set one at a time.
+func (s *Settings) EnsureURLOptions() *catcher.HTTPOptions {
+	if s.URLOptions == nil {
+		s.URLOptions = &catcher.HTTPOptions{}
+	}
+	return s.URLOptions
+}
+
+// EnsureIngest returns the ingest options, adding them when there are none,
+// so they can be set one at a time.
+func (s *Settings) EnsureIngest() *flowdata.IngestOptions {
+	if s.Ingest == nil {
+		s.Ingest = &flowdata.IngestOptions{}
+	}
+	return s.Ingest
+}
+
+// EnsureRetention returns the retention options, adding them when there are
+// none, so they can be set one at a time.
+func (s *Settings) EnsureRetention() *flowdata.RetentionOptions {
+	if s.Retention == nil {
+		s.Retention = &flowdata.RetentionOptions{}
+	}
+	return s.Retention
+}
+
+func (s Settings) Validate() error {
+	if s.RateCalcWindow < 0 {
+		return fmt.Errorf("rateCalcWindow must be positive, got %d", s.RateCalcWindow)
+	}
+	if s.RateCalcInterval < 0 {
+		return fmt.Errorf("rateCalcInterval must be positive, got %d", s.RateCalcInterval)
+	}
+	if s.ReconnectMaxAttempts < 0 {
+		return fmt.Errorf("reconnectMaxAttempts must be positive, got %d", s.ReconnectMaxAttempts)
+	}
+	switch s.Backend {
+	case "", "whisker", "goldmane":
+	default:
+		return fmt.Errorf("backend must be whisker or goldmane, got %q", s.Backend)
+	}
+	switch s.Storage {
+	case "", flowdata.StorageBolt, flowdata.StorageMemory:
+	default:
+		return fmt.Errorf("storage must be bolt or memory, got %q", s.Storage)
+	}
+	switch s.ConnectMode {
+	case "", "auto", "port-forward", "service-proxy":
+	default:
+		return fmt.Errorf("connectMode must be auto, port-forward or service-proxy, got %q", s.ConnectMode)
+	}
+	if s.URLOptions != nil {
+		if err := s.URLOptions.Validate(); err != nil {
+			return fmt.Errorf("urlOptions: %w", err)
+		}
+	}
+	if s.Ingest != nil {
+		if err := s.Ingest.Validate(); err != nil {
+			return fmt.Errorf("ingest: %w", err)
+		}
+	}
+	if s.Retention != nil {
+		if err := s.Retention.Validate(); err != nil {
+			return fmt.Errorf("retention: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultPath(t *testing.T) {
+	t.Setenv(EnvConfigFile, "")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	if got := DefaultPath(); got != filepath.Join("/xdg", "clyde", "config.yaml") {
+		t.Errorf("expected the XDG config dir, got %s", got)
+	}
+	t.Setenv(EnvConfigFile, "/etc/clyde.yaml")
+	if got := DefaultPath(); got != "/etc/clyde.yaml" {
+		t.Errorf("expected %s to win, got %s", EnvConfigFile, got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil || f == nil {
+		t.Fatalf("expected an empty config for a missing file, got %v, %v", f, err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "unknown field", content: "calicoNamespce: x\n", wantErr: "calicoNamespce"},
+		{name: "bad type", content: "rateCalcWindow: soon\n", wantErr: "invalid config file"},
+		{name: "negative", content: "rateCalcInterval: -1\n", wantErr: "rateCalcInterval"},
+		{name: "negative in context", content: "contexts:\n  prod:\n    rateCalcWindow: -5\n", wantErr: "context prod"},
+		{name: "unknown backend", content: "backend: loki\n", wantErr: "backend must be whisker or goldmane"},
+		{name: "negative max attempts", content: "reconnectMaxAttempts: -3\n", wantErr: "reconnectMaxAttempts"},
+		{name: "unknown storage", content: "storage: sqlite\n", wantErr: "storage must be bolt or memory"},
+		{name: "unknown connect mode", content: "contexts:\n  prod:\n    connectMode: ssh\n", wantErr: "connectMode must be"},
+		{name: "bad alert rule", content: "alerts:\n  rules:\n  - name: hot\n    type: rate\n", wantErr: "alerts: rule hot: threshold"},
+		{name: "bad duration", content: "alerts:\n  cooldown: soon\n", wantErr: "invalid config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+	f, err = Load(writeConfig(t, `
+alerts:
+  cooldown: 1m
+  rules:
+  - name: hot
+    type: rate
+    field: dest_total_byte_rate
+    threshold: 1e6
+    for: 30s
+  notifiers:
+  - type: webhook
+    url: http://localhost:9000/hook
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := f.Alerts.Rules[0]; r.Field != "DestTotalByteRate" || r.For.Duration != 30*time.Second || f.Alerts.Cooldown.Duration != time.Minute {
+		t.Errorf("unexpected alerts %+v", f.Alerts)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	f, err := Load(writeConfig(t, `
+calicoNamespace: calico-system
+rateCalcWindow: 120
+contexts:
+  enterprise:
+    calicoNamespace: tigera-system
+    urlPath: /api/flows?watch=true
+  no-whisker:
+    backend: goldmane
+  locked-down:
+    connectMode: service-proxy
+    whiskerService: whisker:8081
+  ephemeral:
+    storage: memory
+`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		context string
+		layers  []Settings
+		want    Settings
+	}{
+		{
+			name:    "file only",
+			context: "dev",
+			want:    Settings{CalicoNamespace: "calico-system", RateCalcWindow: 120},
+		},
+		{
+			name:    "context overrides the file",
+			context: "enterprise",
+			want:    Settings{CalicoNamespace: "tigera-system", URLPath: "/api/flows?watch=true", RateCalcWindow: 120},
+		},
+		{
+			name:    "later layers win",
+			context: "enterprise",
+			layers:  []Settings{{CalicoNamespace: "from-env", RateCalcInterval: 10}, {CalicoNamespace: "from-flag"}},
+			want:    Settings{CalicoNamespace: "from-flag", URLPath: "/api/flows?watch=true", RateCalcWindow: 120, RateCalcInterval: 10},
+		},
+		{
+			name:    "backend per context",
+			context: "no-whisker",
+			layers:  []Settings{{GoldmaneAddr: "localhost:7443"}},
+			want:    Settings{CalicoNamespace: "calico-system", RateCalcWindow: 120, Backend: "goldmane", GoldmaneAddr: "localhost:7443"},
+		},
+		{
+			name:    "connect mode per context",
+			context: "locked-down",
+			want:    Settings{CalicoNamespace: "calico-system", RateCalcWindow: 120, ConnectMode: "service-proxy", WhiskerService: "whisker:8081"},
+		},
+		{
+			name:    "storage per context",
+			context: "ephemeral",
+			want:    Settings{CalicoNamespace: "calico-system", RateCalcWindow: 120, Storage: "memory"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Resolve(tt.context, tt.layers...); got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolve_URLOptions(t *testing.T) {
+	f, err := Load(writeConfig(t, `
+url: https://whisker.example.com
+urlOptions:
+  caFile: /etc/clyde/ca.crt
+  bearerTokenFile: /etc/clyde/token
+  idleTimeout: 2m
+contexts:
+  prod:
+    urlOptions:
+      proxyURL: http://proxy:3128
+      exec:
+        command: get-token
+        apiVersion: client.authentication.k8s.io/v1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := Settings{}
+	flags.EnsureURLOptions().BearerTokenFile = "/tmp/token"
+	got := f.Resolve("prod", flags).URLOptions
+	if got.CAFile != "/etc/clyde/ca.crt" || got.BearerTokenFile != "/tmp/token" || got.ProxyURL != "http://proxy:3128" ||
+		got.Exec == nil || got.Exec.Command != "get-token" || got.IdleTimeout.Duration != 2*time.Minute {
+		t.Errorf("expected the URL options layered option by option, got %+v", got)
+	}
+	if f.URLOptions.BearerTokenFile != "/etc/clyde/token" || f.URLOptions.ProxyURL != "" {
+		t.Errorf("expected resolving to leave the file alone, got %+v", f.URLOptions)
+	}
+
+	_, err = Load(writeConfig(t, "urlOptions:\n  certFile: tls.crt\n"))
+	if err == nil || !strings.Contains(err.Error(), "urlOptions: certFile and keyFile") {
+		t.Errorf("expected invalid URL options to be rejected, got %v", err)
+	}
+}
+
+func TestResolve_Ingest(t *testing.T) {
+	f, err := Load(writeConfig(t, `
+ingest:
+  batchSize: 1000
+  batchLatency: 250ms
+contexts:
+  prod:
+    ingest:
+      backpressure: drop-oldest
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLYDE_INGEST_BATCH_LATENCY", "500ms")
+	t.Setenv("CLYDE_INGEST_QUEUE_SIZE", "20000")
+	env, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := Settings{}
+	flags.EnsureIngest().QueueSize = 50000
+	got := f.Resolve("prod", env.Merge(flags)).Ingest
+	if got.BatchSize != 1000 || got.BatchLatency.Duration != 500*time.Millisecond || got.Backpressure != "drop-oldest" || got.QueueSize != 50000 {
+		t.Errorf("expected the ingest options layered option by option, got %+v", got)
+	}
+
+	_, err = Load(writeConfig(t, "ingest:\n  backpressure: spill\n"))
+	if err == nil || !strings.Contains(err.Error(), "ingest: backpressure must be") {
+		t.Errorf("expected an unknown backpressure policy to be rejected, got %v", err)
+	}
+}
+
+func TestResolve_Retention(t *testing.T) {
+	f, err := Load(writeConfig(t, `
+retention:
+  maxAge: 24h
+  maxSize: 256Mi
+contexts:
+  prod:
+    retention:
+      maxFlows: 100000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLYDE_RETENTION_MAX_SIZE", "1Gi")
+	env, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := f.Resolve("prod", env).Retention
+	if got.MaxAge.Duration != 24*time.Hour || got.MaxFlows != 100000 || got.MaxSize.String() != "1Gi" {
+		t.Errorf("expected the retention options layered option by option, got %+v", got)
+	}
+
+	_, err = Load(writeConfig(t, "retention:\n  maxFlows: -1\n"))
+	if err == nil || !strings.Contains(err.Error(), "retention: maxAge, maxFlows") {
+		t.Errorf("expected a negative limit to be rejected, got %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("CLYDE_CALICO_NAMESPACE", "tigera-system")
+	t.Setenv("CLYDE_WHISKER_URL", "http://localhost:8080")
+	t.Setenv("CLYDE_RATE_WINDOW", "30")
+	t.Setenv("CLYDE_RECONNECT_MAX_ATTEMPTS", "5")
+	t.Setenv("CLYDE_STORAGE", "memory")
+	s, err := FromEnv()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := Settings{CalicoNamespace: "tigera-system", URL: "http://localhost:8080", RateCalcWindow: 30, ReconnectMaxAttempts: 5, Storage: "memory"}
+	if s != want {
+		t.Errorf("expected %+v, got %+v", want, s)
+	}
+
+	t.Setenv("CLYDE_RATE_INTERVAL", "often")
+	if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), "CLYDE_RATE_INTERVAL") {
+		t.Errorf("expected an error naming CLYDE_RATE_INTERVAL, got %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+// Package doctor checks, step by step, everything clyde needs to get flows
+// out of a cluster and suggests a fix for each step that fails.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/doucol/clyde/internal/catcher"
+	"github.com/doucol/clyde/internal/util"
+	"github.com/sirupsen/logrus"
+	authv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusFail Status = "fail"
+	// StatusSkip marks a step that could not run because an earlier one
+	// failed, or that does not apply (e.g. port-forwarding to a direct URL)
+	StatusSkip Status = "skip"
+)
+
+// Result is the outcome of one step.
+type Result struct {
+	Step   string        `json:"step"`
+	Status Status        `json:"status"`
+	Detail string        `json:"detail,omitempty"`
+	Fix    string        `json:"fix,omitempty"`
+	Took   time.Duration `json:"took"`
+}
+
+// ForwardFunc port-forwards to the pod and returns the local base URL along
+// with a func that stops the forward.
+type ForwardFunc func(ctx context.Context, config *rest.Config, namespace, pod, port string) (string, func(), error)
+
+type Doctor struct {
+	KubeconfigPath   string
+	KubeconfigSource string
+	// KubeContext is the context to check, empty for the current context
+	KubeContext string
+	Namespace   string
+	Container   string
+	PortEnvVar  string
+	URLPath     string
+	// URL skips the pod lookup and port-forward and checks this stream
+	URL string
+	// Timeout bounds each step, including the wait for the first event
+	Timeout time.Duration
+	// Connect returns the rest config and clientset for the context
+	Connect func() (*rest.Config, kubernetes.Interface, error)
+	// Forward defaults to a port-forward through the API server
+	Forward ForwardFunc
+	// OnResult, when set, is called as each step finishes
+	OnResult func(Result)
+}
+
+// state is what the steps hand on to the ones after them.
+type state struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+	pod, port string
+	baseURL   string
+	stop      func()
+	resp      *http.Response
+}
+
+type step struct {
+	name string
+	run  func(ctx context.Context, s *state) (detail string, err error)
+	fix  string
+	// skip returns why the step doesn't apply, or "" when it does
+	skip func() string
+}
+
+// Run checks each step in order and returns their results. Once a step fails
+// the ones after it are skipped.
+func (d *Doctor) Run(ctx context.Context) []Result {
+	s := &state{}
+	defer func() {
+		if s.resp != nil {
+			s.resp.Body.Close()
+		}
+		if s.stop != nil {
+			s.stop()
+		}
+	}()
+	var results []Result
+	failed := ""
+	for _, st := range d.steps() {
+		r := Result{Step: st.name}
+		switch {
+		case failed != "":
+			r.Status = StatusSkip
+			r.Detail = fmt.Sprintf("skipped, %s failed", failed)
+		case st.skip != nil && st.skip() != "":
+			r.Status = StatusSkip
+			r.Detail = st.skip()
+		default:
+			stepCtx, cancel := context.WithTimeout(ctx, d.Timeout)
+			start := time.Now()
+			detail, err := st.run(stepCtx, s)
+			r.Took = time.Since(start).Round(time.Millisecond)
+			cancel()
+			r.Status = StatusPass
+			r.Detail = detail
+			if err != nil {
+				logrus.Debugf("doctor: %s failed: %v", st.name, err)
+				r.Status = StatusFail
+				r.Detail = err.Error()
+				r.Fix = st.fix
+				failed = st.name
+			}
+		}
+		results = append(results, r)
+		if d.OnResult != nil {
+			d.OnResult(r)
+		}
+	}
+	return results
+}
+
+// Failed reports whether any of the results failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Doctor) steps() []step {
+	directURL := func() string {
+		if d.URL != "" {
+			return "not needed, connecting to " + d.URL
+		}
+		return ""
+	}
+	return []step{
+		{
+			name: "kubeconfig",
+			run:  d.checkKubeconfig,
+			fix:  "Point --kubeconfig (or $KUBECONFIG) at a valid kubeconfig and pick an existing --context",
+			skip: directURL,
+		},
+		{
+			name: "api server",
+			run:  d.checkAPIServer,
+			fix:  "Check the cluster is up, your network/VPN, and that the kubeconfig credentials haven't expired",
+			skip: directURL,
+		},
+		{
+			name: "rbac: list pods",
+			run: func(ctx context.Context, s *state) (string, error) {
+				return d.checkAccess(ctx, s, "list", "pods", "")
+			},
+			fix:  fmt.Sprintf("Grant your user a Role in %s that allows list on pods", d.Namespace),
+			skip: directURL,
+		},
+		{
+			name: "rbac: port-forward",
+			run: func(ctx context.Context, s *state) (string, error) {
+				return d.checkAccess(ctx, s, "create", "pods", "portforward")
+			},
+			fix:  fmt.Sprintf("Grant your user a Role in %s that allows create on pods/portforward", d.Namespace),
+			skip: directURL,
+		},
+		{
+			name: "whisker pod",
+			run:  d.checkWhiskerPod,
+			fix: fmt.Sprintf("Enable Whisker (Calico 3.30+), or set --calico-namespace / --whisker-container if it runs elsewhere; "+
+				"the %s container needs a %s env var", d.Container, d.PortEnvVar),
+			skip: directURL,
+		},
+		{
+			name: "port-forward",
+			run:  d.checkPortForward,
+			fix:  "Check the Whisker pod is Running and Ready (kubectl describe pod) and that the API server can reach the node",
+			skip: directURL,
+		},
+		{
+			name: "sse handshake",
+			run:  d.checkHandshake,
+			fix:  "Check --whisker-url-path matches the Whisker backend's flow stream (default /flows?watch=true)",
+		},
+		{
+			name: "first event",
+			run:  d.checkFirstEvent,
+			fix:  "Flows only stream as traffic happens: generate some pod traffic, or raise --timeout",
+		},
+	}
+}
+
+func (d *Doctor) checkKubeconfig(ctx context.Context, s *state) (string, error) {
+	kc, err := util.LoadKubeconfigInfo(d.KubeconfigPath, d.KubeconfigSource)
+	if err != nil {
+		return "", err
+	}
+	name := d.KubeContext
+	if name == "" {
+		name = kc.CurrentContext
+	}
+	found := false
+	for _, c := range kc.Contexts {
+		found = found || c == name
+	}
+	if !found {
+		return "", fmt.Errorf("context %q not found in %s", name, kc.Path)
+	}
+	if s.config, s.clientset, err = d.Connect(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("context %s from %s (%s)", name, kc.Path, kc.Source), nil
+}
+
+func (d *Doctor) checkAPIServer(ctx context.Context, s *state) (string, error) {
+	v, err := s.clientset.Discovery().ServerVersion()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s is running Kubernetes %s", s.config.Host, v.GitVersion), nil
+}
+
+func (d *Doctor) checkAccess(ctx context.Context, s *state, verb, resource, subresource string) (string, error) {
+	review := &authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authv1.ResourceAttributes{
+				Namespace:   d.Namespace,
+				Verb:        verb,
+				Resource:    resource,
+				Subresource: subresource,
+			},
+		},
+	}
+	res, err := s.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	what := resource
+	if subresource != "" {
+		what += "/" + subresource
+	}
+	if !res.Status.Allowed {
+		reason := res.Status.Reason
+		if reason == "" {
+			reason = "no reason given"
+		}
+		return "", fmt.Errorf("not allowed to %s %s in %s: %s", verb, what, d.Namespace, reason)
+	}
+	return fmt.Sprintf("allowed to %s %s in %s", verb, what, d.Namespace), nil
+}
+
+func (d *Doctor) checkWhiskerPod(ctx context.Context, s *state) (string, error) {
+	pod, port, err := util.GetPodAndEnvVarByContainerName(ctx, s.clientset, d.Namespace, d.Container, d.PortEnvVar)
+	if err != nil {
+		return "", fmt.Errorf("no pod in %s with a %s container and a %s env var", d.Namespace, d.Container, d.PortEnvVar)
+	}
+	s.pod, s.port = pod, port
+	return fmt.Sprintf("%s/%s serves on port %s", d.Namespace, pod, port), nil
+}
+
+func (d *Doctor) checkPortForward(ctx context.Context, s *state) (string, error) {
+	forward := d.Forward
+	if forward == nil {
+		forward = portForward
+	}
+	baseURL, stop, err := forward(ctx, s.config, d.Namespace, s.pod, s.port)
+	if err != nil {
+		return "", err
+	}
+	// The forward stays up for the steps that follow
+	s.baseURL, s.stop = baseURL, stop
+	return "forwarding " + baseURL + " to " + s.pod + ":" + s.port, nil
+}
+
+func (d *Doctor) checkHandshake(ctx context.Context, s *state) (string, error) {
+	url := d.URL
+	if url == "" {
+		url = s.baseURL + d.URLPath
+	}
+	// The stream outlives this step, the next one reads from it
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	client := &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: d.Timeout}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to SSE stream: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return "", fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	s.resp = resp
+	ct := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "text/event-stream") {
+		return fmt.Sprintf("%s responded %s with content type %q, not text/event-stream", url, resp.Status, ct), nil
+	}
+	return fmt.Sprintf("%s responded %s", url, resp.Status), nil
+}
+
+func (d *Doctor) checkFirstEvent(ctx context.Context, s *state) (string, error) {
+	start := time.Now()
+	first := make(chan error, 1)
+	go func() {
+		_, err := catcher.NewEventReader(s.resp.Body, 0).Next()
+		if util.IsErr(err, io.EOF, io.ErrUnexpectedEOF) {
+			err = errors.New("stream closed before the first event")
+		}
+		first <- err
+	}()
+	select {
+	case err := <-first:
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("first flow after %s", time.Since(start).Round(time.Millisecond)), nil
+	case <-ctx.Done():
+		// Unblock the reader
+		s.resp.Body.Close()
+		return "", fmt.Errorf("no flow within %s", d.Timeout)
+	}
+}
+
+// portForward forwards a free local port to the pod through the API server.
+func portForward(ctx context.Context, config *rest.Config, namespace, pod, port string) (string, func(), error) {
+	stopChan := make(chan struct{})
+	readyChan := make(chan struct{})
+	pf, localPort, err := catcher.PortForward(config, namespace, pod, port, stopChan, readyChan)
+	if err != nil {
+		return "", nil, err
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- pf.ForwardPorts()
+	}()
+	stop := func() { util.ChanClose(stopChan) }
+	select {
+	case <-readyChan:
+		return fmt.Sprintf("http://localhost:%d", localPort), stop, nil
+	case err := <-errChan:
+		return "", nil, err
+	case <-ctx.Done():
+		stop()
+		return "", nil, fmt.Errorf("port-forward was not ready in time: %w", ctx.Err())
+	}
+}
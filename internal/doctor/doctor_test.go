@@ -0,0 +1,197 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	authv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const kubeconfig = `apiVersion: v1
+kind: Config
+current-context: kind
+contexts:
+- name: kind
+  context: {cluster: kind, user: kind}
+clusters:
+- name: kind
+  cluster: {server: "https://127.0.0.1:6443"}
+users:
+- name: kind
+  user: {token: abc}
+`
+
+var whiskerPod = &corev1.Pod{
+	ObjectMeta: metav1.ObjectMeta{Name: "whisker-abc", Namespace: "calico-system"},
+	Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Name: "whisker-backend",
+		Env:  []corev1.EnvVar{{Name: "PORT", Value: "3002"}},
+	}}},
+}
+
+// newDoctor returns a Doctor against a fake cluster, forwarding to server.
+func newDoctor(t *testing.T, server *httptest.Server, denied string, objects ...runtime.Object) *Doctor {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.SelfSubjectAccessReview)
+		ra := review.Spec.ResourceAttributes
+		review.Status.Allowed = ra.Resource+"/"+ra.Subresource != denied
+		return true, review, nil
+	})
+	return &Doctor{
+		KubeconfigPath: path,
+		Namespace:      "calico-system",
+		Container:      "whisker-backend",
+		PortEnvVar:     "PORT",
+		URLPath:        "/flows?watch=true",
+		Timeout:        500 * time.Millisecond,
+		Connect: func() (*rest.Config, kubernetes.Interface, error) {
+			return &rest.Config{Host: "https://127.0.0.1:6443"}, client, nil
+		},
+		Forward: func(ctx context.Context, config *rest.Config, namespace, pod, port string) (string, func(), error) {
+			if pod != "whisker-abc" || port != "3002" {
+				return "", nil, fmt.Errorf("unexpected pod %s:%s", pod, port)
+			}
+			return server.URL, func() {}, nil
+		},
+	}
+}
+
+func sseServer(data string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/flows" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		if data != "" {
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+	}))
+}
+
+func statuses(results []Result) string {
+	var s []string
+	for _, r := range results {
+		s = append(s, string(r.Status))
+	}
+	return strings.Join(s, ",")
+}
+
+func TestDoctor_Run(t *testing.T) {
+	flowing := sseServer(`{"action":"Allow"}`)
+	defer flowing.Close()
+	quiet := sseServer("")
+	defer quiet.Close()
+
+	tests := []struct {
+		name     string
+		doctor   func() *Doctor
+		want     string
+		wantFail string
+	}{
+		{
+			name:   "all good",
+			doctor: func() *Doctor { return newDoctor(t, flowing, "", whiskerPod) },
+			want:   "pass,pass,pass,pass,pass,pass,pass,pass",
+		},
+		{
+			name: "unknown context",
+			doctor: func() *Doctor {
+				d := newDoctor(t, flowing, "", whiskerPod)
+				d.KubeContext = "prod"
+				return d
+			},
+			want:     "fail,skip,skip,skip,skip,skip,skip,skip",
+			wantFail: `context "prod" not found`,
+		},
+		{
+			name:     "port-forward denied",
+			doctor:   func() *Doctor { return newDoctor(t, flowing, "pods/portforward", whiskerPod) },
+			want:     "pass,pass,pass,fail,skip,skip,skip,skip",
+			wantFail: "not allowed to create pods/portforward in calico-system",
+		},
+		{
+			name:     "no whisker",
+			doctor:   func() *Doctor { return newDoctor(t, flowing, "") },
+			want:     "pass,pass,pass,pass,fail,skip,skip,skip",
+			wantFail: "no pod in calico-system with a whisker-backend container",
+		},
+		{
+			name: "wrong path",
+			doctor: func() *Doctor {
+				d := newDoctor(t, flowing, "", whiskerPod)
+				d.URLPath = "/api/flows"
+				return d
+			},
+			want:     "pass,pass,pass,pass,pass,pass,fail,skip",
+			wantFail: "404 Not Found",
+		},
+		{
+			name:     "no flows",
+			doctor:   func() *Doctor { return newDoctor(t, quiet, "", whiskerPod) },
+			want:     "pass,pass,pass,pass,pass,pass,pass,fail",
+			wantFail: "no flow within 500ms",
+		},
+		{
+			name: "direct url",
+			doctor: func() *Doctor {
+				d := newDoctor(t, flowing, "")
+				d.URL = flowing.URL + "/flows?watch=true"
+				return d
+			},
+			want: "skip,skip,skip,skip,skip,skip,pass,pass",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.doctor()
+			var reported int
+			d.OnResult = func(Result) { reported++ }
+			results := d.Run(context.Background())
+			if got := statuses(results); got != tt.want {
+				t.Fatalf("expected %s, got %s: %+v", tt.want, got, results)
+			}
+			if reported != len(results) {
+				t.Errorf("expected OnResult for each of the %d steps, got %d", len(results), reported)
+			}
+			if Failed(results) != (tt.wantFail != "") {
+				t.Errorf("expected Failed() = %v", tt.wantFail != "")
+			}
+			for _, r := range results {
+				if r.Status != StatusFail {
+					continue
+				}
+				if !strings.Contains(r.Detail, tt.wantFail) {
+					t.Errorf("expected %s to fail with %q, got %q", r.Step, tt.wantFail, r.Detail)
+				}
+				if r.Fix == "" {
+					t.Errorf("expected a fix for %s", r.Step)
+				}
+			}
+		})
+	}
+}
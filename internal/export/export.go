@@ -0,0 +1,80 @@
+// Package export writes the locally captured flow data out as JSON, NDJSON
+// or CSV.
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/printer"
+)
+
+type Kind string
+
+const (
+	KindSums  Kind = "sums"
+	KindFlows Kind = "flows"
+	// KindAll nests the flows of each summary under it.
+	KindAll Kind = "all"
+)
+
+// Formats are the output formats supported by Write.
+var Formats = []printer.Format{printer.FormatJSON, printer.FormatNDJSON, printer.FormatCSV}
+
+type Source interface {
+	GetFlowSums(filter flowdata.FilterAttributes) []*flowdata.FlowSum
+	GetFlowsBySumID(sumID int, filter flowdata.FilterAttributes) []*flowdata.FlowData
+}
+
+type Options struct {
+	Kind   Kind
+	Format printer.Format
+	Filter flowdata.FilterAttributes
+}
+
+// SumWithFlows is the record written for KindAll.
+type SumWithFlows struct {
+	*flowdata.FlowSum
+	Flows []*flowdata.FlowData `json:"flows"`
+}
+
+// Write exports the records selected by opts from src to w. The filter is
+// applied the same way the TUI applies it: namespace, name and port select
+// summaries, and flows are listed per selected summary.
+func Write(w io.Writer, src Source, opts Options) error {
+	sums := src.GetFlowSums(opts.Filter)
+	switch opts.Kind {
+	case KindSums:
+		return write(w, opts.Format, sums)
+	case KindFlows:
+		flows := []*flowdata.FlowData{}
+		for _, fs := range sums {
+			flows = append(flows, src.GetFlowsBySumID(fs.ID, opts.Filter)...)
+		}
+		return write(w, opts.Format, flows)
+	case KindAll:
+		if opts.Format == printer.FormatCSV {
+			return fmt.Errorf("csv output requires the %q or %q kind", KindSums, KindFlows)
+		}
+		recs := make([]*SumWithFlows, 0, len(sums))
+		for _, fs := range sums {
+			recs = append(recs, &SumWithFlows{FlowSum: fs, Flows: src.GetFlowsBySumID(fs.ID, opts.Filter)})
+		}
+		return write(w, opts.Format, recs)
+	}
+	return fmt.Errorf("invalid export kind %q, must be one of: %s, %s, %s", opts.Kind, KindSums, KindFlows, KindAll)
+}
+
+func write[T any](w io.Writer, format printer.Format, recs []T) error {
+	p, err := printer.New(w, format, "", printer.FieldColumns[T]())
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		if err := p.Print(r); err != nil {
+			return err
+		}
+	}
+	return p.Flush()
+}
@@ -0,0 +1,147 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/printer"
+)
+
+type mockSource struct {
+	sums    []*flowdata.FlowSum
+	flows   map[int][]*flowdata.FlowData
+	filters []flowdata.FilterAttributes
+}
+
+func (m *mockSource) GetFlowSums(filter flowdata.FilterAttributes) []*flowdata.FlowSum {
+	m.filters = append(m.filters, filter)
+	return m.sums
+}
+
+func (m *mockSource) GetFlowsBySumID(sumID int, filter flowdata.FilterAttributes) []*flowdata.FlowData {
+	m.filters = append(m.filters, filter)
+	return m.flows[sumID]
+}
+
+func newMockSource() *mockSource {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	return &mockSource{
+		sums: []*flowdata.FlowSum{
+			{ID: 1, Key: "a", SourceName: "src1", Action: "Allow", SourcePacketsIn: 10},
+			{ID: 2, Key: "b", SourceName: "src2", Action: "Deny"},
+		},
+		flows: map[int][]*flowdata.FlowData{
+			1: {
+				{ID: 10, SumID: 1, FlowResponse: flowdata.FlowResponse{
+					StartTime: start, EndTime: start.Add(15 * time.Second), SourceName: "src1", Reporter: "Src",
+					Policies: flowdata.PolicyTrace{Enforced: []*flowdata.PolicyHit{{Name: "allow-all", Action: "Allow"}}},
+				}},
+			},
+			2: {
+				{ID: 11, SumID: 2, FlowResponse: flowdata.FlowResponse{SourceName: "src2", Reporter: "Dst"}},
+			},
+		},
+	}
+}
+
+func TestWrite_SumsJSON(t *testing.T) {
+	src := newMockSource()
+	filter := flowdata.FilterAttributes{Action: "Allow"}
+	var buf bytes.Buffer
+	if err := Write(&buf, src, Options{Kind: KindSums, Format: printer.FormatJSON, Filter: filter}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var got []*flowdata.FlowSum
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected valid json, got %v: %s", err, buf.String())
+	}
+	if len(got) != 2 || got[0].SourcePacketsIn != 10 {
+		t.Errorf("expected 2 sums, got %+v", got)
+	}
+	if len(src.filters) != 1 || src.filters[0] != filter {
+		t.Errorf("expected the filter to be passed to the source, got %+v", src.filters)
+	}
+}
+
+func TestWrite_FlowsNDJSON(t *testing.T) {
+	src := newMockSource()
+	var buf bytes.Buffer
+	if err := Write(&buf, src, Options{Kind: KindFlows, Format: printer.FormatNDJSON}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	var fd flowdata.FlowData
+	if err := json.Unmarshal([]byte(lines[0]), &fd); err != nil {
+		t.Fatalf("expected valid json, got %v", err)
+	}
+	if fd.ID != 10 || len(fd.Policies.Enforced) != 1 || fd.Policies.Enforced[0].Name != "allow-all" {
+		t.Errorf("expected flow 10 with its policy trace, got %+v", fd)
+	}
+}
+
+func TestWrite_FlowsCSV(t *testing.T) {
+	src := newMockSource()
+	var buf bytes.Buffer
+	if err := Write(&buf, src, Options{Kind: KindFlows, Format: printer.FormatCSV}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid csv, got %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d", len(rows))
+	}
+	col := map[string]int{}
+	for i, h := range rows[0] {
+		col[h] = i
+	}
+	for _, h := range []string{"id", "sum_id", "start_time", "source_name", "reporter", "policies", "bytes_out"} {
+		if _, ok := col[h]; !ok {
+			t.Errorf("expected csv header %q in %v", h, rows[0])
+		}
+	}
+	if rows[1][col["start_time"]] != "2025-06-01T12:00:00Z" {
+		t.Errorf("expected RFC3339 start time, got %q", rows[1][col["start_time"]])
+	}
+	if !strings.Contains(rows[1][col["policies"]], `"name":"allow-all"`) {
+		t.Errorf("expected policies as json, got %q", rows[1][col["policies"]])
+	}
+}
+
+func TestWrite_All(t *testing.T) {
+	src := newMockSource()
+	var buf bytes.Buffer
+	if err := Write(&buf, src, Options{Kind: KindAll, Format: printer.FormatJSON}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var got []struct {
+		ID    int                  `json:"id"`
+		Key   string               `json:"key"`
+		Flows []*flowdata.FlowData `json:"flows"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected valid json, got %v", err)
+	}
+	if len(got) != 2 || got[0].Key != "a" || len(got[0].Flows) != 1 || got[1].Flows[0].ID != 11 {
+		t.Errorf("expected sums with nested flows, got %+v", got)
+	}
+
+	if err := Write(&buf, src, Options{Kind: KindAll, Format: printer.FormatCSV}); err == nil {
+		t.Error("expected an error for csv output of nested records")
+	}
+}
+
+func TestWrite_InvalidKind(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, newMockSource(), Options{Kind: "policies", Format: printer.FormatJSON}); err == nil {
+		t.Error("expected an error for an invalid kind")
+	}
+}
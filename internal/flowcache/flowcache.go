@@ -21,6 +21,7 @@ type FlowCache struct {
 	fds          FlowDataStore
 	flowSumCache *cache.Cache[string, []*flowdata.FlowSum]
 	flowCache    *cache.Cache[string, []*flowdata.FlowData]
+	done         chan struct{}
 }
 
 const (
@@ -33,9 +34,11 @@ func NewFlowCache(ctx context.Context, fds FlowDataStore) *FlowCache {
 		fds:          fds,
 		flowSumCache: cache.New[string, []*flowdata.FlowSum](),
 		flowCache:    cache.New[string, []*flowdata.FlowData](),
+		done:         make(chan struct{}),
 	}
 	// Go refresh the cache every 2 seconds
 	go func() {
+		defer close(fc.done)
 		ticker := time.Tick(2 * time.Second)
 		for {
 			fc.refreshCache()
@@ -50,6 +53,12 @@ func NewFlowCache(ctx context.Context, fds FlowDataStore) *FlowCache {
 	return fc
 }
 
+// Done is closed once the cache has stopped refreshing after ctx is done, so
+// the caller knows when it is safe to close the store.
+func (fc *FlowCache) Done() <-chan struct{} {
+	return fc.done
+}
+
 func (fc *FlowCache) cacheSortedFlowSums(cacheKey string, fieldName string, ascending bool) []*flowdata.FlowSum {
 	flowSums, _ := fc.flowSumCache.Get(flowSumCacheName)
 	if flowSums == nil {
@@ -102,6 +111,34 @@ func (fc *FlowCache) GetFlowsBySumID(sumID int) []*flowdata.FlowData {
 	return fc.cacheFlowsBySumID(key, sumID)
 }
 
+// QueryFlowSums returns the flow sums matching filter, sorted by sortBy when
+// it is set. Unlike GetFlowSumTotals and GetFlowSumRates it ignores the
+// global filter and sort, for callers that bring their own.
+func (fc *FlowCache) QueryFlowSums(filter flowdata.FilterAttributes, sortBy string, asc bool) []*flowdata.FlowSum {
+	key := fmt.Sprintf("%s-query-%v-%s-%t", flowSumCacheName, filter, sortBy, asc)
+	if flowSums, ok := fc.flowSumCache.Get(key); ok {
+		return flowSums
+	}
+	flowSums := fc.fds.GetFlowSums(filter)
+	if sortBy != "" {
+		util.SortSlice(flowSums, sortBy, asc)
+	}
+	fc.flowSumCache.SetTTL(key, flowSums, 2*time.Second)
+	return flowSums
+}
+
+// QueryFlowsBySumID is GetFlowsBySumID with the given filter instead of the
+// global one.
+func (fc *FlowCache) QueryFlowsBySumID(sumID int, filter flowdata.FilterAttributes) []*flowdata.FlowData {
+	key := fmt.Sprintf("%s-query-%d-%v", flowDataBySumID, sumID, filter)
+	if flows, ok := fc.flowCache.Get(key); ok {
+		return flows
+	}
+	flows := fc.fds.GetFlowsBySumID(sumID, filter)
+	fc.flowCache.SetTTL(key, flows, 2*time.Second)
+	return flows
+}
+
 func (fc *FlowCache) refreshCache() {
 	fc.cacheFlowSums()
 }
@@ -1,8 +1,10 @@
 package flowcache
 
 import (
+	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/doucol/clyde/internal/flowdata"
 	"github.com/doucol/clyde/internal/global"
@@ -31,6 +33,13 @@ func (m *mockFlowDataStore) GetFlowsBySumID(sumID int, _ flowdata.FilterAttribut
 	return m.flowsBySumID[sumID]
 }
 
+// count returns how many times the store was called for name.
+func (m *mockFlowDataStore) count(name string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.calls[name]
+}
+
 // --- Test helpers ---
 
 func newMockFlowDataStore() *mockFlowDataStore {
@@ -40,7 +49,10 @@ func newMockFlowDataStore() *mockFlowDataStore {
 	}
 }
 
-func setGlobalSort(totalsField string, totalsAsc bool, ratesField string, ratesAsc bool) {
+// setGlobalSort sets the global sort until the test is over.
+func setGlobalSort(t *testing.T, totalsField string, totalsAsc bool, ratesField string, ratesAsc bool) {
+	prev := global.GetSort()
+	t.Cleanup(func() { global.SetSort(prev) })
 	global.SetSort(flowdata.SortAttributes{
 		SumTotalsFieldName: totalsField,
 		SumTotalsAscending: totalsAsc,
@@ -49,7 +61,10 @@ func setGlobalSort(totalsField string, totalsAsc bool, ratesField string, ratesA
 	})
 }
 
-func setGlobalFilter(filter flowdata.FilterAttributes) {
+// setGlobalFilter sets the global filter until the test is over.
+func setGlobalFilter(t *testing.T, filter flowdata.FilterAttributes) {
+	prev := global.GetFilter()
+	t.Cleanup(func() { global.SetFilter(prev) })
 	global.SetFilter(filter)
 }
 
@@ -61,8 +76,8 @@ func TestGetFlowSumTotalsAndRates(t *testing.T) {
 		{ID: 1, Key: "a", SourceName: "src1", DestName: "dst1", SourcePacketsIn: 10},
 		{ID: 2, Key: "b", SourceName: "src2", DestName: "dst2", SourcePacketsIn: 20},
 	}
-	setGlobalSort("SourcePacketsIn", true, "ID", false)
-	setGlobalFilter(flowdata.FilterAttributes{})
+	setGlobalSort(t, "SourcePacketsIn", true, "ID", false)
+	setGlobalFilter(t, flowdata.FilterAttributes{})
 
 	ctx := t.Context()
 	fc := NewFlowCache(ctx, fds)
@@ -85,7 +100,7 @@ func TestGetFlowsBySumID(t *testing.T) {
 	fds.flowsBySumID[42] = []*flowdata.FlowData{
 		{ID: 1, SumID: 42, FlowResponse: flowdata.FlowResponse{SourceName: "src", DestName: "dst"}},
 	}
-	setGlobalFilter(flowdata.FilterAttributes{})
+	setGlobalFilter(t, flowdata.FilterAttributes{})
 
 	ctx := t.Context()
 	fc := NewFlowCache(ctx, fds)
@@ -96,11 +111,53 @@ func TestGetFlowsBySumID(t *testing.T) {
 	}
 }
 
+func TestQueryFlowSums(t *testing.T) {
+	fds := newMockFlowDataStore()
+	fds.flowSums = []*flowdata.FlowSum{
+		{ID: 1, Key: "a", SourceName: "src1", SourcePacketsIn: 10},
+		{ID: 2, Key: "b", SourceName: "src2", SourcePacketsIn: 20},
+	}
+	// The global sort must not leak into queries
+	setGlobalSort(t, "SourcePacketsIn", true, "SourcePacketsIn", true)
+	// Stop the refresh, so only the queries call the store
+	ctx, cancel := context.WithCancel(t.Context())
+	fc := NewFlowCache(ctx, fds)
+	cancel()
+	<-fc.Done()
+
+	sums := fc.QueryFlowSums(flowdata.FilterAttributes{Namespace: "x"}, "SourcePacketsIn", false)
+	if len(sums) != 2 || sums[0].ID != 2 {
+		t.Errorf("expected sums sorted by SourcePacketsIn descending, got %+v", sums)
+	}
+	before := fds.count("GetFlowSums")
+	fc.QueryFlowSums(flowdata.FilterAttributes{Namespace: "x"}, "SourcePacketsIn", false)
+	if fds.count("GetFlowSums") != before {
+		t.Error("expected the same query to be served from the cache")
+	}
+	fc.QueryFlowSums(flowdata.FilterAttributes{Namespace: "y"}, "SourcePacketsIn", false)
+	if fds.count("GetFlowSums") != before+1 {
+		t.Error("expected a different filter to go to the store")
+	}
+}
+
+func TestQueryFlowsBySumID(t *testing.T) {
+	fds := newMockFlowDataStore()
+	fds.flowsBySumID[7] = []*flowdata.FlowData{{ID: 1, SumID: 7}}
+	fc := NewFlowCache(t.Context(), fds)
+
+	if flows := fc.QueryFlowsBySumID(7, flowdata.FilterAttributes{Action: "Deny"}); len(flows) != 1 {
+		t.Errorf("expected 1 flow, got %+v", flows)
+	}
+	if flows := fc.QueryFlowsBySumID(8, flowdata.FilterAttributes{}); len(flows) != 0 {
+		t.Errorf("expected no flows, got %+v", flows)
+	}
+}
+
 func TestCacheRefresh(t *testing.T) {
 	fds := newMockFlowDataStore()
 	fds.flowSums = []*flowdata.FlowSum{{ID: 1, Key: "a"}}
-	setGlobalFilter(flowdata.FilterAttributes{})
-	setGlobalSort("", true, "", true)
+	setGlobalFilter(t, flowdata.FilterAttributes{})
+	setGlobalSort(t, "", true, "", true)
 
 	ctx := t.Context()
 	fc := NewFlowCache(ctx, fds)
@@ -116,8 +173,8 @@ func TestCacheRefresh(t *testing.T) {
 func TestCacheRefreshSorted(t *testing.T) {
 	fds := newMockFlowDataStore()
 	fds.flowSums = []*flowdata.FlowSum{{ID: 1, Key: "a"}}
-	setGlobalFilter(flowdata.FilterAttributes{})
-	setGlobalSort("SourcePacketsIn", true, "", true)
+	setGlobalFilter(t, flowdata.FilterAttributes{})
+	setGlobalSort(t, "SourcePacketsIn", true, "", true)
 
 	ctx := t.Context()
 	fc := NewFlowCache(ctx, fds)
@@ -130,10 +187,26 @@ func TestCacheRefreshSorted(t *testing.T) {
 	}
 }
 
+func TestDoneAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fc := NewFlowCache(ctx, newMockFlowDataStore())
+	select {
+	case <-fc.Done():
+		t.Fatal("expected Done to block while the context is active")
+	default:
+	}
+	cancel()
+	select {
+	case <-fc.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Done to be closed after cancel")
+	}
+}
+
 func TestEmptyCacheReturnsEmptySlices(t *testing.T) {
 	fds := newMockFlowDataStore()
-	setGlobalFilter(flowdata.FilterAttributes{})
-	setGlobalSort("ID", true, "ID", true)
+	setGlobalFilter(t, flowdata.FilterAttributes{})
+	setGlobalSort(t, "ID", true, "ID", true)
 
 	ctx := t.Context()
 	fc := NewFlowCache(ctx, fds)
@@ -0,0 +1,582 @@
+package flowdata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// boltStorage keeps the flows in a bbolt file, through storm.
+type boltStorage struct {
+	db *storm.DB
+	// mu is held to use db, and locked while the file is compacted or
+	// another one is swapped in
+	mu sync.RWMutex
+	// failed is set when compacting left no file open to use, and returned
+	// by every call until another file is swapped in
+	failed error
+}
+
+// newBoltStorage opens the file of the flows caught from the kube context.
+func newBoltStorage(dbPath, kubeContext string) (*boltStorage, error) {
+	db, err := openDB(dbPath, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStorage{db: db}, nil
+}
+
+// openDB opens the database file of the flows caught from the kube context,
+// migrating it when it was written by an earlier release, and creating the
+// buckets the flows are stored in when they don't exist.
+func openDB(dbPath, kubeContext string) (*storm.DB, error) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateDB(db, kubeContext); err != nil {
+		runtime.HandleError(db.Close())
+		return nil, err
+	}
+	if err := db.Init(&FlowData{}); err != nil {
+		runtime.HandleError(db.Close())
+		return nil, err
+	}
+	if err := db.Init(&FlowSum{}); err != nil {
+		runtime.HandleError(db.Close())
+		return nil, err
+	}
+	return db, nil
+}
+
+func openReadOnly(dbPath string) (*storm.DB, error) {
+	db, err := storm.Open(dbPath, storm.BoltOptions(0600, &bolt.Options{ReadOnly: true, Timeout: 2 * time.Second}))
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("flow data is locked by another clyde process (%s)", dbPath)
+	}
+	return db, err
+}
+
+// rlock read locks the storage to use db, unless compacting left it without
+// a file open, which is returned.
+func (b *boltStorage) rlock() error {
+	b.mu.RLock()
+	if b.failed != nil {
+		b.mu.RUnlock()
+		return b.failed
+	}
+	return nil
+}
+
+// update runs fn in a read-write transaction, committed when fn succeeds.
+func (b *boltStorage) update(fn func(tx storm.Node) error) error {
+	if err := b.rlock(); err != nil {
+		return err
+	}
+	defer b.mu.RUnlock()
+	tx, err := b.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			runtime.HandleError(tx.Rollback())
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+func (b *boltStorage) Ingest(batch []*FlowData) ([]Ingested, error) {
+	done := make([]Ingested, 0, len(batch))
+	err := b.update(func(tx storm.Node) error {
+		for _, fd := range batch {
+			fs, newSum, err := addFlow(tx, fd)
+			if err != nil {
+				return err
+			}
+			done = append(done, Ingested{Flow: fd, Sum: fs, NewSum: newSum})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// addFlow saves the flow, adding it to its flow sum, in tx.
+func addFlow(tx storm.Node, fd *FlowData) (*FlowSum, bool, error) {
+	newSum := false
+	fs := &FlowSum{}
+	err := tx.One("Key", fd.GetSumKey(), fs)
+	if err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			newSum = true
+			fs = nil
+		} else {
+			return nil, false, err
+		}
+	}
+	fs = flowToFlowSum(fd, fs)
+	err = tx.Save(fs)
+	if err != nil {
+		return nil, false, err
+	}
+	fd.SumID = fs.ID
+	err = tx.Save(fd)
+	if err != nil {
+		return nil, false, err
+	}
+	return fs, newSum, nil
+}
+
+func (b *boltStorage) Sum(id int) (*FlowSum, error) {
+	if err := b.rlock(); err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	fs := &FlowSum{}
+	if err := b.db.One("ID", id, fs); err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (b *boltStorage) Sums() ([]*FlowSum, error) {
+	if err := b.rlock(); err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	fs := []*FlowSum{}
+	if err := b.db.AllByIndex("Key", &fs); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (b *boltStorage) Flow(id int) (*FlowData, error) {
+	if err := b.rlock(); err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	fd := &FlowData{}
+	if err := b.db.One("ID", id, fd); err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return fd, nil
+}
+
+func (b *boltStorage) FlowsBySum(sumID int) ([]*FlowData, error) {
+	if err := b.rlock(); err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	fd := []*FlowData{}
+	if err := b.db.Find("SumID", sumID, &fd); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return fd, nil
+}
+
+func (b *boltStorage) EachFlow(fn func(fd *FlowData) error) error {
+	if err := b.rlock(); err != nil {
+		return err
+	}
+	defer b.mu.RUnlock()
+	err := b.db.Select().Each(new(FlowData), func(record any) error {
+		return fn(record.(*FlowData))
+	})
+	if errors.Is(err, storm.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// UpdateSums reads each of the sums in the same transaction they are saved
+// in, so the totals added meanwhile are kept.
+func (b *boltStorage) UpdateSums(ids []int, update func(fs *FlowSum)) ([]*FlowSum, error) {
+	saved := make([]*FlowSum, 0, len(ids))
+	err := b.update(func(tx storm.Node) error {
+		for _, id := range ids {
+			fs := &FlowSum{}
+			if err := tx.One("ID", id, fs); err != nil {
+				if errors.Is(err, storm.ErrNotFound) {
+					continue
+				}
+				return err
+			}
+			update(fs)
+			if err := tx.Save(fs); err != nil {
+				return err
+			}
+			saved = append(saved, fs)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// Clear deletes the buckets of the flows and sums, and creates them again
+// empty.
+func (b *boltStorage) Clear() error {
+	if err := b.rlock(); err != nil {
+		return err
+	}
+	defer b.mu.RUnlock()
+	for _, data := range []any{&FlowData{}, &FlowSum{}} {
+		if err := b.db.Drop(data); err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		if err := b.db.Init(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *boltStorage) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failed != nil {
+		// Closed already
+		return nil
+	}
+	return b.db.Close()
+}
+
+// switchTo swaps in the file of the flows caught from the kube context,
+// unless it is the one in use, and closes the one that was.
+func (b *boltStorage) switchTo(path, kubeContext string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	b.mu.Lock()
+	if b.db.Bolt.Path() == path {
+		b.mu.Unlock()
+		return false, nil
+	}
+	db, err := openDB(path, kubeContext)
+	if err != nil {
+		b.mu.Unlock()
+		return false, err
+	}
+	old, failed := b.db, b.failed
+	b.db, b.failed = db, nil
+	b.mu.Unlock()
+	if failed != nil {
+		return true, nil
+	}
+	return true, old.Close()
+}
+
+const (
+	// pruneBatchSize is the most flows deleted in one transaction
+	pruneBatchSize = 1000
+	// compactMinFree is how much of the file has to be free before it is
+	// compacted while it is within MaxSize
+	compactMinFree = 16 << 20
+)
+
+// errPruned stops going through the flows once the oldest one that is kept
+// is reached.
+var errPruned = errors.New("pruned")
+
+// Retain deletes the flows past MaxAge, then the oldest flows over MaxFlows
+// and MaxSize. The flow sums the deleted flows belonged to are added up again
+// from the flows left, or deleted when there are none, and the file is
+// compacted once enough of it is free.
+func (b *boltStorage) Retain(r RetentionOptions, now time.Time) ([]int, error) {
+	sums := map[int]bool{}
+	var removed []int
+	resum := func() error {
+		ids, err := b.resum(sums)
+		removed = append(removed, ids...)
+		return err
+	}
+	if r.MaxAge.Duration > 0 {
+		cutoff := now.Add(-r.MaxAge.Duration)
+		if err := b.pruneOldest(sums, func(fd *FlowData, _ int) bool { return fd.EndTime.Before(cutoff) }); err != nil {
+			return removed, err
+		}
+	}
+	if r.MaxFlows > 0 {
+		if err := b.pruneCount(sums, r.MaxFlows); err != nil {
+			return removed, err
+		}
+	}
+	if err := resum(); err != nil {
+		return removed, err
+	}
+
+	maxSize := r.MaxSize.Value()
+	size, free, err := b.fileSize()
+	if err != nil {
+		return removed, err
+	}
+	over := maxSize > 0 && size > maxSize
+	if (over && free > 0) || free >= max(size/2, compactMinFree) {
+		if err := b.compact(); err != nil {
+			return removed, err
+		}
+		if size, _, err = b.fileSize(); err != nil {
+			return removed, err
+		}
+		over = maxSize > 0 && size > maxSize
+	}
+	if !over {
+		return removed, nil
+	}
+
+	// Delete the share of the flows the file is over by, and a tenth more so
+	// it has room to grow again
+	count, err := b.countFlows()
+	if err != nil {
+		return removed, err
+	}
+	keep := int(float64(count)*float64(maxSize)/float64(size)) - count/10
+	logrus.Debugf("flow data is %d bytes, over %d, keeping %d of %d flows", size, maxSize, max(keep, 0), count)
+	if err := b.pruneCount(sums, max(keep, 0)); err != nil {
+		return removed, err
+	}
+	if err := resum(); err != nil {
+		return removed, err
+	}
+	return removed, b.compact()
+}
+
+// pruneCount deletes the oldest flows over keep.
+func (b *boltStorage) pruneCount(sums map[int]bool, keep int) error {
+	count, err := b.countFlows()
+	if err != nil {
+		return err
+	}
+	excess := count - keep
+	if excess <= 0 {
+		return nil
+	}
+	return b.pruneOldest(sums, func(_ *FlowData, deleted int) bool { return deleted < excess })
+}
+
+// pruneOldest deletes the flows stored first for as long as drop says so,
+// given the flow and how many were deleted before it, and adds the sums
+// they belonged to to sums. Flows are stored in the order they arrive, which
+// is close enough to the order they ended in that stopping at the first flow
+// that is kept doesn't go through the rest.
+func (b *boltStorage) pruneOldest(sums map[int]bool, drop func(fd *FlowData, deleted int) bool) error {
+	deleted := 0
+	for {
+		n, done, err := b.pruneBatch(sums, func(fd *FlowData, n int) bool { return drop(fd, deleted+n) })
+		deleted += n
+		if err != nil || done {
+			if deleted > 0 {
+				logrus.Debugf("deleted %d flows past retention", deleted)
+			}
+			return err
+		}
+	}
+}
+
+// pruneBatch deletes up to pruneBatchSize of the oldest flows in a single
+// transaction, and reports whether none is left to delete. drop is given
+// how many flows of the batch are deleted before fd.
+func (b *boltStorage) pruneBatch(sums map[int]bool, drop func(fd *FlowData, n int) bool) (int, bool, error) {
+	var batch []*FlowData
+	done := false
+	err := b.update(func(tx storm.Node) error {
+		err := tx.Select().Limit(pruneBatchSize).Each(new(FlowData), func(record any) error {
+			fd := record.(*FlowData)
+			if !drop(fd, len(batch)) {
+				return errPruned
+			}
+			batch = append(batch, fd)
+			return nil
+		})
+		done = errors.Is(err, errPruned) || len(batch) < pruneBatchSize
+		if err != nil && !errors.Is(err, errPruned) && !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		for _, fd := range batch {
+			if err := tx.DeleteStruct(fd); err != nil {
+				return err
+			}
+			sums[fd.SumID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return len(batch), done, nil
+}
+
+// resum adds the sums up again from the flows left, keeping their rates,
+// deletes the sums without any, and returns the IDs of those.
+func (b *boltStorage) resum(sums map[int]bool) ([]int, error) {
+	if len(sums) == 0 {
+		return nil, nil
+	}
+	var removed []int
+	err := b.update(func(tx storm.Node) (err error) {
+		removed, err = resumTx(tx, sums)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("added up %d flow sums again, deleted %d without flows", len(sums)-len(removed), len(removed))
+	clear(sums)
+	return removed, nil
+}
+
+// resumTx adds the sums up again from their flows in tx, keeping their
+// rates, deletes the sums without any, and returns the IDs of those.
+func resumTx(tx storm.Node, sums map[int]bool) ([]int, error) {
+	var removed []int
+	for id := range sums {
+		fs := &FlowSum{}
+		if err := tx.One("ID", id, fs); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		flows := []*FlowData{}
+		if err := tx.Find("SumID", id, &flows); err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return nil, err
+		}
+		if len(flows) == 0 {
+			if err := tx.DeleteStruct(fs); err != nil {
+				return nil, err
+			}
+			removed = append(removed, id)
+			continue
+		}
+		if err := tx.Save(resumFlows(fs, flows)); err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
+
+// countFlows returns the number of flows stored.
+func (b *boltStorage) countFlows() (int, error) {
+	if err := b.rlock(); err != nil {
+		return 0, err
+	}
+	defer b.mu.RUnlock()
+	return b.db.Count(&FlowData{})
+}
+
+// fileSize returns the size of the database file, and how much of it is
+// free.
+func (b *boltStorage) fileSize() (int64, int64, error) {
+	if err := b.rlock(); err != nil {
+		return 0, 0, err
+	}
+	defer b.mu.RUnlock()
+	info, err := os.Stat(b.db.Bolt.Path())
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), int64(b.db.Bolt.Stats().FreeAlloc), nil
+}
+
+// openCompacted opens the compacted copy of the flow data swapped in.
+var openCompacted = func(path string) (*storm.DB, error) {
+	return storm.Open(path)
+}
+
+// compact rewrites the database file without its free pages, and swaps it
+// in. Writes and reads wait for it. When the copy can't be swapped in, the
+// original file is opened again; when that fails too, the storage is failed.
+func (b *boltStorage) compact() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failed != nil {
+		return b.failed
+	}
+	path := b.db.Bolt.Path()
+	compacted, original := path+".compact", path+".orig"
+	// Left over by a compaction that didn't finish, the file at path is
+	// the one in use either way
+	for _, stale := range []string{compacted, original} {
+		if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s: %w", stale, err)
+		}
+	}
+	dst, err := bolt.Open(compacted, 0600, nil)
+	if err != nil {
+		return err
+	}
+	err = bolt.Compact(dst, b.db.Bolt, 1<<20)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		runtime.HandleError(os.Remove(compacted))
+		return fmt.Errorf("error compacting flow data: %w", err)
+	}
+	// Keep a link to the original to go back to until the copy opens
+	if err := os.Link(path, original); err != nil {
+		runtime.HandleError(os.Remove(compacted))
+		return fmt.Errorf("error compacting flow data: %w", err)
+	}
+	if err := b.db.Close(); err != nil {
+		runtime.HandleError(errors.Join(os.Remove(compacted), os.Remove(original)))
+		return b.reopen(path, err)
+	}
+	if err := os.Rename(compacted, path); err != nil {
+		runtime.HandleError(errors.Join(os.Remove(compacted), os.Remove(original)))
+		return b.reopen(path, fmt.Errorf("error replacing flow data with the compacted copy: %w", err))
+	}
+	db, err := openCompacted(path)
+	if err != nil {
+		err = fmt.Errorf("error opening the compacted flow data: %w", err)
+		if renameErr := os.Rename(original, path); renameErr != nil {
+			b.failed = errors.Join(err, fmt.Errorf("error restoring flow data from %s: %w", original, renameErr))
+			return b.failed
+		}
+		return b.reopen(path, err)
+	}
+	runtime.HandleError(os.Remove(original))
+	b.db = db
+	logrus.Debugf("compacted flow data %s", path)
+	return nil
+}
+
+// reopen opens the flow data at path again once compacting it failed with
+// err, which it returns. The storage is failed when it can't be opened.
+func (b *boltStorage) reopen(path string, err error) error {
+	db, openErr := storm.Open(path)
+	if openErr != nil {
+		b.failed = errors.Join(err, fmt.Errorf("error reopening flow data: %w", openErr))
+		return b.failed
+	}
+	b.db = db
+	return err
+}
@@ -0,0 +1,76 @@
+package flowdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterKeys are the keys accepted by ParseFilter. They mirror the fields of
+// the TUI filter overlay.
+var FilterKeys = []string{"cluster", "action", "port", "namespace", "name", "label", "from", "to"}
+
+// ParseFilter parses a comma separated list of key=value pairs, e.g.
+// "action=Deny,namespace=prod,from=2025-06-01T00:00:00Z", into
+// FilterAttributes. Dates are RFC3339.
+func ParseFilter(s string) (FilterAttributes, error) {
+	fa := FilterAttributes{}
+	if strings.TrimSpace(s) == "" {
+		return fa, nil
+	}
+	for pair := range strings.SplitSeq(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fa, fmt.Errorf("invalid filter %q: expected key=value", pair)
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		switch k {
+		case "cluster":
+			fa.Cluster = v
+		case "action":
+			name, err := parseAction(v)
+			if err != nil {
+				return fa, err
+			}
+			fa.Action = name
+		case "port":
+			port, err := strconv.Atoi(v)
+			if err != nil {
+				return fa, fmt.Errorf("port: %w", err)
+			}
+			fa.Port = port
+		case "namespace", "ns":
+			fa.Namespace = v
+		case "name":
+			fa.Name = v
+		case "label":
+			fa.Label = v
+		case "from":
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return fa, fmt.Errorf("from: %w", err)
+			}
+			fa.DateFrom = t
+		case "to":
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return fa, fmt.Errorf("to: %w", err)
+			}
+			fa.DateTo = t
+		default:
+			return fa, fmt.Errorf("unknown filter key %q, must be one of: %s", k, strings.Join(FilterKeys, ", "))
+		}
+	}
+	return fa, nil
+}
+
+func parseAction(v string) (string, error) {
+	for name := range Action_value {
+		if strings.EqualFold(name, v) && name != Action_name[int32(Action_ActionUnspecified)] {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("invalid action %q", v)
+}
@@ -0,0 +1,51 @@
+package flowdata
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		input   string
+		want    FilterAttributes
+		wantErr bool
+	}{
+		{name: "empty", input: "", want: FilterAttributes{}},
+		{name: "action is case insensitive", input: "action=deny", want: FilterAttributes{Action: "Deny"}},
+		{
+			name:  "all fields",
+			input: "cluster=kind-a,action=Allow, port=443,ns=prod,name=api,label=app=web,from=2025-06-01T00:00:00Z,to=2025-06-01T00:00:00Z",
+			want: FilterAttributes{
+				Cluster: "kind-a", Action: "Allow", Port: 443, Namespace: "prod", Name: "api", Label: "app=web",
+				DateFrom: from, DateTo: from,
+			},
+		},
+		{name: "invalid action", input: "action=Drop", wantErr: true},
+		{name: "unspecified action rejected", input: "action=ActionUnspecified", wantErr: true},
+		{name: "invalid port", input: "port=https", wantErr: true},
+		{name: "invalid date", input: "from=yesterday", wantErr: true},
+		{name: "unknown key", input: "zone=a", wantErr: true},
+		{name: "missing value", input: "action", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
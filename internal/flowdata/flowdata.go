@@ -145,9 +145,13 @@ type FlowData struct {
 	ID           int `json:"id" storm:"id,increment"`
 	SumID        int `json:"sum_id" storm:"index"`
 	FlowResponse `storm:"inline"`
+
+	// Cluster is the kube context the flow was caught from
+	Cluster string `json:"cluster,omitempty"`
 }
 
 type FilterAttributes struct {
+	Cluster   string
 	Action    string
 	Port      int
 	Namespace string
@@ -170,11 +174,20 @@ func (fd *FlowData) GetID() int {
 	return fd.ID
 }
 
+// GetSumKey returns the key of the flow's summary. Flows caught from a named
+// cluster are summed per cluster.
 func (fd *FlowData) GetSumKey() string {
 	key := []string{fd.SourceNamespace, fd.SourceName, fd.DestNamespace, fd.DestName, fd.Protocol, fmt.Sprint(fd.DestPort)}
+	if fd.Cluster != "" {
+		key = append([]string{fd.Cluster}, key...)
+	}
 	return strings.Join(key, "|")
 }
 
+func (fd *FlowData) GetCluster() string {
+	return fd.Cluster
+}
+
 func (fd *FlowData) GetSourceNamespace() string {
 	return fd.SourceNamespace
 }
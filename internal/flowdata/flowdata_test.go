@@ -121,6 +121,12 @@ func TestFlowData_GetSumKey(t *testing.T) {
 	if result != expected {
 		t.Errorf("expected GetSumKey() = %s, got %s", expected, result)
 	}
+
+	fd.Cluster = "kind-a"
+	expected = "kind-a|ns1|pod1|ns2|pod2|TCP|80"
+	if result := fd.GetSumKey(); result != expected {
+		t.Errorf("expected GetSumKey() = %s, got %s", expected, result)
+	}
 }
 
 func TestFlowData_GetterMethods(t *testing.T) {
@@ -398,6 +404,31 @@ func TestFlowToFlowSum_UnknownReporter(t *testing.T) {
 	flowToFlowSum(fd, nil)
 }
 
+func TestSumSortField(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "SourceTotalPacketRate", want: "SourceTotalPacketRate"},
+		{name: "sourcenamespace", want: "SourceNamespace"},
+		{name: "dest_bytes_in", want: "DestBytesIn"},
+		{name: "bogus", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := SumSortField(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("expected an error for %q, got %q", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expected %q for %q, got %q (%v)", tt.want, tt.name, got, err)
+		}
+	}
+}
+
 func TestFilterAttributes_ZeroValue(t *testing.T) {
 	filter := FilterAttributes{}
 
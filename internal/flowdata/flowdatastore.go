@@ -1,35 +1,54 @@
 package flowdata
 
 import (
-	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/asdine/storm/v3"
 	"github.com/doucol/clyde/internal/util"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/runtime"
 )
 
 type FlowDataStore struct {
-	db               *storm.DB
-	inFlow           chan Flower
+	// storage keeps the flows and sums
+	storage          Storage
+	inFlow           chan *FlowData
 	wg               *sync.WaitGroup
 	stop             chan struct{}
 	flowAdded        chan Flower
 	flowSumAdded     chan Flower
 	flowSumsUpdated  chan Flower
 	flowRatesUpdated chan Flower
+	// subscribers are sent every flow committed, see Subscribe
+	subscribers      []chan<- Ingested
+	subscribersMu    sync.Mutex
 	RateCalcWindow   int
 	RateCalcInterval int
+	// Now is the clock the rate window is measured against
+	Now func() time.Time
+	// Ingest configures how flows are queued and written, set before Run
+	Ingest IngestOptions
+	// Retention bounds the flow data kept, set before Run
+	Retention RetentionOptions
+	// rates are the rate windows of the sums with recent flows
+	rates *rateWindows
+
+	// The ingestion counters, see IngestStats
+	queued, committed, dropped, evicted, sampled atomic.Uint64
+	// totals add up the flows committed by sum key, see FlowTotals
+	totals   map[string]*FlowSum
+	totalsMu sync.Mutex
 }
 
 type Flower interface {
 	GetID() int
 	GetSumKey() string
+	GetCluster() string
 	GetSourceNamespace() string
 	GetSourceName() string
 	GetSourceLabels() string
@@ -42,42 +61,164 @@ type Flower interface {
 	GetEndTime() time.Time
 }
 
+// dbPath is where flows were captured into before there were sessions.
 func dbPath() string {
 	return filepath.Join(util.GetDataPath(), "flowdata.db")
 }
 
-func NewFlowDataStore() (*FlowDataStore, error) {
-	dbPath := dbPath()
-	db, err := storm.Open(dbPath)
+func replayDBPath() string {
+	return filepath.Join(util.GetDataPath(), "replay.db")
+}
+
+// NewFlowDataStore opens the store of the session of the kube context, or
+// of its current session when session is empty.
+func NewFlowDataStore(kubeContext, session string) (*FlowDataStore, error) {
+	session, err := resolveSession(kubeContext, session)
 	if err != nil {
 		return nil, err
 	}
-	err = db.Init(&FlowData{})
-	if err != nil {
+	if err := adoptLegacy(kubeContext); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(contextPath(kubeContext), 0755); err != nil {
 		return nil, err
 	}
-	err = db.Init(&FlowSum{})
+	return newFlowDataStore(sessionPath(kubeContext, session), kubeContext)
+}
+
+// NewReplayDataStore opens an empty store, separate from the one live flows
+// are captured into, for replaying a capture file.
+func NewReplayDataStore() (*FlowDataStore, error) {
+	dbPath := replayDBPath()
+	if util.FileExists(dbPath) {
+		if err := os.Remove(dbPath); err != nil {
+			return nil, err
+		}
+	}
+	return newFlowDataStore(dbPath, "")
+}
+
+func newFlowDataStore(dbPath, kubeContext string) (*FlowDataStore, error) {
+	storage, err := newBoltStorage(dbPath, kubeContext)
 	if err != nil {
 		return nil, err
 	}
+	return NewDataStore(storage), nil
+}
+
+// NewMemoryDataStore opens a store that keeps up to maxFlows flows in memory,
+// DefaultMemoryMaxFlows when it is 0, and nothing on disk.
+func NewMemoryDataStore(maxFlows int) *FlowDataStore {
+	return NewDataStore(NewMemoryStorage(maxFlows))
+}
+
+// NewDataStore returns a store that keeps the flows in storage.
+func NewDataStore(storage Storage) *FlowDataStore {
 	return &FlowDataStore{
-		db:               db,
+		storage:          storage,
 		stop:             make(chan struct{}, 1),
-		inFlow:           make(chan Flower, 1000),
+		inFlow:           make(chan *FlowData, DefaultIngestOptions.QueueSize),
+		flowAdded:        make(chan Flower),
+		flowSumAdded:     make(chan Flower),
+		flowSumsUpdated:  make(chan Flower),
+		flowRatesUpdated: make(chan Flower),
 		RateCalcWindow:   60, // Default to 60 seconds
 		RateCalcInterval: 5,  // Default to 5 seconds
-	}, nil
+		Now:              time.Now,
+		Ingest:           DefaultIngestOptions,
+		Retention:        DefaultRetentionOptions,
+		totals:           map[string]*FlowSum{},
+	}
+}
+
+// SwitchSession moves the store over to the session of the kube context, or
+// to its current session when session is empty, e.g. once another context is
+// selected. The flows written from then on go to that session, and the rates
+// are calculated from its flows.
+func (fds *FlowDataStore) SwitchSession(kubeContext, session string) error {
+	b, ok := fds.storage.(*boltStorage)
+	if !ok {
+		// Only files have sessions
+		return nil
+	}
+	session, err := resolveSession(kubeContext, session)
+	if err != nil {
+		return err
+	}
+	switched, err := b.switchTo(sessionPath(kubeContext, session), kubeContext)
+	if !switched {
+		return err
+	}
+	logrus.Debugf("switched flow data to session %q of context %s", session, contextName(kubeContext))
+	if fds.rates != nil {
+		fds.rates.reset()
+		fds.seedRates()
+	}
+	return err
 }
 
+// OpenReadOnly opens the flow data of the session of the kube context, or
+// of its current session when session is empty, for reading, e.g. to export
+// it, without taking the write lock a running clyde instance holds.
+func OpenReadOnly(kubeContext, session string) (*FlowDataStore, error) {
+	session, err := resolveSession(kubeContext, session)
+	if err != nil {
+		return nil, err
+	}
+	if err := adoptLegacy(kubeContext); err != nil {
+		return nil, err
+	}
+	dbPath := sessionPath(kubeContext, session)
+	if !util.FileExists(dbPath) {
+		return nil, fmt.Errorf("no flow data has been captured into session %q of context %s yet (%s)", session, contextName(kubeContext), dbPath)
+	}
+	db, err := openReadOnly(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	version, err := storedVersion(db)
+	if err != nil {
+		runtime.HandleError(db.Close())
+		return nil, err
+	}
+	if version != schemaVersion {
+		// Written by an earlier release: migrate it once, which needs the
+		// write lock, and read it from then on
+		runtime.HandleError(db.Close())
+		if db, err = openDB(dbPath, kubeContext); err != nil {
+			return nil, err
+		}
+		if err := db.Close(); err != nil {
+			return nil, err
+		}
+		if db, err = openReadOnly(dbPath); err != nil {
+			return nil, err
+		}
+	}
+	return NewDataStore(&boltStorage{db: db}), nil
+}
+
+// Clear deletes the flows of every session of every kube context, and of
+// replays.
 func Clear() error {
-	dbPath := dbPath()
-	if util.FileExists(dbPath) {
-		return os.Remove(dbPath)
+	for _, dbPath := range []string{dbPath(), replayDBPath()} {
+		if util.FileExists(dbPath) {
+			if err := os.Remove(dbPath); err != nil {
+				return err
+			}
+		}
 	}
-	return nil
+	return os.RemoveAll(sessionsPath())
 }
 
 func (fds *FlowDataStore) Run(recoverFunc func()) {
+	fds.Ingest = DefaultIngestOptions.Merge(fds.Ingest)
+	if cap(fds.inFlow) != fds.Ingest.QueueSize {
+		fds.inFlow = make(chan *FlowData, fds.Ingest.QueueSize)
+	}
+	fds.Retention = DefaultRetentionOptions.Merge(fds.Retention)
+	fds.rates = newRateWindows(time.Duration(fds.RateCalcWindow)*time.Second, time.Duration(fds.RateCalcInterval)*time.Second)
+	fds.seedRates()
 	fds.wg = &sync.WaitGroup{}
 	fds.wg.Add(1)
 	go func() {
@@ -85,37 +226,7 @@ func (fds *FlowDataStore) Run(recoverFunc func()) {
 		if recoverFunc != nil {
 			defer recoverFunc()
 		}
-		for {
-			select {
-			case <-fds.stop:
-				return
-			case f := <-fds.inFlow:
-				switch fl := f.(type) {
-				case *FlowData:
-					fs, newSum, err := fds.addFlow(fl)
-					if err != nil {
-						panic(err)
-					}
-					chanSignal(fds.flowAdded, f)
-					if newSum {
-						chanSignal(fds.flowSumAdded, f)
-						logrus.Tracef("added flow data: new flow sum: %s", fs.Key)
-					} else {
-						chanSignal(fds.flowSumsUpdated, f)
-						logrus.Tracef("added flow data: existing flow sum: %s", fs.Key)
-					}
-				case *FlowSum:
-					if err := fds.db.Save(fl); err != nil {
-						logrus.WithError(err).Panic("error saving flow sum")
-					} else {
-						chanSignal(fds.flowRatesUpdated, f)
-						logrus.Tracef("updated flow sum: %s", fl.Key)
-					}
-				default:
-					panic("unknown type in inFlow channel")
-				}
-			}
-		}
+		fds.ingest()
 	}()
 
 	fds.wg.Add(1)
@@ -135,42 +246,68 @@ func (fds *FlowDataStore) Run(recoverFunc func()) {
 			}
 		}
 	}()
+
+	fds.wg.Add(1)
+	go func() {
+		defer fds.wg.Done()
+		if recoverFunc != nil {
+			defer recoverFunc()
+		}
+		fds.janitor()
+	}()
 }
 
+// FlowAdded signals the last flow of each batch committed. Like FlowSumAdded, FlowSumsUpdated
+// and FlowRatesUpdated it is best effort: a signal nobody is ready for is
+// dropped. Subscribe to get every flow.
 func (fds *FlowDataStore) FlowAdded() chan Flower {
-	if fds.flowAdded == nil {
-		fds.flowAdded = make(chan Flower)
-	}
 	return fds.flowAdded
 }
 
 func (fds *FlowDataStore) FlowSumAdded() chan Flower {
-	if fds.flowSumAdded == nil {
-		fds.flowSumAdded = make(chan Flower)
-	}
 	return fds.flowSumAdded
 }
 
 func (fds *FlowDataStore) FlowSumsUpdated() chan Flower {
-	if fds.flowSumsUpdated == nil {
-		fds.flowSumsUpdated = make(chan Flower)
-	}
 	return fds.flowSumsUpdated
 }
 
 func (fds *FlowDataStore) FlowRatesUpdated() chan Flower {
-	if fds.flowRatesUpdated == nil {
-		fds.flowRatesUpdated = make(chan Flower)
-	}
 	return fds.flowRatesUpdated
 }
 
-func chanSignal[T any](ch chan T, val T) {
-	if ch == nil {
-		return
+// Subscribe sends every flow committed from then on to ch, in order and
+// without dropping any: ingestion waits for ch when it is full, and the
+// backpressure policy applies from there. ch is closed once the store is.
+func (fds *FlowDataStore) Subscribe(ch chan<- Ingested) {
+	fds.subscribersMu.Lock()
+	defer fds.subscribersMu.Unlock()
+	fds.subscribers = append(fds.subscribers, ch)
+}
+
+// publish sends the flows committed to the subscribers, until the store is
+// stopped.
+func (fds *FlowDataStore) publish(done []Ingested) {
+	fds.subscribersMu.Lock()
+	subscribers := fds.subscribers
+	fds.subscribersMu.Unlock()
+	for _, ch := range subscribers {
+		for _, in := range done {
+			select {
+			case ch <- in:
+			case <-fds.stop:
+				return
+			}
+		}
 	}
-	if err := util.ChanSendTimeout(ch, val, 10); err != nil {
-		logrus.WithError(err).Error("error sending to channel")
+}
+
+// chanSignal sends val on ch when something is ready to take it, and drops
+// it otherwise, so nothing waits on a consumer.
+func chanSignal[T any](ch chan T, val T) {
+	select {
+	case ch <- val:
+	default:
 	}
 }
 
@@ -182,148 +319,28 @@ func (fds *FlowDataStore) Close() {
 	if fds.wg != nil {
 		fds.wg.Wait()
 	}
-	if err := fds.db.Close(); err != nil {
-		logrus.WithError(err).Error("error closing flow data store")
-	}
-}
-
-func (fds *FlowDataStore) addFlow(fd *FlowData) (*FlowSum, bool, error) {
-	newSum, committed := false, false
-	fs := &FlowSum{}
-	tx, err := fds.db.Begin(true)
-	if err != nil {
-		return nil, false, err
-	}
-	defer func() {
-		if !committed {
-			runtime.HandleError(tx.Rollback())
-		}
-	}()
-	err = tx.One("Key", fd.GetSumKey(), fs)
-	if err != nil {
-		if errors.Is(err, storm.ErrNotFound) {
-			newSum = true
-			fs = nil
-		} else {
-			return nil, false, err
-		}
-	}
-	fs = flowToFlowSum(fd, fs)
-	err = tx.Save(fs)
-	if err != nil {
-		return nil, false, err
-	}
-	fd.SumID = fs.ID
-	err = tx.Save(fd)
-	if err != nil {
-		return nil, false, err
-	}
-	err = tx.Commit()
-	if err != nil {
-		return nil, false, err
-	}
-	committed = true
-	return fs, newSum, nil
-}
-
-func (fds *FlowDataStore) AddFlow(fd *FlowData) {
-	select {
-	case <-fds.stop:
-		return
-	default:
-		fds.inFlow <- fd
+	fds.subscribersMu.Lock()
+	for _, ch := range fds.subscribers {
+		close(ch)
 	}
-}
-
-func (fds *FlowDataStore) calcRates() {
-	logrus.Debugf("calculating flow rates for window: %d", fds.RateCalcWindow)
-	now := time.Now().UTC()
-	const year = time.Hour * 24 * 365
-	durationToSubtract := time.Duration(time.Second * time.Duration(-fds.RateCalcWindow))
-	filter := FilterAttributes{DateFrom: now.Add(durationToSubtract)}
-	startTime, endTime := now.Add(year), now.Add(-year)
-
-	fss := fds.GetFlowSums(FilterAttributes{})
-
-	logrus.Debugf("found %d flow sums to calculate rates for", len(fss))
-
-	for _, fs := range fss {
-		var srcPacketsInSum, srcPacketsOutSum, srcBytesInSum, srcBytesOutSum uint64
-		var dstPacketsInSum, dstPacketsOutSum, dstBytesInSum, dstBytesOutSum uint64
-		srcStartTime, dstStartTime := startTime, startTime
-		srcEndTime, dstEndTime := endTime, endTime
-
-		flowDataSet := fds.GetFlowsBySumID(fs.ID, filter)
-		logrus.Tracef("processing %d flow data entries for filter %+v", len(flowDataSet), filter)
-
-		for _, fd := range flowDataSet {
-			switch strings.ToLower(fd.Reporter) {
-			case "src":
-				srcStartTime = util.MinTime(fd.StartTime, srcStartTime)
-				srcEndTime = util.MaxTime(fd.EndTime, srcEndTime)
-				srcPacketsInSum += uint64(fd.PacketsIn)
-				srcPacketsOutSum += uint64(fd.PacketsOut)
-				srcBytesInSum += uint64(fd.BytesIn)
-				srcBytesOutSum += uint64(fd.BytesOut)
-			case "dst":
-				dstStartTime = util.MinTime(fd.StartTime, dstStartTime)
-				dstEndTime = util.MaxTime(fd.EndTime, dstEndTime)
-				dstPacketsInSum += uint64(fd.PacketsIn)
-				dstPacketsOutSum += uint64(fd.PacketsOut)
-				dstBytesInSum += uint64(fd.BytesIn)
-				dstBytesOutSum += uint64(fd.BytesOut)
-			}
-		}
-
-		srcRateSeconds := max(srcEndTime.Sub(srcStartTime).Seconds(), 1)
-		dstRateSeconds := max(dstEndTime.Sub(dstStartTime).Seconds(), 1)
-
-		fs.SourcePacketsInRate = float64(srcPacketsInSum) / srcRateSeconds
-		fs.SourcePacketsOutRate = float64(srcPacketsOutSum) / srcRateSeconds
-		fs.SourceBytesInRate = float64(srcBytesInSum) / srcRateSeconds
-		fs.SourceBytesOutRate = float64(srcBytesOutSum) / srcRateSeconds
-		logrus.Tracef("Source rates: PacketsInRate: %f, PacketsOutRate: %f, BytesInRate: %f, BytesOutRate: %f, sec: %f", fs.SourcePacketsInRate, fs.SourcePacketsOutRate, fs.SourceBytesInRate, fs.SourceBytesOutRate, srcRateSeconds)
-
-		fs.DestPacketsInRate = float64(dstPacketsInSum) / dstRateSeconds
-		fs.DestPacketsOutRate = float64(dstPacketsOutSum) / dstRateSeconds
-		fs.DestBytesInRate = float64(dstBytesInSum) / dstRateSeconds
-		fs.DestBytesOutRate = float64(dstBytesOutSum) / dstRateSeconds
-		logrus.Tracef("Dest rates: PacketsInRate: %f, PacketsOutRate: %f, BytesInRate: %f, BytesOutRate: %f, sec: %f", fs.DestPacketsInRate, fs.DestPacketsOutRate, fs.DestBytesInRate, fs.DestBytesOutRate, dstRateSeconds)
-
-		fs.SourceTotalPacketRate = float64(srcPacketsInSum+srcPacketsOutSum) / srcRateSeconds
-		fs.SourceTotalByteRate = float64(srcBytesInSum+srcBytesOutSum) / srcRateSeconds
-		logrus.Tracef("Total rates: SourceTotalPacketRate: %f, SourceTotalByteRate: %f, sec: %f", fs.SourceTotalPacketRate, fs.SourceTotalByteRate, srcRateSeconds)
-
-		fs.DestTotalPacketRate = float64(dstPacketsInSum+dstPacketsOutSum) / dstRateSeconds
-		fs.DestTotalByteRate = float64(dstBytesInSum+dstBytesOutSum) / dstRateSeconds
-		logrus.Tracef("Total rates: DestTotalPacketRate: %f, DestTotalByteRate: %f, sec: %f", fs.DestTotalPacketRate, fs.DestTotalByteRate, dstRateSeconds)
-
-		select {
-		case <-fds.stop:
-			return
-		default:
-			fds.inFlow <- fs
-		}
+	fds.subscribers = nil
+	fds.subscribersMu.Unlock()
+	if err := fds.storage.Close(); err != nil {
+		logrus.WithError(err).Error("error closing flow data store")
 	}
 }
 
 func (fds *FlowDataStore) GetFlowSum(id int) *FlowSum {
-	fs := &FlowSum{}
-	err := fds.db.One("ID", id, fs)
+	fs, err := fds.storage.Sum(id)
 	if err != nil {
-		if errors.Is(err, storm.ErrNotFound) {
-			return nil
-		} else {
-			logrus.WithError(err).Panic("error getting flow sum")
-		}
+		logrus.WithError(err).Panic("error getting flow sum")
 	}
 	return fs
 }
 
 func (fds *FlowDataStore) GetFlowSums(filter FilterAttributes) []*FlowSum {
-	fs := []*FlowSum{}
-	err := fds.db.AllByIndex("Key", &fs)
-	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+	fs, err := fds.storage.Sums()
+	if err != nil {
 		logrus.WithError(err).Panic("error getting all flow sums")
 	}
 	if filter != (FilterAttributes{}) {
@@ -335,22 +352,16 @@ func (fds *FlowDataStore) GetFlowSums(filter FilterAttributes) []*FlowSum {
 }
 
 func (fds *FlowDataStore) GetFlowDetail(id int) *FlowData {
-	fd := &FlowData{}
-	err := fds.db.One("ID", id, fd)
+	fd, err := fds.storage.Flow(id)
 	if err != nil {
-		if errors.Is(err, storm.ErrNotFound) {
-			return nil
-		} else {
-			logrus.WithError(err).Panic("error getting flow data")
-		}
+		logrus.WithError(err).Panic("error getting flow data")
 	}
 	return fd
 }
 
 func (fds *FlowDataStore) GetFlowsBySumID(sumID int, filter FilterAttributes) []*FlowData {
-	fd := []*FlowData{}
-	err := fds.db.Find("SumID", sumID, &fd)
-	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+	fd, err := fds.storage.FlowsBySum(sumID)
+	if err != nil {
 		logrus.WithError(err).Panic("error getting all flow sums")
 	}
 	if filter != (FilterAttributes{}) {
@@ -379,6 +390,9 @@ func filterFlow(f Flower, filter FilterAttributes) bool {
 		}
 	}
 	// These checks are for FlowSum and FlowData
+	if filter.Cluster != "" && f.GetCluster() != filter.Cluster {
+		return false
+	}
 	if filter.Action != "" && f.GetAction() != filter.Action {
 		return false
 	}
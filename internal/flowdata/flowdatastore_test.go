@@ -12,7 +12,7 @@ func TestNewFlowDataStore(t *testing.T) {
 	os.Setenv("HOME", tempDir)
 	defer os.Unsetenv("HOME")
 
-	fds, err := NewFlowDataStore()
+	fds, err := NewFlowDataStore("", "")
 	if err != nil {
 		t.Fatalf("expected NewFlowDataStore to succeed, got error: %v", err)
 	}
@@ -22,7 +22,7 @@ func TestNewFlowDataStore(t *testing.T) {
 		t.Fatal("expected NewFlowDataStore to return non-nil FlowDataStore")
 	}
 
-	if fds.db == nil {
+	if fds.storage == nil {
 		t.Error("expected database to be initialized")
 	}
 
@@ -48,7 +48,7 @@ func TestClear(t *testing.T) {
 	defer os.Unsetenv("HOME")
 
 	// Create a FlowDataStore to ensure the database file exists
-	fds, err := NewFlowDataStore()
+	fds, err := NewFlowDataStore("", "")
 	if err != nil {
 		t.Fatalf("failed to create FlowDataStore: %v", err)
 	}
@@ -72,7 +72,7 @@ func TestFlowDataStore_ChannelMethods(t *testing.T) {
 	os.Setenv("HOME", tempDir)
 	defer os.Unsetenv("HOME")
 
-	fds, err := NewFlowDataStore()
+	fds, err := NewFlowDataStore("", "")
 	if err != nil {
 		t.Fatalf("failed to create FlowDataStore: %v", err)
 	}
@@ -250,6 +250,7 @@ func TestFilterFlow_FlowData(t *testing.T) {
 			StartTime:    now.Add(-30 * time.Minute),
 			EndTime:      now,
 		},
+		Cluster: "kind-a",
 	}
 
 	tests := []struct {
@@ -262,6 +263,16 @@ func TestFilterFlow_FlowData(t *testing.T) {
 			filter:   FilterAttributes{Action: "Deny"},
 			expected: true,
 		},
+		{
+			name:     "cluster filter matches",
+			filter:   FilterAttributes{Cluster: "kind-a"},
+			expected: true,
+		},
+		{
+			name:     "cluster filter doesn't match",
+			filter:   FilterAttributes{Cluster: "kind"},
+			expected: false,
+		},
 		{
 			name:     "action filter doesn't match",
 			filter:   FilterAttributes{Action: "Allow"},
@@ -396,7 +407,7 @@ func TestFlowDataStore_Configuration(t *testing.T) {
 	os.Setenv("HOME", tempDir)
 	defer os.Unsetenv("HOME")
 
-	fds, err := NewFlowDataStore()
+	fds, err := NewFlowDataStore("", "")
 	if err != nil {
 		t.Fatalf("failed to create FlowDataStore: %v", err)
 	}
@@ -428,21 +439,19 @@ func TestChanSignal(t *testing.T) {
 	// Test with nil channel (should not panic)
 	chanSignal[string](nil, "test")
 
-	// Test with valid unbuffered channel
-	ch := make(chan string)
-
-	// Use goroutine to prevent blocking
-	go func() {
-		chanSignal(ch, "test")
-	}()
+	// Nothing is ready to take it, so it is dropped rather than waited on
+	chanSignal(make(chan string), "test")
 
+	ch := make(chan string, 1)
+	chanSignal(ch, "test")
+	chanSignal(ch, "dropped")
+	if msg := <-ch; msg != "test" {
+		t.Errorf("expected message 'test', got '%s'", msg)
+	}
 	select {
 	case msg := <-ch:
-		if msg != "test" {
-			t.Errorf("expected message 'test', got '%s'", msg)
-		}
-	case <-time.After(100 * time.Millisecond):
-		t.Error("expected message to be sent to channel within timeout")
+		t.Errorf("expected the signal nobody was ready for to be dropped, got '%s'", msg)
+	default:
 	}
 }
 
@@ -451,7 +460,7 @@ func TestFlowDataStore_Close(t *testing.T) {
 	os.Setenv("HOME", tempDir)
 	defer os.Unsetenv("HOME")
 
-	fds, err := NewFlowDataStore()
+	fds, err := NewFlowDataStore("", "")
 	if err != nil {
 		t.Fatalf("failed to create FlowDataStore: %v", err)
 	}
@@ -2,6 +2,9 @@ package flowdata
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/doucol/clyde/internal/util"
@@ -10,6 +13,7 @@ import (
 type FlowSum struct {
 	ID                    int       `json:"id" storm:"id,increment"`
 	Key                   string    `json:"key" storm:"unique"`
+	Cluster               string    `json:"cluster,omitempty"`
 	StartTime             time.Time `json:"start_time"`
 	EndTime               time.Time `json:"end_time"`
 	Action                string    `json:"action"`
@@ -55,6 +59,10 @@ func (fs *FlowSum) GetSumKey() string {
 	return fs.Key
 }
 
+func (fs *FlowSum) GetCluster() string {
+	return fs.Cluster
+}
+
 func (fs *FlowSum) GetSourceNamespace() string {
 	return fs.SourceNamespace
 }
@@ -95,6 +103,51 @@ func (fs *FlowSum) GetEndTime() time.Time {
 	return fs.EndTime
 }
 
+// SumSortField resolves the FlowSum field to sort by from either its Go name
+// or its json name, e.g. "SourceTotalPacketRate" or "source_total_packet_rate".
+func SumSortField(name string) (string, error) {
+	t := reflect.TypeFor[FlowSum]()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		jsonName, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if strings.EqualFold(name, f.Name) || name == jsonName {
+			return f.Name, nil
+		}
+	}
+	return "", fmt.Errorf("invalid sort field %q", name)
+}
+
+// resumFlows returns the sum added up again from its flows, with the ID and
+// the rates of fs.
+func resumFlows(fs *FlowSum, flows []*FlowData) *FlowSum {
+	var added *FlowSum
+	for _, fd := range flows {
+		added = flowToFlowSum(fd, added)
+	}
+	added.ID = fs.ID
+	ratesOf(fs).apply(added)
+	return added
+}
+
+// flowFromFlowSum takes the counts of the flow back out of the sum it was
+// added to.
+func flowFromFlowSum(fd *FlowData, fs *FlowSum) {
+	switch fd.Reporter {
+	case Reporter_name[int32(Reporter_Src)]:
+		fs.SourceReports -= 1
+		fs.SourcePacketsIn -= uint64(fd.PacketsIn)
+		fs.SourcePacketsOut -= uint64(fd.PacketsOut)
+		fs.SourceBytesIn -= uint64(fd.BytesIn)
+		fs.SourceBytesOut -= uint64(fd.BytesOut)
+	case Reporter_name[int32(Reporter_Dst)]:
+		fs.DestReports -= 1
+		fs.DestPacketsIn -= uint64(fd.PacketsIn)
+		fs.DestPacketsOut -= uint64(fd.PacketsOut)
+		fs.DestBytesIn -= uint64(fd.BytesIn)
+		fs.DestBytesOut -= uint64(fd.BytesOut)
+	}
+}
+
 func flowToFlowSum(fd *FlowData, fs *FlowSum) *FlowSum {
 	if fs == nil {
 		fs = &FlowSum{}
@@ -105,6 +158,7 @@ func flowToFlowSum(fd *FlowData, fs *FlowSum) *FlowSum {
 		fs.StartTime = util.MinTime(fs.StartTime, fd.StartTime)
 		fs.EndTime = util.MaxTime(fs.EndTime, fd.EndTime)
 	}
+	fs.Cluster = fd.Cluster
 	fs.Action = fd.Action
 	fs.SourceName = fd.SourceName
 	fs.SourceNamespace = fd.SourceNamespace
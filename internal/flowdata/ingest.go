@@ -0,0 +1,260 @@
+package flowdata
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Backpressure is what AddFlow does when the ingestion queue is full.
+type Backpressure string
+
+const (
+	// BackpressureBlock waits for room in the queue, which holds up the
+	// stream the flows are read from
+	BackpressureBlock Backpressure = "block"
+	// BackpressureDropOldest drops the oldest queued flow to make room
+	BackpressureDropOldest Backpressure = "drop-oldest"
+	// BackpressureSample keeps one in SampleRate of the flows that arrive
+	// while the queue is full, each dropping the oldest queued flow, and
+	// drops the others
+	BackpressureSample Backpressure = "sample"
+)
+
+// IngestOptions configure how flows are queued and written to the store. The
+// zero value of a field leaves it to DefaultIngestOptions.
+type IngestOptions struct {
+	// BatchSize is the most flows written in one transaction
+	BatchSize int `json:"batchSize,omitempty"`
+	// BatchLatency is the longest a flow waits for its batch to fill up
+	// before it is written
+	BatchLatency metav1.Duration `json:"batchLatency,omitempty"`
+	// QueueSize is the most flows waiting to be written
+	QueueSize int `json:"queueSize,omitempty"`
+	// Backpressure is what happens to flows that arrive while the queue is
+	// full
+	Backpressure Backpressure `json:"backpressure,omitempty"`
+	// SampleRate keeps one in that many flows while the queue is full, with
+	// BackpressureSample
+	SampleRate int `json:"sampleRate,omitempty"`
+}
+
+// DefaultIngestOptions write up to 500 flows a transaction, at least every
+// 100ms, and block the stream once 10000 flows are waiting.
+var DefaultIngestOptions = IngestOptions{
+	BatchSize:    500,
+	BatchLatency: metav1.Duration{Duration: 100 * time.Millisecond},
+	QueueSize:    10000,
+	Backpressure: BackpressureBlock,
+	SampleRate:   10,
+}
+
+// Merge returns o with every option that is set in over replaced.
+func (o IngestOptions) Merge(over IngestOptions) IngestOptions {
+	if over.BatchSize != 0 {
+		o.BatchSize = over.BatchSize
+	}
+	if over.BatchLatency.Duration != 0 {
+		o.BatchLatency = over.BatchLatency
+	}
+	if over.QueueSize != 0 {
+		o.QueueSize = over.QueueSize
+	}
+	if over.Backpressure != "" {
+		o.Backpressure = over.Backpressure
+	}
+	if over.SampleRate != 0 {
+		o.SampleRate = over.SampleRate
+	}
+	return o
+}
+
+// Validate reports options out of range.
+func (o IngestOptions) Validate() error {
+	if o.BatchSize < 0 || o.QueueSize < 0 || o.SampleRate < 0 || o.BatchLatency.Duration < 0 {
+		return fmt.Errorf("batchSize, batchLatency, queueSize and sampleRate must be positive")
+	}
+	switch o.Backpressure {
+	case "", BackpressureBlock, BackpressureDropOldest, BackpressureSample:
+	default:
+		return fmt.Errorf("backpressure must be block, drop-oldest or sample, got %q", o.Backpressure)
+	}
+	return nil
+}
+
+// IngestStats count the flows going through ingestion.
+type IngestStats struct {
+	// Queued counts the flows put on the queue
+	Queued uint64 `json:"queued"`
+	// Committed counts the flows written to the store
+	Committed uint64 `json:"committed"`
+	// Dropped counts the flows dropped by the backpressure policy, whether
+	// they were queued or not
+	Dropped uint64 `json:"dropped"`
+	// Pending is the number of flows queued and not written yet
+	Pending uint64 `json:"pending"`
+}
+
+// IngestStats returns the ingestion counters.
+func (fds *FlowDataStore) IngestStats() IngestStats {
+	queued, committed, evicted := fds.queued.Load(), fds.committed.Load(), fds.evicted.Load()
+	return IngestStats{
+		Queued:    queued,
+		Committed: committed,
+		Dropped:   fds.dropped.Load(),
+		Pending:   queued - min(queued, committed+evicted),
+	}
+}
+
+// FlowTotals returns what the flows committed to each sum added up to since
+// the store was created. Unlike the sums, which retention adds up again from
+// the flows left, they only ever grow. The IDs number the sums in the order
+// they were first committed to, and there are no rates.
+func (fds *FlowDataStore) FlowTotals() []*FlowSum {
+	fds.totalsMu.Lock()
+	defer fds.totalsMu.Unlock()
+	totals := make([]*FlowSum, 0, len(fds.totals))
+	for _, t := range fds.totals {
+		total := *t
+		totals = append(totals, &total)
+	}
+	slices.SortFunc(totals, func(a, b *FlowSum) int { return a.ID - b.ID })
+	return totals
+}
+
+// addTotals adds the flows committed to the totals of their sums.
+func (fds *FlowDataStore) addTotals(done []Ingested) {
+	fds.totalsMu.Lock()
+	defer fds.totalsMu.Unlock()
+	for _, in := range done {
+		t, ok := fds.totals[in.Sum.Key]
+		t = flowToFlowSum(in.Flow, t)
+		if !ok {
+			t.ID = len(fds.totals) + 1
+			fds.totals[t.Key] = t
+		}
+	}
+}
+
+// AddFlow queues the flow to be written, following the backpressure policy
+// when the queue is full.
+func (fds *FlowDataStore) AddFlow(fd *FlowData) {
+	select {
+	case <-fds.stop:
+		return
+	default:
+	}
+	select {
+	case fds.inFlow <- fd:
+		fds.queued.Add(1)
+		return
+	default:
+	}
+
+	switch fds.Ingest.Backpressure {
+	case BackpressureSample:
+		if rate := fds.Ingest.SampleRate; rate > 1 && fds.sampled.Add(1)%uint64(rate) != 0 {
+			fds.dropped.Add(1)
+			return
+		}
+		fallthrough
+	case BackpressureDropOldest:
+		for {
+			select {
+			case fds.inFlow <- fd:
+				fds.queued.Add(1)
+				return
+			default:
+			}
+			select {
+			case <-fds.inFlow:
+				fds.evicted.Add(1)
+				fds.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case fds.inFlow <- fd:
+			fds.queued.Add(1)
+		case <-fds.stop:
+			fds.dropped.Add(1)
+		}
+	}
+}
+
+// ingest writes the queued flows in batches until the store is
+// stopped. A batch is written once it is full, or BatchLatency after its
+// first flow was taken off the queue.
+func (fds *FlowDataStore) ingest() {
+	batch := make([]*FlowData, 0, fds.Ingest.BatchSize)
+	for {
+		select {
+		case <-fds.stop:
+			return
+		case f := <-fds.inFlow:
+			batch = append(batch[:0], f)
+		}
+		timer := time.NewTimer(fds.Ingest.BatchLatency.Duration)
+	fill:
+		for len(batch) < fds.Ingest.BatchSize {
+			select {
+			case f := <-fds.inFlow:
+				batch = append(batch, f)
+			case <-timer.C:
+				break fill
+			case <-fds.stop:
+				// Write what was taken off the queue before stopping
+				break fill
+			}
+		}
+		timer.Stop()
+		if err := fds.writeBatch(batch); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// writeBatch writes the flows of the batch in a single transaction, and
+// adds them to the rate windows and sends them to the subscribers once it is
+// committed. Each signal is sent once per batch, with its last flow.
+func (fds *FlowDataStore) writeBatch(batch []*FlowData) error {
+	done, err := fds.storage.Ingest(batch)
+	if err != nil {
+		return err
+	}
+	logrus.Tracef("committed a batch of %d flows", len(batch))
+
+	now := fds.Now().UTC()
+	var added, sumAdded, sumUpdated Flower
+	for _, in := range done {
+		if fds.rates != nil {
+			fds.rates.add(in.Sum.ID, in.Flow, now)
+		}
+		added = in.Flow
+		if in.NewSum {
+			sumAdded = in.Flow
+			logrus.Tracef("added flow data: new flow sum: %s", in.Sum.Key)
+		} else {
+			sumUpdated = in.Flow
+			logrus.Tracef("added flow data: existing flow sum: %s", in.Sum.Key)
+		}
+	}
+	fds.addTotals(done)
+	// Counted once the rate windows and totals have the flows too
+	fds.committed.Add(uint64(len(batch)))
+	if added != nil {
+		chanSignal(fds.flowAdded, added)
+	}
+	if sumAdded != nil {
+		chanSignal(fds.flowSumAdded, sumAdded)
+	}
+	if sumUpdated != nil {
+		chanSignal(fds.flowSumsUpdated, sumUpdated)
+	}
+	fds.publish(done)
+	return nil
+}
@@ -0,0 +1,206 @@
+package flowdata
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testStore opens a store in a temporary directory.
+func testStore(t *testing.T) *FlowDataStore {
+	t.Helper()
+	fds, err := newFlowDataStore(filepath.Join(t.TempDir(), "flowdata.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fds
+}
+
+// testFlow returns a flow from the named source, reported by it.
+func testFlow(source string) *FlowData {
+	return &FlowData{FlowResponse: FlowResponse{
+		SourceNamespace: "shop", SourceName: source, DestNamespace: "db", DestName: "postgres",
+		Protocol: "tcp", DestPort: 5432, Action: "Allow", Reporter: Reporter_name[int32(Reporter_Src)],
+		PacketsOut: 1, StartTime: time.Now(), EndTime: time.Now(),
+	}}
+}
+
+// waitCommitted waits for n flows to be committed.
+func waitCommitted(t *testing.T, fds *FlowDataStore, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for fds.IngestStats().Committed < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := fds.IngestStats().Committed; got != n {
+		t.Fatalf("expected %d flows committed, got %d", n, got)
+	}
+}
+
+func TestFlowDataStore_Batches(t *testing.T) {
+	fds := testStore(t)
+	// Only full batches are written until the store is closed
+	fds.Ingest = IngestOptions{BatchSize: 3, BatchLatency: metav1.Duration{Duration: time.Hour}}
+	fds.Run(nil)
+	for i := range 7 {
+		fds.AddFlow(testFlow(fmt.Sprint("cart-", i%2)))
+	}
+	waitCommitted(t, fds, 6)
+	if stats := fds.IngestStats(); stats != (IngestStats{Queued: 7, Committed: 6, Pending: 1}) {
+		t.Errorf("expected a flow to wait for its batch, got %+v", stats)
+	}
+	sums := fds.GetFlowSums(FilterAttributes{})
+	if len(sums) != 2 || sums[0].SourceReports+sums[1].SourceReports != 6 {
+		t.Errorf("expected the flows of a batch to add up into their sums, got %+v", sums)
+	}
+
+	fds.Close()
+	if stats := fds.IngestStats(); stats.Committed != 7 || stats.Pending != 0 {
+		t.Errorf("expected the last batch to be written on close, got %+v", stats)
+	}
+}
+
+func TestFlowDataStore_BatchLatency(t *testing.T) {
+	fds := testStore(t)
+	fds.Ingest = IngestOptions{BatchSize: 100, BatchLatency: metav1.Duration{Duration: 10 * time.Millisecond}}
+	// Signals are dropped unless something is ready for them
+	added := make(chan Flower, 1)
+	fds.flowAdded = added
+	fds.Run(nil)
+	defer fds.Close()
+	fds.AddFlow(testFlow("cart"))
+	select {
+	case f := <-added:
+		if f.GetSourceName() != "cart" {
+			t.Errorf("expected the flow added to be signalled, got %+v", f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a partial batch to be written after the batch latency")
+	}
+}
+
+func TestFlowDataStore_SignalPerBatch(t *testing.T) {
+	fds := testStore(t)
+	defer fds.Close()
+	fds.flowAdded = make(chan Flower, 10)
+	fds.flowSumAdded = make(chan Flower, 10)
+	fds.flowSumsUpdated = make(chan Flower, 10)
+	batch := []*FlowData{testFlow("cart"), testFlow("checkout"), testFlow("cart"), testFlow("payment")}
+	if err := fds.writeBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	// However many flows there are, a batch signals each change once
+	if len(fds.flowAdded) != 1 || len(fds.flowSumAdded) != 1 || len(fds.flowSumsUpdated) != 1 {
+		t.Fatalf("expected one signal of each per batch, got %d, %d and %d",
+			len(fds.flowAdded), len(fds.flowSumAdded), len(fds.flowSumsUpdated))
+	}
+	if f := <-fds.flowAdded; f.GetSourceName() != "payment" {
+		t.Errorf("expected the last flow of the batch to be signalled, got %s", f.GetSourceName())
+	}
+	if f := <-fds.flowSumsUpdated; f.GetSourceName() != "cart" {
+		t.Errorf("expected the last flow updating a sum to be signalled, got %s", f.GetSourceName())
+	}
+}
+
+func TestFlowDataStore_Backpressure(t *testing.T) {
+	tests := []struct {
+		policy    Backpressure
+		wantQueue string
+		want      IngestStats
+	}{
+		{
+			policy:    BackpressureDropOldest,
+			wantQueue: "[f6 f7 f8 f9]",
+			want:      IngestStats{Queued: 10, Dropped: 6, Pending: 4},
+		},
+		{
+			// Every third flow that arrives while the queue is full is kept
+			policy:    BackpressureSample,
+			wantQueue: "[f2 f3 f6 f9]",
+			want:      IngestStats{Queued: 6, Dropped: 6, Pending: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			fds := testStore(t)
+			defer fds.Close()
+			// Not running, so nothing takes the flows off the queue
+			fds.inFlow = make(chan *FlowData, 4)
+			fds.Ingest = IngestOptions{Backpressure: tt.policy, SampleRate: 3}
+			for i := range 10 {
+				fds.AddFlow(testFlow(fmt.Sprint("f", i)))
+			}
+			if stats := fds.IngestStats(); stats != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, stats)
+			}
+			var queue []string
+			for len(fds.inFlow) > 0 {
+				queue = append(queue, (<-fds.inFlow).GetSourceName())
+			}
+			if fmt.Sprint(queue) != tt.wantQueue {
+				t.Errorf("expected %s queued, got %v", tt.wantQueue, queue)
+			}
+		})
+	}
+
+	t.Run(string(BackpressureBlock), func(t *testing.T) {
+		fds := testStore(t)
+		fds.Ingest = IngestOptions{QueueSize: 2, BatchSize: 1, Backpressure: BackpressureBlock}
+		fds.Run(nil)
+		defer fds.Close()
+		for i := range 20 {
+			fds.AddFlow(testFlow(fmt.Sprint("f", i)))
+		}
+		waitCommitted(t, fds, 20)
+		if stats := fds.IngestStats(); stats.Dropped != 0 {
+			t.Errorf("expected no flow dropped while blocking, got %+v", stats)
+		}
+	})
+}
+
+func TestFlowDataStore_Subscribe(t *testing.T) {
+	fds := testStore(t)
+	fds.Ingest = IngestOptions{BatchSize: 5, BatchLatency: metav1.Duration{Duration: time.Millisecond}}
+	added := make(chan Ingested)
+	fds.Subscribe(added)
+	fds.Run(nil)
+	go func() {
+		for i := range 20 {
+			fds.AddFlow(testFlow(fmt.Sprint("f", i)))
+		}
+	}()
+	// A subscriber slower than the flows still gets every one of them
+	var names []string
+	for len(names) < 20 {
+		select {
+		case in := <-added:
+			names = append(names, in.Flow.SourceName)
+			time.Sleep(time.Millisecond)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the flows, got %v", names)
+		}
+	}
+	if names[0] != "f0" || names[19] != "f19" {
+		t.Errorf("expected the flows in order, got %v", names)
+	}
+	fds.Close()
+	if _, ok := <-added; ok {
+		t.Error("expected the subscription to be closed with the store")
+	}
+}
+
+func TestIngestOptions(t *testing.T) {
+	o := DefaultIngestOptions.Merge(IngestOptions{BatchSize: 50, Backpressure: BackpressureSample})
+	if o.BatchSize != 50 || o.Backpressure != BackpressureSample || o.QueueSize != DefaultIngestOptions.QueueSize {
+		t.Errorf("expected only the options set to be merged, got %+v", o)
+	}
+	if err := (IngestOptions{Backpressure: "spill"}).Validate(); err == nil {
+		t.Error("expected an unknown backpressure policy to be rejected")
+	}
+	if err := (IngestOptions{BatchSize: -1}).Validate(); err == nil {
+		t.Error("expected a negative batch size to be rejected")
+	}
+}
@@ -0,0 +1,244 @@
+package flowdata
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMemoryMaxFlows is the most flows the memory storage keeps when it
+// isn't given a limit.
+const DefaultMemoryMaxFlows = 100000
+
+// memoryStorage keeps the flows in memory, and evicts the oldest of them
+// once it holds more than maxFlows. The sums are kept as running totals: a
+// flow evicted is subtracted from its sum rather than the sum added up again.
+type memoryStorage struct {
+	mu       sync.RWMutex
+	maxFlows int
+	flows    map[int]*FlowData
+	sums     map[int]*FlowSum
+	// order holds the IDs of the flows in the order they were added
+	order []int
+	// keys maps the key of each sum to its ID
+	keys map[string]int
+	// bySum holds the IDs of the flows of each sum in the order they were
+	// added
+	bySum map[int][]int
+	// The IDs given to the last flow and sum added
+	lastFlowID, lastSumID int
+}
+
+// NewMemoryStorage returns a storage that keeps up to maxFlows flows in
+// memory, DefaultMemoryMaxFlows when it is 0.
+func NewMemoryStorage(maxFlows int) Storage {
+	if maxFlows <= 0 {
+		maxFlows = DefaultMemoryMaxFlows
+	}
+	m := &memoryStorage{maxFlows: maxFlows}
+	m.reset()
+	return m
+}
+
+func (m *memoryStorage) reset() {
+	m.flows = map[int]*FlowData{}
+	m.sums = map[int]*FlowSum{}
+	m.order = nil
+	m.keys = map[string]int{}
+	m.bySum = map[int][]int{}
+	m.lastFlowID, m.lastSumID = 0, 0
+}
+
+func (m *memoryStorage) Ingest(batch []*FlowData) ([]Ingested, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	done := make([]Ingested, 0, len(batch))
+	for _, fd := range batch {
+		id, ok := m.keys[fd.GetSumKey()]
+		fs := flowToFlowSum(fd, m.sums[id])
+		if !ok {
+			m.lastSumID++
+			fs.ID = m.lastSumID
+			m.sums[fs.ID], m.keys[fs.Key] = fs, fs.ID
+		}
+		m.lastFlowID++
+		fd.ID, fd.SumID = m.lastFlowID, fs.ID
+		stored := *fd
+		m.flows[fd.ID] = &stored
+		m.order = append(m.order, fd.ID)
+		m.bySum[fs.ID] = append(m.bySum[fs.ID], fd.ID)
+		sum := *fs
+		done = append(done, Ingested{Flow: fd, Sum: &sum, NewSum: !ok})
+	}
+	if excess := len(m.order) - m.maxFlows; excess > 0 {
+		m.evictOldest(excess)
+	}
+	return done, nil
+}
+
+func (m *memoryStorage) Sum(id int) (*FlowSum, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	fs, ok := m.sums[id]
+	if !ok {
+		return nil, nil
+	}
+	sum := *fs
+	return &sum, nil
+}
+
+func (m *memoryStorage) Sums() ([]*FlowSum, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sums := make([]*FlowSum, 0, len(m.sums))
+	for _, fs := range m.sums {
+		sum := *fs
+		sums = append(sums, &sum)
+	}
+	slices.SortFunc(sums, func(a, b *FlowSum) int { return strings.Compare(a.Key, b.Key) })
+	return sums, nil
+}
+
+func (m *memoryStorage) Flow(id int) (*FlowData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	fd, ok := m.flows[id]
+	if !ok {
+		return nil, nil
+	}
+	flow := *fd
+	return &flow, nil
+}
+
+func (m *memoryStorage) FlowsBySum(sumID int) ([]*FlowData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	flows := make([]*FlowData, 0, len(m.bySum[sumID]))
+	for _, id := range m.bySum[sumID] {
+		flow := *m.flows[id]
+		flows = append(flows, &flow)
+	}
+	return flows, nil
+}
+
+func (m *memoryStorage) EachFlow(fn func(fd *FlowData) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, id := range m.order {
+		flow := *m.flows[id]
+		if err := fn(&flow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStorage) UpdateSums(ids []int, update func(fs *FlowSum)) ([]*FlowSum, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := make([]*FlowSum, 0, len(ids))
+	for _, id := range ids {
+		fs, ok := m.sums[id]
+		if !ok {
+			continue
+		}
+		update(fs)
+		sum := *fs
+		saved = append(saved, &sum)
+	}
+	return saved, nil
+}
+
+// Retain evicts the flows past MaxAge, wherever they are as flows don't
+// arrive in the order they end, and the oldest over MaxFlows. MaxSize doesn't
+// apply, there is no file.
+func (m *memoryStorage) Retain(r RetentionOptions, now time.Time) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var removed []int
+	if r.MaxAge.Duration > 0 {
+		removed = m.evictEndedBefore(now.Add(-r.MaxAge.Duration))
+	}
+	if r.MaxFlows > 0 && len(m.order) > r.MaxFlows {
+		removed = append(removed, m.evictOldest(len(m.order)-r.MaxFlows)...)
+	}
+	return removed, nil
+}
+
+// evictOldest deletes the n oldest flows, and returns the IDs of the sums
+// left without any. The oldest flow is also the oldest of its sum, so it is
+// taken off the front of both.
+func (m *memoryStorage) evictOldest(n int) []int {
+	var removed []int
+	for _, id := range m.order[:n] {
+		fd := m.flows[id]
+		m.bySum[fd.SumID] = m.bySum[fd.SumID][1:]
+		if m.subtract(fd) {
+			removed = append(removed, fd.SumID)
+		}
+	}
+	m.order = m.order[n:]
+	return removed
+}
+
+// evictEndedBefore deletes the flows that ended before cutoff, and returns
+// the IDs of the sums left without any.
+func (m *memoryStorage) evictEndedBefore(cutoff time.Time) []int {
+	expired := map[int]bool{}
+	sums := map[int]bool{}
+	for _, fd := range m.flows {
+		if fd.EndTime.Before(cutoff) {
+			expired[fd.ID], sums[fd.SumID] = true, true
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	isExpired := func(id int) bool { return expired[id] }
+	m.order = slices.DeleteFunc(m.order, isExpired)
+	for id := range sums {
+		m.bySum[id] = slices.DeleteFunc(m.bySum[id], isExpired)
+	}
+	var removed []int
+	for id := range expired {
+		fd := m.flows[id]
+		if m.subtract(fd) {
+			removed = append(removed, fd.SumID)
+		}
+	}
+	return removed
+}
+
+// subtract deletes the flow, already taken out of order and bySum, and
+// subtracts it from its sum. A sum without flows left is deleted, and true
+// returned.
+func (m *memoryStorage) subtract(fd *FlowData) bool {
+	delete(m.flows, fd.ID)
+	fs, ok := m.sums[fd.SumID]
+	if !ok {
+		return false
+	}
+	left := m.bySum[fs.ID]
+	if len(left) == 0 {
+		delete(m.sums, fs.ID)
+		delete(m.keys, fs.Key)
+		delete(m.bySum, fs.ID)
+		return true
+	}
+	flowFromFlowSum(fd, fs)
+	// The oldest flow left starts the sum now, flows arrive about in order
+	fs.StartTime = m.flows[left[0]].StartTime
+	return false
+}
+
+func (m *memoryStorage) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reset()
+	return nil
+}
+
+func (m *memoryStorage) Close() error {
+	return m.Clear()
+}
@@ -0,0 +1,299 @@
+package flowdata
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rateTotals are what the flows of a reporter add up to, in a bucket or
+// over the whole window.
+type rateTotals struct {
+	packetsIn, packetsOut, bytesIn, bytesOut uint64
+	start, end                               time.Time
+	flows                                    int
+}
+
+func (t *rateTotals) add(fd *FlowData) {
+	t.merge(rateTotals{
+		packetsIn: uint64(fd.PacketsIn), packetsOut: uint64(fd.PacketsOut),
+		bytesIn: uint64(fd.BytesIn), bytesOut: uint64(fd.BytesOut),
+		start: fd.StartTime, end: fd.EndTime, flows: 1,
+	})
+}
+
+func (t *rateTotals) merge(o rateTotals) {
+	if o.flows == 0 {
+		return
+	}
+	if t.flows == 0 || o.start.Before(t.start) {
+		t.start = o.start
+	}
+	if t.flows == 0 || o.end.After(t.end) {
+		t.end = o.end
+	}
+	t.packetsIn += o.packetsIn
+	t.packetsOut += o.packetsOut
+	t.bytesIn += o.bytesIn
+	t.bytesOut += o.bytesOut
+	t.flows += o.flows
+}
+
+// seconds is the time the flows span, at least a second.
+func (t rateTotals) seconds() float64 {
+	if t.flows == 0 {
+		return 1
+	}
+	return max(t.end.Sub(t.start).Seconds(), 1)
+}
+
+// sumRates are the rate fields of a flow sum.
+type sumRates struct {
+	SourcePacketsIn, SourcePacketsOut, SourceBytesIn, SourceBytesOut float64
+	DestPacketsIn, DestPacketsOut, DestBytesIn, DestBytesOut         float64
+	SourceTotalPacket, SourceTotalByte                               float64
+	DestTotalPacket, DestTotalByte                                   float64
+}
+
+func newSumRates(src, dst rateTotals) sumRates {
+	srcSeconds, dstSeconds := src.seconds(), dst.seconds()
+	return sumRates{
+		SourcePacketsIn:   float64(src.packetsIn) / srcSeconds,
+		SourcePacketsOut:  float64(src.packetsOut) / srcSeconds,
+		SourceBytesIn:     float64(src.bytesIn) / srcSeconds,
+		SourceBytesOut:    float64(src.bytesOut) / srcSeconds,
+		DestPacketsIn:     float64(dst.packetsIn) / dstSeconds,
+		DestPacketsOut:    float64(dst.packetsOut) / dstSeconds,
+		DestBytesIn:       float64(dst.bytesIn) / dstSeconds,
+		DestBytesOut:      float64(dst.bytesOut) / dstSeconds,
+		SourceTotalPacket: float64(src.packetsIn+src.packetsOut) / srcSeconds,
+		SourceTotalByte:   float64(src.bytesIn+src.bytesOut) / srcSeconds,
+		DestTotalPacket:   float64(dst.packetsIn+dst.packetsOut) / dstSeconds,
+		DestTotalByte:     float64(dst.bytesIn+dst.bytesOut) / dstSeconds,
+	}
+}
+
+// ratesOf returns the rates a flow sum was saved with.
+func ratesOf(fs *FlowSum) sumRates {
+	return sumRates{
+		fs.SourcePacketsInRate, fs.SourcePacketsOutRate, fs.SourceBytesInRate, fs.SourceBytesOutRate,
+		fs.DestPacketsInRate, fs.DestPacketsOutRate, fs.DestBytesInRate, fs.DestBytesOutRate,
+		fs.SourceTotalPacketRate, fs.SourceTotalByteRate,
+		fs.DestTotalPacketRate, fs.DestTotalByteRate,
+	}
+}
+
+func (r sumRates) apply(fs *FlowSum) {
+	fs.SourcePacketsInRate, fs.SourcePacketsOutRate = r.SourcePacketsIn, r.SourcePacketsOut
+	fs.SourceBytesInRate, fs.SourceBytesOutRate = r.SourceBytesIn, r.SourceBytesOut
+	fs.DestPacketsInRate, fs.DestPacketsOutRate = r.DestPacketsIn, r.DestPacketsOut
+	fs.DestBytesInRate, fs.DestBytesOutRate = r.DestBytesIn, r.DestBytesOut
+	fs.SourceTotalPacketRate, fs.SourceTotalByteRate = r.SourceTotalPacket, r.SourceTotalByte
+	fs.DestTotalPacketRate, fs.DestTotalByteRate = r.DestTotalPacket, r.DestTotalByte
+}
+
+// rateBucket holds the totals of the flows that ended within its span.
+type rateBucket struct {
+	// start is the start of the span, zero while the bucket is unused
+	start    time.Time
+	src, dst rateTotals
+}
+
+// sumWindow is the ring of buckets of a flow sum.
+type sumWindow struct {
+	buckets []rateBucket
+	// saved are the rates last saved, so unchanged ones aren't saved again
+	saved sumRates
+}
+
+// rateWindows keep a ring of time buckets per flow sum, which the flows are
+// added to as they are written and the buckets that slide out of the window
+// are evicted from. Calculating the rates then only goes through the sums
+// with flows in the window, instead of every flow ever stored.
+type rateWindows struct {
+	window time.Duration
+	// width is the span of a bucket, the rates are as precise as that
+	width time.Duration
+
+	mu   sync.Mutex
+	sums map[int]*sumWindow
+}
+
+func newRateWindows(window, width time.Duration) *rateWindows {
+	width = max(min(width, window), time.Second)
+	return &rateWindows{window: max(window, width), width: width, sums: map[int]*sumWindow{}}
+}
+
+// cutoff is when the window starts: flows that ended before don't count.
+func (rw *rateWindows) cutoff(now time.Time) time.Time {
+	return now.Add(-rw.window)
+}
+
+// add adds the flow to the window of its sum.
+func (rw *rateWindows) add(sumID int, fd *FlowData, now time.Time) {
+	start := fd.EndTime.Truncate(rw.width)
+	if !start.Add(rw.width).After(rw.cutoff(now)) {
+		return
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	sw := rw.sums[sumID]
+	if sw == nil {
+		// A bucket more than the window, so the oldest one can be evicted
+		// while it is still partly in the window
+		sw = &sumWindow{buckets: make([]rateBucket, int(math.Ceil(float64(rw.window)/float64(rw.width)))+1)}
+		rw.sums[sumID] = sw
+	}
+	b := &sw.buckets[int(start.UnixNano()/int64(rw.width))%len(sw.buckets)]
+	if b.start.After(start) {
+		// The bucket has moved on to a later span
+		return
+	}
+	if !b.start.Equal(start) {
+		*b = rateBucket{start: start}
+	}
+	switch strings.ToLower(fd.Reporter) {
+	case "src":
+		b.src.add(fd)
+	case "dst":
+		b.dst.add(fd)
+	}
+}
+
+// track has the rates of the sum saved as they are, e.g. for a sum with
+// rates from an earlier run, so they are zeroed on the next calculation.
+func (rw *rateWindows) track(sumID int, saved sumRates) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.sums[sumID] == nil {
+		rw.sums[sumID] = &sumWindow{buckets: make([]rateBucket, 1), saved: saved}
+	}
+}
+
+// reset drops the windows of every sum.
+func (rw *rateWindows) reset() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	clear(rw.sums)
+}
+
+// forget drops the window of the sum.
+func (rw *rateWindows) forget(sumID int) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	delete(rw.sums, sumID)
+}
+
+// changed evicts the buckets that slid out of the window, and returns the
+// rates of the sums that changed since they were last saved. Sums left
+// without flows in the window get their rates zeroed once and are dropped.
+func (rw *rateWindows) changed(now time.Time) map[int]sumRates {
+	cutoff := rw.cutoff(now)
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	out := map[int]sumRates{}
+	for id, sw := range rw.sums {
+		var src, dst rateTotals
+		active := false
+		for i := range sw.buckets {
+			b := &sw.buckets[i]
+			if b.start.IsZero() {
+				continue
+			}
+			if !b.start.Add(rw.width).After(cutoff) {
+				*b = rateBucket{}
+				continue
+			}
+			src.merge(b.src)
+			dst.merge(b.dst)
+			active = true
+		}
+		rates := newSumRates(src, dst)
+		if rates != sw.saved {
+			out[id] = rates
+			sw.saved = rates
+		}
+		if !active {
+			delete(rw.sums, id)
+		}
+	}
+	return out
+}
+
+// seedRates fills the rate windows from the flows stored within the window,
+// and has the rates of the other sums zeroed on the next calculation, for a
+// store that already holds flows. It goes through the flows once.
+func (fds *FlowDataStore) seedRates() {
+	now := fds.Now().UTC()
+	cutoff := fds.rates.cutoff(now)
+	err := fds.storage.EachFlow(func(fd *FlowData) error {
+		if !fd.EndTime.Before(cutoff) {
+			fds.rates.add(fd.SumID, fd, now)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Error("error seeding flow rates")
+	}
+	for _, fs := range fds.GetFlowSums(FilterAttributes{}) {
+		if rates := ratesOf(fs); rates != (sumRates{}) {
+			fds.rates.track(fs.ID, rates)
+		}
+	}
+}
+
+// calcRates saves the rates of the sums that changed, in a single
+// transaction, and signals each sum once it is committed.
+func (fds *FlowDataStore) calcRates() {
+	logrus.Debugf("calculating flow rates for window: %d", fds.RateCalcWindow)
+	changed := fds.rates.changed(fds.Now().UTC())
+	logrus.Debugf("found %d flow sums with changed rates", len(changed))
+	if len(changed) == 0 {
+		return
+	}
+	saved, err := fds.saveRates(changed)
+	if err != nil {
+		logrus.WithError(err).Error("error saving flow rates")
+		return
+	}
+	for _, fs := range saved {
+		select {
+		case <-fds.stop:
+			return
+		default:
+			chanSignal(fds.flowRatesUpdated, Flower(fs))
+			logrus.Tracef("updated flow sum: %s", fs.Key)
+		}
+	}
+}
+
+// saveRates sets the rates of the sums, and forgets the ones that are gone.
+func (fds *FlowDataStore) saveRates(changed map[int]sumRates) ([]*FlowSum, error) {
+	ids := make([]int, 0, len(changed))
+	for id := range changed {
+		ids = append(ids, id)
+	}
+	saved, err := fds.storage.UpdateSums(ids, func(fs *FlowSum) {
+		changed[fs.ID].apply(fs)
+		logrus.Tracef("Total rates of %s: SourceTotalPacketRate: %f, SourceTotalByteRate: %f, DestTotalPacketRate: %f, DestTotalByteRate: %f",
+			fs.Key, fs.SourceTotalPacketRate, fs.SourceTotalByteRate, fs.DestTotalPacketRate, fs.DestTotalByteRate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(saved) < len(ids) && fds.rates != nil {
+		kept := make(map[int]bool, len(saved))
+		for _, fs := range saved {
+			kept[fs.ID] = true
+		}
+		for _, id := range ids {
+			if !kept[id] {
+				fds.rates.forget(id)
+			}
+		}
+	}
+	return saved, nil
+}
@@ -0,0 +1,142 @@
+package flowdata
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rateFlow returns a flow of the reporter that ran from start to end.
+func rateFlow(reporter string, start, end time.Time, packets, bytes int64) *FlowData {
+	return &FlowData{FlowResponse: FlowResponse{
+		Reporter: reporter, StartTime: start, EndTime: end,
+		PacketsIn: packets, PacketsOut: packets, BytesIn: bytes, BytesOut: bytes,
+	}}
+}
+
+func TestRateWindows(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	rw := newRateWindows(time.Minute, 5*time.Second)
+
+	rw.add(1, rateFlow("Src", now.Add(-20*time.Second), now.Add(-10*time.Second), 10, 100), now)
+	rw.add(1, rateFlow("Src", now.Add(-10*time.Second), now, 30, 300), now)
+	rw.add(1, rateFlow("Dst", now.Add(-4*time.Second), now.Add(-2*time.Second), 1, 10), now)
+	// Out of the window before it is even added
+	rw.add(2, rateFlow("Src", now.Add(-3*time.Minute), now.Add(-2*time.Minute), 1000, 1000), now)
+
+	changed := rw.changed(now)
+	if len(changed) != 1 {
+		t.Fatalf("expected the rates of a single sum, got %+v", changed)
+	}
+	rates := changed[1]
+	// 40 packets each way over the 20s the source flows span
+	if rates.SourcePacketsIn != 2 || rates.SourceTotalPacket != 4 || rates.SourceBytesOut != 20 {
+		t.Errorf("expected the source rates over the flows in the window, got %+v", rates)
+	}
+	// The span is rounded up to a second
+	if rates.DestPacketsIn != 0.5 || rates.DestTotalByte != 10 {
+		t.Errorf("expected the dest rates over at least a second, got %+v", rates)
+	}
+	if changed := rw.changed(now.Add(time.Second)); len(changed) != 0 {
+		t.Errorf("expected unchanged rates not to be returned again, got %+v", changed)
+	}
+
+	// The oldest flow slides out of the window
+	changed = rw.changed(now.Add(56 * time.Second))
+	if rates := changed[1]; rates.SourcePacketsIn != 3 {
+		t.Errorf("expected the rates without the evicted flow, got %+v", changed)
+	}
+
+	changed = rw.changed(now.Add(2 * time.Minute))
+	if rates, ok := changed[1]; !ok || rates != (sumRates{}) {
+		t.Errorf("expected the rates to be zeroed once the window is empty, got %+v", changed)
+	}
+	if len(rw.sums) != 0 {
+		t.Errorf("expected a sum without flows in the window to be dropped, got %d", len(rw.sums))
+	}
+}
+
+func TestRateWindows_Ring(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	rw := newRateWindows(10*time.Second, 5*time.Second)
+	for i := range 10 {
+		at := now.Add(time.Duration(i) * 5 * time.Second)
+		rw.add(1, rateFlow("Src", at, at, 1, 1), at)
+	}
+	if n := len(rw.sums[1].buckets); n != 3 {
+		t.Fatalf("expected a bucket more than the window, got %d", n)
+	}
+	// Flows that come in late are only added while their bucket is still in
+	// the window
+	end := now.Add(45 * time.Second)
+	rw.add(1, rateFlow("Src", now, now, 100, 100), end)
+	rw.add(1, rateFlow("Src", end.Add(-10*time.Second), end.Add(-10*time.Second), 100, 100), end)
+	rates := rw.changed(end)[1]
+	// The flows of the last three buckets, and the late one, over 10s
+	if rates.SourcePacketsIn != 10.3 {
+		t.Errorf("expected the flows of the last buckets only, got %+v", rates)
+	}
+}
+
+func TestFlowDataStore_Rates(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "flowdata.db")
+	fds, err := newFlowDataStore(dbPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only calculated when called, not on an interval
+	fds.RateCalcInterval = 3600
+	// Signals are dropped unless something is ready for them
+	updated := make(chan Flower, 10)
+	fds.flowRatesUpdated = updated
+	fds.Run(nil)
+	now := time.Now().UTC()
+	for _, source := range []string{"cart", "checkout"} {
+		fd := testFlow(source)
+		fd.StartTime, fd.EndTime = now.Add(-10*time.Second), now
+		fds.AddFlow(fd)
+	}
+	waitCommitted(t, fds, 2)
+
+	fds.calcRates()
+	fds.calcRates()
+	for _, fs := range fds.GetFlowSums(FilterAttributes{}) {
+		if fs.SourcePacketsOutRate != 0.1 {
+			t.Errorf("expected the rates of %s to be saved, got %f", fs.Key, fs.SourcePacketsOutRate)
+		}
+	}
+	for i := range 2 {
+		select {
+		case <-updated:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected each sum to be signalled, got %d signals", i)
+		}
+	}
+	if n := len(updated); n != 0 {
+		t.Errorf("expected the sums to be signalled once, while their rates changed, got %d more", n)
+	}
+	fds.Close()
+
+	// Reopened, the windows are seeded from the stored flows, and the rates
+	// of the sums that went quiet are zeroed
+	fds, err = newFlowDataStore(dbPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+	fds.RateCalcInterval = 3600
+	fds.Now = func() time.Time { return now.Add(time.Hour) }
+	fds.Run(nil)
+	if len(fds.rates.sums) != 2 {
+		t.Fatalf("expected the sums with rates to be tracked, got %d", len(fds.rates.sums))
+	}
+	fds.calcRates()
+	for _, fs := range fds.GetFlowSums(FilterAttributes{}) {
+		if fs.SourcePacketsOutRate != 0 {
+			t.Errorf("expected the rates of %s to be zeroed, got %f", fs.Key, fs.SourcePacketsOutRate)
+		}
+	}
+	if len(fds.rates.sums) != 0 {
+		t.Errorf("expected no sum left to calculate rates for, got %d", len(fds.rates.sums))
+	}
+}
@@ -0,0 +1,87 @@
+package flowdata
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RetentionOptions bound how much flow data the store keeps. The zero value
+// of a field leaves it to DefaultRetentionOptions, and a limit of zero there
+// doesn't apply.
+type RetentionOptions struct {
+	// MaxAge is how long a flow is kept after it ended
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+	// MaxFlows is the most flows kept, the oldest are deleted first
+	MaxFlows int `json:"maxFlows,omitempty"`
+	// MaxSize is the largest the database file may grow to, e.g. 512Mi
+	MaxSize resource.Quantity `json:"maxSize,omitempty"`
+	// Interval is how often the limits are enforced
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// DefaultRetentionOptions keep flows of any age in up to 1Gi, checked every
+// minute. Expiring flows by age is opt in, so nothing captured before is
+// deleted on upgrade just for being old.
+var DefaultRetentionOptions = RetentionOptions{
+	MaxSize:  resource.MustParse("1Gi"),
+	Interval: metav1.Duration{Duration: time.Minute},
+}
+
+// Merge returns o with every option that is set in over replaced.
+func (o RetentionOptions) Merge(over RetentionOptions) RetentionOptions {
+	if over.MaxAge.Duration != 0 {
+		o.MaxAge = over.MaxAge
+	}
+	if over.MaxFlows != 0 {
+		o.MaxFlows = over.MaxFlows
+	}
+	if !over.MaxSize.IsZero() {
+		o.MaxSize = over.MaxSize
+	}
+	if over.Interval.Duration != 0 {
+		o.Interval = over.Interval
+	}
+	return o
+}
+
+// Validate reports options out of range.
+func (o RetentionOptions) Validate() error {
+	if o.MaxAge.Duration < 0 || o.MaxFlows < 0 || o.MaxSize.Sign() < 0 || o.Interval.Duration < 0 {
+		return fmt.Errorf("maxAge, maxFlows, maxSize and interval must be positive")
+	}
+	return nil
+}
+
+// janitor enforces the retention limits, once when it starts and then every
+// Interval, until the store is stopped.
+func (fds *FlowDataStore) janitor() {
+	tick := time.NewTicker(fds.Retention.Interval.Duration)
+	defer tick.Stop()
+	for {
+		if err := fds.enforceRetention(); err != nil {
+			logrus.WithError(err).Error("error enforcing flow data retention")
+		}
+		select {
+		case <-fds.stop:
+			logrus.Debug("stop signal received, exiting flow data janitor")
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// enforceRetention has the storage delete the flows past the retention
+// limits, and forgets the rates of the sums left without flows.
+func (fds *FlowDataStore) enforceRetention() error {
+	removed, err := fds.storage.Retain(fds.Retention, fds.Now().UTC())
+	for _, id := range removed {
+		if fds.rates != nil {
+			fds.rates.forget(id)
+		}
+	}
+	return err
+}
@@ -0,0 +1,259 @@
+package flowdata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// writeFlows writes the flows, ended at the given times, from the named
+// sources in turn.
+func writeFlows(t *testing.T, fds *FlowDataStore, ends []time.Time, sources ...string) {
+	t.Helper()
+	batch := make([]*FlowData, len(ends))
+	for i, end := range ends {
+		batch[i] = testFlow(sources[i%len(sources)])
+		batch[i].StartTime, batch[i].EndTime = end.Add(-time.Second), end
+	}
+	if err := fds.writeBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testBolt returns the bolt storage of the store.
+func testBolt(fds *FlowDataStore) *boltStorage {
+	return fds.storage.(*boltStorage)
+}
+
+// sumReports returns the source reports of each sum by source name.
+func sumReports(fds *FlowDataStore) map[string]int64 {
+	reports := map[string]int64{}
+	for _, fs := range fds.GetFlowSums(FilterAttributes{}) {
+		reports[fs.SourceName] = fs.SourceReports
+	}
+	return reports
+}
+
+func TestFlowDataStore_RetentionAge(t *testing.T) {
+	fds := testStore(t)
+	defer fds.Close()
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	writeFlows(t, fds, []time.Time{old, old, old, now}, "cart", "checkout")
+	cart := fds.GetFlowSums(FilterAttributes{Name: "cart"})[0]
+	if _, err := fds.saveRates(map[int]sumRates{cart.ID: {SourcePacketsOut: 5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fds.Retention = RetentionOptions{MaxAge: metav1.Duration{Duration: time.Hour}}
+	if err := fds.enforceRetention(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := testBolt(fds).countFlows(); n != 1 {
+		t.Errorf("expected the flows past the max age to be deleted, got %d left", n)
+	}
+	// checkout keeps the flow that is left, cart has none
+	if got := fmt.Sprint(sumReports(fds)); got != "map[checkout:1]" {
+		t.Errorf("expected the sums to be added up from the flows left, got %s", got)
+	}
+	if fs := fds.GetFlowSum(cart.ID); fs != nil {
+		t.Errorf("expected the sum without flows to be deleted, got %+v", fs)
+	}
+	// The totals keep every flow committed
+	reports := map[string]int64{}
+	for _, total := range fds.FlowTotals() {
+		reports[total.SourceName] = total.SourceReports
+	}
+	if got := fmt.Sprint(reports); got != "map[cart:2 checkout:2]" {
+		t.Errorf("expected the totals not to go down, got %s", got)
+	}
+	checkout := fds.GetFlowSums(FilterAttributes{Name: "checkout"})[0]
+	if !checkout.StartTime.Equal(now.Add(-time.Second)) || !checkout.EndTime.Equal(now) {
+		t.Errorf("expected the times of the sum to be those of the flow left, got %v to %v", checkout.StartTime, checkout.EndTime)
+	}
+}
+
+func TestFlowDataStore_RetentionCount(t *testing.T) {
+	fds := testStore(t)
+	defer fds.Close()
+	now := time.Now()
+	ends := make([]time.Time, 10)
+	for i := range ends {
+		ends[i] = now
+	}
+	writeFlows(t, fds, ends, "cart", "checkout")
+	cart := fds.GetFlowSums(FilterAttributes{Name: "cart"})[0]
+	if _, err := fds.saveRates(map[int]sumRates{cart.ID: {SourcePacketsOut: 5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fds.Retention = RetentionOptions{MaxFlows: 3}
+	if err := fds.enforceRetention(); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, fs := range fds.GetFlowSums(FilterAttributes{}) {
+		for _, fd := range fds.GetFlowsBySumID(fs.ID, FilterAttributes{}) {
+			ids = append(ids, fd.ID)
+		}
+	}
+	if len(ids) != 3 || min(ids[0], ids[1], ids[2]) != 8 {
+		t.Errorf("expected the newest flows to be kept, got %v", ids)
+	}
+	if got := fmt.Sprint(sumReports(fds)); got != "map[cart:1 checkout:2]" {
+		t.Errorf("expected the sums to be added up from the flows left, got %s", got)
+	}
+	if fs := fds.GetFlowSum(cart.ID); fs.SourcePacketsOutRate != 5 {
+		t.Errorf("expected the rates of a sum to be kept, got %f", fs.SourcePacketsOutRate)
+	}
+}
+
+func TestFlowDataStore_RetentionSize(t *testing.T) {
+	fds := testStore(t)
+	defer fds.Close()
+	now := time.Now()
+	for range 4 {
+		batch := make([]*FlowData, 500)
+		for i := range batch {
+			batch[i] = testFlow(fmt.Sprint("cart-", i%50))
+			batch[i].SourceLabels = strings.Repeat("x", 200)
+			batch[i].StartTime, batch[i].EndTime = now, now
+		}
+		if err := fds.writeBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Compacted first, so deleting flows is what it takes to fit
+	if err := testBolt(fds).compact(); err != nil {
+		t.Fatal(err)
+	}
+	size, _, err := testBolt(fds).fileSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maxSize := size / 2
+	fds.Retention = RetentionOptions{MaxSize: *resource.NewQuantity(maxSize, resource.BinarySI)}
+	if err := fds.enforceRetention(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(testBolt(fds).db.Bolt.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > maxSize {
+		t.Errorf("expected the file to be compacted within %d bytes, got %d", maxSize, info.Size())
+	}
+	if n, _ := testBolt(fds).countFlows(); n == 0 || n >= 2000 {
+		t.Errorf("expected the oldest flows to be deleted, got %d left", n)
+	}
+
+	// The store keeps working on the compacted file
+	writeFlows(t, fds, []time.Time{now}, "checkout")
+	if got := sumReports(fds)["checkout"]; got != 1 {
+		t.Errorf("expected flows to be written after compacting, got %d", got)
+	}
+}
+
+func TestFlowDataStore_CompactFailed(t *testing.T) {
+	fds := testStore(t)
+	defer fds.Close()
+	now := time.Now()
+	writeFlows(t, fds, []time.Time{now, now}, "cart")
+	open := openCompacted
+	t.Cleanup(func() { openCompacted = open })
+	openCompacted = func(string) (*storm.DB, error) { return nil, errors.New("disk full") }
+
+	if err := testBolt(fds).compact(); err == nil {
+		t.Fatal("expected compacting to fail when the copy can't be opened")
+	}
+	// The original flow data is opened again, and nothing is left behind
+	path := testBolt(fds).db.Bolt.Path()
+	for _, leftover := range []string{path + ".compact", path + ".orig"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", leftover, err)
+		}
+	}
+	writeFlows(t, fds, []time.Time{now}, "cart")
+	if got := sumReports(fds)["cart"]; got != 3 {
+		t.Errorf("expected flows to be written after compacting failed, got %d", got)
+	}
+}
+
+func TestFlowDataStore_CompactLeftovers(t *testing.T) {
+	fds := testStore(t)
+	defer fds.Close()
+	now := time.Now()
+	writeFlows(t, fds, []time.Time{now, now}, "cart")
+	// Left behind by a compaction that crashed
+	path := testBolt(fds).db.Bolt.Path()
+	for _, leftover := range []string{path + ".orig", path + ".compact"} {
+		if err := os.WriteFile(leftover, []byte("stale"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testBolt(fds).compact(); err != nil {
+		t.Fatalf("expected compacting to get past the leftovers, got %v", err)
+	}
+	if got := sumReports(fds)["cart"]; got != 2 {
+		t.Errorf("expected the flows to be kept, got %d", got)
+	}
+	if _, err := os.Stat(path + ".orig"); !os.IsNotExist(err) {
+		t.Errorf("expected the leftover to be removed, got %v", err)
+	}
+}
+
+func TestFlowDataStore_CompactRestoreFailed(t *testing.T) {
+	fds := testStore(t)
+	defer fds.Close()
+	writeFlows(t, fds, []time.Time{time.Now()}, "cart")
+	open := openCompacted
+	t.Cleanup(func() { openCompacted = open })
+	// Nothing to go back to either
+	openCompacted = func(path string) (*storm.DB, error) {
+		return nil, errors.Join(errors.New("disk full"), os.Remove(path+".orig"))
+	}
+
+	b := testBolt(fds)
+	if err := b.compact(); err == nil {
+		t.Fatal("expected compacting to fail")
+	}
+	// Failed, rather than used closed
+	if _, err := b.Ingest([]*FlowData{testFlow("cart")}); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("expected writes to fail with why, got %v", err)
+	}
+	if _, err := b.Sums(); err == nil {
+		t.Error("expected reads to fail")
+	}
+	// Another file can be swapped in
+	if _, err := b.switchTo(filepath.Join(t.TempDir(), "session.db"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Ingest([]*FlowData{testFlow("cart")}); err != nil {
+		t.Errorf("expected the storage to be usable on another file, got %v", err)
+	}
+}
+
+func TestRetentionOptions(t *testing.T) {
+	o := DefaultRetentionOptions.Merge(RetentionOptions{MaxSize: resource.MustParse("64Mi")})
+	if o.MaxSize.String() != "64Mi" || o.MaxAge != DefaultRetentionOptions.MaxAge {
+		t.Errorf("expected only the options set to be merged, got %+v", o)
+	}
+	if DefaultRetentionOptions.MaxAge.Duration != 0 {
+		t.Errorf("expected flows not to expire by age unless asked to, got %v", DefaultRetentionOptions.MaxAge)
+	}
+	if err := (RetentionOptions{MaxFlows: -1}).Validate(); err == nil {
+		t.Error("expected a negative max flows to be rejected")
+	}
+	if err := (RetentionOptions{MaxSize: resource.MustParse("-1Mi")}).Validate(); err == nil {
+		t.Error("expected a negative max size to be rejected")
+	}
+}
@@ -0,0 +1,162 @@
+package flowdata
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/asdine/storm/v3"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// schemaVersion is the version of the layout flows and sums are stored in.
+// Bump it, and add a migration, along with any change to FlowData or FlowSum
+// that the files written before it would be misread by.
+const schemaVersion = 2
+
+const (
+	metaBucket       = "meta"
+	schemaVersionKey = "schemaVersion"
+)
+
+// A migration upgrades a file to its version from the one before.
+type migration struct {
+	version int
+	// desc says what is upgraded, for the log
+	desc string
+	// migrate upgrades the file of the kube context in tx
+	migrate func(tx storm.Node, kubeContext string) error
+}
+
+// migrations upgrade the files written by earlier releases, in order. Files
+// written before the version was stored are version 1.
+var migrations = []migration{
+	{version: 2, desc: "fill in the cluster of the flows caught before flows carried one", migrate: migrateClusters},
+}
+
+// SchemaError reports a file written by a newer clyde, which this one can't
+// read without misreading it.
+type SchemaError struct {
+	Path    string
+	Version int
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("flow data %s was written by a newer clyde (schema version %d, this one reads up to %d): upgrade clyde, or clear the session", e.Path, e.Version, schemaVersion)
+}
+
+// migrateDB brings the file up to schemaVersion, in a single transaction so
+// a migration that fails leaves it as it was. kubeContext is the context
+// the flows in it were caught from. A file without any flow buckets is new,
+// and is stamped with the version as is.
+func migrateDB(db *storm.DB, kubeContext string) error {
+	return db.Bolt.Update(func(btx *bolt.Tx) error {
+		tx := db.WithTransaction(btx)
+		version, err := storedVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			if btx.Bucket([]byte("FlowData")) == nil {
+				return tx.Set(metaBucket, schemaVersionKey, schemaVersion)
+			}
+			version = 1
+		}
+		if version > schemaVersion {
+			return &SchemaError{Path: db.Bolt.Path(), Version: version}
+		}
+		if version == schemaVersion {
+			return nil
+		}
+		for _, m := range migrations {
+			if m.version <= version {
+				continue
+			}
+			logrus.Infof("migrating flow data %s to schema version %d: %s", db.Bolt.Path(), m.version, m.desc)
+			if err := m.migrate(tx, kubeContext); err != nil {
+				return fmt.Errorf("error migrating flow data %s to schema version %d: %w", db.Bolt.Path(), m.version, err)
+			}
+		}
+		return tx.Set(metaBucket, schemaVersionKey, schemaVersion)
+	})
+}
+
+// storedVersion returns the schema version the file was stamped with, or 0
+// when it wasn't.
+func storedVersion(n storm.Node) (int, error) {
+	var version int
+	if err := n.Get(metaBucket, schemaVersionKey, &version); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return 0, err
+	}
+	return version, nil
+}
+
+// migrateClusters fills in the cluster of the flows caught before flows
+// carried the kube context they came from, and of their sums, with the
+// context of the file. A sum whose key then matches one already caught
+// from the context is merged into it.
+func migrateClusters(tx storm.Node, kubeContext string) error {
+	if kubeContext == "" {
+		return nil
+	}
+	sums := []*FlowSum{}
+	if err := tx.All(&sums); err != nil {
+		return err
+	}
+	ids := make(map[string]int, len(sums))
+	for _, fs := range sums {
+		ids[fs.Key] = fs.ID
+	}
+	// merged maps the IDs of the sums merged away to the sums they went into
+	merged := map[int]int{}
+	for _, fs := range sums {
+		if fs.Cluster != "" {
+			continue
+		}
+		// The key of a flow with a cluster is the one without prefixed with it
+		fs.Cluster, fs.Key = kubeContext, kubeContext+"|"+fs.Key
+		if id, ok := ids[fs.Key]; ok {
+			merged[fs.ID] = id
+			if err := tx.DeleteStruct(fs); err != nil {
+				return err
+			}
+			continue
+		}
+		ids[fs.Key] = fs.ID
+		if err := tx.Save(fs); err != nil {
+			return err
+		}
+	}
+
+	for last := 0; ; {
+		flows := []*FlowData{}
+		err := tx.Range("ID", last+1, math.MaxInt, &flows, storm.Limit(pruneBatchSize))
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		for _, fd := range flows {
+			last = fd.ID
+			if fd.Cluster != "" {
+				continue
+			}
+			fd.Cluster = kubeContext
+			if id, ok := merged[fd.SumID]; ok {
+				fd.SumID = id
+			}
+			if err := tx.Save(fd); err != nil {
+				return err
+			}
+		}
+		if len(flows) < pruneBatchSize {
+			break
+		}
+	}
+
+	resum := map[int]bool{}
+	for _, id := range merged {
+		resum[id] = true
+	}
+	_, err := resumTx(tx, resum)
+	return err
+}
@@ -0,0 +1,269 @@
+package flowdata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/doucol/clyde/internal/util"
+)
+
+// The fixtures in testdata were written before the schema version was
+// stored:
+//
+//   - v1-baseline.db by the first release, whose flows have no cluster: three
+//     flows of cart to checkout (two reported by the source) and two of
+//     frontend to cart
+//   - v1-contexts.db by a development build that watched several contexts at
+//     once, which was never released: two flows of cart to checkout without
+//     a cluster and one from prod, one flow of frontend to cart from dev and
+//     one from prod. No release wrote flows with a cluster into an unversioned
+//     file, so it has no migration step of its own; it checks that filling in
+//     the clusters copes with a file where some flows already have one.
+
+// copyFixture copies the fixture into the default session of the kube
+// context, and returns its path.
+func copyFixture(t *testing.T, name, kubeContext string) string {
+	t.Helper()
+	return copyFixtureTo(t, name, sessionPath(kubeContext, DefaultSession))
+}
+
+// copyFixtureTo copies the fixture to path, and returns it.
+func copyFixtureTo(t *testing.T, name, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sumsByKey returns the source and dest reports, and the number of flows, of
+// each sum by key.
+func sumsByKey(fds *FlowDataStore) map[string]string {
+	sums := map[string]string{}
+	for _, fs := range fds.GetFlowSums(FilterAttributes{}) {
+		flows := fds.GetFlowsBySumID(fs.ID, FilterAttributes{})
+		for _, fd := range flows {
+			if fd.Cluster != fs.Cluster {
+				sums[fs.Key] += fmt.Sprintf(" flow %d of cluster %q", fd.ID, fd.Cluster)
+			}
+		}
+		sums[fs.Key] = fmt.Sprintf("%d/%d/%d", fs.SourceReports, fs.DestReports, len(flows)) + sums[fs.Key]
+	}
+	return sums
+}
+
+func TestMigrate_Baseline(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	copyFixture(t, "v1-baseline.db", "prod")
+	fds, err := NewFlowDataStore("prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+
+	if v, _ := storedVersion(testBolt(fds).db); v != schemaVersion {
+		t.Errorf("expected the file to be stamped with version %d, got %d", schemaVersion, v)
+	}
+	want := map[string]string{
+		"prod|shop|cart|shop|checkout|TCP|8080": "2/1/3",
+		"prod|shop|frontend|shop|cart|TCP|8080": "1/1/2",
+	}
+	if got := sumsByKey(fds); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected the flows and sums to be of the context, got %v", got)
+	}
+
+	// The flows caught from now on go into the same sums
+	fd := testFlow("cart")
+	fd.DestNamespace, fd.DestName, fd.Protocol, fd.DestPort, fd.Cluster = "shop", "checkout", "TCP", 8080, "prod"
+	if err := fds.writeBatch([]*FlowData{fd}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sumsByKey(fds)["prod|shop|cart|shop|checkout|TCP|8080"]; got != "3/1/4" {
+		t.Errorf("expected a new flow to be added to the migrated sum, got %s", got)
+	}
+}
+
+func TestMigrate_Contexts(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	copyFixture(t, "v1-contexts.db", "prod")
+	fds, err := NewFlowDataStore("prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+
+	want := map[string]string{
+		// The flows without a cluster are merged into the sum of prod
+		"prod|shop|cart|shop|checkout|TCP|8080": "2/1/3",
+		"prod|shop|frontend|shop|cart|TCP|8080": "0/1/1",
+		"dev|shop|frontend|shop|cart|TCP|8080":  "1/0/1",
+	}
+	if got := sumsByKey(fds); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected the sums without a cluster to be merged, got %v", got)
+	}
+	cart := fds.GetFlowSums(FilterAttributes{Cluster: "prod", Name: "checkout"})
+	if len(cart) != 1 || !cart[0].StartTime.Equal(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the merged sum to start with the earliest flow, got %+v", cart)
+	}
+}
+
+func TestMigrate_NoContext(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	copyFixture(t, "v1-baseline.db", "")
+	fds, err := NewFlowDataStore("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+
+	if v, _ := storedVersion(testBolt(fds).db); v != schemaVersion {
+		t.Errorf("expected the file to be stamped with version %d, got %d", schemaVersion, v)
+	}
+	if got := sumsByKey(fds)["shop|cart|shop|checkout|TCP|8080"]; got != "2/1/3" {
+		t.Errorf("expected the flows without a context to be kept as they were, got %v", sumsByKey(fds))
+	}
+}
+
+func TestMigrate_OpenReadOnly(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	copyFixture(t, "v1-baseline.db", "prod")
+	fds, err := OpenReadOnly("prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+	if v, _ := storedVersion(testBolt(fds).db); v != schemaVersion {
+		t.Errorf("expected the file to be migrated before it is read, got version %d", v)
+	}
+	if got := len(fds.GetFlowSums(FilterAttributes{Cluster: "prod"})); got != 2 {
+		t.Errorf("expected the migrated sums to be read, got %d", got)
+	}
+}
+
+func TestMigrate_Legacy(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	// Where the first release captured flows, before there were sessions
+	copyFixtureTo(t, "v1-baseline.db", dbPath())
+	fds, err := NewFlowDataStore("prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+
+	if util.FileExists(dbPath()) || !util.FileExists(sessionPath("prod", DefaultSession)) {
+		t.Error("expected the flows to be moved into the default session of the context")
+	}
+	want := map[string]string{
+		"prod|shop|cart|shop|checkout|TCP|8080": "2/1/3",
+		"prod|shop|frontend|shop|cart|TCP|8080": "1/1/2",
+	}
+	if got := sumsByKey(fds); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected the flows and sums to be of the context, got %v", got)
+	}
+}
+
+func TestMigrate_LegacyOpenReadOnly(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	copyFixtureTo(t, "v1-baseline.db", dbPath())
+	fds, err := OpenReadOnly("prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+	if got := len(fds.GetFlowSums(FilterAttributes{Cluster: "prod"})); got != 2 {
+		t.Errorf("expected the migrated sums to be read, got %d", got)
+	}
+}
+
+func TestMigrate_LegacyBehindSession(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	copyFixtureTo(t, "v1-baseline.db", dbPath())
+	if err := createSession(sessionPath("prod", DefaultSession)); err != nil {
+		t.Fatal(err)
+	}
+	fds, err := NewFlowDataStore("prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+	// The default session has flows of its own, which are not overwritten
+	if !util.FileExists(dbPath()) || len(fds.GetFlowSums(FilterAttributes{})) != 0 {
+		t.Error("expected the flows before sessions to be left where they are")
+	}
+}
+
+func TestMigrate_Newer(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	path := copyFixture(t, "v1-baseline.db", "prod")
+	db, err := storm.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(metaBucket, schemaVersionKey, schemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	_, err = NewFlowDataStore("prod", "")
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) || schemaErr.Version != schemaVersion+1 {
+		t.Fatalf("expected a file of a newer version to be refused, got %v", err)
+	}
+	if _, err := OpenReadOnly("prod", ""); !errors.As(err, &schemaErr) {
+		t.Errorf("expected a file of a newer version to be refused for reading, got %v", err)
+	}
+}
+
+func TestMigrate_Failed(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	path := copyFixture(t, "v1-baseline.db", "prod")
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{
+		version: schemaVersion + 1,
+		desc:    "fail",
+		migrate: func(storm.Node, string) error { return errors.New("boom") },
+	})
+
+	if _, err := NewFlowDataStore("prod", ""); err == nil {
+		t.Fatal("expected a failed migration to be reported")
+	}
+	// The migrations before it are rolled back with it
+	db, err := storm.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, _ := storedVersion(db); v != 0 {
+		t.Errorf("expected the file to be left unversioned, got version %d", v)
+	}
+	flows := []*FlowData{}
+	if err := db.All(&flows); err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range flows {
+		if fd.Cluster != "" {
+			t.Errorf("expected the flows to be left as they were, got cluster %q", fd.Cluster)
+		}
+	}
+}
+
+func TestMigrate_New(t *testing.T) {
+	fds := testStore(t)
+	defer fds.Close()
+	if v, _ := storedVersion(testBolt(fds).db); v != schemaVersion {
+		t.Errorf("expected a new file to be stamped with version %d, got %d", schemaVersion, v)
+	}
+}
@@ -0,0 +1,311 @@
+package flowdata
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/doucol/clyde/internal/util"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultSession is the session flows of a kube context are captured into
+// until another one is used.
+const DefaultSession = "default"
+
+// Session is a named store of the flows captured from a kube context. Each
+// context has its own sessions, and one of them is current: the one the
+// flows are captured into and read from unless another is asked for.
+type Session struct {
+	Context  string    `json:"context"`
+	Name     string    `json:"name"`
+	Current  bool      `json:"current"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified,omitzero"`
+}
+
+const (
+	sessionExt = ".db"
+	// currentFile names the current session in the directory of a context
+	currentFile = "current"
+	// noContext is the directory of the flows captured without a kube
+	// context, e.g. from a URL
+	noContext = "_"
+)
+
+var sessionNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateSessionName reports a session name that can't name a file.
+func ValidateSessionName(name string) error {
+	if !sessionNameRE.MatchString(name) || len(name) > 64 {
+		return fmt.Errorf("invalid session name %q: use up to 64 letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+func sessionsPath() string {
+	return filepath.Join(util.GetDataPath(), "sessions")
+}
+
+// contextPath is the directory of the sessions of the kube context.
+func contextPath(kubeContext string) string {
+	if kubeContext == "" {
+		return filepath.Join(sessionsPath(), noContext)
+	}
+	return filepath.Join(sessionsPath(), url.PathEscape(kubeContext))
+}
+
+func sessionPath(kubeContext, name string) string {
+	return filepath.Join(contextPath(kubeContext), name+sessionExt)
+}
+
+// CurrentSession returns the current session of the kube context.
+func CurrentSession(kubeContext string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(contextPath(kubeContext), currentFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultSession, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(string(b))
+	if ValidateSessionName(name) != nil {
+		return DefaultSession, nil
+	}
+	return name, nil
+}
+
+// resolveSession returns the session name, or the current session of the
+// kube context when it is empty.
+func resolveSession(kubeContext, name string) (string, error) {
+	if name == "" {
+		return CurrentSession(kubeContext)
+	}
+	return name, ValidateSessionName(name)
+}
+
+// Sessions returns the sessions of the kube context, by name. The current
+// session is among them even before anything was captured into it.
+func Sessions(kubeContext string) ([]Session, error) {
+	current, err := CurrentSession(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(contextPath(kubeContext))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	sessions := []Session{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), sessionExt)
+		if !ok || e.IsDir() || ValidateSessionName(name) != nil {
+			continue
+		}
+		s := Session{Context: kubeContext, Name: name, Current: name == current}
+		if info, err := e.Info(); err == nil {
+			s.Size, s.Modified = info.Size(), info.ModTime()
+		}
+		sessions = append(sessions, s)
+	}
+	if !slices.ContainsFunc(sessions, func(s Session) bool { return s.Current }) {
+		sessions = append(sessions, Session{Context: kubeContext, Name: current, Current: true})
+	}
+	slices.SortFunc(sessions, func(a, b Session) int { return strings.Compare(a.Name, b.Name) })
+	return sessions, nil
+}
+
+// AllSessions returns the sessions of every kube context flows were captured
+// from.
+func AllSessions() ([]Session, error) {
+	entries, err := os.ReadDir(sessionsPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	all := []Session{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		kubeContext := ""
+		if e.Name() != noContext {
+			if kubeContext, err = url.PathUnescape(e.Name()); err != nil {
+				continue
+			}
+		}
+		sessions, err := Sessions(kubeContext)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, sessions...)
+	}
+	return all, nil
+}
+
+// NewSession creates an empty session for the kube context, and makes it
+// the current one.
+func NewSession(kubeContext, name string) error {
+	if err := ValidateSessionName(name); err != nil {
+		return err
+	}
+	path := sessionPath(kubeContext, name)
+	if util.FileExists(path) {
+		return fmt.Errorf("session %q already exists in context %s", name, contextName(kubeContext))
+	}
+	if err := createSession(path); err != nil {
+		return err
+	}
+	return setCurrentSession(kubeContext, name)
+}
+
+// UseSession makes the session the current one of the kube context.
+func UseSession(kubeContext, name string) error {
+	if err := ValidateSessionName(name); err != nil {
+		return err
+	}
+	if name != DefaultSession && !util.FileExists(sessionPath(kubeContext, name)) {
+		return fmt.Errorf("session %q doesn't exist in context %s, create it with clyde session new", name, contextName(kubeContext))
+	}
+	return setCurrentSession(kubeContext, name)
+}
+
+// DeleteSession deletes the session and the flows captured into it. The
+// default session becomes the current one when it was.
+func DeleteSession(kubeContext, name string) error {
+	if err := ValidateSessionName(name); err != nil {
+		return err
+	}
+	path := sessionPath(kubeContext, name)
+	if !util.FileExists(path) {
+		return fmt.Errorf("session %q doesn't exist in context %s", name, contextName(kubeContext))
+	}
+	if err := checkSessionUnused(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if current, err := CurrentSession(kubeContext); err != nil || current != name {
+		return err
+	}
+	return setCurrentSession(kubeContext, DefaultSession)
+}
+
+// RenameSession renames the session, which stays current when it was.
+func RenameSession(kubeContext, from, to string) error {
+	for _, name := range []string{from, to} {
+		if err := ValidateSessionName(name); err != nil {
+			return err
+		}
+	}
+	fromPath, toPath := sessionPath(kubeContext, from), sessionPath(kubeContext, to)
+	if !util.FileExists(fromPath) {
+		return fmt.Errorf("session %q doesn't exist in context %s", from, contextName(kubeContext))
+	}
+	if util.FileExists(toPath) {
+		return fmt.Errorf("session %q already exists in context %s", to, contextName(kubeContext))
+	}
+	if err := checkSessionUnused(fromPath); err != nil {
+		return err
+	}
+	if err := os.Rename(fromPath, toPath); err != nil {
+		return err
+	}
+	if current, err := CurrentSession(kubeContext); err != nil || current != from {
+		return err
+	}
+	return setCurrentSession(kubeContext, to)
+}
+
+// ClearSession deletes the flows captured into the session, or into the
+// current session of the kube context when name is empty, and keeps the
+// session.
+func ClearSession(kubeContext, name string) error {
+	name, err := resolveSession(kubeContext, name)
+	if err != nil {
+		return err
+	}
+	path := sessionPath(kubeContext, name)
+	if !util.FileExists(path) {
+		return nil
+	}
+	if err := checkSessionUnused(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return createSession(path)
+}
+
+// adoptLegacy moves the flows captured before there were sessions into the
+// default session of the kube context, unless it has flows of its own
+// already. They are migrated, and given the context as their cluster, as the
+// session is opened.
+func adoptLegacy(kubeContext string) error {
+	legacy := dbPath()
+	if !util.FileExists(legacy) {
+		return nil
+	}
+	path := sessionPath(kubeContext, DefaultSession)
+	if util.FileExists(path) {
+		logrus.Warnf("the flows captured before there were sessions are left in %s, session %q of context %s has flows already", legacy, DefaultSession, contextName(kubeContext))
+		return nil
+	}
+	if err := checkSessionUnused(legacy); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(legacy, path); err != nil {
+		return err
+	}
+	logrus.Infof("moved the flows captured before there were sessions into session %q of context %s", DefaultSession, contextName(kubeContext))
+	return nil
+}
+
+func setCurrentSession(kubeContext, name string) error {
+	if err := os.MkdirAll(contextPath(kubeContext), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(contextPath(kubeContext), currentFile), []byte(name+"\n"), 0644)
+}
+
+// createSession creates the empty store of a session.
+func createSession(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	db, err := openDB(path, "")
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// checkSessionUnused reports a session a running clyde process captures
+// into, which holds the lock on its file.
+func checkSessionUnused(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return fmt.Errorf("session is in use by another clyde process (%s)", path)
+		}
+		return err
+	}
+	return db.Close()
+}
+
+func contextName(kubeContext string) string {
+	if kubeContext == "" {
+		return "(none)"
+	}
+	return kubeContext
+}
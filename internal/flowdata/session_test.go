@@ -0,0 +1,163 @@
+package flowdata
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sessionNames returns the session names of the kube context, the current
+// one marked with a star.
+func sessionNames(t *testing.T, kubeContext string) string {
+	t.Helper()
+	sessions, err := Sessions(kubeContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(sessions))
+	for i, s := range sessions {
+		names[i] = s.Name
+		if s.Current {
+			names[i] += "*"
+		}
+	}
+	return fmt.Sprint(names)
+}
+
+func TestSessions(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	if got := sessionNames(t, "prod"); got != "[default*]" {
+		t.Errorf("expected the default session to be current, got %s", got)
+	}
+
+	if err := NewSession("prod", "incident-42"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionNames(t, "prod"); got != "[incident-42*]" {
+		t.Errorf("expected a new session to become current, got %s", got)
+	}
+	if err := NewSession("prod", "incident-42"); err == nil {
+		t.Error("expected creating an existing session to fail")
+	}
+	if got := sessionNames(t, "dev"); got != "[default*]" {
+		t.Errorf("expected the sessions of another context to be apart, got %s", got)
+	}
+
+	if err := UseSession("prod", "nope"); err == nil {
+		t.Error("expected using a session that doesn't exist to fail")
+	}
+	if err := UseSession("prod", DefaultSession); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenameSession("prod", "incident-42", "incident-43"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionNames(t, "prod"); got != "[default* incident-43]" {
+		t.Errorf("expected the session to be renamed, got %s", got)
+	}
+
+	if err := UseSession("prod", "incident-43"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteSession("prod", "incident-43"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionNames(t, "prod"); got != "[default*]" {
+		t.Errorf("expected the default session to be current after deleting the current one, got %s", got)
+	}
+
+	all, err := AllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Context != "prod" {
+		t.Errorf("expected the sessions of the context with a directory, got %+v", all)
+	}
+}
+
+func TestSessions_InUse(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	if err := NewSession("prod", "capture"); err != nil {
+		t.Fatal(err)
+	}
+	fds, err := NewFlowDataStore("prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteSession("prod", "capture"); err == nil {
+		t.Error("expected deleting a session that is captured into to fail")
+	}
+	if err := ClearSession("prod", ""); err == nil {
+		t.Error("expected clearing a session that is captured into to fail")
+	}
+	fds.Close()
+	if err := DeleteSession("prod", "capture"); err != nil {
+		t.Errorf("expected deleting the closed session to succeed, got %v", err)
+	}
+}
+
+func TestClearSession(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	for _, kubeContext := range []string{"prod", "dev"} {
+		fds, err := NewFlowDataStore(kubeContext, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFlows(t, fds, []time.Time{time.Now()}, "cart")
+		fds.Close()
+	}
+
+	if err := ClearSession("prod", ""); err != nil {
+		t.Fatal(err)
+	}
+	for kubeContext, want := range map[string]int{"prod": 0, "dev": 1} {
+		fds, err := OpenReadOnly(kubeContext, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(fds.GetFlowSums(FilterAttributes{})); got != want {
+			t.Errorf("expected %d sums left in context %s, got %d", want, kubeContext, got)
+		}
+		fds.Close()
+	}
+}
+
+func TestFlowDataStore_SwitchSession(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	fds, err := NewFlowDataStore("prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Close()
+	writeFlows(t, fds, []time.Time{time.Now()}, "cart")
+
+	if err := fds.SwitchSession("dev", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fds.GetFlowSums(FilterAttributes{})); got != 0 {
+		t.Errorf("expected the session of another context to start empty, got %d sums", got)
+	}
+	writeFlows(t, fds, []time.Time{time.Now()}, "checkout")
+
+	if err := fds.SwitchSession("prod", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(sumReports(fds)); got != "map[cart:1]" {
+		t.Errorf("expected the flows of the context to be back, got %s", got)
+	}
+}
+
+func TestValidateSessionName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"default":     true,
+		"incident.42": true,
+		"":            false,
+		".hidden":     false,
+		"a/b":         false,
+		"with space":  false,
+	} {
+		if err := ValidateSessionName(name); (err == nil) != valid {
+			t.Errorf("expected %q valid = %v, got %v", name, valid, err)
+		}
+	}
+}
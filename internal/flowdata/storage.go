@@ -0,0 +1,50 @@
+package flowdata
+
+import "time"
+
+const (
+	// StorageBolt keeps the flows in the file of a session, see Sessions
+	StorageBolt = "bolt"
+	// StorageMemory keeps a bounded number of flows in memory, and nothing
+	// on disk
+	StorageMemory = "memory"
+)
+
+// Storage keeps the flows and the sums they add up to. FlowDataStore queues
+// and batches the flows, calculates the rates and signals the changes on top
+// of it. The flows and sums returned are the caller's to change.
+type Storage interface {
+	// Ingest adds the flows of the batch to their sums, all of them or none,
+	// and sets the ID and SumID of each flow
+	Ingest(batch []*FlowData) ([]Ingested, error)
+	// Sum returns the flow sum, or nil when there is none with the ID
+	Sum(id int) (*FlowSum, error)
+	// Sums returns every flow sum, by key
+	Sums() ([]*FlowSum, error)
+	// Flow returns the flow, or nil when there is none with the ID
+	Flow(id int) (*FlowData, error)
+	// FlowsBySum returns the flows of the sum, in the order they were added
+	FlowsBySum(sumID int) ([]*FlowData, error)
+	// EachFlow calls fn with every flow, in the order they were added, until
+	// it returns an error. fn must not call back into the storage.
+	EachFlow(fn func(fd *FlowData) error) error
+	// UpdateSums calls update with each of the sums and saves them, all at
+	// once, e.g. to set their rates. It returns the sums saved, leaving out
+	// the IDs there is no sum with.
+	UpdateSums(ids []int, update func(fs *FlowSum)) ([]*FlowSum, error)
+	// Retain deletes the flows past the retention options at now, adds the
+	// sums they belonged to up again, and returns the IDs of the sums
+	// deleted for having no flows left
+	Retain(opts RetentionOptions, now time.Time) ([]int, error)
+	// Clear deletes every flow and sum
+	Clear() error
+	Close() error
+}
+
+// Ingested is what ingesting a flow did.
+type Ingested struct {
+	Flow *FlowData
+	Sum  *FlowSum
+	// NewSum is set when the flow is the first of its sum
+	NewSum bool
+}
@@ -0,0 +1,218 @@
+package flowdata
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testStorages returns a fresh storage of each kind by name.
+func testStorages(t *testing.T) map[string]func() Storage {
+	return map[string]func() Storage{
+		StorageBolt: func() Storage {
+			b, err := newBoltStorage(filepath.Join(t.TempDir(), "flowdata.db"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		},
+		StorageMemory: func() Storage { return NewMemoryStorage(0) },
+	}
+}
+
+// sourceNames returns the source names of the flows, in order.
+func sourceNames(flows []*FlowData) string {
+	names := make([]string, len(flows))
+	for i, fd := range flows {
+		names[i] = fd.SourceName
+	}
+	return fmt.Sprint(names)
+}
+
+func TestStorage(t *testing.T) {
+	for name, open := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			s := open()
+			defer s.Close()
+			batch := []*FlowData{testFlow("checkout"), testFlow("cart"), testFlow("checkout")}
+			done, err := s.Ingest(batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(done) != 3 || !done[0].NewSum || !done[1].NewSum || done[2].NewSum {
+				t.Fatalf("expected the first flow of each sum to start it, got %+v", done)
+			}
+			checkout := done[2].Sum
+			if batch[2].ID == 0 || batch[2].SumID != checkout.ID || checkout.SourceReports != 2 {
+				t.Errorf("expected the flows to be added to their sums, got flow %+v of sum %+v", batch[2], checkout)
+			}
+
+			sums, err := s.Sums()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sums) != 2 || sums[0].SourceName != "cart" || sums[1].SourceName != "checkout" {
+				t.Errorf("expected the sums by key, got %+v", sums)
+			}
+			if fs, _ := s.Sum(checkout.ID); fs == nil || fs.Key != checkout.Key {
+				t.Errorf("expected the sum %d, got %+v", checkout.ID, fs)
+			}
+			if fs, _ := s.Sum(99); fs != nil {
+				t.Errorf("expected no sum, got %+v", fs)
+			}
+			if fd, _ := s.Flow(batch[1].ID); fd == nil || fd.SourceName != "cart" {
+				t.Errorf("expected the flow %d, got %+v", batch[1].ID, fd)
+			}
+			if fd, _ := s.Flow(99); fd != nil {
+				t.Errorf("expected no flow, got %+v", fd)
+			}
+			flows, _ := s.FlowsBySum(checkout.ID)
+			if len(flows) != 2 || flows[0].ID != batch[0].ID || flows[1].ID != batch[2].ID {
+				t.Errorf("expected the flows of the sum in order, got %+v", flows)
+			}
+			var each []*FlowData
+			if err := s.EachFlow(func(fd *FlowData) error { each = append(each, fd); return nil }); err != nil {
+				t.Fatal(err)
+			}
+			if got := sourceNames(each); got != "[checkout cart checkout]" {
+				t.Errorf("expected every flow in order, got %s", got)
+			}
+
+			// Changing what is returned leaves the storage alone
+			flows[0].SourceName = "changed"
+			sums[0].SourceReports = 42
+			if fd, _ := s.Flow(flows[0].ID); fd.SourceName != "checkout" {
+				t.Errorf("expected the flow kept to be unchanged, got %q", fd.SourceName)
+			}
+			if fs, _ := s.Sum(sums[0].ID); fs.SourceReports != 1 {
+				t.Errorf("expected the sum kept to be unchanged, got %d reports", fs.SourceReports)
+			}
+
+			saved, err := s.UpdateSums([]int{checkout.ID, 99}, func(fs *FlowSum) { fs.SourcePacketsOutRate = 5 })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 1 || saved[0].ID != checkout.ID {
+				t.Errorf("expected only the sum there is to be saved, got %+v", saved)
+			}
+			if fs, _ := s.Sum(checkout.ID); fs.SourcePacketsOutRate != 5 {
+				t.Errorf("expected the rate to be saved, got %f", fs.SourcePacketsOutRate)
+			}
+
+			// Keeping the newest flow deletes cart and adds checkout up again
+			removed, err := s.Retain(RetentionOptions{MaxFlows: 1}, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(removed) != 1 || removed[0] != done[1].Sum.ID {
+				t.Errorf("expected the cart sum to be removed, got %v", removed)
+			}
+			if fs, _ := s.Sum(checkout.ID); fs.SourceReports != 1 || fs.SourcePacketsOutRate != 5 {
+				t.Errorf("expected the sum added up again with its rates, got %+v", fs)
+			}
+
+			if err := s.Clear(); err != nil {
+				t.Fatal(err)
+			}
+			if sums, _ := s.Sums(); len(sums) != 0 {
+				t.Errorf("expected no sums once cleared, got %+v", sums)
+			}
+		})
+	}
+}
+
+func TestMemoryStorage_Bounded(t *testing.T) {
+	s := NewMemoryStorage(3)
+	defer s.Close()
+	batch := []*FlowData{testFlow("cart"), testFlow("checkout"), testFlow("checkout")}
+	if _, err := s.Ingest(batch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Ingest([]*FlowData{testFlow("checkout"), testFlow("payment")}); err != nil {
+		t.Fatal(err)
+	}
+	var flows []*FlowData
+	_ = s.EachFlow(func(fd *FlowData) error { flows = append(flows, fd); return nil })
+	if got := sourceNames(flows); got != "[checkout checkout payment]" {
+		t.Errorf("expected the oldest flows to be evicted, got %s", got)
+	}
+	sums, _ := s.Sums()
+	reports := map[string]int64{}
+	for _, fs := range sums {
+		reports[fs.SourceName] = fs.SourceReports
+	}
+	if got := fmt.Sprint(reports); got != "map[checkout:2 payment:1]" {
+		t.Errorf("expected the sums to be added up from the flows kept, got %s", got)
+	}
+	if fs, _ := s.Sum(batch[0].SumID); fs != nil {
+		t.Errorf("expected the sum without flows to be deleted, got %+v", fs)
+	}
+}
+
+func TestMemoryStorage_RetentionAge(t *testing.T) {
+	s := NewMemoryStorage(0)
+	defer s.Close()
+	now := time.Now()
+	batch := []*FlowData{testFlow("cart"), testFlow("checkout"), testFlow("cart")}
+	batch[0].EndTime = now.Add(-2 * time.Hour)
+	batch[1].EndTime = now.Add(-2 * time.Hour)
+	if _, err := s.Ingest(batch); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := s.Retain(RetentionOptions{MaxAge: metav1.Duration{Duration: time.Hour}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != batch[1].SumID {
+		t.Errorf("expected the checkout sum to be removed, got %v", removed)
+	}
+	if fs, _ := s.Sum(batch[0].SumID); fs == nil || fs.SourceReports != 1 {
+		t.Errorf("expected the cart sum to keep the newer flow, got %+v", fs)
+	}
+
+	// A flow that ended long ago expires even behind one added before it
+	late := []*FlowData{testFlow("payment"), testFlow("payment")}
+	late[0].PacketsOut = 3
+	late[1].EndTime = now.Add(-2 * time.Hour)
+	if _, err := s.Ingest(late); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Retain(RetentionOptions{MaxAge: metav1.Duration{Duration: time.Hour}}, now); err != nil {
+		t.Fatal(err)
+	}
+	if fd, _ := s.Flow(late[1].ID); fd != nil {
+		t.Errorf("expected the expired flow to be evicted, got %+v", fd)
+	}
+	if fs, _ := s.Sum(late[0].SumID); fs == nil || fs.SourceReports != 1 || fs.SourcePacketsOut != 3 {
+		t.Errorf("expected the expired flow to be subtracted from its sum, got %+v", fs)
+	}
+	flows, _ := s.FlowsBySum(late[0].SumID)
+	if len(flows) != 1 || flows[0].ID != late[0].ID {
+		t.Errorf("expected the flow left in its sum, got %+v", flows)
+	}
+}
+
+func TestNewMemoryDataStore(t *testing.T) {
+	fds := NewMemoryDataStore(0)
+	defer fds.Close()
+	fds.Ingest = IngestOptions{BatchSize: 2, BatchLatency: metav1.Duration{Duration: 10 * time.Millisecond}}
+	fds.Run(nil)
+	for _, source := range []string{"cart", "checkout", "cart"} {
+		fds.AddFlow(testFlow(source))
+	}
+	waitCommitted(t, fds, 3)
+	if got := fmt.Sprint(sumReports(fds)); got != "map[cart:2 checkout:1]" {
+		t.Errorf("expected the flows to be summed in memory, got %s", got)
+	}
+	cart := fds.GetFlowSums(FilterAttributes{Name: "cart"})[0]
+	if flows := fds.GetFlowsBySumID(cart.ID, FilterAttributes{}); len(flows) != 2 {
+		t.Errorf("expected the flows of the sum, got %+v", flows)
+	}
+	// Sessions don't apply, there is no file to switch to
+	if err := fds.SwitchSession("prod", "incident"); err != nil {
+		t.Errorf("expected switching sessions to do nothing, got %v", err)
+	}
+}
@@ -14,6 +14,8 @@ type GlobalState struct {
 var (
 	mu = sync.RWMutex{}
 	gs = GlobalState{}
+	// filterChanged is closed, and replaced, when the filter changes
+	filterChanged = make(chan struct{})
 )
 
 func GetState() GlobalState {
@@ -25,6 +27,7 @@ func GetState() GlobalState {
 func SetState(g GlobalState) {
 	mu.Lock()
 	defer mu.Unlock()
+	setFilter(g.Filter)
 	gs = g
 }
 
@@ -35,9 +38,26 @@ func GetFilter() flowdata.FilterAttributes {
 func SetFilter(fa flowdata.FilterAttributes) {
 	mu.Lock()
 	defer mu.Unlock()
+	setFilter(fa)
+}
+
+// setFilter sets the filter and signals a change. The caller holds the lock.
+func setFilter(fa flowdata.FilterAttributes) {
+	if fa != gs.Filter {
+		close(filterChanged)
+		filterChanged = make(chan struct{})
+	}
 	gs.Filter = fa
 }
 
+// FilterChanged returns a channel that is closed the next time the filter
+// changes.
+func FilterChanged() <-chan struct{} {
+	mu.RLock()
+	defer mu.RUnlock()
+	return filterChanged
+}
+
 func GetSort() flowdata.SortAttributes {
 	return GetState().Sort
 }
@@ -86,3 +86,26 @@ func TestFilterAndSort(t *testing.T) {
 		t.Errorf("GetSort().SumTotalsAscending = %v; want %v", gotSort.SumTotalsAscending, sort.SumTotalsAscending)
 	}
 }
+
+func TestFilterChanged(t *testing.T) {
+	SetFilter(flowdata.FilterAttributes{})
+	changed := FilterChanged()
+
+	SetSort(flowdata.SortAttributes{SumTotalsFieldName: "other"})
+	SetFilter(flowdata.FilterAttributes{})
+	select {
+	case <-changed:
+		t.Fatal("expected no signal when the filter stays the same")
+	default:
+	}
+
+	SetFilter(flowdata.FilterAttributes{Action: "Deny"})
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected a signal when the filter changes")
+	}
+	if FilterChanged() == changed {
+		t.Error("expected a new channel for the next change")
+	}
+}
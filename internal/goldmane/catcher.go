@@ -0,0 +1,184 @@
+package goldmane
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/doucol/clyde/internal/catcher"
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/util"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FlowCatcher streams flows from Goldmane, port-forwarded to its pod the same
+// way catcher.DataCatcher reaches the Whisker backend, and hands each one to
+// the catcher as the JSON the Whisker backend would have sent.
+type FlowCatcher struct {
+	catcher        catcher.CatcherFunc
+	namespace      string
+	containerName  string
+	PortEnvVarName string
+	recoverFunc    func()
+	// Addr connects straight to a Goldmane server, in plaintext, instead of
+	// port-forwarding
+	Addr string
+	// TLSSecret names the secret, next to the pod, holding the client
+	// certificate Goldmane requires (tls.crt and tls.key)
+	TLSSecret string
+	// Request is the stream request, with its filter and how far back the
+	// history goes
+	Request StreamRequest
+	// Recorder, when set, receives every flow before the catcher does
+	Recorder *catcher.Recorder
+	// Cluster names the kube context the flows are recorded from
+	Cluster string
+	// Skip, when set, drops the flows it returns true for before they are
+	// recorded or caught
+	Skip func(data string) bool
+	// OnState, when set, is told each state the connection goes through
+	OnState func(catcher.State)
+}
+
+func NewFlowCatcher(namespace, containerName string, catcher catcher.CatcherFunc, recover func()) *FlowCatcher {
+	return &FlowCatcher{
+		namespace:      namespace,
+		containerName:  containerName,
+		catcher:        catcher,
+		PortEnvVarName: "PORT",
+		recoverFunc:    recover,
+	}
+}
+
+// state reports the state the connection is in.
+func (fc *FlowCatcher) state(s catcher.State) {
+	if fc.OnState != nil {
+		fc.OnState(s)
+	}
+}
+
+// CatchFlows streams the flows until ctx is done or the stream fails.
+// ready is closed once the stream is being connected to.
+func (fc *FlowCatcher) CatchFlows(ctx context.Context, ready chan bool) error {
+	select {
+	case <-ctx.Done():
+		logrus.Debug("done signal received - not entering goldmane flow catcher")
+		return nil
+	default:
+		logrus.Debug("entering goldmane flow catcher")
+	}
+
+	addr := fc.Addr
+	creds := insecure.NewCredentials()
+	if addr == "" {
+		wg := &sync.WaitGroup{}
+		stopChan := make(chan struct{}, 20)
+		defer func() {
+			util.ChanClose(stopChan)
+			wg.Wait()
+			logrus.Debug("exited goldmane flow catcher")
+		}()
+		var err error
+		if addr, err = fc.portForward(ctx, stopChan, wg); err != nil {
+			return err
+		}
+		if fc.TLSSecret != "" {
+			if creds, err = fc.credentials(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	fc.state(catcher.StateConnecting)
+	client, err := NewClient(addr, creds)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	client.OnStreaming = func() { fc.state(catcher.StateStreaming) }
+
+	logrus.Debugf("Streaming goldmane flows from %s", addr)
+	util.ChanClose(ready)
+	return client.Stream(ctx, &fc.Request, func(fr *FlowResult) error {
+		data, err := json.Marshal(fr.Flow.FlowResponse())
+		if err != nil {
+			return err
+		}
+		if fc.Skip != nil && fc.Skip(string(data)) {
+			return nil
+		}
+		if fc.Recorder != nil {
+			if err := fc.Recorder.Record(fc.Cluster, string(data)); err != nil {
+				logrus.WithError(err).Error("error recording goldmane flow")
+			}
+		}
+		return fc.catcher(string(data))
+	})
+}
+
+// portForward forwards a free local port to the Goldmane pod and returns
+// the local address once the forward is ready.
+func (fc *FlowCatcher) portForward(ctx context.Context, stopChan chan struct{}, wg *sync.WaitGroup) (string, error) {
+	config := cmdctx.K8sConfigFromContext(ctx)
+	clientset := cmdctx.K8sClientsetFromContext(ctx)
+
+	fc.state(catcher.StateResolvingPod)
+	podName, port, err := util.GetPodAndEnvVarByContainerName(ctx, clientset, fc.namespace, fc.containerName, fc.PortEnvVarName)
+	if err != nil {
+		return "", err
+	}
+
+	fc.state(catcher.StatePortForwarding)
+	readyChan := make(chan struct{})
+	pf, freePort, err := catcher.PortForward(config, fc.namespace, podName, port, stopChan, readyChan)
+	if err != nil {
+		return "", err
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer fc.recoverFunc()
+		logrus.Debugf("Starting port forward from localhost:%d to %s/%s:%s", freePort, fc.namespace, podName, port)
+		if err := pf.ForwardPorts(); err != nil {
+			logrus.Debugf("error: ForwardPorts return error: %s", err.Error())
+		}
+		logrus.Debug("port forward has stopped")
+	}()
+
+	select {
+	case <-readyChan:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(5 * time.Second):
+		return "", fmt.Errorf("timeout waiting for the port forward to %s/%s", fc.namespace, podName)
+	}
+	return fmt.Sprintf("localhost:%d", freePort), nil
+}
+
+// credentials loads the client certificate from the TLS secret. Goldmane's
+// own certificate is issued for its service name, not the forwarded local
+// port, and the tunnel through the API server is already authenticated, so
+// it isn't verified.
+func (fc *FlowCatcher) credentials(ctx context.Context) (credentials.TransportCredentials, error) {
+	clientset := cmdctx.K8sClientsetFromContext(ctx)
+	secret, err := clientset.CoreV1().Secrets(fc.namespace).Get(ctx, fc.TLSSecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the goldmane client certificate: %w", err)
+	}
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid goldmane client certificate in %s/%s: %w", fc.namespace, fc.TLSSecret, err)
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+	}), nil
+}
@@ -0,0 +1,293 @@
+// Package goldmane is a client for the Flows gRPC API of Calico's Goldmane,
+// the flow aggregator behind the Whisker UI.
+package goldmane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Match types of a StringMatch
+const (
+	MatchExact = "Exact"
+	MatchFuzzy = "Fuzzy"
+)
+
+// Sort orders of a SortOption
+const (
+	SortTime            = "Time"
+	SortDestName        = "DestName"
+	SortDestNamespace   = "DestNamespace"
+	SortSourceName      = "SourceName"
+	SortSourceNamespace = "SourceNamespace"
+)
+
+// The Go mirrors of the goldmane messages. int64 fields are strings in
+// protojson, hence the string option on their tags.
+
+type StringMatch struct {
+	Value string `json:"value,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+type PortMatch struct {
+	Port int64 `json:"port,omitempty,string"`
+}
+
+// Filter narrows the flows down on the server. Each field matches any of
+// its values, the fields all have to match.
+type Filter struct {
+	SourceNames      []StringMatch `json:"sourceNames,omitempty"`
+	SourceNamespaces []StringMatch `json:"sourceNamespaces,omitempty"`
+	DestNames        []StringMatch `json:"destNames,omitempty"`
+	DestNamespaces   []StringMatch `json:"destNamespaces,omitempty"`
+	Protocols        []string      `json:"protocols,omitempty"`
+	DestPorts        []PortMatch   `json:"destPorts,omitempty"`
+	Actions          []string      `json:"actions,omitempty"`
+}
+
+type SortOption struct {
+	SortBy string `json:"sortBy,omitempty"`
+}
+
+// ListRequest lists the aggregated flows. Times are unix seconds, or
+// seconds relative to now when negative.
+type ListRequest struct {
+	StartTimeGte int64        `json:"startTimeGte,omitempty,string"`
+	StartTimeLt  int64        `json:"startTimeLt,omitempty,string"`
+	Page         int64        `json:"page,omitempty,string"`
+	PageSize     int64        `json:"pageSize,omitempty,string"`
+	SortBy       []SortOption `json:"sortBy,omitempty"`
+	Filter       *Filter      `json:"filter,omitempty"`
+}
+
+type ListMetadata struct {
+	TotalPages   int64 `json:"totalPages,omitempty,string"`
+	TotalResults int64 `json:"totalResults,omitempty,string"`
+}
+
+type ListResult struct {
+	Meta  ListMetadata `json:"meta"`
+	Flows []FlowResult `json:"flows"`
+}
+
+// StreamRequest streams the flows from StartTimeGte on, the history first,
+// then new flows as they are aggregated.
+type StreamRequest struct {
+	StartTimeGte int64   `json:"startTimeGte,omitempty,string"`
+	Filter       *Filter `json:"filter,omitempty"`
+}
+
+// FilterHintsRequest asks for the values seen for a filter type, e.g.
+// "FilterTypeSourceNamespace".
+type FilterHintsRequest struct {
+	Type     string  `json:"type,omitempty"`
+	Filter   *Filter `json:"filter,omitempty"`
+	PageSize int64   `json:"pageSize,omitempty,string"`
+}
+
+type FlowResult struct {
+	ID   int64 `json:"id,omitempty,string"`
+	Flow Flow  `json:"flow"`
+}
+
+type Flow struct {
+	Key          FlowKey  `json:"Key"`
+	StartTime    int64    `json:"startTime,omitempty,string"`
+	EndTime      int64    `json:"endTime,omitempty,string"`
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	DestLabels   []string `json:"destLabels,omitempty"`
+	PacketsIn    int64    `json:"packetsIn,omitempty,string"`
+	PacketsOut   int64    `json:"packetsOut,omitempty,string"`
+	BytesIn      int64    `json:"bytesIn,omitempty,string"`
+	BytesOut     int64    `json:"bytesOut,omitempty,string"`
+}
+
+type FlowKey struct {
+	SourceName      string       `json:"sourceName,omitempty"`
+	SourceNamespace string       `json:"sourceNamespace,omitempty"`
+	DestName        string       `json:"destName,omitempty"`
+	DestNamespace   string       `json:"destNamespace,omitempty"`
+	DestPort        int64        `json:"destPort,omitempty,string"`
+	Proto           string       `json:"proto,omitempty"`
+	Reporter        string       `json:"reporter,omitempty"`
+	Action          string       `json:"action,omitempty"`
+	Policies        *PolicyTrace `json:"policies,omitempty"`
+}
+
+type PolicyTrace struct {
+	EnforcedPolicies []*PolicyHit `json:"enforcedPolicies,omitempty"`
+	PendingPolicies  []*PolicyHit `json:"pendingPolicies,omitempty"`
+}
+
+type PolicyHit struct {
+	Kind        string     `json:"kind,omitempty"`
+	Namespace   string     `json:"namespace,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	Tier        string     `json:"tier,omitempty"`
+	Action      string     `json:"action,omitempty"`
+	PolicyIndex int64      `json:"policyIndex,omitempty,string"`
+	RuleIndex   int64      `json:"ruleIndex,omitempty,string"`
+	Trigger     *PolicyHit `json:"trigger,omitempty"`
+}
+
+// FlowResponse converts the flow to the shape the Whisker backend serves,
+// which is what the rest of clyde stores.
+func (f *Flow) FlowResponse() *flowdata.FlowResponse {
+	fr := &flowdata.FlowResponse{
+		StartTime:       time.Unix(f.StartTime, 0).UTC(),
+		EndTime:         time.Unix(f.EndTime, 0).UTC(),
+		Action:          f.Key.Action,
+		SourceName:      f.Key.SourceName,
+		SourceNamespace: f.Key.SourceNamespace,
+		SourceLabels:    strings.Join(f.SourceLabels, " | "),
+		DestName:        f.Key.DestName,
+		DestNamespace:   f.Key.DestNamespace,
+		DestLabels:      strings.Join(f.DestLabels, " | "),
+		Protocol:        f.Key.Proto,
+		DestPort:        f.Key.DestPort,
+		Reporter:        f.Key.Reporter,
+		PacketsIn:       f.PacketsIn,
+		PacketsOut:      f.PacketsOut,
+		BytesIn:         f.BytesIn,
+		BytesOut:        f.BytesOut,
+	}
+	if p := f.Key.Policies; p != nil {
+		fr.Policies.Enforced = policyHits(p.EnforcedPolicies)
+		fr.Policies.Pending = policyHits(p.PendingPolicies)
+	}
+	return fr
+}
+
+func policyHits(hits []*PolicyHit) []*flowdata.PolicyHit {
+	var out []*flowdata.PolicyHit
+	for _, h := range hits {
+		out = append(out, policyHit(h))
+	}
+	return out
+}
+
+func policyHit(h *PolicyHit) *flowdata.PolicyHit {
+	if h == nil {
+		return nil
+	}
+	return &flowdata.PolicyHit{
+		Kind:        h.Kind,
+		Name:        h.Name,
+		Namespace:   h.Namespace,
+		Tier:        h.Tier,
+		Action:      h.Action,
+		PolicyIndex: h.PolicyIndex,
+		RuleIndex:   h.RuleIndex,
+		Trigger:     policyHit(h.Trigger),
+	}
+}
+
+// Client calls the Flows API of a Goldmane server.
+type Client struct {
+	conn *grpc.ClientConn
+	// OnStreaming, when set, is called once a stream is sent its request
+	OnStreaming func()
+}
+
+// NewClient returns a client for the Goldmane server at target (host:port).
+// No connection is made until the first call.
+func NewClient(target string, creds credentials.TransportCredentials) (*Client, error) {
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create goldmane client for %s: %w", target, err)
+	}
+	return &Client{conn: conn}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// List returns a page of the aggregated flows, filtered and sorted on the
+// server.
+func (c *Client) List(ctx context.Context, req *ListRequest) (*ListResult, error) {
+	in, err := encode("FlowListRequest", req)
+	if err != nil {
+		return nil, err
+	}
+	out := newMessage("FlowListResult")
+	if err := c.conn.Invoke(ctx, methodList, in, out); err != nil {
+		return nil, fmt.Errorf("failed to list goldmane flows: %w", err)
+	}
+	res := &ListResult{}
+	return res, decode(out, res)
+}
+
+// Stream calls fn with each flow the server streams until ctx is done, the
+// server ends the stream, or fn returns an error.
+func (c *Client) Stream(ctx context.Context, req *StreamRequest, fn func(*FlowResult) error) error {
+	in, err := encode("FlowStreamRequest", req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, methodStream)
+	if err != nil {
+		return fmt.Errorf("failed to stream goldmane flows: %w", err)
+	}
+	if err := stream.SendMsg(in); err != nil {
+		return fmt.Errorf("failed to stream goldmane flows: %w", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	if c.OnStreaming != nil {
+		c.OnStreaming()
+	}
+	for {
+		out := newMessage("FlowResult")
+		if err := stream.RecvMsg(out); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("goldmane flow stream failed: %w", err)
+		}
+		fr := &FlowResult{}
+		if err := decode(out, fr); err != nil {
+			return err
+		}
+		if err := fn(fr); err != nil {
+			return err
+		}
+	}
+}
+
+// FilterHints returns the values the server has seen for a filter type.
+func (c *Client) FilterHints(ctx context.Context, req *FilterHintsRequest) ([]string, error) {
+	in, err := encode("FilterHintsRequest", req)
+	if err != nil {
+		return nil, err
+	}
+	out := newMessage("FilterHintsResult")
+	if err := c.conn.Invoke(ctx, methodFilterHints, in, out); err != nil {
+		return nil, fmt.Errorf("failed to get goldmane filter hints: %w", err)
+	}
+	var res struct {
+		Hints []struct {
+			Value string `json:"value"`
+		} `json:"hints"`
+	}
+	if err := decode(out, &res); err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, h := range res.Hints {
+		values = append(values, h.Value)
+	}
+	return values, nil
+}
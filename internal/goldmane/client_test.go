@@ -0,0 +1,247 @@
+package goldmane
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// standIn is an in-process Goldmane that serves its flows and remembers the
+// requests it was sent.
+type standIn struct {
+	flows []FlowResult
+
+	mu     sync.Mutex
+	list   *ListRequest
+	stream *StreamRequest
+}
+
+func (s *standIn) listHandler(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+	in := newMessage("FlowListRequest")
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	req := &ListRequest{}
+	if err := decode(in, req); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.list = req
+	s.mu.Unlock()
+	return encode("FlowListResult", &ListResult{Meta: ListMetadata{TotalPages: 1, TotalResults: int64(len(s.flows))}, Flows: s.flows})
+}
+
+func (s *standIn) hintsHandler(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+	in := newMessage("FilterHintsRequest")
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	var hints []map[string]string
+	for _, f := range s.flows {
+		hints = append(hints, map[string]string{"value": f.Flow.Key.SourceNamespace})
+	}
+	return encode("FilterHintsResult", map[string]any{"hints": hints})
+}
+
+func (s *standIn) streamHandler(srv any, stream grpc.ServerStream) error {
+	in := newMessage("FlowStreamRequest")
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	req := &StreamRequest{}
+	if err := decode(in, req); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.stream = req
+	s.mu.Unlock()
+	for i := range s.flows {
+		out, err := encode("FlowResult", &s.flows[i])
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(out); err != nil {
+			return err
+		}
+	}
+	// Keep the stream open like Goldmane does, until the client goes away
+	<-stream.Context().Done()
+	return nil
+}
+
+// serve starts the stand-in on a local port and returns its address.
+func (s *standIn) serve(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "List", Handler: s.listHandler},
+			{MethodName: "FilterHints", Handler: s.hintsHandler},
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Stream", Handler: s.streamHandler, ServerStreams: true},
+		},
+	}, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func newStandIn() *standIn {
+	flow := func(id int64, srcNs, action string) FlowResult {
+		return FlowResult{ID: id, Flow: Flow{
+			Key: FlowKey{
+				SourceName: "web", SourceNamespace: srcNs, DestName: "db", DestNamespace: "prod",
+				DestPort: 5432, Proto: "tcp", Reporter: "Src", Action: action,
+				Policies: &PolicyTrace{EnforcedPolicies: []*PolicyHit{{
+					Kind: "CalicoNetworkPolicy", Namespace: "prod", Name: "allow-db", Tier: "default", Action: action, RuleIndex: 2,
+				}}},
+			},
+			StartTime:    1748779200,
+			EndTime:      1748779215,
+			SourceLabels: []string{"app=web", "tier=frontend"},
+			PacketsIn:    10, PacketsOut: 12, BytesIn: 1000, BytesOut: 1200,
+		}}
+	}
+	return &standIn{flows: []FlowResult{flow(1, "dev", "Allow"), flow(2, "shop", "Deny")}}
+}
+
+func TestClient_List(t *testing.T) {
+	s := newStandIn()
+	c, err := NewClient(s.serve(t), insecure.NewCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	req := &ListRequest{
+		StartTimeGte: -3600,
+		PageSize:     50,
+		SortBy:       []SortOption{{SortBy: SortDestNamespace}},
+		Filter: &Filter{
+			SourceNamespaces: []StringMatch{{Value: "dev", Type: MatchFuzzy}},
+			DestPorts:        []PortMatch{{Port: 5432}},
+			Actions:          []string{"Deny"},
+		},
+	}
+	res, err := c.List(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Meta.TotalResults != 2 || len(res.Flows) != 2 || res.Flows[1].ID != 2 {
+		t.Fatalf("expected both flows, got %+v", res)
+	}
+	if got, want := toJSON(t, s.list), toJSON(t, req); got != want {
+		t.Errorf("expected the server to get %s, got %s", want, got)
+	}
+
+	hints, err := c.FilterHints(context.Background(), &FilterHintsRequest{Type: "FilterTypeSourceNamespace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hints) != 2 || hints[0] != "dev" || hints[1] != "shop" {
+		t.Errorf("expected the source namespaces as hints, got %v", hints)
+	}
+}
+
+func TestFlow_FlowResponse(t *testing.T) {
+	fr := newStandIn().flows[1].Flow.FlowResponse()
+	if fr.SourceNamespace != "shop" || fr.DestPort != 5432 || fr.Protocol != "tcp" || fr.Action != "Deny" || fr.Reporter != "Src" {
+		t.Errorf("unexpected flow key %+v", fr)
+	}
+	if !fr.StartTime.Equal(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)) || fr.EndTime.Sub(fr.StartTime) != 15*time.Second {
+		t.Errorf("unexpected times %s - %s", fr.StartTime, fr.EndTime)
+	}
+	if fr.SourceLabels != "app=web | tier=frontend" || fr.DestLabels != "" {
+		t.Errorf("unexpected labels %q and %q", fr.SourceLabels, fr.DestLabels)
+	}
+	if fr.BytesOut != 1200 || len(fr.Policies.Enforced) != 1 || fr.Policies.Enforced[0].RuleIndex != 2 {
+		t.Errorf("unexpected stats or policies %+v", fr)
+	}
+}
+
+func TestFlowCatcher(t *testing.T) {
+	s := newStandIn()
+	var mu sync.Mutex
+	var caught []flowdata.FlowResponse
+	fc := NewFlowCatcher("calico-system", "goldmane", func(data string) error {
+		var fr flowdata.FlowResponse
+		if err := json.Unmarshal([]byte(data), &fr); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		caught = append(caught, fr)
+		return nil
+	}, func() {})
+	fc.Addr = s.serve(t)
+	fc.Request = StreamRequest{StartTimeGte: -300, Filter: &Filter{Actions: []string{"Deny"}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ready := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- fc.CatchFlows(ctx, ready)
+	}()
+	select {
+	case <-ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the catcher to be ready")
+	}
+
+	deadline := time.After(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(caught)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("timed out waiting for the flows, got %d", n)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if caught[0].SourceNamespace != "dev" || caught[1].Action != "Deny" {
+		t.Errorf("unexpected flows %+v", caught)
+	}
+	s.mu.Lock()
+	if s.stream == nil || s.stream.StartTimeGte != -300 || s.stream.Filter.Actions[0] != "Deny" {
+		t.Errorf("expected the stream request to reach the server, got %+v", s.stream)
+	}
+	s.mu.Unlock()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the catcher to exit")
+	}
+}
+
+func toJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,255 @@
+package goldmane
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// The goldmane.Flows service, described in code from Calico's
+// goldmane/proto/api.proto so clyde doesn't depend on the Calico module. Only
+// the messages and fields clyde uses are described, the rest are skipped
+// over on the wire like any unknown field.
+const (
+	ServiceName       = "goldmane.Flows"
+	methodList        = "/goldmane.Flows/List"
+	methodStream      = "/goldmane.Flows/Stream"
+	methodFilterHints = "/goldmane.Flows/FilterHints"
+)
+
+var (
+	optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+)
+
+func field(name string, number int32, label *descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  label,
+		Type:   typ.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(".goldmane." + typeName)
+	}
+	return f
+}
+
+func str(name string, number int32) *descriptorpb.FieldDescriptorProto {
+	return field(name, number, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")
+}
+
+func i64(name string, number int32) *descriptorpb.FieldDescriptorProto {
+	return field(name, number, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, "")
+}
+
+func enum(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	return field(name, number, optional, descriptorpb.FieldDescriptorProto_TYPE_ENUM, typeName)
+}
+
+func msg(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	return field(name, number, optional, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName)
+}
+
+func list(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	f.Label = repeated
+	return f
+}
+
+func message(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+func enumType(name string, values ...string) *descriptorpb.EnumDescriptorProto {
+	e := &descriptorpb.EnumDescriptorProto{Name: proto.String(name)}
+	for i, v := range values {
+		e.Value = append(e.Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(v), Number: proto.Int32(int32(i))})
+	}
+	return e
+}
+
+func method(name, in, out string, serverStreaming bool) *descriptorpb.MethodDescriptorProto {
+	return &descriptorpb.MethodDescriptorProto{
+		Name:            proto.String(name),
+		InputType:       proto.String(".goldmane." + in),
+		OutputType:      proto.String(".goldmane." + out),
+		ServerStreaming: proto.Bool(serverStreaming),
+	}
+}
+
+var apiProto = &descriptorpb.FileDescriptorProto{
+	Name:    proto.String("goldmane/proto/api.proto"),
+	Package: proto.String("goldmane"),
+	Syntax:  proto.String("proto3"),
+	EnumType: []*descriptorpb.EnumDescriptorProto{
+		enumType("Action", "ActionUnspecified", "Allow", "Deny", "Pass"),
+		enumType("Reporter", "ReporterUnspecified", "Src", "Dst"),
+		enumType("EndpointType", "EndpointTypeUnspecified", "WorkloadEndpoint", "HostEndpoint", "NetworkSet", "Network"),
+		enumType("PolicyKind", "KindUnspecified", "CalicoNetworkPolicy", "GlobalNetworkPolicy", "StagedNetworkPolicy",
+			"StagedGlobalNetworkPolicy", "StagedKubernetesNetworkPolicy", "NetworkPolicy", "AdminNetworkPolicy",
+			"BaselineAdminNetworkPolicy", "Profile", "EndOfTier"),
+		enumType("MatchType", "Exact", "Fuzzy"),
+		enumType("SortBy", "Time", "DestName", "DestNamespace", "DestType", "SourceName", "SourceNamespace", "SourceType"),
+		enumType("FilterType", "FilterTypeUnspecified", "FilterTypeDestName", "FilterTypeSourceName",
+			"FilterTypeDestNamespace", "FilterTypeSourceNamespace", "FilterTypePolicyTier", "FilterTypePolicyName"),
+	},
+	MessageType: []*descriptorpb.DescriptorProto{
+		message("FlowListRequest",
+			i64("start_time_gte", 1),
+			i64("start_time_lt", 2),
+			i64("page", 3),
+			i64("page_size", 4),
+			list(msg("sort_by", 5, "SortOption")),
+			msg("filter", 6, "Filter"),
+			i64("aggregation_interval", 7),
+		),
+		message("FlowListResult",
+			msg("meta", 1, "ListMetadata"),
+			list(msg("flows", 2, "FlowResult")),
+		),
+		message("ListMetadata",
+			i64("totalPages", 1),
+			i64("totalResults", 2),
+		),
+		message("FlowStreamRequest",
+			i64("start_time_gte", 1),
+			msg("filter", 2, "Filter"),
+			i64("aggregation_interval", 3),
+		),
+		message("FilterHintsRequest",
+			enum("type", 1, "FilterType"),
+			msg("filter", 2, "Filter"),
+			i64("start_time_gte", 3),
+			i64("start_time_lt", 4),
+			i64("page", 5),
+			i64("page_size", 6),
+		),
+		message("FilterHintsResult",
+			msg("meta", 1, "ListMetadata"),
+			list(msg("hints", 2, "FilterHint")),
+		),
+		message("FilterHint", str("value", 1)),
+		message("FlowResult",
+			i64("id", 1),
+			msg("flow", 2, "Flow"),
+		),
+		message("Filter",
+			list(msg("source_names", 1, "StringMatch")),
+			list(msg("source_namespaces", 2, "StringMatch")),
+			list(msg("dest_names", 3, "StringMatch")),
+			list(msg("dest_namespaces", 4, "StringMatch")),
+			list(str("protocols", 5)),
+			list(msg("dest_ports", 6, "PortMatch")),
+			list(enum("actions", 7, "Action")),
+			list(msg("policies", 8, "PolicyMatch")),
+		),
+		message("StringMatch",
+			str("value", 1),
+			enum("type", 2, "MatchType"),
+		),
+		message("PortMatch", i64("port", 1)),
+		message("PolicyMatch",
+			enum("kind", 1, "PolicyKind"),
+			str("tier", 2),
+			str("namespace", 3),
+			str("name", 4),
+			enum("action", 5, "Action"),
+		),
+		message("SortOption", enum("sort_by", 1, "SortBy")),
+		message("FlowKey",
+			str("source_name", 1),
+			str("source_namespace", 2),
+			enum("source_type", 3, "EndpointType"),
+			str("dest_name", 4),
+			str("dest_namespace", 5),
+			enum("dest_type", 6, "EndpointType"),
+			i64("dest_port", 7),
+			str("dest_service_name", 8),
+			str("dest_service_namespace", 9),
+			str("dest_service_port_name", 10),
+			i64("dest_service_port", 11),
+			str("proto", 12),
+			enum("reporter", 13, "Reporter"),
+			enum("action", 14, "Action"),
+			msg("policies", 15, "PolicyTrace"),
+		),
+		message("Flow",
+			msg("Key", 1, "FlowKey"),
+			i64("start_time", 2),
+			i64("end_time", 3),
+			list(str("source_labels", 4)),
+			list(str("dest_labels", 5)),
+			i64("packets_in", 6),
+			i64("packets_out", 7),
+			i64("bytes_in", 8),
+			i64("bytes_out", 9),
+			i64("num_connections_started", 10),
+			i64("num_connections_completed", 11),
+			i64("num_connections_live", 12),
+		),
+		message("PolicyTrace",
+			list(msg("enforced_policies", 1, "PolicyHit")),
+			list(msg("pending_policies", 2, "PolicyHit")),
+		),
+		message("PolicyHit",
+			enum("kind", 1, "PolicyKind"),
+			str("namespace", 2),
+			str("name", 3),
+			str("tier", 4),
+			enum("action", 5, "Action"),
+			i64("policy_index", 6),
+			i64("rule_index", 7),
+			msg("trigger", 8, "PolicyHit"),
+		),
+	},
+	Service: []*descriptorpb.ServiceDescriptorProto{{
+		Name: proto.String("Flows"),
+		Method: []*descriptorpb.MethodDescriptorProto{
+			method("List", "FlowListRequest", "FlowListResult", false),
+			method("Stream", "FlowStreamRequest", "FlowResult", true),
+			method("FilterHints", "FilterHintsRequest", "FilterHintsResult", false),
+		},
+	}},
+}
+
+// apiFile is the resolved descriptor of apiProto.
+var apiFile = func() protoreflect.FileDescriptor {
+	fd, err := protodesc.NewFile(apiProto, nil)
+	if err != nil {
+		panic("invalid goldmane descriptor: " + err.Error())
+	}
+	return fd
+}()
+
+// newMessage returns an empty goldmane message by name, e.g. "Flow".
+func newMessage(name string) *dynamicpb.Message {
+	return dynamicpb.NewMessage(apiFile.Messages().ByName(protoreflect.Name(name)))
+}
+
+// encode converts v, one of the Go mirrors of the goldmane messages, to the
+// named message. The mirrors carry the protojson names in their json tags.
+func encode(name string, v any) (*dynamicpb.Message, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := newMessage(name)
+	if err := protojson.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decode converts a goldmane message to its Go mirror v.
+func decode(m proto.Message, v any) error {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
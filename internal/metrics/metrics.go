@@ -0,0 +1,231 @@
+// Package metrics exposes flow summaries in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/doucol/clyde/internal/flowdata"
+)
+
+// ContentType is the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Other is the label value of the series flow summaries are folded into
+// once MaxSeries is reached.
+const Other = "__other__"
+
+// DefaultMaxSeries is the default limit on the number of label sets exported.
+const DefaultMaxSeries = 1000
+
+type FlowSumSource interface {
+	GetFlowSums(filter flowdata.FilterAttributes) []*flowdata.FlowSum
+}
+
+// IngestStatsSource is a FlowSumSource that also counts the flows it
+// ingests, which are then exported too.
+type IngestStatsSource interface {
+	IngestStats() flowdata.IngestStats
+}
+
+// TotalsSource is a FlowSumSource that also adds up the flows it ingests
+// into totals retention doesn't lower, which the counters are then exported
+// from. Otherwise the counters are the totals of the summaries, which go down
+// as retention deletes flows.
+type TotalsSource interface {
+	FlowTotals() []*flowdata.FlowSum
+}
+
+// labelNames are the labels of every per flow summary series, in order.
+var labelNames = []string{"cluster", "source_namespace", "source_name", "dest_namespace", "dest_name", "protocol", "dest_port", "action"}
+
+// Exporter serves the flow summaries of its source on each scrape.
+type Exporter struct {
+	fss FlowSumSource
+	// MaxSeries caps the number of label sets exported. Summaries are admitted
+	// oldest first, so a series doesn't come and go between scrapes, and the
+	// rest are folded into a single series labelled Other.
+	MaxSeries int
+}
+
+func NewExporter(fss FlowSumSource) *Exporter {
+	return &Exporter{fss: fss, MaxSeries: DefaultMaxSeries}
+}
+
+// series is the aggregate of the flow summaries sharing a label set.
+type series struct {
+	labels  [8]string
+	reports [2]int64
+	// [reporter][direction]
+	packets, bytes       [2][2]uint64
+	packetRate, byteRate [2][2]float64
+}
+
+var (
+	reporters  = [2]string{"src", "dst"}
+	directions = [2]string{"in", "out"}
+)
+
+// addTotals adds the reports, packets and bytes of the summary up.
+func (s *series) addTotals(fs *flowdata.FlowSum) {
+	s.reports[0] += fs.SourceReports
+	s.reports[1] += fs.DestReports
+	s.packets[0][0] += fs.SourcePacketsIn
+	s.packets[0][1] += fs.SourcePacketsOut
+	s.packets[1][0] += fs.DestPacketsIn
+	s.packets[1][1] += fs.DestPacketsOut
+	s.bytes[0][0] += fs.SourceBytesIn
+	s.bytes[0][1] += fs.SourceBytesOut
+	s.bytes[1][0] += fs.DestBytesIn
+	s.bytes[1][1] += fs.DestBytesOut
+}
+
+// addRates adds the rates of the summary up.
+func (s *series) addRates(fs *flowdata.FlowSum) {
+	s.packetRate[0][0] += fs.SourcePacketsInRate
+	s.packetRate[0][1] += fs.SourcePacketsOutRate
+	s.packetRate[1][0] += fs.DestPacketsInRate
+	s.packetRate[1][1] += fs.DestPacketsOutRate
+	s.byteRate[0][0] += fs.SourceBytesInRate
+	s.byteRate[0][1] += fs.SourceBytesOutRate
+	s.byteRate[1][0] += fs.DestBytesInRate
+	s.byteRate[1][1] += fs.DestBytesOutRate
+}
+
+// collect groups the flow summaries, and the totals of a TotalsSource, by
+// label set, folding everything past MaxSeries into the Other series. It also
+// returns how many summaries were folded.
+func (e *Exporter) collect() ([]*series, int) {
+	sums := e.fss.GetFlowSums(flowdata.FilterAttributes{})
+	sort.Slice(sums, func(i, j int) bool { return sums[i].ID < sums[j].ID })
+
+	bySet := map[[8]string]*series{}
+	var all []*series
+	var other *series
+	// seriesOf returns the series of the label set of fs, admitting it while
+	// there is room, or the Other series
+	seriesOf := func(fs *flowdata.FlowSum) (*series, bool) {
+		labels := [8]string{fs.Cluster, fs.SourceNamespace, fs.SourceName, fs.DestNamespace, fs.DestName,
+			fs.Protocol, strconv.FormatInt(fs.DestPort, 10), fs.Action}
+		if s, ok := bySet[labels]; ok {
+			return s, false
+		}
+		if e.MaxSeries > 0 && len(bySet) >= e.MaxSeries {
+			if other == nil {
+				other = &series{labels: [8]string{Other, Other, Other, Other, Other, Other, Other, Other}}
+			}
+			return other, true
+		}
+		s := &series{labels: labels}
+		bySet[labels] = s
+		all = append(all, s)
+		return s, false
+	}
+	ts, hasTotals := e.fss.(TotalsSource)
+	folded := 0
+	for _, fs := range sums {
+		s, isOther := seriesOf(fs)
+		if isOther {
+			folded++
+		}
+		s.addRates(fs)
+		if !hasTotals {
+			s.addTotals(fs)
+		}
+	}
+	if hasTotals {
+		// Including those of the summaries retention has deleted since
+		for _, t := range ts.FlowTotals() {
+			s, _ := seriesOf(t)
+			s.addTotals(t)
+		}
+	}
+	if other != nil {
+		all = append(all, other)
+	}
+	return all, folded
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	all, folded := e.collect()
+	w.Header().Set("Content-Type", ContentType)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	header(bw, "clyde_flow_reports_total", "counter", "Flow reports per reporter (src or dst).")
+	for _, s := range all {
+		for ri, reporter := range reporters {
+			sample(bw, "clyde_flow_reports_total", s, reporter, "", float64(s.reports[ri]))
+		}
+	}
+	for _, f := range families {
+		header(bw, f.name, f.kind, f.help)
+		for _, s := range all {
+			for ri, reporter := range reporters {
+				for di, direction := range directions {
+					sample(bw, f.name, s, reporter, direction, f.value(s, ri, di))
+				}
+			}
+		}
+	}
+
+	header(bw, "clyde_flow_series", "gauge", "Label sets exported, including the folded one.")
+	fmt.Fprintf(bw, "clyde_flow_series %d\n", len(all))
+	header(bw, "clyde_flow_sums_folded", "gauge", fmt.Sprintf("Flow summaries folded into the %s series by the series limit.", Other))
+	fmt.Fprintf(bw, "clyde_flow_sums_folded %d\n", folded)
+
+	if is, ok := e.fss.(IngestStatsSource); ok {
+		stats := is.IngestStats()
+		header(bw, "clyde_ingest_flows_total", "counter", "Flows queued, committed to the local store and dropped by the backpressure policy.")
+		fmt.Fprintf(bw, "clyde_ingest_flows_total{state=\"queued\"} %d\n", stats.Queued)
+		fmt.Fprintf(bw, "clyde_ingest_flows_total{state=\"committed\"} %d\n", stats.Committed)
+		fmt.Fprintf(bw, "clyde_ingest_flows_total{state=\"dropped\"} %d\n", stats.Dropped)
+		header(bw, "clyde_ingest_flows_pending", "gauge", "Flows queued and not written to the local store yet.")
+		fmt.Fprintf(bw, "clyde_ingest_flows_pending %d\n", stats.Pending)
+	}
+}
+
+// families are the per reporter and direction metrics.
+var families = []struct {
+	name, kind, help string
+	value            func(s *series, ri, di int) float64
+}{
+	{"clyde_flow_packets_total", "counter", "Packets per reporter (src or dst) and direction.",
+		func(s *series, ri, di int) float64 { return float64(s.packets[ri][di]) }},
+	{"clyde_flow_bytes_total", "counter", "Bytes per reporter (src or dst) and direction.",
+		func(s *series, ri, di int) float64 { return float64(s.bytes[ri][di]) }},
+	{"clyde_flow_packet_rate", "gauge", "Packets per second over the rate window, per reporter and direction.",
+		func(s *series, ri, di int) float64 { return s.packetRate[ri][di] }},
+	{"clyde_flow_byte_rate", "gauge", "Bytes per second over the rate window, per reporter and direction.",
+		func(s *series, ri, di int) float64 { return s.byteRate[ri][di] }},
+}
+
+func header(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sample(w *bufio.Writer, name string, s *series, reporter, direction string, v float64) {
+	w.WriteString(name)
+	w.WriteByte('{')
+	for i, l := range labelNames {
+		fmt.Fprintf(w, "%s=\"%s\",", l, escape(s.labels[i]))
+	}
+	fmt.Fprintf(w, "reporter=%q", reporter)
+	if direction != "" {
+		fmt.Fprintf(w, ",direction=%q", direction)
+	}
+	w.WriteString("} ")
+	w.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	w.WriteByte('\n')
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes a label value for the text format.
+func escape(v string) string {
+	return escaper.Replace(v)
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/doucol/clyde/internal/flowdata"
+)
+
+type fakeSource []*flowdata.FlowSum
+
+func (f fakeSource) GetFlowSums(filter flowdata.FilterAttributes) []*flowdata.FlowSum {
+	return f
+}
+
+func scrape(t *testing.T, e *Exporter) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected content type %q, got %q", ContentType, ct)
+	}
+	return rec.Body.String()
+}
+
+func sum(id int, srcName, action string) *flowdata.FlowSum {
+	return &flowdata.FlowSum{
+		ID: id, Cluster: "kind-a", SourceNamespace: "shop", SourceName: srcName, DestNamespace: "db", DestName: "postgres",
+		Protocol: "tcp", DestPort: 5432, Action: action,
+		SourceReports: 2, SourcePacketsOut: 10, SourceBytesOut: 1000, SourcePacketsOutRate: 0.5,
+		DestReports: 1, DestPacketsIn: 9,
+	}
+}
+
+func TestExporter(t *testing.T) {
+	e := NewExporter(fakeSource{sum(1, "cart", "Allow"), sum(2, "cart", "Allow"), sum(3, "web \"1\"", "Deny")})
+	out := scrape(t, e)
+
+	labels := `cluster="kind-a",source_namespace="shop",source_name="cart",dest_namespace="db",dest_name="postgres",protocol="tcp",dest_port="5432",action="Allow"`
+	for _, want := range []string{
+		"# TYPE clyde_flow_packets_total counter\n",
+		"# TYPE clyde_flow_packet_rate gauge\n",
+		// Summaries sharing a label set are added up
+		"clyde_flow_reports_total{" + labels + `,reporter="src"} 4` + "\n",
+		"clyde_flow_packets_total{" + labels + `,reporter="src",direction="out"} 20` + "\n",
+		"clyde_flow_bytes_total{" + labels + `,reporter="src",direction="out"} 2000` + "\n",
+		"clyde_flow_packets_total{" + labels + `,reporter="dst",direction="in"} 18` + "\n",
+		"clyde_flow_packet_rate{" + labels + `,reporter="src",direction="out"} 1` + "\n",
+		`source_name="web \"1\""`,
+		"clyde_flow_series 2\n",
+		"clyde_flow_sums_folded 0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestExporter_MaxSeries(t *testing.T) {
+	// Out of order on purpose: the oldest summaries are admitted first
+	e := NewExporter(fakeSource{sum(3, "c", "Allow"), sum(1, "a", "Allow"), sum(2, "b", "Allow"), sum(4, "a", "Allow")})
+	e.MaxSeries = 1
+	out := scrape(t, e)
+
+	other := `cluster="__other__",source_namespace="__other__",source_name="__other__",dest_namespace="__other__",dest_name="__other__",protocol="__other__",dest_port="__other__",action="__other__"`
+	for _, want := range []string{
+		`clyde_flow_packets_total{cluster="kind-a",source_namespace="shop",source_name="a",dest_namespace="db",dest_name="postgres",protocol="tcp",dest_port="5432",action="Allow",reporter="src",direction="out"} 20` + "\n",
+		"clyde_flow_packets_total{" + other + `,reporter="src",direction="out"} 20` + "\n",
+		"clyde_flow_series 2\n",
+		"clyde_flow_sums_folded 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `source_name="b"`) || strings.Contains(out, `source_name="c"`) {
+		t.Errorf("expected b and c to be folded, got:\n%s", out)
+	}
+}
+
+// ingestingSource is a fakeSource that counts the flows it ingests.
+type ingestingSource struct {
+	fakeSource
+	stats flowdata.IngestStats
+}
+
+func (s ingestingSource) IngestStats() flowdata.IngestStats {
+	return s.stats
+}
+
+func TestExporter_IngestStats(t *testing.T) {
+	if out := scrape(t, NewExporter(fakeSource{})); strings.Contains(out, "clyde_ingest") {
+		t.Errorf("expected no ingest metrics from a source without them, got:\n%s", out)
+	}
+
+	out := scrape(t, NewExporter(ingestingSource{stats: flowdata.IngestStats{Queued: 120, Committed: 100, Dropped: 7, Pending: 13}}))
+	for _, want := range []string{
+		"# TYPE clyde_ingest_flows_total counter\n",
+		`clyde_ingest_flows_total{state="queued"} 120` + "\n",
+		`clyde_ingest_flows_total{state="committed"} 100` + "\n",
+		`clyde_ingest_flows_total{state="dropped"} 7` + "\n",
+		"clyde_ingest_flows_pending 13\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+// totalingSource is a fakeSource that keeps totals retention doesn't lower.
+type totalingSource struct {
+	fakeSource
+	totals []*flowdata.FlowSum
+}
+
+func (s totalingSource) FlowTotals() []*flowdata.FlowSum {
+	return s.totals
+}
+
+func TestExporter_Totals(t *testing.T) {
+	// Retention left cart a flow, and deleted web
+	cart := sum(1, "cart", "Allow")
+	cart.SourceReports, cart.SourcePacketsOut = 1, 5
+	totals := []*flowdata.FlowSum{sum(1, "cart", "Allow"), sum(2, "web", "Allow")}
+	out := scrape(t, NewExporter(totalingSource{fakeSource: fakeSource{cart}, totals: totals}))
+
+	labels := `cluster="kind-a",source_namespace="shop",source_name="%s",dest_namespace="db",dest_name="postgres",protocol="tcp",dest_port="5432",action="Allow"`
+	for _, want := range []string{
+		"# TYPE clyde_flow_packets_total counter\n",
+		// The counters don't go down with the summaries
+		"clyde_flow_reports_total{" + fmt.Sprintf(labels, "cart") + `,reporter="src"} 2` + "\n",
+		"clyde_flow_packets_total{" + fmt.Sprintf(labels, "cart") + `,reporter="src",direction="out"} 10` + "\n",
+		"clyde_flow_packet_rate{" + fmt.Sprintf(labels, "cart") + `,reporter="src",direction="out"} 0.5` + "\n",
+		"clyde_flow_packets_total{" + fmt.Sprintf(labels, "web") + `,reporter="src",direction="out"} 10` + "\n",
+		"clyde_flow_series 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package printer
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// FieldColumns returns a column for every exported field of the struct type
+// T (or the struct T points to), headed by the field's json name. Embedded
+// structs are flattened and nested values are rendered as compact JSON, which
+// makes it suitable for csv output of the flow types.
+func FieldColumns[T any]() []Column[T] {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	var cols []Column[T]
+	for _, f := range structFields(typ, nil) {
+		index := f.index
+		cols = append(cols, Column[T]{
+			Header: f.name,
+			Width:  len(f.name),
+			Value: func(rec T) string {
+				v := reflect.ValueOf(rec)
+				if v.Kind() == reflect.Pointer {
+					if v.IsNil() {
+						return ""
+					}
+					v = v.Elem()
+				}
+				fv, err := v.FieldByIndexErr(index)
+				if err != nil {
+					return ""
+				}
+				return formatValue(fv)
+			},
+		})
+	}
+	return cols
+}
+
+type field struct {
+	name  string
+	index []int
+}
+
+func structFields(typ reflect.Type, parent []int) []field {
+	var fields []field
+	for i := range typ.NumField() {
+		sf := typ.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		index := append(append([]int{}, parent...), i)
+		ft := sf.Type
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields = append(fields, structFields(ft, index)...)
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{name: name, index: index})
+	}
+	return fields
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprintf("%v", v.Interface())
+	}
+	return string(b)
+}
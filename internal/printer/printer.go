@@ -0,0 +1,257 @@
+// Package printer renders records to a writer in the output formats supported
+// by the headless (non-TUI) commands.
+package printer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+type Format string
+
+const (
+	FormatTable      Format = "table"
+	FormatWide       Format = "wide"
+	FormatJSON       Format = "json"
+	FormatNDJSON     Format = "ndjson"
+	FormatYAML       Format = "yaml"
+	FormatCSV        Format = "csv"
+	FormatGoTemplate Format = "go-template"
+)
+
+// ParseFormat parses an -o/--output flag value. An empty value is the table
+// format. A Go template can be given inline as 'go-template=<template>' or
+// with 'go-template' and a separate template argument.
+func ParseFormat(output, tmpl string, allowed ...Format) (Format, string, error) {
+	f := Format(output)
+	if output == "" {
+		f = FormatTable
+	}
+	if name, inline, ok := strings.Cut(output, "="); ok {
+		f = Format(name)
+		tmpl = inline
+	}
+	if len(allowed) > 0 && !slices.Contains(allowed, f) {
+		names := make([]string, len(allowed))
+		for i, a := range allowed {
+			names[i] = string(a)
+		}
+		return "", "", fmt.Errorf("invalid output format %q, must be one of: %s", output, strings.Join(names, ", "))
+	}
+	if f == FormatGoTemplate && tmpl == "" {
+		return "", "", fmt.Errorf("output format %q requires a template", FormatGoTemplate)
+	}
+	return f, tmpl, nil
+}
+
+// Column describes one column of the table, wide and csv formats.
+type Column[T any] struct {
+	Header string
+	Width  int
+	Wide   bool
+	Value  func(T) string
+}
+
+// Printer writes records of type T. When Stream is set every record is
+// written out as soon as it is printed, which suits commands that follow a
+// live feed; otherwise list formats are buffered until Flush.
+type Printer[T any] struct {
+	Stream  bool
+	w       io.Writer
+	format  Format
+	tmpl    *template.Template
+	columns []Column[T]
+	tw      *tabwriter.Writer
+	cw      *csv.Writer
+	records []T
+	header  bool
+}
+
+func New[T any](w io.Writer, format Format, tmpl string, columns []Column[T]) (*Printer[T], error) {
+	p := &Printer[T]{w: w, format: format}
+	switch format {
+	case FormatTable, FormatWide, FormatCSV:
+		for _, c := range columns {
+			if c.Wide && format == FormatTable {
+				continue
+			}
+			p.columns = append(p.columns, c)
+		}
+	case FormatGoTemplate:
+		t, err := template.New("output").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("parse template: %w", err)
+		}
+		p.tmpl = t
+	case FormatJSON, FormatNDJSON, FormatYAML:
+	default:
+		return nil, fmt.Errorf("unsupported output format %q", format)
+	}
+	return p, nil
+}
+
+func (p *Printer[T]) Print(rec T) error {
+	switch p.format {
+	case FormatTable, FormatWide:
+		return p.printRow(rec)
+	case FormatCSV:
+		return p.printCSV(rec)
+	case FormatGoTemplate:
+		var buf bytes.Buffer
+		if err := p.tmpl.Execute(&buf, rec); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		_, err := p.w.Write(buf.Bytes())
+		return err
+	case FormatNDJSON:
+		return json.NewEncoder(p.w).Encode(rec)
+	case FormatJSON:
+		if p.Stream {
+			return json.NewEncoder(p.w).Encode(rec)
+		}
+		p.records = append(p.records, rec)
+	case FormatYAML:
+		if p.Stream {
+			return p.writeYAML("---\n", rec)
+		}
+		p.records = append(p.records, rec)
+	}
+	return nil
+}
+
+// Flush writes any buffered output. It must be called once all records
+// have been printed.
+func (p *Printer[T]) Flush() error {
+	switch p.format {
+	case FormatTable, FormatWide:
+		if p.tw != nil {
+			return p.tw.Flush()
+		}
+	case FormatCSV:
+		if p.cw != nil {
+			p.cw.Flush()
+			return p.cw.Error()
+		}
+	case FormatJSON:
+		if p.Stream {
+			return nil
+		}
+		recs := p.records
+		if recs == nil {
+			recs = []T{}
+		}
+		b, err := json.MarshalIndent(recs, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	case FormatYAML:
+		if p.Stream {
+			return nil
+		}
+		recs := p.records
+		if recs == nil {
+			recs = []T{}
+		}
+		return p.writeYAML("", recs)
+	}
+	return nil
+}
+
+func (p *Printer[T]) writeYAML(prefix string, v any) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s%s", prefix, b)
+	return err
+}
+
+func (p *Printer[T]) printRow(rec T) error {
+	if p.Stream {
+		// A tabwriter can only align what it has buffered, so streamed rows
+		// are padded to the fixed column widths instead.
+		if !p.header {
+			p.header = true
+			if _, err := fmt.Fprintln(p.w, p.padded(func(c Column[T]) string { return c.Header })); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintln(p.w, p.padded(func(c Column[T]) string { return c.Value(rec) }))
+		return err
+	}
+	if p.tw == nil {
+		p.tw = tabwriter.NewWriter(p.w, 0, 8, 2, ' ', 0)
+	}
+	if !p.header {
+		p.header = true
+		if _, err := fmt.Fprintln(p.tw, p.joined(func(c Column[T]) string { return c.Header })); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(p.tw, p.joined(func(c Column[T]) string { return c.Value(rec) }))
+	return err
+}
+
+func (p *Printer[T]) printCSV(rec T) error {
+	if p.cw == nil {
+		p.cw = csv.NewWriter(p.w)
+	}
+	if !p.header {
+		p.header = true
+		hdr := make([]string, len(p.columns))
+		for i, c := range p.columns {
+			hdr[i] = c.Header
+		}
+		if err := p.cw.Write(hdr); err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(p.columns))
+	for i, c := range p.columns {
+		row[i] = c.Value(rec)
+	}
+	if err := p.cw.Write(row); err != nil {
+		return err
+	}
+	if p.Stream {
+		p.cw.Flush()
+		return p.cw.Error()
+	}
+	return nil
+}
+
+func (p *Printer[T]) joined(cell func(Column[T]) string) string {
+	cells := make([]string, len(p.columns))
+	for i, c := range p.columns {
+		cells[i] = cell(c)
+	}
+	return strings.Join(cells, "\t")
+}
+
+func (p *Printer[T]) padded(cell func(Column[T]) string) string {
+	var sb strings.Builder
+	for i, c := range p.columns {
+		v := cell(c)
+		if i == len(p.columns)-1 {
+			sb.WriteString(v)
+			break
+		}
+		sb.WriteString(v)
+		sb.WriteString(strings.Repeat(" ", max(c.Width-len([]rune(v)), 0)+2))
+	}
+	return sb.String()
+}
@@ -0,0 +1,207 @@
+package printer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type rec struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+var testColumns = []Column[rec]{
+	{Header: "NAME", Width: 6, Value: func(r rec) string { return r.Name }},
+	{Header: "COUNT", Width: 5, Wide: true, Value: func(r rec) string { return strings.Repeat("#", r.Count) }},
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		tmpl     string
+		allowed  []Format
+		want     Format
+		wantTmpl string
+		wantErr  bool
+	}{
+		{name: "empty defaults to table", output: "", want: FormatTable},
+		{name: "wide", output: "wide", want: FormatWide},
+		{name: "inline template", output: "go-template={{.Name}}", want: FormatGoTemplate, wantTmpl: "{{.Name}}"},
+		{name: "separate template", output: "go-template", tmpl: "{{.Count}}", want: FormatGoTemplate, wantTmpl: "{{.Count}}"},
+		{name: "template missing", output: "go-template", wantErr: true},
+		{name: "not allowed", output: "csv", allowed: []Format{FormatTable, FormatJSON}, wantErr: true},
+		{name: "allowed", output: "json", allowed: []Format{FormatTable, FormatJSON}, want: FormatJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, tmpl, err := ParseFormat(tt.output, tt.tmpl, tt.allowed...)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got format %q", f)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if f != tt.want {
+				t.Errorf("expected format %q, got %q", tt.want, f)
+			}
+			if tmpl != tt.wantTmpl {
+				t.Errorf("expected template %q, got %q", tt.wantTmpl, tmpl)
+			}
+		})
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	recs := []rec{{Name: "a", Count: 1}, {Name: "bbbbbbbb", Count: 2}}
+	tests := []struct {
+		name   string
+		format Format
+		tmpl   string
+		stream bool
+		want   string
+	}{
+		{
+			name:   "table hides wide columns",
+			format: FormatTable,
+			want:   "NAME\na\nbbbbbbbb\n",
+		},
+		{
+			name:   "wide aligns columns",
+			format: FormatWide,
+			want:   "NAME      COUNT\na         #\nbbbbbbbb  ##\n",
+		},
+		{
+			name:   "streamed wide pads to column width",
+			format: FormatWide,
+			stream: true,
+			want:   "NAME    COUNT\na       #\nbbbbbbbb  ##\n",
+		},
+		{
+			name:   "json array",
+			format: FormatJSON,
+			want:   "[\n  {\n    \"name\": \"a\",\n    \"count\": 1\n  },\n  {\n    \"name\": \"bbbbbbbb\",\n    \"count\": 2\n  }\n]\n",
+		},
+		{
+			name:   "streamed json is one object per line",
+			format: FormatJSON,
+			stream: true,
+			want:   "{\"name\":\"a\",\"count\":1}\n{\"name\":\"bbbbbbbb\",\"count\":2}\n",
+		},
+		{
+			name:   "ndjson",
+			format: FormatNDJSON,
+			want:   "{\"name\":\"a\",\"count\":1}\n{\"name\":\"bbbbbbbb\",\"count\":2}\n",
+		},
+		{
+			name:   "yaml list",
+			format: FormatYAML,
+			want:   "- count: 1\n  name: a\n- count: 2\n  name: bbbbbbbb\n",
+		},
+		{
+			name:   "csv",
+			format: FormatCSV,
+			want:   "NAME,COUNT\na,#\nbbbbbbbb,##\n",
+		},
+		{
+			name:   "go template adds trailing newline",
+			format: FormatGoTemplate,
+			tmpl:   "{{.Name}}={{.Count}}",
+			want:   "a=1\nbbbbbbbb=2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p, err := New(&buf, tt.format, tt.tmpl, testColumns)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			p.Stream = tt.stream
+			for _, r := range recs {
+				if err := p.Print(r); err != nil {
+					t.Fatalf("expected no error printing, got %v", err)
+				}
+			}
+			if err := p.Flush(); err != nil {
+				t.Fatalf("expected no error flushing, got %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("expected output:\n%q\ngot:\n%q", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestPrinter_EmptyJSON(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := New(&buf, FormatJSON, "", testColumns)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatalf("expected no error flushing, got %v", err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("expected empty json array, got %q", buf.String())
+	}
+}
+
+func TestNew_InvalidTemplate(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, FormatGoTemplate, "{{.Name", testColumns); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+type inner struct {
+	Tags []string `json:"tags"`
+}
+
+type outer struct {
+	ID    int       `json:"id"`
+	When  time.Time `json:"when"`
+	Rate  float64   `json:"rate"`
+	Skip  string    `json:"-"`
+	inner `json:"-"`
+	Embedded
+	Nested inner `json:"nested"`
+}
+
+type Embedded struct {
+	Name string `json:"name,omitempty"`
+}
+
+func TestFieldColumns(t *testing.T) {
+	when := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	cols := FieldColumns[*outer]()
+	headers := make([]string, len(cols))
+	for i, c := range cols {
+		headers[i] = c.Header
+	}
+	wantHeaders := []string{"id", "when", "rate", "name", "nested"}
+	if strings.Join(headers, ",") != strings.Join(wantHeaders, ",") {
+		t.Fatalf("expected headers %v, got %v", wantHeaders, headers)
+	}
+
+	rec := &outer{ID: 7, When: when, Rate: 1.5, Embedded: Embedded{Name: "x"}, Nested: inner{Tags: []string{"a"}}}
+	want := []string{"7", "2025-06-01T12:00:00Z", "1.5", "x", `{"tags":["a"]}`}
+	for i, c := range cols {
+		if got := c.Value(rec); got != want[i] {
+			t.Errorf("expected %s = %q, got %q", c.Header, want[i], got)
+		}
+	}
+
+	if got := cols[0].Value(nil); got != "" {
+		t.Errorf("expected empty value for nil record, got %q", got)
+	}
+	if got := cols[1].Value(&outer{}); got != "" {
+		t.Errorf("expected empty value for zero time, got %q", got)
+	}
+}
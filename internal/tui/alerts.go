@@ -0,0 +1,64 @@
+package tui
+
+import (
+	"fmt"
+
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+
+	"github.com/doucol/clyde/internal/alert"
+)
+
+// alertSource is what the app reads the fired alerts from, an alert.Engine.
+type alertSource interface {
+	Recent() []alert.Alert
+	Total() int
+}
+
+type alertsModel struct {
+	width  int
+	height int
+	alerts []alert.Alert
+}
+
+func newAlertsModel(alerts []alert.Alert) alertsModel {
+	return alertsModel{alerts: alerts}
+}
+
+func (m alertsModel) setSize(w, h int) alertsModel {
+	m.width = w
+	m.height = h
+	return m
+}
+
+func (m alertsModel) Update(msg tea.Msg) (alertsModel, bool) {
+	if msg, ok := msg.(tea.KeyPressMsg); ok {
+		switch msg.String() {
+		case "esc", "a":
+			return m, true
+		}
+	}
+	return m, false
+}
+
+func (m alertsModel) View() string {
+	lines := []string{}
+	if len(m.alerts) == 0 {
+		lines = append(lines, styleStatusVal.Render("No alerts yet"))
+	}
+	// Newest first, as many as fit
+	room := max(m.height-8, 1)
+	for i := len(m.alerts) - 1; i >= 0 && len(lines) < room; i-- {
+		a := m.alerts[i]
+		line := lipgloss.JoinHorizontal(lipgloss.Top,
+			styleHelp.Render(a.Time.Format("15:04:05")+"  "),
+			styleMenuKey.Render(padRight(a.Rule, 16)),
+			styleStatusVal.Render(a.Message),
+		)
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", styleHelp.Render("esc or a to close"))
+	body := lipgloss.JoinVertical(lipgloss.Left, lines...)
+	padded := lipgloss.NewStyle().Background(colorBg).Padding(1, 2).Render(body)
+	return renderTitledBorder(fmt.Sprintf("Alerts — %d most recent", len(m.alerts)), padded, lipgloss.Width(padded))
+}
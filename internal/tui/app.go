@@ -4,12 +4,15 @@ package tui
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
 
 	"charm.land/bubbles/v2/key"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
 
+	"github.com/doucol/clyde/internal/catcher"
 	"github.com/doucol/clyde/internal/cmdctx"
 	"github.com/doucol/clyde/internal/flowcache"
 	"github.com/doucol/clyde/internal/flowdata"
@@ -39,6 +42,7 @@ const (
 	overlayNone overlayKind = iota
 	overlayHelp
 	overlayFilter
+	overlayAlerts
 )
 
 type FlowApp struct {
@@ -49,6 +53,17 @@ type FlowApp struct {
 	pages   pageRegistry
 	prog    *tea.Program
 	exitErr error
+	replay  string
+
+	alerts      alertSource
+	contexts    []string
+	connections connectionSource
+}
+
+// connectionSource is what the app reads the state of the flow streams
+// from, a catcher.Statuses.
+type connectionSource interface {
+	Connections() []catcher.Status
 }
 
 type pageRegistry struct {
@@ -83,6 +98,9 @@ type appModel struct {
 	filter  filterModel
 	loading bool // goldmane check in flight
 
+	alerts     alertsModel
+	alertsSeen int // alerts fired when the alerts overlay was last opened
+
 	ctx context.Context
 	cc  *cmdctx.CmdCtx
 }
@@ -104,6 +122,11 @@ func (fa *FlowApp) newAppModel(ctx context.Context) appModel {
 		ctx:        ctx,
 		cc:         cc,
 	}
+	if fa.fixedSource() {
+		m.page = pageSummaryTotalsName
+		m.home = m.home.blur()
+		m.totals = m.totals.focus()
+	}
 	return m
 }
 
@@ -123,6 +146,9 @@ type autoSelectMsg struct{ name string }
 
 func (m appModel) Init() tea.Cmd {
 	cmds := []tea.Cmd{tickCmd(), m.home.Init()}
+	if m.fa.fixedSource() {
+		return tea.Batch(append(cmds, fetchSumTotals(m.fa.fc))...)
+	}
 	if sel := m.initialAutoSelect(); sel != "" {
 		name := sel
 		cmds = append(cmds, func() tea.Msg { return autoSelectMsg{name: name} })
@@ -139,6 +165,7 @@ func (m appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case tickMsg:
+		m = m.updateAlertStatus().updateConnectionStatus().updateIngestStatus()
 		return m, tea.Batch(tickCmd(), m.refreshCmd())
 
 	case flowSumTotalsMsg, flowSumRatesMsg:
@@ -157,6 +184,12 @@ func (m appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case clusterReadyMsg:
 		m.loading = false
+		if !msg.info.CalicoInstalled {
+			// Stay home so another context can be picked
+			m.home.notice = cniNotice(m.home.selected, msg.info)
+			m.home.selected = ""
+			return m, nil
+		}
 		if !msg.info.WhiskerAvailable {
 			m.fa.setExitErr(ErrGoldmaneNotAvailable)
 			return m, tea.Quit
@@ -181,6 +214,13 @@ func (m appModel) updateOverlay(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
 			m.overlay = overlayNone
 		}
 		return m, nil
+	case overlayAlerts:
+		var close bool
+		m.alerts, close = m.alerts.Update(msg)
+		if close {
+			m.overlay = overlayNone
+		}
+		return m, nil
 	case overlayFilter:
 		var result filterResult
 		var cmd tea.Cmd
@@ -228,6 +268,14 @@ func (m appModel) updatePage(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
 		m.help = m.help.setSize(m.width, m.height)
 		m.overlay = overlayHelp
 		return m, nil
+	case key.Matches(msg, keys.Alerts):
+		if m.fa.alerts == nil {
+			return m, nil
+		}
+		m.alertsSeen = m.fa.alerts.Total()
+		m.alerts = newAlertsModel(m.fa.alerts.Recent()).setSize(m.width, m.height)
+		m.overlay = overlayAlerts
+		return m.updateAlertStatus(), nil
 	case key.Matches(msg, keys.Filter):
 		if m.page == pageHomeName {
 			return m, nil
@@ -236,7 +284,7 @@ func (m appModel) updatePage(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
 		m.overlay = overlayFilter
 		return m, nil
 	case key.Matches(msg, keys.Home):
-		if m.page != pageHomeName {
+		if m.page != pageHomeName && !m.fa.fixedSource() {
 			return m.gotoPage(pageHomeName)
 		}
 	case key.Matches(msg, keys.Rates):
@@ -308,6 +356,7 @@ func (m appModel) onContextSelected(name string, extra tea.Cmd) (tea.Model, tea.
 	}
 	m.cc.SetContext(name)
 	m.home.selected = name
+	m.home.notice = ""
 	m.loading = true
 	return m, tea.Batch(extra, checkClusterReadyCmd(m.ctx))
 }
@@ -315,6 +364,11 @@ func (m appModel) onContextSelected(name string, extra tea.Cmd) (tea.Model, tea.
 func (m appModel) handleBack() (tea.Model, tea.Cmd) {
 	switch m.page {
 	case pageSummaryTotalsName, pageSummaryRatesName:
+		if m.fa.fixedSource() {
+			// There is no context to go back to when replaying a capture file
+			// or watching several contexts
+			return m, nil
+		}
 		return m.gotoPage(pageHomeName)
 	case pageSumDetailName:
 		target := m.fa.fas.lastHomePage
@@ -377,6 +431,71 @@ func (m appModel) propagateSize() appModel {
 	m.flowDetail = m.flowDetail.setSize(m.width, m.height)
 	m.help = m.help.setSize(m.width, m.height)
 	m.filter = m.filter.setSize(m.width, m.height)
+	m.alerts = m.alerts.setSize(m.width, m.height)
+	return m
+}
+
+// updateAlertStatus shows how many alerts fired, and how many of them are
+// new since the alerts overlay was last opened, in the summary status lines.
+func (m appModel) updateAlertStatus() appModel {
+	if m.fa.alerts == nil {
+		return m
+	}
+	text := ""
+	if total := m.fa.alerts.Total(); total > m.alertsSeen {
+		text = fmt.Sprintf("alerts: %d new (a)", total-m.alertsSeen)
+	} else if total > 0 {
+		text = fmt.Sprintf("alerts: %d", total)
+	}
+	m.totals.alerts = text
+	m.rates.alerts = text
+	return m
+}
+
+// updateIngestStatus shows when writing the flows to the store falls behind:
+// more than a batch of them is queued, or some were dropped.
+func (m appModel) updateIngestStatus() appModel {
+	if m.fa.fds == nil {
+		return m
+	}
+	stats := m.fa.fds.IngestStats()
+	var parts []string
+	if stats.Pending > uint64(m.fa.fds.Ingest.BatchSize) {
+		parts = append(parts, fmt.Sprintf("%d queued", stats.Pending))
+	}
+	if stats.Dropped > 0 {
+		parts = append(parts, fmt.Sprintf("%d dropped", stats.Dropped))
+	}
+	text := ""
+	if len(parts) > 0 {
+		text = "flows: " + strings.Join(parts, ", ")
+	}
+	m.totals.ingest = text
+	m.rates.ingest = text
+	return m
+}
+
+// updateConnectionStatus shows the state of the flow streams in the summary
+// status lines, unless they are all streaming, and the pods they are
+// port-forwarded to.
+func (m appModel) updateConnectionStatus() appModel {
+	if m.fa.connections == nil {
+		return m
+	}
+	var parts []string
+	for _, st := range m.fa.connections.Connections() {
+		if st.State == catcher.StateStreaming && st.Pod == "" {
+			continue
+		}
+		if len(m.fa.contexts) > 0 {
+			parts = append(parts, st.Cluster+": "+st.String())
+		} else {
+			parts = append(parts, st.String())
+		}
+	}
+	text := strings.Join(parts, ", ")
+	m.totals.connection = text
+	m.rates.connection = text
 	return m
 }
 
@@ -420,6 +539,8 @@ func (m appModel) View() tea.View {
 		overlay = m.help.View()
 	case overlayFilter:
 		overlay = m.filter.View()
+	case overlayAlerts:
+		overlay = m.alerts.View()
 	}
 
 	content := body
@@ -440,6 +561,34 @@ func (m appModel) View() tea.View {
 	return v
 }
 
+// SetReplay puts the app in offline mode for the given capture file: there
+// is no cluster to pick, so it opens straight on the summary totals.
+func (fa *FlowApp) SetReplay(file string) {
+	fa.replay = file
+}
+
+// SetContexts puts the app in multi-context mode: the contexts are all
+// watched at once, so it opens straight on the summary totals too.
+func (fa *FlowApp) SetContexts(names []string) {
+	fa.contexts = names
+}
+
+// fixedSource reports whether the flows come from a capture file or several
+// contexts rather than the context picked on the home page.
+func (fa *FlowApp) fixedSource() bool {
+	return fa.replay != "" || len(fa.contexts) > 0
+}
+
+// SetConnections shows the state of the flow streams in the app.
+func (fa *FlowApp) SetConnections(connections connectionSource) {
+	fa.connections = connections
+}
+
+// SetAlerts shows the alerts fired by the source in the app.
+func (fa *FlowApp) SetAlerts(alerts alertSource) {
+	fa.alerts = alerts
+}
+
 func (fa *FlowApp) setExitErr(err error) {
 	fa.mu.Lock()
 	defer fa.mu.Unlock()
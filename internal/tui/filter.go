@@ -21,6 +21,7 @@ const (
 	fieldNamespace
 	fieldName
 	fieldLabel
+	fieldCluster
 	fieldDateFrom
 	fieldDateTo
 	buttonSave
@@ -70,6 +71,7 @@ func newFilterModel() filterModel {
 	inputs[fieldNamespace].SetValue(current.Namespace)
 	inputs[fieldName].SetValue(current.Name)
 	inputs[fieldLabel].SetValue(current.Label)
+	inputs[fieldCluster].SetValue(current.Cluster)
 	inputs[fieldDateFrom].SetValue(tf(current.DateFrom))
 	inputs[fieldDateFrom].SetWidth(24)
 	inputs[fieldDateFrom].Placeholder = time.RFC3339
@@ -179,6 +181,7 @@ func (m filterModel) toAttributes() (flowdata.FilterAttributes, error) {
 	fa.Namespace = strings.TrimSpace(m.inputs[fieldNamespace].Value())
 	fa.Name = strings.TrimSpace(m.inputs[fieldName].Value())
 	fa.Label = strings.TrimSpace(m.inputs[fieldLabel].Value())
+	fa.Cluster = strings.TrimSpace(m.inputs[fieldCluster].Value())
 	if s := strings.TrimSpace(m.inputs[fieldDateFrom].Value()); s != "" {
 		t, err := time.Parse(time.RFC3339, s)
 		if err != nil {
@@ -203,6 +206,7 @@ func (m filterModel) View() string {
 	rows = append(rows, m.renderField("Namespace:", m.inputs[fieldNamespace].View(), m.focusIdx == fieldNamespace))
 	rows = append(rows, m.renderField("Name:", m.inputs[fieldName].View(), m.focusIdx == fieldName))
 	rows = append(rows, m.renderField("Label:", m.inputs[fieldLabel].View(), m.focusIdx == fieldLabel))
+	rows = append(rows, m.renderField("Cluster:", m.inputs[fieldCluster].View(), m.focusIdx == fieldCluster))
 	rows = append(rows, m.renderField("Date From:", m.inputs[fieldDateFrom].View(), m.focusIdx == fieldDateFrom))
 	rows = append(rows, m.renderField("Date To:", m.inputs[fieldDateTo].View(), m.focusIdx == fieldDateTo))
 	rows = append(rows, "")
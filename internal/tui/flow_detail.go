@@ -116,6 +116,7 @@ func (m flowDetailModel) renderHeader() string {
 		return ""
 	}
 	return infoTable([][]string{
+		{"Cluster", fd.Cluster},
 		{"Source", fmt.Sprintf("%s / %s", fd.SourceNamespace, fd.SourceName)},
 		{"Destination", fmt.Sprintf("%s / %s", fd.DestNamespace, fd.DestName)},
 		{"Reporter", fd.Reporter},
@@ -2,6 +2,8 @@ package tui
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"charm.land/bubbles/v2/key"
 	tea "charm.land/bubbletea/v2"
@@ -18,6 +20,9 @@ type homeModel struct {
 	height   int
 	focused  bool
 	selected string
+
+	// notice explains why the last selected context can't be watched
+	notice string
 }
 
 func newHomeModel(kc *util.KubeconfigInfo, loadErr error) homeModel {
@@ -81,6 +86,30 @@ func (m homeModel) Update(msg tea.Msg) (homeModel, bool, tea.Cmd) {
 	return m, false, nil
 }
 
+// cniNotice describes the CNI found on a context that isn't running Calico,
+// along with the evidence it was detected from.
+func cniNotice(name string, info util.ClusterNetworkingInfo) string {
+	if info.CNI == nil {
+		return fmt.Sprintf("Calico is not installed on %s and the CNI could not be detected.", name)
+	}
+	cni := info.CNI.DisplayName()
+	if info.CNI.Version != "" {
+		cni += " " + info.CNI.Version
+	}
+	lines := []string{fmt.Sprintf("%s is running %s, not Calico.", name, cni)}
+	var evidence []string
+	for k, v := range info.CNI.Details {
+		if k != "source" {
+			evidence = append(evidence, k+"="+v)
+		}
+	}
+	sort.Strings(evidence)
+	if src := info.CNI.Details["source"]; src != "" {
+		lines = append(lines, fmt.Sprintf("Detected from %s: %s", src, strings.Join(evidence, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (m homeModel) viewLoading() string {
 	title := styleTitle.Render("Clyde — Checking cluster")
 	body := styleStatusVal.Render("Context: " + m.selected)
@@ -137,6 +166,10 @@ func (m homeModel) View() string {
 		body = lipgloss.JoinVertical(lipgloss.Left, lines...)
 	}
 
+	if m.notice != "" {
+		body = lipgloss.JoinVertical(lipgloss.Left, styleError.Render(m.notice), "", body)
+	}
+
 	footer := styleHelp.Render("↑/↓ to move  ·  enter to select  ·  q to quit")
 
 	parts := []string{title, ""}
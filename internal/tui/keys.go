@@ -7,6 +7,7 @@ type keyMap struct {
 	Back        key.Binding
 	Help        key.Binding
 	Filter      key.Binding
+	Alerts      key.Binding
 	Home        key.Binding
 	Rates       key.Binding
 	Totals      key.Binding
@@ -42,6 +43,10 @@ func newKeyMap() keyMap {
 			key.WithKeys("/"),
 			key.WithHelp("/", "filter"),
 		),
+		Alerts: key.NewBinding(
+			key.WithKeys("a"),
+			key.WithHelp("a", "alerts"),
+		),
 		Home: key.NewBinding(
 			key.WithKeys("h"),
 			key.WithHelp("h", "home"),
@@ -99,5 +104,6 @@ var helpEntries = []struct {
 	{"B", "Sort by Dest Byte Rate (rates only)"},
 	{"n", "Sort by Key (totals or rates)"},
 	{"/", "Open filter dialog"},
+	{"a", "Show the latest alerts"},
 	{"?", "Show this help dialog"},
 }
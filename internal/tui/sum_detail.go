@@ -240,6 +240,7 @@ func (m sumDetailModel) renderHeader() string {
 		return styleHelp.Render("(no summary selected)")
 	}
 	return infoTable([][]string{
+		{"Cluster", fs.Cluster},
 		{"Source Namespace", fs.SourceNamespace},
 		{"Source Name", fs.SourceName},
 		{"Destination Namespace", fs.DestNamespace},
@@ -28,6 +28,10 @@ type summaryModel struct {
 	width   int
 	height  int
 	focused bool
+
+	alerts     string // alert status, set by the app
+	connection string // flow stream status, set by the app
+	ingest     string // flow ingestion status, set by the app
 }
 
 type variantProvider interface {
@@ -49,6 +53,7 @@ func (totalsVariant) kind() summaryVariant { return variantTotals }
 func (totalsVariant) title() string        { return "Calico Flow Summary Totals" }
 func (totalsVariant) columns() []table.Column {
 	return []table.Column{
+		{Title: "CLUSTER", Width: 14},
 		{Title: "SRC NAMESPACE / NAME", Width: 28},
 		{Title: "DST NAMESPACE / NAME", Width: 28},
 		{Title: "PROTO:PORT", Width: 12},
@@ -63,6 +68,7 @@ func (totalsVariant) columns() []table.Column {
 
 func (totalsVariant) toRow(fs *flowdata.FlowSum) table.Row {
 	return table.Row{
+		fs.Cluster,
 		fmt.Sprintf("%s / %s", fs.SourceNamespace, fs.SourceName),
 		fmt.Sprintf("%s / %s", fs.DestNamespace, fs.DestName),
 		fmt.Sprintf("%s:%d", fs.Protocol, fs.DestPort),
@@ -97,6 +103,7 @@ func (ratesVariant) kind() summaryVariant { return variantRates }
 func (ratesVariant) title() string        { return "Calico Flow Summary Rates" }
 func (ratesVariant) columns() []table.Column {
 	return []table.Column{
+		{Title: "CLUSTER", Width: 14},
 		{Title: "SRC NAMESPACE / NAME", Width: 28},
 		{Title: "DST NAMESPACE / NAME", Width: 28},
 		{Title: "PROTO:PORT", Width: 12},
@@ -110,6 +117,7 @@ func (ratesVariant) columns() []table.Column {
 
 func (ratesVariant) toRow(fs *flowdata.FlowSum) table.Row {
 	return table.Row{
+		fs.Cluster,
 		fmt.Sprintf("%s / %s", fs.SourceNamespace, fs.SourceName),
 		fmt.Sprintf("%s / %s", fs.DestNamespace, fs.DestName),
 		fmt.Sprintf("%s:%d", fs.Protocol, fs.DestPort),
@@ -436,7 +444,7 @@ func (m summaryModel) statusLine() string {
 		filterText = "filter: on"
 	}
 	count := fmt.Sprintf("rows: %d", len(m.rows))
-	return styleHelp.Render(joinStatus(count, sortText, filterText))
+	return styleHelp.Render(joinStatus(count, sortText, filterText, m.alerts, m.connection, m.ingest))
 }
 
 func ascDesc(asc bool) string {
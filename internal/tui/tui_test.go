@@ -1,10 +1,20 @@
 package tui
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 
+	tea "charm.land/bubbletea/v2"
+
+	"github.com/doucol/clyde/internal/alert"
+	"github.com/doucol/clyde/internal/catcher"
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/cnitype"
 	"github.com/doucol/clyde/internal/flowcache"
 	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/util"
 )
 
 func TestFlowAppState_Reset(t *testing.T) {
@@ -305,3 +315,145 @@ func TestFlowAppState_ChainedOperations(t *testing.T) {
 		t.Error("expected all values to be 0 after reset")
 	}
 }
+
+func TestFlowApp_Replay(t *testing.T) {
+	fa := NewFlowApp(nil, nil)
+	fa.SetReplay("capture.ndjson")
+	ctx := cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "").ToContext(context.Background())
+
+	m := fa.newAppModel(ctx)
+	if m.page != pageSummaryTotalsName {
+		t.Fatalf("expected replay to open on %s, got %s", pageSummaryTotalsName, m.page)
+	}
+
+	for _, k := range []tea.KeyPressMsg{{Code: tea.KeyEscape}, {Code: 'h', Text: "h"}} {
+		next, _ := m.updatePage(k)
+		if got := next.(appModel).page; got != pageSummaryTotalsName {
+			t.Errorf("expected %q to stay on %s while replaying, got %s", k.String(), pageSummaryTotalsName, got)
+		}
+	}
+}
+
+func TestFlowApp_ClusterNotCalico(t *testing.T) {
+	fa := NewFlowApp(nil, nil)
+	ctx := cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "").ToContext(context.Background())
+	m := fa.newAppModel(ctx)
+	m.home.selected = "kind-cilium"
+	m.loading = true
+
+	cni := &cnitype.CNIInfo{Name: "cilium", Version: "v1.16.1", Details: map[string]string{
+		"source": "daemonset", "daemonset": "cilium", "namespace": "kube-system",
+	}}
+	next, cmd := m.Update(clusterReadyMsg{info: util.ClusterNetworkingInfo{CNIType: "Cilium", CNI: cni}})
+	got := next.(appModel)
+	if cmd != nil || fa.exitErr != nil {
+		t.Errorf("expected to stay in the app, got cmd %v and exit error %v", cmd, fa.exitErr)
+	}
+	if got.page != pageHomeName || got.loading || got.home.selected != "" {
+		t.Errorf("expected to be back on %s, got page %s loading %v selected %q", pageHomeName, got.page, got.loading, got.home.selected)
+	}
+	for _, want := range []string{"kind-cilium is running Cilium v1.16.1", "daemonset=cilium, namespace=kube-system"} {
+		if !strings.Contains(got.home.notice, want) {
+			t.Errorf("expected notice to contain %q, got %q", want, got.home.notice)
+		}
+	}
+
+	m.loading = true
+	next, _ = m.Update(clusterReadyMsg{info: util.ClusterNetworkingInfo{CalicoInstalled: true}})
+	if fa.exitErr != ErrGoldmaneNotAvailable || next.(appModel).home.notice != "" {
+		t.Errorf("expected %v without a notice when Calico has no Whisker, got %v", ErrGoldmaneNotAvailable, fa.exitErr)
+	}
+}
+
+type fakeAlerts []alert.Alert
+
+func (f fakeAlerts) Recent() []alert.Alert { return f }
+func (f fakeAlerts) Total() int            { return len(f) }
+
+func TestFlowApp_Alerts(t *testing.T) {
+	fa := NewFlowApp(nil, nil)
+	fa.SetReplay("capture.ndjson")
+	ctx := cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "").ToContext(context.Background())
+	m := fa.newAppModel(ctx)
+
+	a := tea.KeyPressMsg{Code: 'a', Text: "a"}
+	next, _ := m.updatePage(a)
+	if next.(appModel).overlay != overlayNone {
+		t.Errorf("expected no alerts overlay without alerts")
+	}
+
+	alerts := fakeAlerts{
+		{Rule: "deny-prod", Message: "denied flow dev/web -> prod/db:443"},
+		{Rule: "new", Message: "new edge dev/web -> prod/cache:443 (Allow)"},
+	}
+	fa.SetAlerts(alerts)
+	next, _ = m.Update(tickMsg{})
+	m = next.(appModel)
+	if m.totals.alerts != "alerts: 2 new (a)" {
+		t.Errorf("expected the new alerts in the status line, got %q", m.totals.alerts)
+	}
+
+	m.width, m.height = 120, 40
+	next, _ = m.updatePage(a)
+	m = next.(appModel)
+	if m.overlay != overlayAlerts || m.rates.alerts != "alerts: 2" {
+		t.Fatalf("expected the alerts overlay with them seen, got overlay %d and status %q", m.overlay, m.rates.alerts)
+	}
+	view := m.alerts.View()
+	if !strings.Contains(view, "deny-prod") || strings.Index(view, "new edge") > strings.Index(view, "denied flow") {
+		t.Errorf("expected the alerts newest first, got\n%s", view)
+	}
+	next, _ = m.Update(a)
+	if next.(appModel).overlay != overlayNone {
+		t.Errorf("expected a to close the alerts overlay")
+	}
+}
+
+func TestFlowApp_Contexts(t *testing.T) {
+	fa := NewFlowApp(nil, nil)
+	fa.SetContexts([]string{"one", "two"})
+	ctx := cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "").ToContext(context.Background())
+
+	m := fa.newAppModel(ctx)
+	if m.page != pageSummaryTotalsName {
+		t.Fatalf("expected several contexts to open on %s, got %s", pageSummaryTotalsName, m.page)
+	}
+	next, _ := m.updatePage(tea.KeyPressMsg{Code: 'h', Text: "h"})
+	if got := next.(appModel).page; got != pageSummaryTotalsName {
+		t.Errorf("expected no context picker while watching several contexts, got %s", got)
+	}
+	row := totalsVariant{}.toRow(&flowdata.FlowSum{Cluster: "two", SourceNamespace: "a", SourceName: "b"})
+	if row[0] != "two" || row[1] != "a / b" {
+		t.Errorf("expected the cluster in the first column, got %v", row)
+	}
+}
+
+func TestFlowApp_Connections(t *testing.T) {
+	fa := NewFlowApp(nil, nil)
+	fa.SetContexts([]string{"one", "two"})
+	statuses := catcher.NewStatuses(2)
+	fa.SetConnections(statuses)
+	ctx := cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "").ToContext(context.Background())
+	m := fa.newAppModel(ctx)
+
+	statuses.Set(0, catcher.Status{Cluster: "one", State: catcher.StateStreaming})
+	statuses.Set(1, catcher.Status{Cluster: "two", State: catcher.StateFailed, Attempt: 5, Err: errors.New("connection refused")})
+	next, _ := m.Update(tickMsg{})
+	m = next.(appModel)
+	want := "two: failed after 5 attempts: connection refused"
+	if m.totals.connection != want || !strings.Contains(m.rates.statusLine(), want) {
+		t.Errorf("expected %q in the status line, got %q", want, m.totals.connection)
+	}
+
+	statuses.Set(1, catcher.Status{Cluster: "two", State: catcher.StateStreaming})
+	next, _ = m.Update(tickMsg{})
+	if got := next.(appModel).totals.connection; got != "" {
+		t.Errorf("expected nothing in the status line while streaming, got %q", got)
+	}
+
+	statuses.Set(0, catcher.Status{Cluster: "one", State: catcher.StateStreaming, Pod: "whisker-7d9f-x2k"})
+	next, _ = m.Update(tickMsg{})
+	if got, want := next.(appModel).totals.connection, "one: streaming via whisker-7d9f-x2k"; got != want {
+		t.Errorf("expected %q in the status line, got %q", want, got)
+	}
+}
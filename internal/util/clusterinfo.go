@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/doucol/clyde/internal/cnitype"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
@@ -18,82 +19,47 @@ import (
 // ClusterNetworkingInfo holds metadata about the cluster's networking
 // and Calico/operator status
 type ClusterNetworkingInfo struct {
-	CNIType           string
-	PodCIDRs          []string
-	ServiceCIDRs      []string
-	Overlay           string
-	Encapsulation     string
-	CalicoInstalled   bool
-	CalicoVersion     string
-	OperatorInstalled bool
-	OperatorVersion   string
-	WhiskerAvailable  bool
-	CalicoNamespace   string
-	CalicoOperatorNS  string
-	CalicoPods        []string
-	OperatorPods      []string
-	Errors            []string
+	CNIType           string   `json:"cni_type"`
+	PodCIDRs          []string `json:"pod_cidrs"`
+	ServiceCIDRs      []string `json:"service_cidrs"`
+	Overlay           string   `json:"overlay"`
+	Encapsulation     string   `json:"encapsulation"`
+	CalicoInstalled   bool     `json:"calico_installed"`
+	CalicoVersion     string   `json:"calico_version"`
+	OperatorInstalled bool     `json:"operator_installed"`
+	OperatorVersion   string   `json:"operator_version"`
+	WhiskerAvailable  bool     `json:"whisker_available"`
+	CalicoNamespace   string   `json:"calico_namespace"`
+	CalicoOperatorNS  string   `json:"calico_operator_namespace"`
+	CalicoPods        []string `json:"calico_pods"`
+	OperatorPods      []string `json:"operator_pods"`
+	Errors            []string `json:"errors"`
+
+	// CNI is the full detection result, with its evidence, when there is one
+	CNI *cnitype.CNIInfo `json:"cni,omitempty"`
+}
+
+// Missing lists what clyde needs from the cluster but did not find: Calico
+// and the Whisker backend.
+func (info ClusterNetworkingInfo) Missing() []string {
+	var missing []string
+	if !info.CalicoInstalled {
+		missing = append(missing, "Calico")
+	}
+	if !info.WhiskerAvailable {
+		missing = append(missing, "Whisker")
+	}
+	return missing
 }
 
-// DetectCNIType inspects kube-system and calico-system DaemonSets/pods for known CNI plugins
+// DetectCNIType returns the display name of the CNI plugin found by
+// cnitype.DetectCNI, or "Unknown" when it cannot tell.
 func DetectCNIType(ctx context.Context, clientset kubernetes.Interface) (string, error) {
-	// Check multiple namespaces where CNI components might be deployed
-	namespaces := []string{"kube-system", "calico-system", "tigera-operator"}
-	
-	for _, namespace := range namespaces {
-		// Check DaemonSets first
-		daemonsets, err := clientset.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
-		if err == nil {
-			for _, ds := range daemonsets.Items {
-				name := strings.ToLower(ds.Name)
-				if strings.Contains(name, "calico") || name == "calico-node" {
-					return "Calico", nil
-				}
-				if strings.Contains(name, "cilium") {
-					return "Cilium", nil
-				}
-				if strings.Contains(name, "weave") {
-					return "Weave", nil
-				}
-				if strings.Contains(name, "flannel") {
-					return "Flannel", nil
-				}
-				if strings.Contains(name, "canal") {
-					return "Canal", nil
-				}
-				if strings.Contains(name, "kube-router") {
-					return "Kube-Router", nil
-				}
-				if strings.Contains(name, "ovn") {
-					return "OVN-Kubernetes", nil
-				}
-			}
-		}
-		
-		// Also check pods for Calico images (especially useful for operator-managed installs)
-		pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
-		if err == nil {
-			for _, pod := range pods.Items {
-				for _, container := range pod.Spec.Containers {
-					image := strings.ToLower(container.Image)
-					if strings.Contains(image, "calico/node") || strings.Contains(image, "calico/cni") {
-						return "Calico", nil
-					}
-					if strings.Contains(image, "cilium") {
-						return "Cilium", nil
-					}
-					if strings.Contains(image, "weave") {
-						return "Weave", nil
-					}
-					if strings.Contains(image, "flannel") {
-						return "Flannel", nil
-					}
-				}
-			}
-		}
+	cni, err := cnitype.DetectCNI(ctx, clientset)
+	if err != nil {
+		return "Unknown", nil
 	}
-	
-	return "Unknown", nil
+	return cni.DisplayName(), nil
 }
 
 // GetCalicoPods returns pod names and versions for Calico in calico-system
@@ -346,11 +312,11 @@ func GetClusterNetworkingInfo(ctx context.Context, clientset kubernetes.Interfac
 		CalicoOperatorNS:  "calico-system",
 	}
 	var errs []string
-	cni, err := DetectCNIType(ctx, clientset)
-	if err != nil {
-		errs = append(errs, "CNI detection: "+err.Error())
+	info.CNIType = "Unknown"
+	if cni, err := cnitype.DetectCNI(ctx, clientset); err == nil {
+		info.CNIType = cni.DisplayName()
+		info.CNI = cni
 	}
-	info.CNIType = cni
 	pods, ver, err := GetCalicoPods(ctx, clientset, info.CalicoNamespace)
 	if err == nil && len(pods) > 0 {
 		info.CalicoInstalled = true
@@ -2,6 +2,7 @@ package util
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -766,3 +767,22 @@ func TestClusterNetworkingInfo(t *testing.T) {
 		t.Errorf("Expected PodCIDRs [10.244.0.0/16], got %v", info.PodCIDRs)
 	}
 }
+
+func TestClusterNetworkingInfo_Missing(t *testing.T) {
+	tests := []struct {
+		name string
+		info ClusterNetworkingInfo
+		want []string
+	}{
+		{name: "nothing missing", info: ClusterNetworkingInfo{CalicoInstalled: true, WhiskerAvailable: true}},
+		{name: "whisker missing", info: ClusterNetworkingInfo{CalicoInstalled: true}, want: []string{"Whisker"}},
+		{name: "both missing", info: ClusterNetworkingInfo{}, want: []string{"Calico", "Whisker"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.Missing(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/util/homedir"
@@ -26,35 +28,83 @@ func GetPodAndEnvVarByContainerName(ctx context.Context, clientset kubernetes.In
 	return "", "", fmt.Errorf("pod or env var not found")
 }
 
+// GetPodAndEnvVarsByContainerName returns the pod running the container,
+// preferring the Ready ones, and the values of the env vars of the container.
 func GetPodAndEnvVarsByContainerName(ctx context.Context, clientset kubernetes.Interface, namespace, containerName string, envVarNames ...string) (string, map[string]string, error) {
-	envVals := map[string]string{}
 	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return "", nil, err
 	}
-	for _, pod := range pods.Items {
-		for _, container := range pod.Spec.Containers {
-			if container.Name == containerName {
-
-				for _, env := range container.Env {
-					for _, evn := range envVarNames {
-						if env.Name == evn {
-							envVals[env.Name] = env.Value
-						}
-					}
-				}
+	for _, pod := range PodsByReadiness(pods.Items) {
+		envVals, ok := ContainerEnvVars(pod, containerName, envVarNames...)
+		if !ok {
+			continue
+		}
+		if len(envVals) > 0 {
+			return pod.Name, envVals, nil
+		} else {
+			return "", nil, fmt.Errorf("pod or env vars not found")
+		}
+	}
 
-				if len(envVals) > 0 {
-					return pod.Name, envVals, nil
-				} else {
-					return "", nil, fmt.Errorf("pod or env vars not found")
-				}
+	return "", nil, fmt.Errorf("pod or env vars not found")
+}
 
+// ContainerEnvVars returns the values of the env vars of the container that
+// are set, and whether the pod runs the container at all.
+func ContainerEnvVars(pod *corev1.Pod, containerName string, envVarNames ...string) (map[string]string, bool) {
+	for _, container := range pod.Spec.Containers {
+		if container.Name != containerName {
+			continue
+		}
+		envVals := map[string]string{}
+		for _, env := range container.Env {
+			if slices.Contains(envVarNames, env.Name) {
+				envVals[env.Name] = env.Value
 			}
 		}
+		return envVals, true
 	}
+	return nil, false
+}
 
-	return "", nil, fmt.Errorf("pod or env vars not found")
+// PodReady reports whether the pod is running, Ready and not being deleted.
+func PodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// PodsByReadiness orders the pods to connect to: the Ready ones first, the
+// most recently created first, then the others in the order given, with the
+// ones being deleted last.
+func PodsByReadiness(pods []corev1.Pod) []*corev1.Pod {
+	ordered := make([]*corev1.Pod, len(pods))
+	for i := range pods {
+		ordered[i] = &pods[i]
+	}
+	rank := func(pod *corev1.Pod) int {
+		switch {
+		case PodReady(pod):
+			return 0
+		case pod.DeletionTimestamp == nil:
+			return 1
+		}
+		return 2
+	}
+	slices.SortStableFunc(ordered, func(a, b *corev1.Pod) int {
+		if rankA, rankB := rank(a), rank(b); rankA != rankB || rankA != 0 {
+			return rankA - rankB
+		}
+		return b.CreationTimestamp.Compare(a.CreationTimestamp.Time)
+	})
+	return ordered
 }
 
 func GetFreePort() (port int, err error) {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -274,3 +275,30 @@ func TestGetPodAndEnvVarByContainerName(t *testing.T) {
 		})
 	}
 }
+
+func TestPodsByReadiness(t *testing.T) {
+	now := time.Now()
+	pod := func(name string, created time.Time, ready bool) corev1.Pod {
+		p := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)}}
+		if ready {
+			p.Status.Phase = corev1.PodRunning
+			p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		}
+		return p
+	}
+	terminating := pod("terminating", now, true)
+	terminating.DeletionTimestamp = &metav1.Time{Time: now}
+	pods := []corev1.Pod{
+		terminating,
+		pod("pending", now, false),
+		pod("old", now.Add(-time.Hour), true),
+		pod("new", now.Add(-time.Minute), true),
+	}
+	var got []string
+	for _, p := range PodsByReadiness(pods) {
+		got = append(got, p.Name)
+	}
+	if want := "[new old pending terminating]"; fmt.Sprint(got) != want {
+		t.Errorf("expected %s, got %v", want, got)
+	}
+}
@@ -0,0 +1,168 @@
+package whisker
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/goldmane"
+)
+
+// serverFilter is the part of the filter a single flow stream subscription
+// applies on the server, so flows that would be filtered out anyway aren't
+// stored. Labels are only filtered on the client.
+type serverFilter struct {
+	SourceNamespace string
+	SourceName      string
+	DestNamespace   string
+	DestName        string
+	Action          string
+	Port            int64
+	// To is the unix second flows start before, 0 when not set. The start
+	// of the time range is left to the client: the server tests when a flow
+	// starts, the client keeps one that started before From but ends after.
+	To int64
+}
+
+// subscriptions splits the filter into the server filters to subscribe to
+// the flow stream with. The filter matches a namespace or name at either end
+// of a flow, which the server can't express in one subscription, so each end
+// gets its own. There is always at least one subscription.
+func subscriptions(fa flowdata.FilterAttributes) []serverFilter {
+	base := serverFilter{Action: fa.Action, Port: int64(fa.Port)}
+	if !fa.DateTo.IsZero() {
+		// The client keeps flows that start at To too
+		base.To = fa.DateTo.Unix() + 1
+	}
+	subs := []serverFilter{base}
+	if fa.Namespace != "" {
+		subs = split(subs, func(sf *serverFilter) { sf.SourceNamespace = fa.Namespace }, func(sf *serverFilter) { sf.DestNamespace = fa.Namespace })
+	}
+	if fa.Name != "" {
+		subs = split(subs, func(sf *serverFilter) { sf.SourceName = fa.Name }, func(sf *serverFilter) { sf.DestName = fa.Name })
+	}
+	return subs
+}
+
+// split returns each of the subscriptions twice, once narrowed down to the
+// source end and once to the destination end.
+func split(subs []serverFilter, source, dest func(*serverFilter)) []serverFilter {
+	var out []serverFilter
+	for _, sf := range subs {
+		s, d := sf, sf
+		source(&s)
+		dest(&d)
+		out = append(out, s, d)
+	}
+	return out
+}
+
+// matches reports whether the server would send the flow to this
+// subscription, as far as the ends of the flow go.
+func (sf serverFilter) matches(fr *flowdata.FlowResponse) bool {
+	return strings.Contains(fr.SourceNamespace, sf.SourceNamespace) &&
+		strings.Contains(fr.SourceName, sf.SourceName) &&
+		strings.Contains(fr.DestNamespace, sf.DestNamespace) &&
+		strings.Contains(fr.DestName, sf.DestName)
+}
+
+// skipCaught returns a Skip func for the subscription after the given ones,
+// dropping the flows they have already caught.
+func skipCaught(earlier []serverFilter) func(data string) bool {
+	if len(earlier) == 0 {
+		return nil
+	}
+	return func(data string) bool {
+		var fr flowdata.FlowResponse
+		if err := json.Unmarshal([]byte(data), &fr); err != nil {
+			return false
+		}
+		for _, sf := range earlier {
+			if sf.matches(&fr) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// whiskerMatch is a filter value in the whisker-backend filters parameter
+type whiskerMatch struct {
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+type whiskerFilters struct {
+	SourceNames      []whiskerMatch `json:"source_names,omitempty"`
+	SourceNamespaces []whiskerMatch `json:"source_namespaces,omitempty"`
+	DestNames        []whiskerMatch `json:"dest_names,omitempty"`
+	DestNamespaces   []whiskerMatch `json:"dest_namespaces,omitempty"`
+	DestPorts        []whiskerMatch `json:"dest_ports,omitempty"`
+	Actions          []string       `json:"actions,omitempty"`
+}
+
+func fuzzy(v string) []whiskerMatch {
+	if v == "" {
+		return nil
+	}
+	return []whiskerMatch{{Type: goldmane.MatchFuzzy, Value: v}}
+}
+
+// withQuery adds the whisker-backend query parameters of the subscription
+// to the flow stream URL (or path).
+func (sf serverFilter) withQuery(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	if sf.To != 0 {
+		q.Set("startTimeLt", strconv.FormatInt(sf.To, 10))
+	}
+	f := whiskerFilters{
+		SourceNames:      fuzzy(sf.SourceName),
+		SourceNamespaces: fuzzy(sf.SourceNamespace),
+		DestNames:        fuzzy(sf.DestName),
+		DestNamespaces:   fuzzy(sf.DestNamespace),
+	}
+	if sf.Port != 0 {
+		f.DestPorts = []whiskerMatch{{Type: goldmane.MatchExact, Value: sf.Port}}
+	}
+	if sf.Action != "" {
+		f.Actions = []string{sf.Action}
+	}
+	if data, _ := json.Marshal(f); string(data) != "{}" {
+		q.Set("filters", string(data))
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// streamRequest is the Goldmane stream request for the subscription.
+// Goldmane streams have no end time, so To is left to the client.
+func (sf serverFilter) streamRequest() goldmane.StreamRequest {
+	req := goldmane.StreamRequest{}
+	f := &goldmane.Filter{}
+	match := func(v string) []goldmane.StringMatch {
+		if v == "" {
+			return nil
+		}
+		return []goldmane.StringMatch{{Value: v, Type: goldmane.MatchFuzzy}}
+	}
+	f.SourceNames = match(sf.SourceName)
+	f.SourceNamespaces = match(sf.SourceNamespace)
+	f.DestNames = match(sf.DestName)
+	f.DestNamespaces = match(sf.DestNamespace)
+	if sf.Port != 0 {
+		f.DestPorts = []goldmane.PortMatch{{Port: sf.Port}}
+	}
+	if sf.Action != "" {
+		f.Actions = []string{sf.Action}
+	}
+	if f.SourceNames != nil || f.SourceNamespaces != nil || f.DestNames != nil || f.DestNamespaces != nil || f.DestPorts != nil || f.Actions != nil {
+		req.Filter = f
+	}
+	return req
+}
@@ -0,0 +1,97 @@
+package whisker
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/doucol/clyde/internal/flowdata"
+)
+
+func TestSubscriptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter flowdata.FilterAttributes
+		want   []string
+	}{
+		{
+			name: "no filter",
+			want: []string{"/flows?watch=true"},
+		},
+		{
+			name: "action, port and time range",
+			filter: flowdata.FilterAttributes{
+				Action: "Deny", Port: 443, Label: "app=web",
+				DateFrom: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+				DateTo:   time.Date(2025, 6, 1, 13, 0, 0, 0, time.UTC),
+			},
+			want: []string{`/flows?filters={"dest_ports":[{"type":"Exact","value":443}],"actions":["Deny"]}&startTimeLt=1748782801&watch=true`},
+		},
+		{
+			name:   "namespace at either end",
+			filter: flowdata.FilterAttributes{Namespace: "prod"},
+			want: []string{
+				`/flows?filters={"source_namespaces":[{"type":"Fuzzy","value":"prod"}]}&watch=true`,
+				`/flows?filters={"dest_namespaces":[{"type":"Fuzzy","value":"prod"}]}&watch=true`,
+			},
+		},
+		{
+			name:   "namespace and name",
+			filter: flowdata.FilterAttributes{Namespace: "prod", Name: "db"},
+			want: []string{
+				`/flows?filters={"source_names":[{"type":"Fuzzy","value":"db"}],"source_namespaces":[{"type":"Fuzzy","value":"prod"}]}&watch=true`,
+				`/flows?filters={"source_namespaces":[{"type":"Fuzzy","value":"prod"}],"dest_names":[{"type":"Fuzzy","value":"db"}]}&watch=true`,
+				`/flows?filters={"source_names":[{"type":"Fuzzy","value":"db"}],"dest_namespaces":[{"type":"Fuzzy","value":"prod"}]}&watch=true`,
+				`/flows?filters={"dest_names":[{"type":"Fuzzy","value":"db"}],"dest_namespaces":[{"type":"Fuzzy","value":"prod"}]}&watch=true`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subs := subscriptions(tt.filter)
+			if len(subs) != len(tt.want) {
+				t.Fatalf("expected %d subscriptions, got %d", len(tt.want), len(subs))
+			}
+			for i, sf := range subs {
+				got, err := url.QueryUnescape(sf.withQuery("/flows?watch=true"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want[i] {
+					t.Errorf("subscription %d: expected %s, got %s", i, tt.want[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestSkipCaught(t *testing.T) {
+	subs := subscriptions(flowdata.FilterAttributes{Namespace: "prod"})
+	if skip := skipCaught(subs[:0]); skip != nil {
+		t.Error("expected nothing to skip for the first subscription")
+	}
+	skip := skipCaught(subs[:1])
+	// Both ends in prod: the source namespace subscription has it already
+	if !skip(`{"source_namespace":"prod","dest_namespace":"prod"}`) {
+		t.Error("expected a flow caught by the first subscription to be skipped")
+	}
+	if skip(`{"source_namespace":"dev","dest_namespace":"prod"}`) {
+		t.Error("expected a flow only the second subscription catches to be kept")
+	}
+}
+
+func TestServerFilter_StreamRequest(t *testing.T) {
+	if req := subscriptions(flowdata.FilterAttributes{Label: "app=web"})[0].streamRequest(); req.Filter != nil || req.StartTimeGte != 0 {
+		t.Errorf("expected an unfiltered request, got %+v", req)
+	}
+	req := subscriptions(flowdata.FilterAttributes{
+		Namespace: "prod", Action: "Allow", Port: 53,
+		DateFrom: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+	})[1].streamRequest()
+	f := req.Filter
+	// Flows that started before DateFrom may still end after it
+	if req.StartTimeGte != 0 || f == nil || f.SourceNamespaces != nil || f.DestNamespaces[0].Value != "prod" ||
+		f.DestPorts[0].Port != 53 || f.Actions[0] != "Allow" {
+		t.Errorf("unexpected request %+v with filter %+v", req, f)
+	}
+}
@@ -5,13 +5,19 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/doucol/clyde/internal/alert"
 	"github.com/doucol/clyde/internal/catcher"
+	"github.com/doucol/clyde/internal/cmdctx"
 	"github.com/doucol/clyde/internal/flowcache"
 	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/global"
+	"github.com/doucol/clyde/internal/goldmane"
 	"github.com/doucol/clyde/internal/tui"
 	"github.com/doucol/clyde/internal/util"
 	"github.com/sirupsen/logrus"
@@ -27,8 +33,70 @@ type WhiskerConfig struct {
 	RateCalcInterval int
 	RecoverFunc      func()
 	CatcherFunc      catcher.CatcherFunc
+	// RecordFile captures the raw SSE payloads, with their arrival times, to
+	// be replayed later
+	RecordFile string
+	// ReplayFile replays a capture file instead of watching a cluster
+	ReplayFile string
+	// ReplaySpeed scales the replay: 1 is the original speed, 0 as fast as possible
+	ReplaySpeed float64
+	// ForContext, when set, returns the config for the named kube context so
+	// per-context settings follow the context picked in the TUI. Rates are
+	// set up once, for the context being watched when WatchFlows starts.
+	ForContext func(kubeContext string) *WhiskerConfig
+	// Alerts, when set, are evaluated against the flows as they are caught
+	Alerts *alert.Config
+	// Contexts, when there are more than one, are all watched at once, each
+	// with its own catcher, instead of the context of the command
+	Contexts []string
+	// Backend is where flows are read from, BackendWhisker or BackendGoldmane
+	Backend string
+	// GoldmaneContainer, GoldmaneAddr and GoldmaneTLSSecret reach Goldmane
+	// like WhiskerContainer and URL reach the Whisker backend
+	GoldmaneContainer string
+	GoldmaneAddr      string
+	GoldmaneTLSSecret string
+	// Backoff spaces out the reconnects to the flow stream, and gives up
+	// after Backoff.MaxAttempts failures in a row when that is set
+	Backoff catcher.Backoff
+	// StatusOut, without the TUI, is where the connection states are
+	// written, os.Stderr when nil
+	StatusOut io.Writer
+	// AlertOut, without the TUI, is where the stdout alert notifiers write,
+	// os.Stdout when nil. Commands that print data to stdout send the
+	// alerts elsewhere so they don't end up in it.
+	AlertOut io.Writer
+	// ConnectMode is how the Whisker backend is reached without a URL, one
+	// of the catcher.Connect* modes
+	ConnectMode string
+	// WhiskerService is the service, as name or name:port, streamed through
+	// in the service proxy mode
+	WhiskerService string
+	// URLOptions, when set, configure the TLS, credentials, proxy and
+	// timeouts URL is connected with
+	URLOptions *catcher.HTTPOptions
+	// Ingest configures how the flows caught are batched into the store
+	Ingest flowdata.IngestOptions
+	// Retention bounds the flow data the store keeps
+	Retention flowdata.RetentionOptions
+	// Session is the session flows are captured into, the current session
+	// of the kube context when empty. Watching several contexts at once
+	// captures into the session of the context of the command.
+	Session string
+	// Storage is where the store keeps the flows, flowdata.StorageBolt or
+	// flowdata.StorageMemory, which keeps up to Retention.MaxFlows of them
+	// and has no sessions
+	Storage string
 }
 
+const (
+	// BackendWhisker reads flows from the Whisker backend's SSE stream
+	BackendWhisker = "whisker"
+	// BackendGoldmane reads flows from Goldmane's gRPC API, which works
+	// without the Whisker UI
+	BackendGoldmane = "goldmane"
+)
+
 func DefaultConfig() *WhiskerConfig {
 	return &WhiskerConfig{
 		TerminalUI:       os.Getenv("NOTUI") == "",
@@ -40,12 +108,36 @@ func DefaultConfig() *WhiskerConfig {
 		URL:              "",
 		RateCalcWindow:   60,
 		RateCalcInterval: 5,
+		ReplaySpeed:      1,
+
+		Backend:           BackendWhisker,
+		GoldmaneContainer: "goldmane",
+		GoldmaneTLSSecret: "whisker-backend-key-pair",
+		Backoff:           catcher.DefaultBackoff,
+		ConnectMode:       catcher.ConnectAuto,
+		WhiskerService:    "whisker",
+		Ingest:            flowdata.DefaultIngestOptions,
+		Retention:         flowdata.DefaultRetentionOptions,
+		Storage:           flowdata.StorageBolt,
 	}
 }
 
+// forContext returns the config for the kube context currently selected in
+// ctx.
+func (cfg *WhiskerConfig) forContext(ctx context.Context) *WhiskerConfig {
+	if cfg.ForContext == nil {
+		return cfg
+	}
+	return cfg.ForContext(cmdctx.CmdCtxFromContext(ctx).KubeContext())
+}
+
 type Whisker struct {
-	cfg *WhiskerConfig
-	fds *flowdata.FlowDataStore
+	cfg      *WhiskerConfig
+	fds      *flowdata.FlowDataStore
+	alerts   *alert.Engine
+	statuses *catcher.Statuses
+	// subscribers are subscribed to the store once WatchFlows opens it
+	subscribers []chan flowdata.Ingested
 }
 
 func New(cfg *WhiskerConfig) *Whisker {
@@ -56,6 +148,22 @@ func (w *Whisker) Config() *WhiskerConfig {
 	return w.cfg
 }
 
+// FlowDataStore returns the store flows are captured into once OpenStore or
+// WatchFlows has opened it.
+func (w *Whisker) FlowDataStore() *flowdata.FlowDataStore {
+	return w.fds
+}
+
+// Subscribe returns a channel every flow caught is sent to once it is
+// stored, without dropping any, see flowdata.FlowDataStore.Subscribe. It
+// must be called before WatchFlows, and the channel is closed once
+// WatchFlows returns.
+func (w *Whisker) Subscribe(buffer int) <-chan flowdata.Ingested {
+	ch := make(chan flowdata.Ingested, buffer)
+	w.subscribers = append(w.subscribers, ch)
+	return ch
+}
+
 func (w *Whisker) FlowAdded() chan flowdata.Flower {
 	return w.fds.FlowAdded()
 }
@@ -72,21 +180,76 @@ func (w *Whisker) FlowRatesUpdated() chan flowdata.Flower {
 	return w.fds.FlowRatesUpdated()
 }
 
+// OpenStore opens the store flows are captured into, so what it holds can be
+// read before the flow stream connects. WatchFlows opens it unless it was
+// opened already, and closes it once it returns either way.
+func (w *Whisker) OpenStore(ctx context.Context) (*flowdata.FlowDataStore, error) {
+	var err error
+	if w.cfg.Storage == flowdata.StorageMemory {
+		w.fds = flowdata.NewMemoryDataStore(w.cfg.Retention.MaxFlows)
+	} else if w.cfg.ReplayFile != "" {
+		w.fds, err = flowdata.NewReplayDataStore()
+	} else {
+		w.fds, err = flowdata.NewFlowDataStore(ClusterName(ctx), w.cfg.Session)
+	}
+	if err != nil {
+		for _, ch := range w.subscribers {
+			close(ch)
+		}
+		return nil, err
+	}
+	// The store closes the channels of the subscribers
+	for _, ch := range w.subscribers {
+		w.fds.Subscribe(ch)
+	}
+	return w.fds, nil
+}
+
 func (w *Whisker) WatchFlows(ctx context.Context, whiskerReady chan bool) error {
 	var err error
 	wg := &sync.WaitGroup{}
-	w.fds, err = flowdata.NewFlowDataStore()
-	if err != nil {
-		return err
+	// Giving up on the flow stream stops everything else too
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	if w.fds == nil {
+		if _, err := w.OpenStore(ctx); err != nil {
+			return err
+		}
 	}
 	defer w.fds.Close()
-	w.fds.RateCalcWindow = w.cfg.RateCalcWindow
-	w.fds.RateCalcInterval = w.cfg.RateCalcInterval
+	cfg := w.cfg.forContext(ctx)
+	w.fds.RateCalcWindow = cfg.RateCalcWindow
+	w.fds.RateCalcInterval = cfg.RateCalcInterval
+	w.fds.Ingest = cfg.Ingest
+	w.fds.Retention = cfg.Retention
 
 	flowCache := flowcache.NewFlowCache(ctx, w.fds)
 	flowApp := tui.NewFlowApp(w.fds, flowCache)
+	if w.cfg.ReplayFile != "" {
+		flowApp.SetReplay(w.cfg.ReplayFile)
+	} else {
+		if len(w.cfg.Contexts) > 1 {
+			flowApp.SetContexts(w.cfg.Contexts)
+		}
+		w.statuses = catcher.NewStatuses(max(len(w.cfg.Contexts), 1))
+		flowApp.SetConnections(w.statuses)
+	}
+	if w.cfg.Alerts != nil {
+		// The TUI shows the alerts itself, and owns stdout
+		var stdout io.Writer
+		if !w.cfg.TerminalUI {
+			stdout = w.cfg.AlertOut
+			if stdout == nil {
+				stdout = os.Stdout
+			}
+		}
+		w.alerts = alert.NewEngine(w.cfg.Alerts, alert.NewNotifiers(w.cfg.Alerts.Notifiers, stdout))
+		w.alerts.Now = func() time.Time { return w.fds.Now() }
+		w.alerts.Seed(w.fds.GetFlowSums(flowdata.FilterAttributes{}))
+		flowApp.SetAlerts(w.alerts)
+	}
 
-	var tuiErr error
+	var tuiErr, replayErr, catchErr error
 
 	recoverFunc := w.cfg.RecoverFunc
 	if recoverFunc == nil {
@@ -98,57 +261,110 @@ func (w *Whisker) WatchFlows(ctx context.Context, whiskerReady chan bool) error
 		}
 	}
 
-	// Go capture flows
-	w.fds.Run(recoverFunc)
-
-	flowCatcher := w.cfg.CatcherFunc
-	if flowCatcher == nil {
-		flowCatcher = func(data string) error {
+	// catcherFor returns the catcher that stores the flows of a cluster
+	catcherFor := func(cluster string) catcher.CatcherFunc {
+		if w.cfg.CatcherFunc != nil {
+			return w.cfg.CatcherFunc
+		}
+		return func(data string) error {
 			var fr flowdata.FlowResponse
 			if err := json.Unmarshal([]byte(data), &fr); err != nil {
 				logrus.Panicf("error unmarshalling flow data: %v", err)
 			}
-			fd := &flowdata.FlowData{FlowResponse: fr}
+			fd := &flowdata.FlowData{FlowResponse: fr, Cluster: cluster}
 			w.fds.AddFlow(fd)
+			if w.alerts != nil {
+				w.alerts.OnFlow(fd)
+			}
 			return nil
 		}
 	}
 
 	sseReady := make(chan bool)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		defer flowApp.Stop()
-		defer recoverFunc()
-		tock := time.Tick(2 * time.Second)
-		var lastError error
-		for {
-			dc := catcher.NewDataCatcher(w.cfg.CalicoNamespace, w.cfg.WhiskerContainer, w.cfg.URLPath, flowCatcher, recoverFunc)
-			dc.URLFull = w.cfg.URL
-			if err := dc.CatchServerSentEvents(ctx, sseReady); err != nil {
-				// Don't keep logging the same error
-				if !errors.Is(err, lastError) {
-					lastError = err
-					logrus.Debugf("error in flow catcher: %s", err.Error())
-				}
+	var recorder *catcher.Recorder
+	var replayer *catcher.Replayer
+	if w.cfg.ReplayFile != "" {
+		replayer = catcher.NewReplayer(w.cfg.ReplayFile, w.cfg.ReplaySpeed, nil)
+		replayer.ForCluster = catcherFor
+		w.fds.Now = replayer.Now
+	} else if w.cfg.RecordFile != "" {
+		if recorder, err = catcher.NewRecorder(w.cfg.RecordFile); err != nil {
+			return err
+		}
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				logrus.WithError(err).Error("error closing capture file")
 			}
-			select {
-			case <-ctx.Done():
-				logrus.Debug("exiting flow catcher routine: done signal received")
+		}()
+	}
+
+	// Go capture flows, once the store runs on the clock of the replay
+	w.fds.Run(recoverFunc)
+
+	switch {
+	case replayer != nil:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer flowApp.Stop()
+			defer recoverFunc()
+			util.ChanClose(sseReady)
+			if err := replayer.Replay(ctx); err != nil {
+				replayErr = fmt.Errorf("error replaying %s: %w", w.cfg.ReplayFile, err)
 				return
-			case <-tock:
-				select {
-				case <-ctx.Done():
-					// If we are already done, don't restart the flow catcher
+			}
+			// Keep the replayed flows around until we are told to exit
+			<-ctx.Done()
+		}()
+	case len(w.cfg.Contexts) > 1:
+		// Whisker is ready once the first of the contexts is
+		readies := readyAny(ctx, sseReady, len(w.cfg.Contexts))
+		cc := cmdctx.CmdCtxFromContext(ctx)
+		// Only stop once all of the contexts have been given up on
+		mu := &sync.Mutex{}
+		var errs []error
+		for i, name := range w.cfg.Contexts {
+			ready := readies[i]
+			cctx := cmdctx.NewCmdCtx(cc.KubeconfigPath(), cc.KubeconfigSource(), name).ToContext(ctx)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer recoverFunc()
+				err := w.catchFlows(cctx, i, catcherFor, recorder, ready, recoverFunc)
+				if err == nil {
 					return
-				default:
-					logrus.Debug("restarting the flow catcher")
-					continue
 				}
-			}
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				if len(errs) == len(w.cfg.Contexts) {
+					catchErr = errors.Join(errs...)
+					flowApp.Stop()
+					stop()
+				}
+			}()
 		}
-	}()
+	default:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer flowApp.Stop()
+			defer recoverFunc()
+			if catchErr = w.catchFlows(ctx, 0, catcherFor, recorder, sseReady, recoverFunc); catchErr != nil {
+				stop()
+			}
+		}()
+	}
+
+	if w.alerts != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer recoverFunc()
+			w.alerts.Run(ctx, w.fds, time.Duration(cfg.RateCalcInterval)*time.Second)
+		}()
+	}
 
 	// Go run the flow watcher TUI app
 	if w.cfg.TerminalUI {
@@ -174,7 +390,7 @@ func (w *Whisker) WatchFlows(ctx context.Context, whiskerReady chan bool) error
 				return
 			}
 		}()
-		logrus.Debug("Whisker is running and waiting for an exit signal. However, data is not flowing. Is this a Calico 3.30+ cluster with whisker enabled?")
+		logrus.Debug("Whisker is running and waiting for an exit signal. However, data is not flowing. Is this a Calico 3.30+ cluster with whisker enabled? Run `clyde doctor` to find out.")
 	} else {
 		logrus.Debug("Whisker is running and waiting for an exit signal")
 	}
@@ -182,5 +398,239 @@ func (w *Whisker) WatchFlows(ctx context.Context, whiskerReady chan bool) error
 	// Wait for both goroutines to finish
 	wg.Wait()
 	logrus.Debug("exiting watch flows")
+	if replayErr != nil {
+		return replayErr
+	}
+	if catchErr != nil {
+		return catchErr
+	}
 	return tuiErr
 }
+
+// catchFlows catches the flows of the kube context of ctx, tagged with its
+// name, reconnecting with backoff until ctx is done or the attempts run out.
+// The filter is pushed to the server, and the stream re-subscribed whenever
+// the filter changes. The states of the connection are reported as the
+// conn-th one.
+func (w *Whisker) catchFlows(ctx context.Context, conn int, catcherFor func(cluster string) catcher.CatcherFunc, recorder *catcher.Recorder, sseReady chan bool, recoverFunc func()) error {
+	// Each subscription resumes where its last connection left off
+	resumes := map[string]*catcher.Resume{}
+	sup := catcher.NewSupervisor(w.cfg.forContext(ctx).Backoff)
+	fwd := &podForwarder{}
+	defer fwd.close()
+	sup.Report = func(st catcher.Status) {
+		st.Cluster = ClusterName(ctx)
+		st.Pod = fwd.pod()
+		w.reportStatus(conn, st)
+	}
+	// Wait at least as long as the server asked us to, if it did
+	sup.RetryHint = func() time.Duration { return retryHint(resumes) }
+	return sup.Run(ctx, func(ctx context.Context, report func(catcher.State)) error {
+		for {
+			changed := global.FilterChanged()
+			filter := global.GetFilter()
+			cfg := w.cfg.forContext(ctx)
+			cluster := ClusterName(ctx)
+			if len(w.cfg.Contexts) <= 1 {
+				// The flows of the context picked in the TUI go to its own session
+				if err := w.fds.SwitchSession(cluster, w.cfg.Session); err != nil {
+					return err
+				}
+			}
+			subCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				select {
+				case <-changed:
+					cancel()
+				case <-subCtx.Done():
+				}
+			}()
+			err := w.subscribe(subCtx, cfg, cluster, filter, catcherFor(cluster), recorder, resumes, fwd.get(cfg, cluster), sseReady, report, recoverFunc)
+			cancel()
+			select {
+			case <-changed:
+				if ctx.Err() == nil {
+					logrus.Debug("filter changed, re-subscribing to the flow stream")
+					clear(resumes)
+					continue
+				}
+			default:
+			}
+			if err != nil {
+				logrus.Debugf("error in flow catcher for %s: %s", cluster, err.Error())
+			}
+			return err
+		}
+	})
+}
+
+// podForwarder keeps the port-forward the streams of a connection share,
+// and starts over with another one when the kube context is switched.
+type podForwarder struct {
+	mu      sync.Mutex
+	cluster string
+	fwd     *catcher.Forwarder
+}
+
+// get returns the forwarder to the Whisker pods of the cluster.
+func (p *podForwarder) get(cfg *WhiskerConfig, cluster string) *catcher.Forwarder {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fwd == nil || p.cluster != cluster {
+		if p.fwd != nil {
+			p.fwd.Close()
+		}
+		p.fwd = catcher.NewForwarder(cfg.CalicoNamespace, cfg.WhiskerContainer, "PORT")
+		p.cluster = cluster
+	}
+	return p.fwd
+}
+
+// pod returns the name of the pod the streams are port-forwarded to, if
+// they are.
+func (p *podForwarder) pod() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fwd == nil {
+		return ""
+	}
+	return p.fwd.Pod()
+}
+
+func (p *podForwarder) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fwd != nil {
+		p.fwd.Close()
+	}
+}
+
+// reportStatus records the status of the conn-th connection, and writes it
+// out when there is no TUI to show it.
+func (w *Whisker) reportStatus(conn int, st catcher.Status) {
+	logrus.Debugf("flow stream %s: %s", st.Cluster, st)
+	w.statuses.Set(conn, st)
+	if w.cfg.TerminalUI {
+		return
+	}
+	out := w.cfg.StatusOut
+	if out == nil {
+		out = os.Stderr
+	}
+	fmt.Fprintln(out, formatStatus(st))
+}
+
+// formatStatus is the line a status is written out as.
+func formatStatus(st catcher.Status) string {
+	line := st.Since.Format(time.RFC3339) + " "
+	if st.Cluster != "" {
+		line += st.Cluster + ": "
+	}
+	return line + st.String()
+}
+
+// subscribe catches the flows of the cluster that pass the server side part
+// of the filter, with a catcher per subscription, until ctx is done or one
+// of them stops.
+func (w *Whisker) subscribe(ctx context.Context, cfg *WhiskerConfig, cluster string, filter flowdata.FilterAttributes, catch catcher.CatcherFunc, recorder *catcher.Recorder, resumes map[string]*catcher.Resume, fwd *catcher.Forwarder, sseReady chan bool, onState func(catcher.State), recoverFunc func()) error {
+	if filter.Cluster != "" && filter.Cluster != cluster {
+		// None of the flows of this cluster would be shown
+		util.ChanClose(sseReady)
+		<-ctx.Done()
+		return nil
+	}
+	subs := subscriptions(filter)
+	readies := []chan bool{sseReady}
+	if len(subs) > 1 {
+		readies = readyAny(ctx, sseReady, len(subs))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(subs))
+	wg := &sync.WaitGroup{}
+	for i, sf := range subs {
+		path := sf.withQuery(cfg.URLPath)
+		if resumes[path] == nil {
+			resumes[path] = &catcher.Resume{}
+		}
+		resume := resumes[path]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			defer recoverFunc()
+			if cfg.Backend == BackendGoldmane {
+				fc := goldmane.NewFlowCatcher(cfg.CalicoNamespace, cfg.GoldmaneContainer, catch, recoverFunc)
+				fc.Addr = cfg.GoldmaneAddr
+				fc.TLSSecret = cfg.GoldmaneTLSSecret
+				fc.Request = sf.streamRequest()
+				fc.Recorder = recorder
+				fc.Cluster = cluster
+				fc.Skip = skipCaught(subs[:i])
+				fc.OnState = onState
+				errs[i] = fc.CatchFlows(ctx, readies[i])
+				return
+			}
+			dc := catcher.NewDataCatcher(cfg.CalicoNamespace, cfg.WhiskerContainer, path, catch, recoverFunc)
+			if cfg.URL != "" {
+				dc.URLFull = sf.withQuery(cfg.URL)
+			}
+			dc.Resume = resume
+			dc.Recorder = recorder
+			dc.Cluster = cluster
+			dc.Skip = skipCaught(subs[:i])
+			dc.OnState = onState
+			dc.Mode = cfg.ConnectMode
+			dc.Service = cfg.WhiskerService
+			dc.HTTP = cfg.URLOptions
+			dc.Forwarder = fwd
+			errs[i] = dc.CatchServerSentEvents(ctx, readies[i])
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// retryHint is the longest reconnection time the servers of the
+// subscriptions asked for, 0 when none did.
+func retryHint(resumes map[string]*catcher.Resume) time.Duration {
+	var retry time.Duration
+	for _, r := range resumes {
+		retry = max(retry, r.Retry)
+	}
+	return retry
+}
+
+// readyAny returns n ready channels, one per catcher, and closes ready as
+// soon as the first of them is closed.
+func readyAny(ctx context.Context, ready chan bool, n int) []chan bool {
+	once := &sync.Once{}
+	readies := make([]chan bool, n)
+	for i := range readies {
+		readies[i] = make(chan bool)
+		go func() {
+			select {
+			case <-readies[i]:
+				once.Do(func() { util.ChanClose(ready) })
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return readies
+}
+
+// ClusterName names the kube context selected in ctx, resolving the default
+// to the current context of the kubeconfig.
+func ClusterName(ctx context.Context) string {
+	cc, ok := cmdctx.LookupCmdCtx(ctx)
+	if !ok {
+		return ""
+	}
+	if name := cc.KubeContext(); name != "" {
+		return name
+	}
+	if kc, err := util.LoadKubeconfigInfo(cc.KubeconfigPath(), cc.KubeconfigSource()); err == nil {
+		return kc.CurrentContext
+	}
+	return ""
+}
@@ -2,9 +2,22 @@ package whisker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/doucol/clyde/internal/catcher"
+	"github.com/doucol/clyde/internal/cmdctx"
+	"github.com/doucol/clyde/internal/flowdata"
+	"github.com/doucol/clyde/internal/global"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -64,6 +77,9 @@ func TestDefaultConfig(t *testing.T) {
 			if cfg.CatcherFunc != nil {
 				t.Error("expected CatcherFunc = nil")
 			}
+			if cfg.ReplaySpeed != 1 {
+				t.Errorf("expected ReplaySpeed = 1, got %f", cfg.ReplaySpeed)
+			}
 		})
 	}
 }
@@ -317,3 +333,214 @@ func TestWatchFlows_DatabaseInitializationError(t *testing.T) {
 		t.Error("expected FlowDataStore to be nil before WatchFlows")
 	}
 }
+
+func TestWatchFlows_Replay(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	capture := filepath.Join(t.TempDir(), "capture.ndjson")
+	var lines string
+	for i := range 3 {
+		data := fmt.Sprintf(`{\"source_namespace\":\"ns\",\"source_name\":\"src%d\",\"dest_name\":\"dst\",\"reporter\":\"Src\",\"action\":\"Allow\"}`, i)
+		lines += fmt.Sprintf("{\"time\":\"2025-06-01T12:00:0%dZ\",\"data\":\"%s\"}\n", i, data)
+	}
+	if err := os.WriteFile(capture, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.TerminalUI = false
+	cfg.ReplayFile = capture
+	cfg.ReplaySpeed = 0
+	w := New(cfg)
+	added := w.Subscribe(3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ready := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.WatchFlows(ctx, ready)
+	}()
+	select {
+	case <-ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the replay to be ready")
+	}
+
+	var names []string
+	for len(names) < 3 {
+		select {
+		case in := <-added:
+			names = append(names, in.Flow.SourceName)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for replayed flows, got %v", names)
+		}
+	}
+	if names[0] != "src0" || names[2] != "src2" {
+		t.Errorf("expected flows replayed in order, got %v", names)
+	}
+	if now := w.fds.Now(); !now.Equal(time.Date(2025, 6, 1, 12, 0, 2, 0, time.UTC)) {
+		t.Errorf("expected the store clock to follow the replay, got %s", now)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestWhiskerConfig_ForContext(t *testing.T) {
+	cfg := DefaultConfig()
+	if got := cfg.forContext(context.Background()); got != cfg {
+		t.Error("expected the config itself without ForContext")
+	}
+
+	var asked string
+	cfg.ForContext = func(kubeContext string) *WhiskerConfig {
+		asked = kubeContext
+		c := *cfg
+		c.CalicoNamespace = "tigera-system"
+		return &c
+	}
+	ctx := cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "enterprise").ToContext(context.Background())
+	got := cfg.forContext(ctx)
+	if asked != "enterprise" || got.CalicoNamespace != "tigera-system" {
+		t.Errorf("expected the enterprise overrides, got %q for context %q", got.CalicoNamespace, asked)
+	}
+	if cfg.CalicoNamespace != "calico-system" {
+		t.Errorf("expected the base config to be left alone, got %q", cfg.CalicoNamespace)
+	}
+}
+
+func TestWatchFlows_Contexts(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"source_namespace":"ns","source_name":"src","dest_name":"dst","reporter":"Src","action":"Allow"}` + "\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.TerminalUI = false
+	cfg.URL = srv.URL
+	cfg.Contexts = []string{"one", "two"}
+	w := New(cfg)
+	added := w.Subscribe(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "").ToContext(ctx)
+	ready := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.WatchFlows(ctx, ready)
+	}()
+	select {
+	case <-ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the contexts to be ready")
+	}
+
+	clusters := map[string]bool{}
+	for len(clusters) < 2 {
+		select {
+		case in := <-added:
+			clusters[in.Flow.Cluster] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for flows of both contexts, got %v", clusters)
+		}
+	}
+	if !clusters["one"] || !clusters["two"] {
+		t.Errorf("expected flows tagged with both contexts, got %v", clusters)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestWatchFlows_FilterPushDown(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	global.SetFilter(flowdata.FilterAttributes{})
+	t.Cleanup(func() { global.SetFilter(flowdata.FilterAttributes{}) })
+	queries := make(chan url.Values, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.TerminalUI = false
+	cfg.URL = srv.URL + "/flows?watch=true"
+	w := New(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "").ToContext(ctx)
+	ready := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.WatchFlows(ctx, ready)
+	}()
+	next := func() url.Values {
+		t.Helper()
+		select {
+		case q := <-queries:
+			return q
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for a subscription")
+			return nil
+		}
+	}
+	if q := next(); q.Get("watch") != "true" || q.Get("filters") != "" {
+		t.Errorf("expected an unfiltered subscription, got %v", q)
+	}
+
+	global.SetFilter(flowdata.FilterAttributes{Action: "Deny"})
+	if q := next(); q.Get("filters") != `{"actions":["Deny"]}` {
+		t.Errorf("expected a subscription for denied flows, got %v", q)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestWatchFlows_GivesUp(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.TerminalUI = false
+	cfg.URL = srv.URL
+	cfg.Backoff = catcher.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Factor: 2, MaxAttempts: 2}
+	out := &strings.Builder{}
+	cfg.StatusOut = out
+	w := New(cfg)
+
+	ctx := cmdctx.NewCmdCtx("/nonexistent/kubeconfig", "flag", "").ToContext(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- w.WatchFlows(ctx, make(chan bool))
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, catcher.ErrGaveUp) {
+			t.Errorf("expected %v, got %v", catcher.ErrGaveUp, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting to give up on the flow stream")
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], "connecting") || !strings.Contains(lines[1], "backing off, attempt 1") ||
+		!strings.Contains(lines[3], "failed after 2 attempts: failed to connect to SSE stream") {
+		t.Errorf("expected the connection states in the output, got\n%s", out)
+	}
+}
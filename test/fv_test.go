@@ -58,15 +58,15 @@ func TestFlowData(t *testing.T) {
 		t.Log("Mock server is ready")
 	}
 
-	var wh *whisker.Whisker
+	wc := whisker.DefaultConfig()
+	wc.TerminalUI = false
+	wc.URL = server.URL()
+	wh := whisker.New(wc)
+	ingested := wh.Subscribe(0)
 
 	wgWhisker.Add(1)
 	go func() {
 		defer wgWhisker.Done()
-		wc := whisker.DefaultConfig()
-		wc.TerminalUI = false
-		wc.URL = server.URL()
-		wh = whisker.New(wc)
 		if err := wh.WatchFlows(ctx, whiskerReady); err != nil {
 			log.Fatalf("Failed to watch flows: %v", err)
 		}
@@ -91,48 +91,33 @@ func TestFlowData(t *testing.T) {
 	pairCount := len(pairs)
 	flowCount := pairCount * 2
 	t.Logf("Broadcasting %d flow pairs (%d flows)", pairCount, flowCount)
-	chanMap := map[string]struct {
-		channel chan flowdata.Flower
-		count   int
-	}{
-		"FlowAdded":        {wh.FlowAdded(), flowCount},
-		"FlowSumAdded":     {wh.FlowSumAdded(), pairCount},
-		"FlowSumsUpdated":  {wh.FlowSumsUpdated(), pairCount},
-		"FlowRatesUpdated": {wh.FlowRatesUpdated(), pairCount},
-	}
-	wg := &sync.WaitGroup{}
-	start := make(chan bool)
-	for name, s := range chanMap {
-		wg.Add(1)
-		go func(name string, ch chan flowdata.Flower, cnt int) {
-			defer wg.Done()
-			<-start
-			var eventCount int
-			t.Logf("Waiting for %s events", name)
-			select {
-			case <-ch:
-				t.Logf("Received %s event", name)
-				eventCount++
-			case <-time.After(time.Duration(wh.Config().RateCalcInterval+2) * time.Second):
-				t.Errorf("Timed out waiting for the first %s event", name)
-				return
-			}
-			for {
-				select {
-				case <-ch:
-					eventCount++
-				case <-time.After(1 * time.Second):
-					if eventCount != cnt {
-						t.Errorf("Expected %d events, got %d - %s", cnt, eventCount, name)
-					}
-					return
-				}
+	server.BroadcastFlowPairs(pairs)
+
+	// Every flow is sent to the subscription, the first of each pair adding
+	// its sum and the second one updating it
+	var flows, sumsAdded int
+	for flows < flowCount {
+		select {
+		case in := <-ingested:
+			flows++
+			if in.NewSum {
+				sumsAdded++
 			}
-		}(name, s.channel, s.count)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for flows, got %d of %d", flows, flowCount)
+		}
+	}
+	if sumsAdded != pairCount {
+		t.Errorf("Expected %d flow sums added, got %d", pairCount, sumsAdded)
+	}
+
+	// Rate updates are only signalled to whoever is waiting for them
+	select {
+	case <-wh.FlowRatesUpdated():
+		t.Log("Received FlowRatesUpdated event")
+	case <-time.After(time.Duration(wh.Config().RateCalcInterval+2) * time.Second):
+		t.Error("Timed out waiting for the first FlowRatesUpdated event")
 	}
-	close(start)
-	server.BroadcastFlowPairs(pairs)
-	wg.Wait()
 
 	t.Logf("Cancelling context to stop whisker")
 	cancel()
@@ -142,7 +127,7 @@ func TestFlowData(t *testing.T) {
 
 	// We have to wait for whisker to shutdown before we can open the
 	// flowdata store.
-	fds, err := flowdata.NewFlowDataStore()
+	fds, err := flowdata.NewFlowDataStore("", "")
 	if err != nil {
 		t.Fatalf("Failed to create flow data store: %v", err)
 	}